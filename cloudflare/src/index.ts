@@ -1,6 +1,8 @@
 import { Container, loadBalance } from '@cloudflare/containers';
-import { SessionDO, SessionMetadata } from './session';
+import { SessionDO, SessionMetadata, goRunCommand, runOutputKey } from './session';
+import { encodeFilePath } from './paths';
 import { SessionSetup } from './plugins/types';
+import { mergeSessionPackages } from './plugins/package_managers';
 import { handleMCPRequest } from './mcp/server';
 import { handleKVOperation, handleD1Operation, handleR2Operation } from './storage-proxy';
 import { StorageRegistry } from './storage-registry';
@@ -126,6 +128,11 @@ export default {
         return handleGetSessionCode(sessionId, env);
       }
 
+      // POST /api/sessions/{id}/stop - Stop session VMs, keeping files
+      if (subPath === '/stop' && request.method === 'POST') {
+        return handleStopSession(sessionId, env);
+      }
+
       // DELETE /api/sessions/{id} - Delete session
       if (subPath === '' && request.method === 'DELETE') {
         return handleDeleteSession(sessionId, env);
@@ -187,6 +194,8 @@ export async function handleExecute(request: Request, agentStub: any): Promise<R
       code: string;
       language: string;
       timeout?: number;
+      envs?: Record<string, string>;
+      keep?: boolean;
     };
 
     // Validate required fields
@@ -275,9 +284,8 @@ export async function handleExecute(request: Request, agentStub: any): Promise<R
           command = `sh -c "echo '${codeBase64}' | base64 -d > ${tmpFile} && npx -y tsx ${tmpFile} && rm ${tmpFile}"`;
           break;
         case 'go':
-          // Go needs a file to run
-          const goFile = `/tmp/main_${vmId}.go`;
-          command = `sh -c "echo '${codeBase64}' | base64 -d > ${goFile} && go run ${goFile} && rm ${goFile}"`;
+          // Go needs a file inside a module to run
+          command = goRunCommand(codeBase64, vmId);
           break;
         case 'deno':
           // Deno needs a file to run (supports both JS and TS natively)
@@ -294,6 +302,7 @@ export async function handleExecute(request: Request, agentStub: any): Promise<R
         body: JSON.stringify({
           command: command,
           timeout: timeout,
+          envs: body.envs,
         }),
       }));
 
@@ -302,6 +311,7 @@ export async function handleExecute(request: Request, agentStub: any): Promise<R
         stdout: string;
         stderr: string;
         duration: string;
+        timed_out?: boolean;
       };
 
       // Add language and vm_id to response
@@ -309,16 +319,19 @@ export async function handleExecute(request: Request, agentStub: any): Promise<R
         ...result,
         language: normalizedLang,
         vm_id: vmId,
+        kept: body.keep === true,
       }), {
         status: 200,
         headers: { 'Content-Type': 'application/json' },
       });
 
     } finally {
-      // Step 3: Always cleanup VM
-      await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, {
-        method: 'DELETE',
-      }));
+      // Step 3: Cleanup VM, unless the caller keeps it to fetch output files
+      if (!body.keep) {
+        await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, {
+          method: 'DELETE',
+        }));
+      }
     }
 
   } catch (error) {
@@ -332,6 +345,27 @@ export async function handleExecute(request: Request, agentStub: any): Promise<R
   }
 }
 
+/**
+ * Read a file from a VM's work directory, e.g. one kept by handleExecute
+ * with keep: true. Deletes the VM afterwards when cleanup is set.
+ */
+export async function handleDownloadVMFile(vmId: string, filePath: string, agentStub: any, cleanup = false): Promise<Response> {
+  try {
+    const fileRes = await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${encodeFilePath(filePath)}`, {
+      method: 'GET',
+    }));
+    // Buffer the body so the VM can be deleted before the caller reads it
+    const content = await fileRes.arrayBuffer();
+    return new Response(content, { status: fileRes.status, headers: fileRes.headers });
+  } finally {
+    if (cleanup) {
+      await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, {
+        method: 'DELETE',
+      }));
+    }
+  }
+}
+
 /**
  * Session Management Handlers
  */
@@ -346,7 +380,9 @@ export async function handleCreateSession(request: Request, env: Env, ctx: Execu
     setup,
     allowInternetAccess = true,
     allowPublicAccess = true,
-    default_timeout
+    default_timeout,
+    repl = false,
+    packages
   } = await request.json() as {
     session_id?: string;
     language: string;
@@ -357,6 +393,8 @@ export async function handleCreateSession(request: Request, env: Env, ctx: Execu
     allowInternetAccess?: boolean;
     allowPublicAccess?: boolean;
     default_timeout?: number;
+    repl?: boolean;
+    packages?: string[];
   };
 
   // Validate language
@@ -367,6 +405,29 @@ export async function handleCreateSession(request: Request, env: Env, ctx: Execu
     });
   }
 
+  // REPL sessions keep one interpreter running, which the agent offers for
+  // python and node
+  if (repl && language !== 'python' && language !== 'node') {
+    return new Response(JSON.stringify({ error: 'repl sessions support python and node only' }), {
+      status: 400,
+      headers: { 'Content-Type': 'application/json' },
+    });
+  }
+
+  // packages is shorthand for setup.pip or setup.npm, installed by the
+  // normal setup run
+  let sessionSetup = setup;
+  if (packages !== undefined) {
+    const merged = mergeSessionPackages(language, setup, packages);
+    if (merged.error) {
+      return new Response(JSON.stringify({ error: merged.error }), {
+        status: 400,
+        headers: { 'Content-Type': 'application/json' },
+      });
+    }
+    sessionSetup = merged.setup;
+  }
+
   // Use provided session_id or generate one
   const sessionId = session_id || `sess_${Date.now()}_${Math.random().toString(36).substr(2, 9)}`;
 
@@ -407,11 +468,12 @@ export async function handleCreateSession(request: Request, env: Env, ctx: Execu
     total_size_bytes: 0,
     metadata,
     data,
-    setup,
-    setup_status: setup ? 'pending' : undefined,
+    setup: sessionSetup,
+    setup_status: sessionSetup ? 'pending' : undefined,
     allowInternetAccess,
     allowPublicAccess,
     default_timeout,
+    repl: repl || undefined,
   };
 
   await stub.fetch(new Request('http://session/update', {
@@ -421,11 +483,11 @@ export async function handleCreateSession(request: Request, env: Env, ctx: Execu
 
   // Run setup asynchronously if provided (install packages, run commands, etc.)
   // Delegate to Durable Object to avoid Worker's 30-second waitUntil limit
-  if (setup) {
+  if (sessionSetup) {
     // Fire and forget - DO will handle it
     stub.fetch(new Request('http://session/run-setup', {
       method: 'POST',
-      body: JSON.stringify({ sessionId, language, setup }),
+      body: JSON.stringify({ sessionId, language, setup: sessionSetup }),
     })).catch(error => {
       console.error(`[Setup] Failed to start setup for ${sessionId}:`, error);
     });
@@ -690,7 +752,26 @@ async function handleGetSessionCode(sessionId: string, env: Env): Promise<Respon
   });
 }
 
+export async function handleStopSession(sessionId: string, env: Env): Promise<Response> {
+  const id = env.SESSIONS.idFromName(sessionId);
+  const stub = env.SESSIONS.get(id);
+
+  // Files in R2 and session data are kept; the next run resumes the session
+  return stub.fetch(new Request('http://session/stop', {
+    method: 'POST',
+  }));
+}
+
+// Drop the latest run's output, which is kept outside the session's files
+async function deleteRunOutput(sessionId: string, env: Env): Promise<void> {
+  await env.SESSIONS_BUCKET.delete([runOutputKey(sessionId, 'stdout'), runOutputKey(sessionId, 'stderr')]);
+}
+
 export async function handleDeleteSession(sessionId: string, env: Env): Promise<Response> {
+  // Free the session's VMs, including a REPL session's interpreter
+  const stub = env.SESSIONS.get(env.SESSIONS.idFromName(sessionId));
+  await stub.fetch(new Request('http://session/stop', { method: 'POST' })).catch(() => undefined);
+
   // Delete all files from R2
   const prefix = `sessions/${sessionId}/`;
   const listed = await env.SESSIONS_BUCKET.list({ prefix });
@@ -698,6 +779,7 @@ export async function handleDeleteSession(sessionId: string, env: Env): Promise<
   for (const obj of listed.objects) {
     await env.SESSIONS_BUCKET.delete(obj.key);
   }
+  await deleteRunOutput(sessionId, env);
 
   // Remove from registry
   await env.SESSIONS_BUCKET.delete(`_registry/${sessionId}`);
@@ -804,6 +886,7 @@ async function handleDeleteAllSessions(env: Env): Promise<Response> {
     for (const file of sessionFiles.objects) {
       await env.SESSIONS_BUCKET.delete(file.key);
     }
+    await deleteRunOutput(sessionId, env);
 
     // Remove from registry
     await env.SESSIONS_BUCKET.delete(`_registry/${sessionId}`);
@@ -47,11 +47,15 @@ export function validateJSONRPCRequest(req: any): req is JSONRPCRequest {
 
 /**
  * Parse JSON-RPC request from request body
+ * A batch (JSON array) is returned as is, so each element can be validated and answered on its own
  */
-export async function parseJSONRPCRequest(request: Request): Promise<JSONRPCRequest> {
+export async function parseJSONRPCRequest(request: Request): Promise<JSONRPCRequest | unknown[]> {
   try {
     const body = await request.json();
 
+    if (Array.isArray(body)) {
+      return body;
+    }
     if (!validateJSONRPCRequest(body)) {
       throw new Error('Invalid JSON-RPC request');
     }
@@ -72,7 +76,7 @@ export function jsonResponse(data: any, status: number = 200): Response {
       'Content-Type': 'application/json',
       'Access-Control-Allow-Origin': '*',
       'Access-Control-Allow-Methods': 'GET, POST, OPTIONS',
-      'Access-Control-Allow-Headers': 'Content-Type',
+      'Access-Control-Allow-Headers': 'Content-Type, Mcp-Session-Id',
     },
   });
 }
@@ -124,7 +128,7 @@ export function handleCORS(): Response {
     headers: {
       'Access-Control-Allow-Origin': '*',
       'Access-Control-Allow-Methods': 'GET, POST, OPTIONS',
-      'Access-Control-Allow-Headers': 'Content-Type',
+      'Access-Control-Allow-Headers': 'Content-Type, Mcp-Session-Id',
       'Access-Control-Max-Age': '86400',
     },
   });
@@ -1,7 +1,9 @@
 // MCP Resource Handlers
 // Provides access to session metadata and files as resources
 
-import { MCPResource, MCPResourceContent } from './types';
+import { MCPResource, MCPResourceContent, MCPResourceTemplate } from './types';
+import { handleDownloadSessionFile } from '../index';
+import { encodeFileContent } from './tools';
 
 /**
  * List all available resources
@@ -49,6 +51,79 @@ export async function listResources(env: Env): Promise<MCPResource[]> {
   return resources;
 }
 
+/**
+ * Templates for resources that are not listed one by one
+ */
+export function listResourceTemplates(): MCPResourceTemplate[] {
+  return [
+    {
+      uriTemplate: 'session://{session_id}/files/{path}',
+      name: 'Session file',
+      description: 'Contents of a file in a session workspace; see session://{session_id}/files for the paths',
+    },
+  ];
+}
+
+/**
+ * A parsed session resource URI; path is set for kind 'file'
+ */
+export interface SessionResourceURI {
+  sessionId: string;
+  kind: 'session' | 'files' | 'file';
+  path?: string;
+}
+
+/**
+ * Parse session://{id}, session://{id}/files or session://{id}/files/{path}
+ * A trailing slash is allowed on the first two; anything else after the ID is
+ * rejected rather than read as the nearest match. File path segments are
+ * percent-decoded, and ones that could leave the session (".", "..", empty,
+ * or holding a slash or backslash) are rejected
+ */
+export function parseResourceURI(uri: string): SessionResourceURI {
+  const prefix = 'session://';
+  if (!uri.startsWith(prefix)) {
+    throw new Error(`Invalid resource URI: ${uri}`);
+  }
+
+  const segments = uri.slice(prefix.length).split('/');
+  if (segments[segments.length - 1] === '' && segments.length > 1 && segments.length <= 3) {
+    segments.pop();
+  }
+  const [sessionId, resourceType, ...rest] = segments;
+
+  if (!sessionId) {
+    throw new Error('Invalid resource URI: missing session ID');
+  }
+  // Same rule as session creation, so the ID cannot reach another path
+  if (!/^[a-zA-Z0-9_-]+$/.test(sessionId)) {
+    throw new Error(`Invalid resource URI: bad session ID in ${uri}`);
+  }
+  if (resourceType === undefined) {
+    return { sessionId, kind: 'session' };
+  }
+  if (resourceType !== 'files') {
+    throw new Error(`Unknown resource: ${uri}`);
+  }
+  if (rest.length === 0) {
+    return { sessionId, kind: 'files' };
+  }
+
+  const pathSegments = rest.map((segment) => {
+    let decoded: string;
+    try {
+      decoded = decodeURIComponent(segment);
+    } catch {
+      throw new Error(`Invalid resource URI: bad escape in ${uri}`);
+    }
+    if (decoded === '' || decoded === '.' || decoded === '..' || /[\/\\\0]/.test(decoded)) {
+      throw new Error(`Invalid resource URI: file path must stay inside the session: ${uri}`);
+    }
+    return decoded;
+  });
+  return { sessionId, kind: 'file', path: pathSegments.join('/') };
+}
+
 /**
  * Read a specific resource
  */
@@ -56,29 +131,86 @@ export async function readResource(
   uri: string,
   env: Env
 ): Promise<MCPResourceContent[]> {
-  // Parse URI format: session://{id} or session://{id}/files
-  if (!uri.startsWith('session://')) {
-    throw new Error(`Invalid resource URI: ${uri}`);
+  const { sessionId, kind, path } = parseResourceURI(uri);
+
+  if (kind === 'file') {
+    return await readSessionFile(sessionId, path!, uri, env);
   }
+  if (kind === 'files') {
+    return await readSessionFiles(sessionId, env);
+  }
+  return await readSessionMetadata(sessionId, env);
+}
 
-  const path = uri.replace('session://', '');
-  const parts = path.split('/');
-  const sessionId = parts[0];
-  const resourceType = parts[1]; // undefined for session metadata, 'files' for files list
+/**
+ * MIME types by extension, for files stored without a content type
+ */
+const MIME_TYPES: Record<string, string> = {
+  txt: 'text/plain',
+  md: 'text/markdown',
+  csv: 'text/csv',
+  html: 'text/html',
+  css: 'text/css',
+  xml: 'application/xml',
+  json: 'application/json',
+  yaml: 'application/yaml',
+  yml: 'application/yaml',
+  py: 'text/x-python',
+  js: 'text/javascript',
+  mjs: 'text/javascript',
+  ts: 'text/x-typescript',
+  go: 'text/x-go',
+  sh: 'text/x-shellscript',
+  log: 'text/plain',
+  png: 'image/png',
+  jpg: 'image/jpeg',
+  jpeg: 'image/jpeg',
+  gif: 'image/gif',
+  svg: 'image/svg+xml',
+  pdf: 'application/pdf',
+  zip: 'application/zip',
+  gz: 'application/gzip',
+};
 
-  if (!sessionId) {
-    throw new Error('Invalid resource URI: missing session ID');
+/**
+ * Guess a file's MIME type from its extension
+ */
+export function guessMimeType(path: string): string | undefined {
+  const name = path.slice(path.lastIndexOf('/') + 1);
+  const dot = name.lastIndexOf('.');
+  if (dot <= 0) {
+    return undefined;
   }
+  return MIME_TYPES[name.slice(dot + 1).toLowerCase()];
+}
 
-  if (!resourceType) {
-    // Read session metadata
-    return await readSessionMetadata(sessionId, env);
-  } else if (resourceType === 'files') {
-    // Read files list
-    return await readSessionFiles(sessionId, env);
-  } else {
-    throw new Error(`Unknown resource type: ${resourceType}`);
+/**
+ * Read one file from a session workspace
+ * UTF-8 content is returned as text and anything else as a base64 blob
+ */
+async function readSessionFile(
+  sessionId: string,
+  path: string,
+  uri: string,
+  env: Env
+): Promise<MCPResourceContent[]> {
+  const response = await handleDownloadSessionFile(sessionId, path, env);
+
+  if (!response.ok) {
+    throw new Error(`File ${path} not found in session ${sessionId}`);
   }
+
+  const stored = response.headers.get('Content-Type');
+  const { encoding, text } = encodeFileContent(await response.arrayBuffer());
+  const mimeType = (stored && stored !== 'application/octet-stream' ? stored : undefined)
+    || guessMimeType(path)
+    || (encoding === 'utf-8' ? 'text/plain' : 'application/octet-stream');
+
+  return [
+    encoding === 'utf-8'
+      ? { uri, mimeType, text }
+      : { uri, mimeType, blob: text },
+  ];
 }
 
 /**
@@ -1,12 +1,15 @@
 // MCP Server
 // Main request handler for MCP protocol endpoints
 
-import { JSONRPCRequest, JSONRPC_ERRORS } from './types';
+import { JSONRPCRequest, JSONRPCResponse, JSONRPCNotification, JSONRPC_ERRORS } from './types';
 import {
   parseJSONRPCRequest,
+  validateJSONRPCRequest,
   createSuccessResponse,
   createErrorResponse,
   jsonResponse,
+  createSSEResponse,
+  createSSEStream,
   handleCORS,
 } from './protocol';
 import {
@@ -17,17 +20,23 @@ import {
   handleListSessions,
   handleGetSession,
   handleDeleteSession,
+  handleStopSessionTool,
   handleUpdateSessionTool,
   handleUploadFile,
   handleReadFile,
   handleListFiles,
+  handleGetOutput,
+  handleDownloadOutput,
   handlePython,
   handleNode,
   handleTypeScript,
   handleDeno,
+  handleGo,
   handleShell,
+  SUPPORTED_LANGUAGES,
 } from './tools';
-import { listResources, readResource } from './resources';
+import { listResources, listResourceTemplates, readResource } from './resources';
+import { clientKey, subscribe, unsubscribe, toolNotifications } from './subscriptions';
 
 /**
  * Main MCP server handler
@@ -43,7 +52,7 @@ export async function handleMCPRequest(
   }
 
   // Parse JSON-RPC request
-  let rpcRequest: JSONRPCRequest;
+  let rpcRequest: JSONRPCRequest | unknown[];
   try {
     rpcRequest = await parseJSONRPCRequest(request);
   } catch (error: any) {
@@ -57,71 +66,175 @@ export async function handleMCPRequest(
     );
   }
 
-  // Route to appropriate handler
+  const client = clientKey(request);
+  const notifications: JSONRPCNotification[] = [];
+  let response: JSONRPCResponse | JSONRPCResponse[];
+
+  if (Array.isArray(rpcRequest)) {
+    // JSON-RPC batch: requests run in order and each gets its own response or
+    // error, except notifications (no id), which get none
+    if (rpcRequest.length === 0) {
+      return jsonResponse(
+        createErrorResponse(undefined, JSONRPC_ERRORS.INVALID_REQUEST, 'Invalid Request', 'Empty batch')
+      );
+    }
+    const responses: JSONRPCResponse[] = [];
+    for (const element of rpcRequest) {
+      if (!validateJSONRPCRequest(element)) {
+        responses.push(createErrorResponse(undefined, JSONRPC_ERRORS.INVALID_REQUEST, 'Invalid Request'));
+        continue;
+      }
+      const elementResponse = await dispatchRequest(element, env, ctx, client, notifications);
+      if (element.id !== undefined) {
+        responses.push(elementResponse);
+      }
+    }
+    if (responses.length === 0) {
+      return new Response(null, { status: 202, headers: { 'Access-Control-Allow-Origin': '*' } });
+    }
+    response = responses;
+  } else {
+    response = await dispatchRequest(rpcRequest, env, ctx, client, notifications);
+  }
+
+  // Notifications ride ahead of the response on an SSE stream, for clients that accept one
+  let reply: Response;
+  if (notifications.length > 0 && acceptsEventStream(request)) {
+    reply = createSSEResponse(createSSEStream([...notifications, response]));
+  } else {
+    reply = jsonResponse(response);
+  }
+
+  // initialize hands out the session id that later requests identify themselves with
+  if (client === null && !Array.isArray(rpcRequest) && rpcRequest.method === 'initialize') {
+    reply.headers.set('Mcp-Session-Id', crypto.randomUUID());
+    reply.headers.set('Access-Control-Expose-Headers', 'Mcp-Session-Id');
+  }
+  return reply;
+}
+
+/**
+ * Run one JSON-RPC request, turning a handler failure into an error response
+ */
+async function dispatchRequest(
+  rpcRequest: JSONRPCRequest,
+  env: Env,
+  ctx: ExecutionContext,
+  client: string | null,
+  notifications: JSONRPCNotification[]
+): Promise<JSONRPCResponse> {
   try {
-    const result = await routeRequest(rpcRequest, env, ctx);
-    return jsonResponse(createSuccessResponse(rpcRequest.id, result));
+    const result = await routeRequest(rpcRequest, env, ctx, client, notifications);
+    return createSuccessResponse(rpcRequest.id, result);
   } catch (error: any) {
     console.error('MCP Error:', error);
-    return jsonResponse(
-      createErrorResponse(
-        rpcRequest.id,
-        JSONRPC_ERRORS.INTERNAL_ERROR,
-        error.message || 'Internal error',
-        error.stack
-      )
+    return createErrorResponse(
+      rpcRequest.id,
+      JSONRPC_ERRORS.INTERNAL_ERROR,
+      error.message || 'Internal error',
+      error.stack
     );
   }
 }
 
+/**
+ * Whether the client accepts a text/event-stream response
+ */
+function acceptsEventStream(request: Request): boolean {
+  return (request.headers.get('Accept') || '').includes('text/event-stream');
+}
+
 /**
  * Route JSON-RPC request to the appropriate handler
+ * Notifications caused by the request are appended to notifications
  */
 async function routeRequest(
   request: JSONRPCRequest,
   env: Env,
-  ctx: ExecutionContext
+  ctx: ExecutionContext,
+  client: string | null,
+  notifications: JSONRPCNotification[]
 ): Promise<any> {
   const { method, params } = request;
 
-  // Get Durable Object stub for API calls
-  const stub = env.ERA_AGENT.get(env.ERA_AGENT.idFromName('primary'));
-
   switch (method) {
     // Protocol methods
     case 'initialize':
-      return handleInitialize(params);
+      return handleInitialize(params, env);
 
     // Tools methods
     case 'tools/list':
       return handleToolsList();
 
-    case 'tools/call':
-      return handleToolsCall(params, env, ctx, stub);
+    case 'tools/call': {
+      // Get Durable Object stub for API calls
+      const result = await handleToolsCall(params, env, ctx, getAgentStub(env));
+      if (!result?.isError) {
+        notifications.push(...toolNotifications(client, params.name, params.arguments));
+      }
+      return result;
+    }
 
     // Resources methods
     case 'resources/list':
       return handleResourcesList(env);
 
+    case 'resources/templates/list':
+      return { resourceTemplates: listResourceTemplates() };
+
     case 'resources/read':
       return handleResourcesRead(params, env);
 
+    case 'resources/subscribe':
+      return handleResourcesSubscribe(params, client);
+
+    case 'resources/unsubscribe':
+      return handleResourcesUnsubscribe(params, client);
+
     default:
       throw new Error(`Method not found: ${method}`);
   }
 }
 
+/**
+ * Resolve the ERA Agent Durable Object stub, failing if the binding is missing
+ */
+function getAgentStub(env: Env): DurableObjectStub {
+  if (!env.ERA_AGENT) {
+    throw new Error('VM service not available');
+  }
+  return env.ERA_AGENT.get(env.ERA_AGENT.idFromName('primary'));
+}
+
 /**
  * Handle initialize request
+ * Capabilities reflect the bindings actually present so clients can adapt
  */
-function handleInitialize(params: any): any {
+function handleInitialize(params: any, env: Env): any {
+  const vmServiceAvailable = Boolean(env.ERA_AGENT);
+
+  // Tools are always listed; without the VM service they are advertised as degraded
+  const tools: Record<string, any> = {};
+  if (!vmServiceAvailable) {
+    tools.degraded = true;
+    tools.note = 'VM service not available: tool calls will fail until the ERA_AGENT binding is configured';
+  }
+
   return {
     protocolVersion: '0.1.0',
     capabilities: {
-      tools: {},
+      tools,
       resources: {
-        subscribe: false,
-        listChanged: false,
+        subscribe: true,
+        listChanged: true,
+      },
+      experimental: {
+        era: {
+          vmService: vmServiceAvailable,
+          guestVolumes: Boolean(env.SESSIONS_BUCKET),
+          storageProxy: Boolean(env.ERA_KV && env.ERA_R2 && env.ERA_D1),
+          languages: SUPPORTED_LANGUAGES,
+        },
       },
     },
     serverInfo: {
@@ -170,6 +283,9 @@ async function handleToolsCall(
     case 'era_deno':
       return await handleDeno(args, env, stub);
 
+    case 'era_go':
+      return await handleGo(args, env, stub);
+
     case 'era_shell':
       return await handleShell(args, env, stub);
 
@@ -177,6 +293,9 @@ async function handleToolsCall(
     case 'era_execute_code':
       return await handleExecuteCode(args, env, stub);
 
+    case 'era_download_output':
+      return await handleDownloadOutput(args, env, stub);
+
     case 'era_create_session':
       return await handleCreateSession(args, env, ctx);
 
@@ -192,6 +311,9 @@ async function handleToolsCall(
     case 'era_delete_session':
       return await handleDeleteSession(args, env);
 
+    case 'era_stop_session':
+      return await handleStopSessionTool(args, env);
+
     case 'era_update_session':
       return await handleUpdateSessionTool(args, env);
 
@@ -204,6 +326,9 @@ async function handleToolsCall(
     case 'era_list_files':
       return await handleListFiles(args, env);
 
+    case 'era_get_output':
+      return await handleGetOutput(args, env);
+
     default:
       throw new Error(`Unknown tool: ${name}`);
   }
@@ -234,3 +359,37 @@ async function handleResourcesRead(params: any, env: Env): Promise<any> {
     contents,
   };
 }
+
+/**
+ * Handle resources/subscribe request
+ * Later tool calls that change the resource emit notifications/resources/updated
+ */
+function handleResourcesSubscribe(params: any, client: string | null): any {
+  const { uri } = params || {};
+
+  if (!uri) {
+    throw new Error('Missing resource URI');
+  }
+  if (client === null) {
+    throw new Error('resources/subscribe needs an Mcp-Session-Id header, as returned by initialize');
+  }
+
+  subscribe(client, uri);
+  return {};
+}
+
+/**
+ * Handle resources/unsubscribe request
+ */
+function handleResourcesUnsubscribe(params: any, client: string | null): any {
+  const { uri } = params || {};
+
+  if (!uri) {
+    throw new Error('Missing resource URI');
+  }
+
+  if (client !== null) {
+    unsubscribe(client, uri);
+  }
+  return {};
+}
@@ -0,0 +1,134 @@
+// MCP Resource Subscriptions
+// Tracks resources/subscribe URIs and works out which notifications a tool call triggers
+
+import { JSONRPCNotification } from './types';
+import { encodeFilePath } from '../paths';
+
+// Clients idle this long lose their subscriptions
+const SUBSCRIPTION_TTL_MS = 60 * 60 * 1000;
+// Caps the memory subscriptions can take in one isolate
+const MAX_SUBSCRIBED_CLIENTS = 1000;
+const MAX_URIS_PER_CLIENT = 100;
+
+interface ClientSubscriptions {
+  uris: Set<string>;
+  lastSeen: number;
+}
+
+/**
+ * Subscribed URIs per client, keyed by the Mcp-Session-Id header, oldest
+ * activity first. Held in Worker memory, so subscriptions are best effort:
+ * a new isolate starts empty
+ */
+const subscriptions = new Map<string, ClientSubscriptions>();
+
+/**
+ * Identify the client a request belongs to, or null when it sent no
+ * Mcp-Session-Id. Such clients cannot subscribe, since they could not be
+ * told apart from each other
+ */
+export function clientKey(request: Request): string | null {
+  return request.headers.get('Mcp-Session-Id') || null;
+}
+
+/**
+ * Look up a client's subscriptions, dropping them once expired, and mark
+ * the client as active
+ */
+function touch(client: string, now: number): ClientSubscriptions | undefined {
+  const entry = subscriptions.get(client);
+  if (!entry) {
+    return undefined;
+  }
+  subscriptions.delete(client);
+  if (now - entry.lastSeen > SUBSCRIPTION_TTL_MS) {
+    return undefined;
+  }
+  entry.lastSeen = now;
+  subscriptions.set(client, entry);
+  return entry;
+}
+
+export function subscribe(client: string, uri: string): void {
+  const now = Date.now();
+  let entry = touch(client, now);
+  if (!entry) {
+    // Make room by dropping expired clients, then the least recently active
+    for (const [key, other] of subscriptions) {
+      if (subscriptions.size < MAX_SUBSCRIBED_CLIENTS && now - other.lastSeen <= SUBSCRIPTION_TTL_MS) {
+        break;
+      }
+      subscriptions.delete(key);
+    }
+    entry = { uris: new Set(), lastSeen: now };
+    subscriptions.set(client, entry);
+  }
+  if (!entry.uris.has(uri) && entry.uris.size >= MAX_URIS_PER_CLIENT) {
+    throw new Error(`A client may subscribe to at most ${MAX_URIS_PER_CLIENT} resources`);
+  }
+  entry.uris.add(uri);
+}
+
+export function unsubscribe(client: string, uri: string): void {
+  const entry = touch(client, Date.now());
+  if (!entry) {
+    return;
+  }
+  entry.uris.delete(uri);
+  if (entry.uris.size === 0) {
+    subscriptions.delete(client);
+  }
+}
+
+function isSubscribed(client: string | null, uri: string): boolean {
+  return client !== null && (touch(client, Date.now())?.uris.has(uri) ?? false);
+}
+
+/**
+ * Notifications caused by a successful tool call.
+ * Creating or deleting a session changes the resource list; running, stopping,
+ * updating or uploading to one changes its resources, which subscribers hear about
+ */
+export function toolNotifications(client: string | null, name: string, args: any): JSONRPCNotification[] {
+  if (name === 'era_create_session' || name === 'era_delete_session') {
+    if (client !== null && name === 'era_delete_session' && args?.session_id) {
+      unsubscribe(client, `session://${args.session_id}`);
+      unsubscribe(client, `session://${args.session_id}/files`);
+    }
+    return [{ jsonrpc: '2.0', method: 'notifications/resources/list_changed' }];
+  }
+
+  const sessionId = args?.session_id;
+  if (!sessionId) {
+    return [];
+  }
+
+  let uris: string[];
+  switch (name) {
+    case 'era_run_in_session':
+      uris = [`session://${sessionId}`, `session://${sessionId}/files`];
+      break;
+    case 'era_stop_session':
+    case 'era_update_session':
+      uris = [`session://${sessionId}`];
+      break;
+    case 'era_upload_file':
+      uris = [`session://${sessionId}/files`];
+      if (args.path) {
+        // Encoded the same way as file resource URIs, so "my file.txt"
+        // reaches subscribers of session://{id}/files/my%20file.txt
+        uris.push(`session://${sessionId}/files/${encodeFilePath(args.path)}`);
+      }
+      break;
+    default:
+      return [];
+  }
+
+  return uris
+    .filter((uri) => isSubscribed(client, uri))
+    .map((uri): JSONRPCNotification => ({
+      jsonrpc: '2.0',
+      method: 'notifications/resources/updated',
+      params: { uri },
+    }));
+}
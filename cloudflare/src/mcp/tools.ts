@@ -9,11 +9,31 @@ import {
   handleGetSession as apiGetSession,
   handleListSessions as apiListSessions,
   handleDeleteSession as apiDeleteSession,
+  handleStopSession as apiStopSession,
   handleUpdateSession as apiUpdateSession,
   handleListSessionFiles as apiListSessionFiles,
   handleUploadSessionFile,
   handleDownloadSessionFile,
+  handleDownloadVMFile,
 } from '../index';
+import { runOutputKey } from '../session';
+import { encodeFilePath } from '../paths';
+
+/**
+ * Languages accepted by the execution and session tools
+ */
+export const SUPPORTED_LANGUAGES = ['python', 'node', 'typescript', 'go', 'deno'];
+
+/**
+ * Schema of the optional env argument of the language tools, with an example
+ * that fits the language
+ */
+function envProperty(example: string): { type: string; description: string } {
+  return {
+    type: 'object',
+    description: `Optional environment variables for this execution (e.g. ${example})`,
+  };
+}
 
 /**
  * Get list of all available MCP tools
@@ -31,6 +51,7 @@ export function getTools(): MCPTool[] {
             type: 'string',
             description: 'Python code to execute. Write clean Python code without extra escaping. Newlines and indentation are preserved.',
           },
+          env: envProperty('{"PYTHONWARNINGS": "ignore"}'),
           timeout: {
             type: 'number',
             description: 'Execution timeout in seconds (default: 30)',
@@ -39,6 +60,10 @@ export function getTools(): MCPTool[] {
             type: 'boolean',
             description: 'Allow internet access (default: true)',
           },
+          keep: {
+            type: 'boolean',
+            description: 'Keep the VM after execution and return its id, so files it wrote can be fetched with era_download_output (default: false)',
+          },
         },
         required: ['code'],
       },
@@ -53,6 +78,7 @@ export function getTools(): MCPTool[] {
             type: 'string',
             description: 'JavaScript/Node.js code to execute',
           },
+          env: envProperty('{"NODE_ENV": "production"}'),
           timeout: {
             type: 'number',
             description: 'Execution timeout in seconds (default: 30)',
@@ -75,6 +101,7 @@ export function getTools(): MCPTool[] {
             type: 'string',
             description: 'TypeScript code to execute',
           },
+          env: envProperty('{"NODE_ENV": "production"}'),
           timeout: {
             type: 'number',
             description: 'Execution timeout in seconds (default: 30)',
@@ -97,6 +124,30 @@ export function getTools(): MCPTool[] {
             type: 'string',
             description: 'Deno code to execute',
           },
+          env: envProperty('{"NO_COLOR": "1"}'),
+          timeout: {
+            type: 'number',
+            description: 'Execution timeout in seconds (default: 30)',
+          },
+          allowInternetAccess: {
+            type: 'boolean',
+            description: 'Allow internet access (default: true)',
+          },
+        },
+        required: ['code'],
+      },
+    },
+    {
+      name: 'era_go',
+      description: 'Execute Go code in an isolated environment. The code is saved as main.go inside a fresh module and run with go run, so it must declare package main and a main function. Example: package main; import "fmt"; func main() { fmt.Println("Hello from Go!") }',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          code: {
+            type: 'string',
+            description: 'Go source for main.go (package main with a main function)',
+          },
+          env: envProperty('{"GODEBUG": "gctrace=1"}'),
           timeout: {
             type: 'number',
             description: 'Execution timeout in seconds (default: 30)',
@@ -134,7 +185,7 @@ export function getTools(): MCPTool[] {
     // Core execution tools
     {
       name: 'era_execute_code',
-      description: 'Execute code in an ephemeral environment. Supports Python, Node.js, TypeScript, Go, and Deno. The environment is automatically cleaned up after execution. Prefer using language-specific tools (era_python, era_node, etc.) for simpler usage.',
+      description: 'Execute code in an ephemeral environment. Supports Python, Node.js, TypeScript, Go, and Deno. The environment is automatically cleaned up after execution unless keep is set. Prefer using language-specific tools (era_python, era_node, etc.) for simpler usage.',
       inputSchema: {
         type: 'object',
         properties: {
@@ -145,7 +196,7 @@ export function getTools(): MCPTool[] {
           language: {
             type: 'string',
             description: 'Programming language',
-            enum: ['python', 'node', 'typescript', 'go', 'deno'],
+            enum: SUPPORTED_LANGUAGES,
           },
           files: {
             type: 'object',
@@ -163,10 +214,36 @@ export function getTools(): MCPTool[] {
             type: 'boolean',
             description: 'Allow internet access (default: true)',
           },
+          keep: {
+            type: 'boolean',
+            description: 'Keep the VM after execution and return its id, so files it wrote can be fetched with era_download_output (default: false)',
+          },
         },
         required: ['code', 'language'],
       },
     },
+    {
+      name: 'era_download_output',
+      description: 'Read a file from the work directory of a VM kept by era_python or era_execute_code with keep: true. Text files are returned as-is, binary files as base64.',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          vm_id: {
+            type: 'string',
+            description: 'VM ID returned by an execution with keep: true',
+          },
+          path: {
+            type: 'string',
+            description: 'File path relative to the VM work directory',
+          },
+          cleanup: {
+            type: 'boolean',
+            description: 'Delete the VM after reading the file (default: false)',
+          },
+        },
+        required: ['vm_id', 'path'],
+      },
+    },
     {
       name: 'era_create_session',
       description: 'Create a persistent execution session. Sessions maintain state across multiple code executions.',
@@ -176,7 +253,7 @@ export function getTools(): MCPTool[] {
           language: {
             type: 'string',
             description: 'Programming language for the session',
-            enum: ['python', 'node', 'typescript', 'go', 'deno'],
+            enum: SUPPORTED_LANGUAGES,
           },
           persistent: {
             type: 'boolean',
@@ -190,6 +267,15 @@ export function getTools(): MCPTool[] {
             type: 'number',
             description: 'Default timeout for executions in this session (seconds)',
           },
+          repl: {
+            type: 'boolean',
+            description: 'Keep one python or node interpreter running for the session, so variables, imports and functions persist between era_run_in_session calls (default: false)',
+          },
+          packages: {
+            type: 'array',
+            items: { type: 'string' },
+            description: 'Packages to pip install (python) or npm install (node, typescript) when the session is created, e.g. ["requests", "pydantic>=2"]. Installation runs in the background; era_get_session reports setup_status and, on failure, the installer output in setup_result',
+          },
         },
         required: ['language'],
       },
@@ -256,6 +342,20 @@ export function getTools(): MCPTool[] {
         required: ['session_id'],
       },
     },
+    {
+      name: 'era_stop_session',
+      description: 'Stop a session without deleting it. Frees any VM still running for the session while keeping its files and data; the next era_run_in_session resumes it.',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          session_id: {
+            type: 'string',
+            description: 'Session ID to stop',
+          },
+        },
+        required: ['session_id'],
+      },
+    },
     {
       name: 'era_update_session',
       description: 'Update session configuration (timeout, network access, etc.)',
@@ -336,6 +436,24 @@ export function getTools(): MCPTool[] {
         required: ['session_id'],
       },
     },
+    {
+      name: 'era_get_output',
+      description: 'Get the stdout and stderr of the latest run in a session',
+      inputSchema: {
+        type: 'object',
+        properties: {
+          session_id: {
+            type: 'string',
+            description: 'Session ID to query',
+          },
+          tail: {
+            type: 'number',
+            description: 'Only return the last N lines of each stream (default: all)',
+          },
+        },
+        required: ['session_id'],
+      },
+    },
   ];
 }
 
@@ -347,7 +465,7 @@ export async function handleExecuteCode(
   env: Env,
   stub: DurableObjectStub
 ): Promise<MCPToolResponse> {
-  const { code, language, files, envs, timeout, allowInternetAccess } = args;
+  const { code, language, files, envs, timeout, allowInternetAccess, keep } = args;
 
   // Validate required arguments
   if (!code || !language) {
@@ -365,6 +483,7 @@ export async function handleExecuteCode(
       envs,
       timeout,
       allowInternetAccess,
+      keep,
     }),
   });
 
@@ -395,7 +514,7 @@ export async function handleCreateSession(
   env: Env,
   ctx: ExecutionContext
 ): Promise<MCPToolResponse> {
-  const { language, persistent, allowInternetAccess, default_timeout } = args;
+  const { language, persistent, allowInternetAccess, default_timeout, repl, packages } = args;
 
   if (!language) {
     throw new Error('Missing required argument: language');
@@ -410,6 +529,8 @@ export async function handleCreateSession(
       persistent,
       allowInternetAccess,
       default_timeout,
+      repl,
+      packages,
     }),
   });
 
@@ -425,7 +546,7 @@ export async function handleCreateSession(
     content: [
       {
         type: 'text',
-        text: `Session created successfully!\n\nSession ID: ${result.id}\nLanguage: ${result.language}\nPersistent: ${result.persistent}\n\nYou can now use era_run_in_session to execute code in this session.`,
+        text: `Session created successfully!\n\nSession ID: ${result.id}\nLanguage: ${result.language}\nPersistent: ${result.persistent}${result.repl ? '\nREPL: variables persist between runs' : ''}${result.setup_status === 'pending' ? '\nSetup: installing packages in the background; era_get_session shows setup_status' : ''}\n\nYou can now use era_run_in_session to execute code in this session.`,
       },
     ],
   };
@@ -568,6 +689,37 @@ export async function handleDeleteSession(
   };
 }
 
+/**
+ * Handle era_stop_session tool call
+ */
+export async function handleStopSessionTool(
+  args: any,
+  env: Env
+): Promise<MCPToolResponse> {
+  const { session_id } = args;
+
+  if (!session_id) {
+    throw new Error('Missing required argument: session_id');
+  }
+
+  const response = await apiStopSession(session_id, env);
+  const result = await response.json();
+
+  if (!response.ok) {
+    throw new Error(result.error || 'Failed to stop session');
+  }
+
+  const stoppedVMs = result.stopped_vms?.length || 0;
+  return {
+    content: [
+      {
+        type: 'text',
+        text: `Session ${session_id} stopped.\n\nStopped VMs: ${stoppedVMs}\nFiles and data are kept; run code in the session again to resume it.`,
+      },
+    ],
+  };
+}
+
 /**
  * Handle era_update_session tool call
  */
@@ -635,7 +787,7 @@ export async function handleUploadFile(
     throw new Error('Missing required arguments: session_id, path, and content');
   }
 
-  const apiRequest = new Request(`http://internal/api/sessions/${session_id}/files/${path}`, {
+  const apiRequest = new Request(`http://internal/api/sessions/${session_id}/files/${encodeFilePath(path)}`, {
     method: 'PUT',
     body: content,
   });
@@ -688,6 +840,58 @@ export async function handleReadFile(
   };
 }
 
+/**
+ * Handle era_download_output tool call
+ */
+export async function handleDownloadOutput(
+  args: any,
+  env: Env,
+  stub: DurableObjectStub
+): Promise<MCPToolResponse> {
+  const { vm_id, path, cleanup } = args;
+
+  if (!vm_id || !path) {
+    throw new Error('Missing required arguments: vm_id and path');
+  }
+
+  const response = await handleDownloadVMFile(vm_id, path, stub, cleanup === true);
+
+  if (!response.ok) {
+    throw new Error(`File not found or could not be read: ${path} (vm ${vm_id})`);
+  }
+
+  const { encoding, text } = encodeFileContent(await response.arrayBuffer());
+
+  return {
+    content: [
+      {
+        type: 'text',
+        text: `File: ${path}\nVM: ${vm_id}\nEncoding: ${encoding}\n\nContent:\n${text}`,
+      },
+    ],
+  };
+}
+
+/**
+ * Decode file bytes as UTF-8 text, falling back to base64 for binary
+ * content (invalid UTF-8 or NUL bytes)
+ */
+export function encodeFileContent(content: ArrayBuffer): { encoding: 'utf-8' | 'base64'; text: string } {
+  const bytes = new Uint8Array(content);
+  if (!bytes.includes(0)) {
+    try {
+      return { encoding: 'utf-8', text: new TextDecoder('utf-8', { fatal: true }).decode(bytes) };
+    } catch {
+      // Not valid UTF-8, so send it as base64
+    }
+  }
+  let binary = '';
+  for (let i = 0; i < bytes.length; i += 0x8000) {
+    binary += String.fromCharCode(...bytes.subarray(i, i + 0x8000));
+  }
+  return { encoding: 'base64', text: btoa(binary) };
+}
+
 /**
  * Handle era_list_files tool call
  */
@@ -733,6 +937,67 @@ export async function handleListFiles(
   };
 }
 
+/**
+ * Handle era_get_output tool call
+ */
+export async function handleGetOutput(
+  args: any,
+  env: Env
+): Promise<MCPToolResponse> {
+  const { session_id, tail } = args;
+
+  if (!session_id) {
+    throw new Error('Missing required argument: session_id');
+  }
+  if (tail !== undefined && (!Number.isInteger(tail) || tail < 0)) {
+    throw new Error('tail must be a non-negative integer');
+  }
+
+  const session = await apiGetSession(session_id, env);
+  if (!session.ok) {
+    const error = await session.json();
+    throw new Error(error.error || 'Session not found');
+  }
+
+  // A session that has not run yet has no logs, which reads as empty output
+  const readLog = async (stream: 'stdout' | 'stderr'): Promise<string> => {
+    const obj = await env.SESSIONS_BUCKET.get(runOutputKey(session_id, stream));
+    return obj ? tailLines(await obj.text(), tail || 0) : '';
+  };
+  const stdout = await readLog('stdout');
+  const stderr = await readLog('stderr');
+
+  let text = `Session: ${session_id}\n\n`;
+  text += `Stdout:\n${stdout}\n\n`;
+  if (stderr) {
+    text += `Stderr:\n${stderr}\n\n`;
+  }
+
+  return {
+    content: [
+      {
+        type: 'text',
+        text: text.trim(),
+      },
+    ],
+  };
+}
+
+/**
+ * Return the last n lines of text, all of it when n <= 0. A trailing
+ * newline does not count as an extra, empty line.
+ */
+export function tailLines(text: string, n: number): string {
+  if (n <= 0 || text === '') {
+    return text;
+  }
+  const lines = text.endsWith('\n') ? text.slice(0, -1).split('\n') : text.split('\n');
+  if (lines.length <= n) {
+    return text;
+  }
+  return lines.slice(-n).join('\n') + (text.endsWith('\n') ? '\n' : '');
+}
+
 /**
  * Format execution result for display
  */
@@ -743,6 +1008,10 @@ function formatExecutionResult(result: any): string {
     output += `Exit Code: ${result.exit_code}\n\n`;
   }
 
+  if (result.timed_out) {
+    output += `Timed Out: yes (killed at the run timeout)\n\n`;
+  }
+
   if (result.stdout) {
     output += `Stdout:\n${result.stdout}\n\n`;
   }
@@ -752,7 +1021,11 @@ function formatExecutionResult(result: any): string {
   }
 
   if (result.duration) {
-    output += `Duration: ${result.duration}`;
+    output += `Duration: ${result.duration}\n\n`;
+  }
+
+  if (result.kept && result.vm_id) {
+    output += `VM ID: ${result.vm_id} (kept; fetch output files with era_download_output)`;
   }
 
   return output.trim();
@@ -774,12 +1047,21 @@ function formatBytes(bytes: number): string {
  * These wrap handleExecuteCode with the language pre-filled
  */
 
+/**
+ * Build era_execute_code arguments for a language tool, forwarding the
+ * tool's `env` object as the execution's `envs`
+ */
+export function languageToolArgs(args: any, language: string): any {
+  const { env: envVars, ...rest } = args;
+  return { ...rest, language, envs: envVars ?? args.envs };
+}
+
 export async function handlePython(
   args: any,
   env: Env,
   stub: DurableObjectStub
 ): Promise<MCPToolResponse> {
-  return handleExecuteCode({ ...args, language: 'python' }, env, stub);
+  return handleExecuteCode(languageToolArgs(args, 'python'), env, stub);
 }
 
 export async function handleNode(
@@ -787,7 +1069,7 @@ export async function handleNode(
   env: Env,
   stub: DurableObjectStub
 ): Promise<MCPToolResponse> {
-  return handleExecuteCode({ ...args, language: 'node' }, env, stub);
+  return handleExecuteCode(languageToolArgs(args, 'node'), env, stub);
 }
 
 export async function handleTypeScript(
@@ -795,7 +1077,7 @@ export async function handleTypeScript(
   env: Env,
   stub: DurableObjectStub
 ): Promise<MCPToolResponse> {
-  return handleExecuteCode({ ...args, language: 'typescript' }, env, stub);
+  return handleExecuteCode(languageToolArgs(args, 'typescript'), env, stub);
 }
 
 export async function handleDeno(
@@ -803,7 +1085,15 @@ export async function handleDeno(
   env: Env,
   stub: DurableObjectStub
 ): Promise<MCPToolResponse> {
-  return handleExecuteCode({ ...args, language: 'deno' }, env, stub);
+  return handleExecuteCode(languageToolArgs(args, 'deno'), env, stub);
+}
+
+export async function handleGo(
+  args: any,
+  env: Env,
+  stub: DurableObjectStub
+): Promise<MCPToolResponse> {
+  return handleExecuteCode(languageToolArgs(args, 'go'), env, stub);
 }
 
 export async function handleShell(
@@ -14,6 +14,12 @@ export interface JSONRPCResponse {
   error?: JSONRPCError;
 }
 
+export interface JSONRPCNotification {
+  jsonrpc: '2.0';
+  method: string;
+  params?: any;
+}
+
 export interface JSONRPCError {
   code: number;
   message: string;
@@ -54,6 +60,13 @@ export interface MCPResource {
   mimeType?: string;
 }
 
+export interface MCPResourceTemplate {
+  uriTemplate: string;
+  name: string;
+  description?: string;
+  mimeType?: string;
+}
+
 export interface MCPResourceContent {
   uri: string;
   mimeType?: string;
@@ -0,0 +1,10 @@
+// Path helpers for building agent and API URLs
+
+/**
+ * Encode a relative file path for use in a URL, one segment at a time, so
+ * the slashes between directories survive and characters such as ?, # or %
+ * in a name do not end the path early
+ */
+export function encodeFilePath(path: string): string {
+  return path.split('/').map(encodeURIComponent).join('/');
+}
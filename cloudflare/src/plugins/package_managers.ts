@@ -3,7 +3,63 @@
  * Functions to install packages via pip, npm, go, etc.
  */
 
-import { PackageInstallResult } from './types';
+import { PackageInstallResult, SessionSetup } from './types';
+
+const PIP_INSTALL = 'pip install --target=/home/agent/.local/lib/python3.11/site-packages';
+
+/**
+ * Build the install command for a list of package specs, or null when the
+ * language has no package installer. Specs are deduplicated and quoted, so
+ * version constraints like `pydantic>=2` reach pip intact.
+ */
+export function packageInstallCommand(language: string, packages: string[]): string | null {
+  const specs = [...new Set(packages.map(spec => spec.trim()).filter(Boolean))];
+  const quoted = specs.map(spec => `'${spec.replace(/'/g, `'\\''`)}'`).join(' ');
+  switch (language) {
+    case 'python':
+      return `${PIP_INSTALL} ${quoted}`;
+    case 'node':
+    case 'typescript':
+      return `npm install ${quoted}`;
+    default:
+      return null;
+  }
+}
+
+// A spec is one word that cannot be mistaken for an installer option.
+function isPackageSpec(spec: unknown): boolean {
+  return typeof spec === 'string' && /^[^\s-]\S*$/.test(spec.trim());
+}
+
+/**
+ * Fold a session's `packages` list into its setup as pip or npm installs,
+ * depending on the language. Packages already listed are not added twice,
+ * so repeating one is harmless.
+ */
+export function mergeSessionPackages(
+  language: string,
+  setup: SessionSetup | undefined,
+  packages: unknown
+): { setup?: SessionSetup; error?: string } {
+  if (!Array.isArray(packages) || !packages.every(isPackageSpec)) {
+    return { error: 'packages must be a list of package names' };
+  }
+  if (packages.length === 0) {
+    return { setup };
+  }
+
+  const field = language === 'python' ? 'pip' : language === 'node' || language === 'typescript' ? 'npm' : null;
+  if (!field) {
+    return { error: 'packages are supported for python, node and typescript sessions' };
+  }
+  const existing = setup?.[field];
+  if (existing !== undefined && !Array.isArray(existing)) {
+    return { error: `packages cannot be combined with a setup.${field} manifest` };
+  }
+
+  const merged = [...new Set([...(existing || []), ...packages.map((spec: string) => spec.trim())])];
+  return { setup: { ...setup, [field]: merged } };
+}
 
 /**
  * Install Python packages via pip
@@ -22,7 +78,7 @@ export async function installPipPackages(
     // Install from array: pip install requests pandas
     // Use --target to explicitly specify installation directory, avoiding site-packages scanning
     packagesList.push(...packages);
-    command = `pip install --target=/home/agent/.local/lib/python3.11/site-packages ${packages.join(' ')}`;
+    command = packageInstallCommand('python', packages)!;
   } else {
     // Install from requirements.txt content
     // Write requirements.txt to VM, then install
@@ -34,7 +90,7 @@ export async function installPipPackages(
       body: reqBytes,
     }));
 
-    command = 'pip install --target=/home/agent/.local/lib/python3.11/site-packages -r requirements.txt';
+    command = `${PIP_INSTALL} -r requirements.txt`;
     // Parse package names from requirements
     packagesList.push(...reqContent.split('\n').filter(line => line.trim() && !line.startsWith('#')));
   }
@@ -77,7 +133,7 @@ export async function installNpmPackages(
   if (Array.isArray(packages)) {
     // Install from array: npm install express lodash
     packagesList.push(...packages);
-    command = `npm install ${packages.join(' ')}`;
+    command = packageInstallCommand('node', packages)!;
   } else {
     // Install from package.json content
     const pkgJson = packages.packageJson;
@@ -4,6 +4,7 @@
  */
 
 import { SessionSetup, SetupResult } from './types';
+import { encodeFilePath } from '../paths';
 import {
   installPipPackages,
   installNpmPackages,
@@ -214,7 +215,7 @@ async function extractFilesToR2(
 
     await Promise.all(batch.map(async (file) => {
       try {
-        const fileRes = await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${file.path}`, {
+        const fileRes = await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${encodeFilePath(file.path)}`, {
           method: 'GET',
         }));
 
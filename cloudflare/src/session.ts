@@ -6,6 +6,7 @@
 import { SessionSetup, SetupResult } from './plugins/types';
 import { runSessionSetup } from './plugins/session_setup';
 import { PYTHON_SDK, JAVASCRIPT_SDK } from './sdk/sdk-content';
+import { encodeFilePath } from './paths';
 
 export interface SessionMetadata {
   id: string;
@@ -23,11 +24,55 @@ export interface SessionMetadata {
   allowInternetAccess?: boolean;  // Allow outbound requests (default: true)
   allowPublicAccess?: boolean;    // Allow inbound requests via proxy (default: true)
   default_timeout?: number;       // Default timeout in seconds for code execution (default: 30)
+  status?: 'active' | 'stopped';  // Stopped sessions have no VMs; the next run resumes them
+  stopped_at?: string;
+  repl?: boolean;       // Runs go to one long-lived interpreter, so variables persist between runs
+  repl_vm_id?: string;  // The VM holding the REPL interpreter, while it is up
+}
+
+// The latest run's output is kept under its own R2 prefix, outside the
+// session's work dir, so it is neither injected into VMs nor listed as a file
+export const RUN_OUTPUT_PREFIX = 'run-output/';
+
+export function runOutputKey(sessionId: string, stream: 'stdout' | 'stderr'): string {
+  return `${RUN_OUTPUT_PREFIX}${sessionId}/${stream}.log`;
+}
+
+// How often a streamed run's output is rewritten to R2 while it runs
+const RUN_OUTPUT_SAVE_INTERVAL_MS = 1000;
+
+// How much of each stream a streamed run keeps; older output is dropped
+const RUN_OUTPUT_MAX_CHARS = 1024 * 1024;
+
+// The tail of one stream of a streamed run. Keeping it bounded also bounds
+// what each periodic save uploads, however long the run goes on.
+class RunOutputTail {
+  private text = '';
+  private dropped = false;
+
+  append(chunk: string): void {
+    this.text += chunk;
+    // Trim in batches so a run printing many short lines is not re-sliced
+    // on every line
+    if (this.text.length > 2 * RUN_OUTPUT_MAX_CHARS) {
+      this.text = this.text.slice(-RUN_OUTPUT_MAX_CHARS);
+      this.dropped = true;
+    }
+  }
+
+  toString(): string {
+    if (!this.dropped && this.text.length <= RUN_OUTPUT_MAX_CHARS) {
+      return this.text;
+    }
+    return `[output truncated, showing the last ${RUN_OUTPUT_MAX_CHARS} characters]\n` + this.text.slice(-RUN_OUTPUT_MAX_CHARS);
+  }
 }
 
 export class SessionDO {
   state: DurableObjectState;
   env: Env;
+  // VMs created by runs that have not been cleaned up yet
+  activeVMs = new Set<string>();
 
   constructor(state: DurableObjectState, env: Env) {
     this.state = state;
@@ -62,6 +107,11 @@ export class SessionDO {
       });
     }
 
+    // POST /stop - Stop in-flight VMs, keeping files and data
+    if (url.pathname === '/stop' && request.method === 'POST') {
+      return this.handleStop();
+    }
+
     // POST /run-setup - Run package installation asynchronously
     if (url.pathname === '/run-setup' && request.method === 'POST') {
       return this.handleRunSetup(request);
@@ -101,9 +151,19 @@ export class SessionDO {
         });
       }
 
+      // Running a stopped session resumes it
+      if (metadata.status === 'stopped') {
+        metadata.status = 'active';
+        await this.state.storage.put('metadata', metadata);
+      }
+
       // Get agent stub
       const agentStub = this.env.ERA_AGENT.get(this.env.ERA_AGENT.idFromName('primary'));
 
+      if (metadata.repl) {
+        return this.handleReplRun(metadata, code, timeout, agentStub);
+      }
+
       // Map TypeScript to node for VM creation (TypeScript runs on Node.js)
       const vmLanguage = metadata.language === 'typescript' ? 'node' : metadata.language;
 
@@ -129,6 +189,7 @@ export class SessionDO {
       }
 
       const { id: vmId } = await createRes.json() as { id: string };
+      this.activeVMs.add(vmId);
 
       try {
         // 2. INJECT: Upload files from R2 to VM
@@ -152,8 +213,7 @@ export class SessionDO {
           const tmpFile = `/tmp/code_${vmId}.ts`;
           command = `sh -c "echo '${codeBase64}' | base64 -d > ${tmpFile} && npx -y tsx ${tmpFile} && rm ${tmpFile}"`;
         } else if (metadata.language === 'go') {
-          const goFile = `/tmp/main_${vmId}.go`;
-          command = `sh -c "echo '${codeBase64}' | base64 -d > ${goFile} && go run ${goFile} && rm ${goFile}"`;
+          command = goRunCommand(codeBase64, vmId);
         } else if (metadata.language === 'deno') {
           const denoFile = `/tmp/code_${vmId}.ts`;
           command = `sh -c "echo '${codeBase64}' | base64 -d > ${denoFile} && /usr/local/bin/deno run --allow-read --allow-write ${denoFile} && rm ${denoFile}"`;
@@ -185,6 +245,7 @@ export class SessionDO {
           stdout: string;
           stderr: string;
           duration: string;
+          timed_out?: boolean;
         };
 
         // 3.5. EXTRACT DATA: Read session data from VM
@@ -194,10 +255,12 @@ export class SessionDO {
         if (metadata.persistent) {
           await this.extractFiles(vmId, metadata.id, agentStub);
         }
+        await this.saveRunOutput(metadata.id, result.stdout, result.stderr);
 
-        // 5. Update metadata with new data
+        // 5. Update metadata with new data (re-read so a concurrent stop is kept)
+        const currentMetadata = (await this.state.storage.get<SessionMetadata>('metadata')) || metadata;
         const updatedMetadata = {
-          ...metadata,
+          ...currentMetadata,
           last_run_at: new Date().toISOString(),
           data: updatedData !== null ? updatedData : metadata.data,
         };
@@ -213,6 +276,7 @@ export class SessionDO {
 
       } finally {
         // 6. Always cleanup VM
+        this.activeVMs.delete(vmId);
         await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, {
           method: 'DELETE',
         }));
@@ -229,6 +293,138 @@ export class SessionDO {
     }
   }
 
+  /**
+   * Run code in the session's REPL. Unlike other runs, the VM is kept between
+   * runs and the agent feeds each run to the same interpreter, so variables
+   * and imports persist. The VM is created on first use, and again if the
+   * agent no longer knows it; stopping the session frees it.
+   */
+  async handleReplRun(
+    metadata: SessionMetadata,
+    code: string,
+    timeout: number | undefined,
+    agentStub: any
+  ): Promise<Response> {
+    const evalInVM = (vmId: string) => agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/repl`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({
+        code,
+        timeout: timeout || metadata.default_timeout || 30,
+      }),
+    }));
+
+    let vmId = metadata.repl_vm_id;
+    let res = vmId ? await evalInVM(vmId) : undefined;
+    if (!res || res.status === 404) {
+      const createRes = await agentStub.fetch(new Request('http://agent/api/vm', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+          language: metadata.language,
+          network_mode: 'none',
+          persist: false,
+        }),
+      }));
+      if (!createRes.ok) {
+        const error = await createRes.text();
+        return new Response(JSON.stringify({ error: 'Failed to create VM', details: error }), {
+          status: 500,
+          headers: { 'Content-Type': 'application/json' },
+        });
+      }
+      vmId = (await createRes.json() as { id: string }).id;
+      if (metadata.persistent) {
+        await this.injectFiles(vmId, metadata.id, agentStub);
+      }
+      await this.state.storage.put('metadata', { ...metadata, repl_vm_id: vmId });
+      res = await evalInVM(vmId);
+    }
+
+    if (!res.ok) {
+      const error = await res.text();
+      return new Response(JSON.stringify({ error: 'Failed to run code in REPL', details: error }), {
+        status: res.status,
+        headers: { 'Content-Type': 'application/json' },
+      });
+    }
+
+    const { data: result } = await res.json() as {
+      data: {
+        exit_code: number;
+        stdout: string;
+        stderr: string;
+        duration: string;
+        started?: boolean;
+        exited?: boolean;
+        timed_out?: boolean;
+      };
+    };
+
+    await this.saveRunOutput(metadata.id, result.stdout, result.stderr);
+
+    const currentMetadata = (await this.state.storage.get<SessionMetadata>('metadata')) || metadata;
+    const updatedMetadata = {
+      ...currentMetadata,
+      last_run_at: new Date().toISOString(),
+    };
+    await this.state.storage.put('metadata', updatedMetadata);
+
+    return new Response(JSON.stringify({
+      ...result,
+      session_id: metadata.id,
+      vm_id: vmId,
+      data: updatedMetadata.data,
+    }), {
+      headers: { 'Content-Type': 'application/json' },
+    });
+  }
+
+  async handleStop(): Promise<Response> {
+    const metadata = await this.state.storage.get<SessionMetadata>('metadata');
+    if (!metadata) {
+      return new Response(JSON.stringify({ error: 'Session not found' }), {
+        status: 404,
+        headers: { 'Content-Type': 'application/json' },
+      });
+    }
+
+    // Runs tear their VM down when they finish; stopping frees any that are
+    // still running. R2 files and session data are left alone.
+    const agentStub = this.env.ERA_AGENT.get(this.env.ERA_AGENT.idFromName('primary'));
+    const stopped: string[] = [];
+    for (const vmId of this.activeVMs) {
+      const res = await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/stop`, {
+        method: 'POST',
+      }));
+      if (res.ok) {
+        stopped.push(vmId);
+      }
+    }
+    // A REPL session's VM outlives its runs, so it is removed here, along
+    // with the interpreter's state
+    if (metadata.repl_vm_id) {
+      const res = await agentStub.fetch(new Request(`http://agent/api/vm/${metadata.repl_vm_id}`, {
+        method: 'DELETE',
+      }));
+      if (res.ok) {
+        stopped.push(metadata.repl_vm_id);
+      }
+    }
+
+    const updated: SessionMetadata = {
+      ...metadata,
+      status: 'stopped',
+      stopped_at: new Date().toISOString(),
+      repl_vm_id: undefined,
+    };
+    await this.state.storage.put('metadata', updated);
+
+    return new Response(JSON.stringify({ ...updated, stopped_vms: stopped }), {
+      headers: { 'Content-Type': 'application/json' },
+    });
+  }
+
   async handleRunSetup(request: Request): Promise<Response> {
     try {
       const { sessionId, language, setup } = await request.json() as {
@@ -311,6 +507,12 @@ export class SessionDO {
         });
       }
 
+      // Running a stopped session resumes it
+      if (metadata.status === 'stopped') {
+        metadata.status = 'active';
+        await this.state.storage.put('metadata', metadata);
+      }
+
       // Get agent stub
       const agentStub = this.env.ERA_AGENT.get(this.env.ERA_AGENT.idFromName('primary'));
 
@@ -339,6 +541,7 @@ export class SessionDO {
       }
 
       const { id: vmId } = await createRes.json() as { id: string };
+      this.activeVMs.add(vmId);
 
       // 2. INJECT: Upload files from R2 to VM
       if (metadata.persistent) {
@@ -360,8 +563,7 @@ export class SessionDO {
         const tmpFile = `/tmp/code_${vmId}.ts`;
         command = `sh -c "echo '${codeBase64}' | base64 -d > ${tmpFile} && npx -y tsx ${tmpFile} && rm ${tmpFile}"`;
       } else if (metadata.language === 'go') {
-        const goFile = `/tmp/main_${vmId}.go`;
-        command = `sh -c "echo '${codeBase64}' | base64 -d > ${goFile} && go run ${goFile} && rm ${goFile}"`;
+        command = goRunCommand(codeBase64, vmId);
       } else if (metadata.language === 'deno') {
         const denoFile = `/tmp/code_${vmId}.ts`;
         command = `sh -c "echo '${codeBase64}' | base64 -d > ${denoFile} && /usr/local/bin/deno run --allow-read --allow-write ${denoFile} && rm ${denoFile}"`;
@@ -391,6 +593,7 @@ export class SessionDO {
 
       if (!streamRes.ok) {
         // Cleanup VM
+        this.activeVMs.delete(vmId);
         await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, { method: 'DELETE' }));
         return new Response(JSON.stringify({ error: 'Failed to stream execution' }), {
           status: 500,
@@ -404,12 +607,30 @@ export class SessionDO {
 
       // Process stream and cleanup
       (async () => {
+        // Output is saved as it streams, so era_get_output sees a run that
+        // is still going. The agent names each data line's stream in a
+        // preceding event line.
+        const stdout = new RunOutputTail();
+        const stderr = new RunOutputTail();
+        let current: 'stdout' | 'stderr' = 'stdout';
+        let lastSave = Date.now();
+        let unsaved = false;
+        const record = (line: string) => {
+          if (line.startsWith('event: ')) {
+            current = line.slice(7).trim() === 'stderr' ? 'stderr' : 'stdout';
+          } else if (line.startsWith('data: ')) {
+            (current === 'stderr' ? stderr : stdout).append(line.slice(6) + '\n');
+            unsaved = true;
+          }
+        };
+
         try {
           const reader = streamRes.body?.getReader();
           if (!reader) throw new Error('No stream body');
 
           const decoder = new TextDecoder();
           let buffer = '';
+          await this.saveRunOutput(metadata.id, '', '');
 
           while (true) {
             const { done, value } = await reader.read();
@@ -421,13 +642,21 @@ export class SessionDO {
 
             for (const line of lines) {
               if (line.trim()) {
+                record(line);
                 await writer.write(new TextEncoder().encode(line + '\n'));
               }
             }
+
+            if (unsaved && Date.now() - lastSave >= RUN_OUTPUT_SAVE_INTERVAL_MS) {
+              await this.saveRunOutput(metadata.id, stdout.toString(), stderr.toString());
+              lastSave = Date.now();
+              unsaved = false;
+            }
           }
 
           // Process any remaining buffer
           if (buffer.trim()) {
+            record(buffer);
             await writer.write(new TextEncoder().encode(buffer + '\n'));
           }
 
@@ -436,6 +665,8 @@ export class SessionDO {
         } finally {
           // 6. Extract session data after execution
           try {
+            await this.saveRunOutput(metadata.id, stdout.toString(), stderr.toString());
+
             const updatedData = await this.extractSessionData(vmId, agentStub);
             if (updatedData !== null) {
               const currentMetadata = await this.state.storage.get<SessionMetadata>('metadata');
@@ -458,6 +689,7 @@ export class SessionDO {
           }
 
           // 8. Cleanup VM
+          this.activeVMs.delete(vmId);
           await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, {
             method: 'DELETE',
           }));
@@ -512,7 +744,7 @@ export class SessionDO {
         const bytes = await content.arrayBuffer();
 
         // Upload to VM via Go agent file API
-        await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${path}`, {
+        await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${encodeFilePath(path)}`, {
           method: 'PUT',
           body: bytes,
         }));
@@ -543,7 +775,7 @@ export class SessionDO {
 
     for (const file of files) {
       // Download from VM
-      const fileRes = await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${file.path}`, {
+      const fileRes = await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${encodeFilePath(file.path)}`, {
         method: 'GET',
       }));
 
@@ -569,6 +801,12 @@ export class SessionDO {
     }
   }
 
+  // SAVE OUTPUT: Keep the latest run's output where era_get_output reads it
+  async saveRunOutput(sessionId: string, stdout: string, stderr: string): Promise<void> {
+    await this.env.SESSIONS_BUCKET.put(runOutputKey(sessionId, 'stdout'), stdout || '');
+    await this.env.SESSIONS_BUCKET.put(runOutputKey(sessionId, 'stderr'), stderr || '');
+  }
+
   // INJECT DATA: Write session data to special file .session_data.json
   async injectSessionData(vmId: string, data: Record<string, any>, agentStub: any): Promise<void> {
     const dataJson = JSON.stringify(data, null, 2);
@@ -593,7 +831,7 @@ export class SessionDO {
       const bytes = new TextEncoder().encode(content);
 
       // Upload to VM root directory
-      await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${path}`, {
+      await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}/files/${encodeFilePath(path)}`, {
         method: 'PUT',
         body: bytes,
       }));
@@ -652,6 +890,12 @@ export class SessionDO {
         });
       }
 
+      // Running a stopped session resumes it
+      if (metadata.status === 'stopped') {
+        metadata.status = 'active';
+        await this.state.storage.put('metadata', metadata);
+      }
+
       // Get agent stub
       const agentStub = this.env.ERA_AGENT.get(this.env.ERA_AGENT.idFromName('primary'));
 
@@ -680,6 +924,7 @@ export class SessionDO {
       }
 
       const { id: vmId } = await createRes.json() as { id: string };
+      this.activeVMs.add(vmId);
 
       try {
         // Inject files from R2
@@ -702,8 +947,7 @@ export class SessionDO {
           const tmpFile = `/tmp/code_${vmId}.ts`;
           command = `sh -c "echo '${codeBase64}' | base64 -d > ${tmpFile} && npx -y tsx ${tmpFile} && rm ${tmpFile}"`;
         } else if (metadata.language === 'go') {
-          const goFile = `/tmp/main_${vmId}.go`;
-          command = `sh -c "echo '${codeBase64}' | base64 -d > ${goFile} && go run ${goFile} && rm ${goFile}"`;
+          command = goRunCommand(codeBase64, vmId);
         } else if (metadata.language === 'deno') {
           const denoFile = `/tmp/code_${vmId}.ts`;
           command = `sh -c "echo '${codeBase64}' | base64 -d > ${denoFile} && /usr/local/bin/deno run --allow-read --allow-write ${denoFile} && rm ${denoFile}"`;
@@ -743,6 +987,7 @@ export class SessionDO {
           stdout: string;
           stderr: string;
           duration: string;
+          timed_out?: boolean;
         };
 
         // Extract updated session data
@@ -753,9 +998,10 @@ export class SessionDO {
           await this.extractFiles(vmId, metadata.id, agentStub);
         }
 
-        // Update metadata
+        // Update metadata (re-read so a concurrent stop is kept)
+        const currentMetadata = (await this.state.storage.get<SessionMetadata>('metadata')) || metadata;
         const updatedMetadata = {
-          ...metadata,
+          ...currentMetadata,
           last_run_at: new Date().toISOString(),
           data: updatedData !== null ? updatedData : metadata.data,
         };
@@ -781,6 +1027,7 @@ export class SessionDO {
 
       } finally {
         // Cleanup VM
+        this.activeVMs.delete(vmId);
         await agentStub.fetch(new Request(`http://agent/api/vm/${vmId}`, {
           method: 'DELETE',
         }));
@@ -798,6 +1045,16 @@ export class SessionDO {
   }
 }
 
+/**
+ * Build the command that runs a Go snippet. The code is written to main.go in
+ * its own directory and a throwaway module is initialised first, since
+ * `go run` refuses to build outside a module on recent toolchains.
+ */
+export function goRunCommand(codeBase64: string, vmId: string): string {
+  const goDir = `/tmp/go_${vmId}`;
+  return `sh -c "mkdir -p ${goDir} && cd ${goDir} && echo '${codeBase64}' | base64 -d > main.go && (go mod init era 2>/dev/null || true) && go run main.go && rm -rf ${goDir}"`;
+}
+
 function getInterpreter(language: string): string {
   switch (language) {
     case 'python': return 'python3';
@@ -0,0 +1,99 @@
+#!/bin/bash
+
+# Test that MCP initialize advertises degraded tools when the Worker has no
+# ERA_AGENT binding. Starts `wrangler dev` on a config without any bindings,
+# unless MCP_URL already points at such a Worker.
+
+set -e
+
+cd "$(dirname "$0")/.."
+
+echo "=========================================="
+echo "ERA Agent MCP Degraded Capabilities Test"
+echo "=========================================="
+
+if [ -z "$MCP_URL" ]; then
+  config=$(mktemp "${TMPDIR:-/tmp}/era-degraded-XXXXXX.toml")
+  cat > "$config" <<EOF
+name = "era-agent-degraded"
+main = "$PWD/src/index.ts"
+compatibility_date = "2024-10-01"
+compatibility_flags = ["nodejs_compat"]
+EOF
+  npx wrangler dev --config "$config" --local --port 9595 > /dev/null 2>&1 &
+  wrangler_pid=$!
+  trap 'kill $wrangler_pid 2>/dev/null; rm -f "$config"' EXIT
+  MCP_URL="http://localhost:9595/mcp/v1"
+
+  for _ in $(seq 60); do
+    if curl -s -o /dev/null -X OPTIONS "$MCP_URL"; then
+      break
+    fi
+    sleep 1
+  done
+fi
+echo "Endpoint: $MCP_URL"
+echo ""
+
+mcp_call() {
+  local id=$1
+  local method=$2
+  local params=$3
+
+  curl -s -X POST "$MCP_URL" \
+    -H "Content-Type: application/json" \
+    -d "{
+      \"jsonrpc\": \"2.0\",
+      \"id\": $id,
+      \"method\": \"$method\",
+      \"params\": $params
+    }"
+}
+
+# Test 1: initialize reports the missing VM service
+echo "Test 1: Degraded capabilities"
+echo "----------------------------------------"
+result=$(mcp_call 1 "initialize" '{
+  "protocolVersion": "0.1.0",
+  "clientInfo": {
+    "name": "test-script",
+    "version": "1.0.0"
+  }
+}')
+echo "$result" | jq '.result.capabilities'
+if echo "$result" | jq -e '.result.capabilities.tools.degraded == true and (.result.capabilities.tools.note | contains("ERA_AGENT")) and .result.capabilities.experimental.era.vmService == false' > /dev/null; then
+  echo "✅ degraded capabilities test passed"
+else
+  echo "❌ initialize did not advertise degraded tools without ERA_AGENT"
+  echo "$result"
+  exit 1
+fi
+echo ""
+
+# Test 2: Tools are still listed, but calls fail
+echo "Test 2: Tool calls fail without the VM service"
+echo "----------------------------------------"
+result=$(mcp_call 2 "tools/list" '{}')
+if ! echo "$result" | jq -e '.result.tools | length > 0' > /dev/null; then
+  echo "❌ tools/list returned no tools"
+  echo "$result"
+  exit 1
+fi
+result=$(mcp_call 3 "tools/call" '{
+  "name": "era_python",
+  "arguments": {
+    "code": "print(1)"
+  }
+}')
+if echo "$result" | jq -r '.error.message' | grep -q "VM service not available"; then
+  echo "✅ tool call failure test passed"
+else
+  echo "❌ era_python did not fail with \"VM service not available\""
+  echo "$result"
+  exit 1
+fi
+echo ""
+
+echo "=========================================="
+echo "All degraded-mode tests passed"
+echo "=========================================="
@@ -29,6 +29,25 @@ mcp_call() {
     }"
 }
 
+# Like mcp_call, but as the client identified by $mcp_session, accepting
+# notifications on an SSE stream
+mcp_session_call() {
+  local id=$1
+  local method=$2
+  local params=$3
+
+  curl -s -X POST "$MCP_URL" \
+    -H "Content-Type: application/json" \
+    -H "Accept: application/json, text/event-stream" \
+    -H "Mcp-Session-Id: $mcp_session" \
+    -d "{
+      \"jsonrpc\": \"2.0\",
+      \"id\": $id,
+      \"method\": \"$method\",
+      \"params\": $params
+    }"
+}
+
 # Test 1: Initialize
 echo "Test 1: Initialize connection"
 echo "----------------------------------------"
@@ -54,6 +73,27 @@ echo "Found $tool_count tools:"
 echo "$result" | jq -r '.result.tools[] | "  - \(.name): \(.description[:60])..."'
 echo ""
 
+# Test 2b: JSON-RPC batch
+echo "Test 2b: Batch request (tools/list + initialize)"
+echo "----------------------------------------"
+result=$(curl -s -X POST "$MCP_URL" \
+  -H "Content-Type: application/json" \
+  -d '[
+    {"jsonrpc": "2.0", "id": 21, "method": "tools/list", "params": {}},
+    {"jsonrpc": "2.0", "id": 22, "method": "initialize", "params": {"protocolVersion": "2024-11-05", "capabilities": {}}}
+  ]')
+
+if [ "$(echo "$result" | jq 'length')" = "2" ] \
+  && [ "$(echo "$result" | jq '.[0].id')" = "21" ] && echo "$result" | jq -e '.[0].result.tools' > /dev/null \
+  && [ "$(echo "$result" | jq '.[1].id')" = "22" ] && echo "$result" | jq -e '.[1].result.serverInfo' > /dev/null; then
+  echo "✅ batch test passed"
+else
+  echo "❌ batch test failed"
+  echo "$result"
+  exit 1
+fi
+echo ""
+
 # Test 3: Execute Python code
 echo "Test 3: Execute Python code (era_python)"
 echo "----------------------------------------"
@@ -67,6 +107,76 @@ result=$(mcp_call 3 "tools/call" '{
 echo "$result" | jq -r '.result.content[0].text'
 echo ""
 
+# Test 3b: Environment variables reach language tools
+echo "Test 3b: Pass env to era_python"
+echo "----------------------------------------"
+result=$(mcp_call 31 "tools/call" '{
+  "name": "era_python",
+  "arguments": {
+    "code": "import os\nprint(os.environ.get(\"ERA_TEST_VAR\"))",
+    "env": {"ERA_TEST_VAR": "from-env"}
+  }
+}')
+
+echo "$result" | jq -r '.result.content[0].text'
+if echo "$result" | jq -r '.result.content[0].text' | grep -q "from-env"; then
+  echo "✅ env test passed"
+else
+  echo "❌ env test failed"
+  exit 1
+fi
+echo ""
+
+# Test 3c: Keep the VM and download a file it wrote
+echo "Test 3c: era_python with keep, then era_download_output"
+echo "----------------------------------------"
+result=$(mcp_call 32 "tools/call" '{
+  "name": "era_python",
+  "arguments": {
+    "code": "open(\"report.txt\", \"w\").write(\"kept-output\")\nopen(\"blob.bin\", \"wb\").write(bytes([0, 255, 1]))",
+    "keep": true
+  }
+}')
+
+kept_vm=$(echo "$result" | jq -r '.result.content[0].text' | sed -n 's/^VM ID: \([^ ]*\) (kept.*/\1/p')
+if [ -z "$kept_vm" ]; then
+  echo "❌ keep test failed (no VM id returned)"
+  echo "$result"
+  exit 1
+fi
+echo "Kept VM: $kept_vm"
+
+result=$(mcp_call 33 "tools/call" "{
+  \"name\": \"era_download_output\",
+  \"arguments\": {
+    \"vm_id\": \"$kept_vm\",
+    \"path\": \"report.txt\"
+  }
+}")
+if ! echo "$result" | jq -r '.result.content[0].text' | grep -q "kept-output"; then
+  echo "❌ era_download_output failed to read report.txt"
+  echo "$result"
+  exit 1
+fi
+
+result=$(mcp_call 34 "tools/call" "{
+  \"name\": \"era_download_output\",
+  \"arguments\": {
+    \"vm_id\": \"$kept_vm\",
+    \"path\": \"blob.bin\",
+    \"cleanup\": true
+  }
+}")
+text=$(echo "$result" | jq -r '.result.content[0].text')
+if echo "$text" | grep -q "Encoding: base64" && echo "$text" | grep -q "AP8B"; then
+  echo "✅ keep/download test passed"
+else
+  echo "❌ era_download_output did not return binary content as base64"
+  echo "$result"
+  exit 1
+fi
+echo ""
+
 # Test 4: Execute Node.js code
 echo "Test 4: Execute Node.js code (era_node)"
 echo "----------------------------------------"
@@ -80,6 +190,25 @@ result=$(mcp_call 4 "tools/call" '{
 echo "$result" | jq -r '.result.content[0].text'
 echo ""
 
+# Test 4b: Execute Go code
+echo "Test 4b: Execute Go code (era_go)"
+echo "----------------------------------------"
+result=$(mcp_call 41 "tools/call" '{
+  "name": "era_go",
+  "arguments": {
+    "code": "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"Sum:\", 1+2+3+4+5)\n}"
+  }
+}')
+
+echo "$result" | jq -r '.result.content[0].text'
+if echo "$result" | jq -r '.result.content[0].text' | grep -q "Sum: 15"; then
+  echo "✅ era_go test passed"
+else
+  echo "❌ era_go test failed"
+  exit 1
+fi
+echo ""
+
 # Test 5: Create a session
 echo "Test 5: Create persistent session"
 echo "----------------------------------------"
@@ -92,7 +221,7 @@ result=$(mcp_call 5 "tools/call" '{
 }')
 
 session_info=$(echo "$result" | jq -r '.result.content[0].text')
-session_id=$(echo "$session_info" | grep -o '"id":"[^"]*"' | cut -d'"' -f4)
+session_id=$(echo "$session_info" | sed -n 's/^Session ID: //p')
 echo "Created session: $session_id"
 echo ""
 
@@ -124,6 +253,81 @@ result=$(mcp_call 7 "tools/call" "{
 echo "$result" | jq -r '.result.content[0].text'
 echo ""
 
+# Test 7b: REPL session keeps variables in the interpreter
+echo "Test 7b: REPL session (repl: true)"
+echo "----------------------------------------"
+result=$(mcp_call 71 "tools/call" '{
+  "name": "era_create_session",
+  "arguments": {
+    "language": "python",
+    "repl": true
+  }
+}')
+repl_session=$(echo "$result" | jq -r '.result.content[0].text' | sed -n 's/^Session ID: //p')
+echo "Created REPL session: $repl_session"
+
+mcp_call 72 "tools/call" "{
+  \"name\": \"era_run_in_session\",
+  \"arguments\": {
+    \"session_id\": \"$repl_session\",
+    \"code\": \"counter = 41\"
+  }
+}" > /dev/null
+result=$(mcp_call 73 "tools/call" "{
+  \"name\": \"era_run_in_session\",
+  \"arguments\": {
+    \"session_id\": \"$repl_session\",
+    \"code\": \"print(counter + 1)\"
+  }
+}")
+echo "$result" | jq -r '.result.content[0].text'
+if echo "$result" | jq -r '.result.content[0].text' | grep -q "42"; then
+  echo "✅ REPL session test passed"
+else
+  echo "❌ REPL session lost its variable between runs"
+  exit 1
+fi
+mcp_call 74 "tools/call" "{
+  \"name\": \"era_delete_session\",
+  \"arguments\": { \"session_id\": \"$repl_session\" }
+}" > /dev/null
+echo ""
+
+# Test 7c: Subscribers hear about changes to a session
+echo "Test 7c: resources/subscribe and notifications/resources/updated"
+echo "----------------------------------------"
+mcp_session=$(curl -s -D - -o /dev/null -X POST "$MCP_URL" \
+  -H "Content-Type: application/json" \
+  -d '{"jsonrpc": "2.0", "id": 75, "method": "initialize", "params": {"protocolVersion": "0.1.0", "clientInfo": {"name": "test-script", "version": "1.0.0"}}}' \
+  | tr -d '\r' | sed -n 's/^[Mm]cp-[Ss]ession-[Ii]d: //p')
+if [ -z "$mcp_session" ]; then
+  echo "❌ initialize did not return an Mcp-Session-Id"
+  exit 1
+fi
+result=$(mcp_call 76 "resources/subscribe" "{\"uri\": \"session://$session_id\"}")
+if ! echo "$result" | jq -e '.error' > /dev/null; then
+  echo "❌ resources/subscribe without an Mcp-Session-Id was accepted"
+  echo "$result"
+  exit 1
+fi
+mcp_session_call 77 "resources/subscribe" "{\"uri\": \"session://$session_id\"}" > /dev/null
+result=$(mcp_session_call 78 "tools/call" "{
+  \"name\": \"era_run_in_session\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\",
+    \"code\": \"print('notify')\"
+  }
+}")
+if echo "$result" | grep '"method":"notifications/resources/updated"' | grep -q "\"uri\":\"session://$session_id\""; then
+  echo "✅ resource subscription test passed"
+else
+  echo "❌ the run did not notify the session's subscriber"
+  echo "$result"
+  exit 1
+fi
+mcp_session_call 79 "resources/unsubscribe" "{\"uri\": \"session://$session_id\"}" > /dev/null
+echo ""
+
 # Test 8: List sessions
 echo "Test 8: List all sessions"
 echo "----------------------------------------"
@@ -135,6 +339,105 @@ result=$(mcp_call 8 "tools/call" '{
 echo "$result" | jq -r '.result.content[0].text'
 echo ""
 
+# Test 8b: Read session resources by URI
+echo "Test 8b: resources/read for session://id and session://id/files"
+echo "----------------------------------------"
+result=$(mcp_call 81 "resources/read" "{\"uri\": \"session://$session_id\"}")
+if ! echo "$result" | jq -r '.result.contents[0].text' | jq -e ".id == \"$session_id\"" > /dev/null; then
+  echo "❌ session://$session_id did not return the session"
+  echo "$result"
+  exit 1
+fi
+result=$(mcp_call 82 "resources/read" "{\"uri\": \"session://$session_id/files\"}")
+if [ "$(echo "$result" | jq -r '.result.contents[0].uri')" != "session://$session_id/files" ]; then
+  echo "❌ session://$session_id/files did not return the file list"
+  echo "$result"
+  exit 1
+fi
+result=$(mcp_call 83 "resources/read" "{\"uri\": \"session://$session_id/bogus\"}")
+if echo "$result" | jq -e '.error' > /dev/null; then
+  echo "✅ resource URI test passed"
+else
+  echo "❌ session://$session_id/bogus was not rejected"
+  echo "$result"
+  exit 1
+fi
+echo ""
+
+# Test 8c: Read one file as a resource
+echo "Test 8c: resources/read for session://id/files/<path>"
+echo "----------------------------------------"
+mcp_call 84 "tools/call" "{
+  \"name\": \"era_upload_file\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\",
+    \"path\": \"notes/hello.txt\",
+    \"content\": \"hello from a resource\"
+  }
+}" > /dev/null
+result=$(mcp_call 85 "resources/read" "{\"uri\": \"session://$session_id/files/notes/hello.txt\"}")
+if [ "$(echo "$result" | jq -r '.result.contents[0].text')" != "hello from a resource" ] \
+  || [ "$(echo "$result" | jq -r '.result.contents[0].mimeType')" != "text/plain" ]; then
+  echo "❌ file resource did not return the uploaded content"
+  echo "$result"
+  exit 1
+fi
+for bad in "../other/secret.txt" "notes/..%2F..%2Fother" "notes//hello.txt"; do
+  result=$(mcp_call 86 "resources/read" "{\"uri\": \"session://$session_id/files/$bad\"}")
+  if ! echo "$result" | jq -e '.error' > /dev/null; then
+    echo "❌ traversal URI files/$bad was not rejected"
+    echo "$result"
+    exit 1
+  fi
+done
+result=$(mcp_call 87 "resources/templates/list" '{}')
+if echo "$result" | jq -e '.result.resourceTemplates[] | select(.uriTemplate == "session://{session_id}/files/{path}")' > /dev/null; then
+  echo "✅ file resource test passed"
+else
+  echo "❌ resources/templates/list is missing the file template"
+  echo "$result"
+  exit 1
+fi
+echo ""
+
+# Test 8d: Tail the latest run's output
+echo "Test 8d: era_get_output with tail"
+echo "----------------------------------------"
+mcp_call 88 "tools/call" "{
+  \"name\": \"era_run_in_session\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\",
+    \"code\": \"for i in range(1, 5):\\n    print(f'line {i}')\"
+  }
+}" > /dev/null
+result=$(mcp_call 89 "tools/call" "{
+  \"name\": \"era_get_output\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\",
+    \"tail\": 2
+  }
+}")
+text=$(echo "$result" | jq -r '.result.content[0].text')
+if echo "$text" | grep -q "line 3" && echo "$text" | grep -q "line 4" && ! echo "$text" | grep -q "line 2"; then
+  echo "✅ era_get_output test passed"
+else
+  echo "❌ era_get_output did not return the last 2 lines"
+  echo "$result"
+  exit 1
+fi
+result=$(mcp_call 90 "tools/call" "{
+  \"name\": \"era_list_files\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\"
+  }
+}")
+if echo "$result" | jq -r '.result.content[0].text' | grep -q "stdout.log"; then
+  echo "❌ the run's output logs show up as session files"
+  echo "$result"
+  exit 1
+fi
+echo ""
+
 # Test 9: Shell command
 echo "Test 9: Execute shell command (era_shell)"
 echo "----------------------------------------"
@@ -161,6 +464,31 @@ result=$(mcp_call 10 "tools/call" '{
 echo "$result" | jq -r '.result.content[0].text'
 echo ""
 
+# Test 10b: Stop session
+echo "Test 10b: Stop session (era_stop_session)"
+echo "----------------------------------------"
+result=$(mcp_call 101 "tools/call" "{
+  \"name\": \"era_stop_session\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\"
+  }
+}")
+
+echo "$result" | jq -r '.result.content[0].text'
+result=$(mcp_call 102 "tools/call" "{
+  \"name\": \"era_get_session\",
+  \"arguments\": {
+    \"session_id\": \"$session_id\"
+  }
+}")
+if echo "$result" | jq -r '.result.content[0].text' | jq -e '.status == "stopped"' > /dev/null; then
+  echo "✅ era_stop_session test passed"
+else
+  echo "❌ era_stop_session test failed (session not marked stopped)"
+  exit 1
+fi
+echo ""
+
 # Test 11: Delete session
 echo "Test 11: Delete session"
 echo "----------------------------------------"
@@ -174,6 +502,50 @@ result=$(mcp_call 11 "tools/call" "{
 echo "$result" | jq -r '.result.content[0].text'
 echo ""
 
+# Test 11b: Session set changes emit list_changed notifications
+echo "Test 11b: Resource list_changed notifications"
+echo "----------------------------------------"
+# Accepting an event stream lets the server send notifications ahead of the result
+mcp_stream_call() {
+  curl -s -X POST "$MCP_URL" \
+    -H "Content-Type: application/json" \
+    -H "Accept: application/json, text/event-stream" \
+    -d "{
+      \"jsonrpc\": \"2.0\",
+      \"id\": $1,
+      \"method\": \"tools/call\",
+      \"params\": $2
+    }"
+}
+list_changed='data: {"jsonrpc":"2.0","method":"notifications/resources/list_changed"}'
+
+stream=$(mcp_stream_call 111 '{
+  "name": "era_create_session",
+  "arguments": {
+    "language": "python"
+  }
+}')
+notify_session=$(echo "$stream" | grep -o 'Session ID: [^\\]*' | head -1 | cut -d' ' -f3)
+if ! echo "$stream" | grep -qF "$list_changed"; then
+  echo "❌ era_create_session did not emit list_changed"
+  echo "$stream"
+  exit 1
+fi
+
+stream=$(mcp_stream_call 112 "{
+  \"name\": \"era_delete_session\",
+  \"arguments\": {
+    \"session_id\": \"$notify_session\"
+  }
+}")
+if ! echo "$stream" | grep -qF "$list_changed"; then
+  echo "❌ era_delete_session did not emit list_changed"
+  echo "$stream"
+  exit 1
+fi
+echo "✅ list_changed notification test passed"
+echo ""
+
 # Test 12: List resources
 echo "Test 12: List resources"
 echo "----------------------------------------"
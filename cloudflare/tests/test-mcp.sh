@@ -46,6 +46,19 @@ else
 fi
 echo ""
 
+# Test 1b: Capabilities reflect runtime state
+echo "Test 1b: Capabilities Negotiation"
+echo "---------------------------------"
+echo "$RESPONSE" | jq '.result.capabilities'
+
+if echo "$RESPONSE" | jq -e '.result.capabilities.experimental.era.vmService == true and (.result.capabilities.experimental.era.languages | index("python")) != null and (.result.capabilities.tools.degraded // false) == false' > /dev/null; then
+  echo "✅ Capabilities test passed"
+else
+  echo "❌ Capabilities test failed (expected VM service available and python advertised)"
+  exit 1
+fi
+echo ""
+
 # Test 2: List Tools
 echo "Test 2: List Tools"
 echo "------------------"
@@ -273,6 +273,55 @@ else
     echo "❌ Test 6 FAILED (setup)"
 fi
 
+echo ""
+echo ""
+
+# Test 7: packages shorthand on session create
+echo "📦 Test 7: packages shorthand"
+echo "-------------------------------"
+session_id="test-packages-${TIMESTAMP}"
+
+echo "Creating session with packages: requests, pydantic>=2 (requests listed twice)..."
+response=$(curl -s -X POST "$API_URL/api/sessions" \
+    -H "Content-Type: application/json" \
+    -d "{
+        \"language\": \"python\",
+        \"session_id\": \"$session_id\",
+        \"packages\": [\"requests\", \"pydantic>=2\"],
+        \"setup\": {
+            \"pip\": [\"requests\"]
+        }
+    }")
+
+echo "$response" | jq '{id, setup_status, setup}'
+
+if [ "$(echo "$response" | jq -c '.setup.pip')" != '["requests","pydantic>=2"]' ]; then
+    echo "❌ Test 7 FAILED (packages not merged into setup.pip)"
+elif wait_for_setup "$session_id" 120; then
+    result=$(curl -s -X POST "$API_URL/api/sessions/$session_id/run" \
+        -H "Content-Type: application/json" \
+        -d '{"code": "import pydantic\nprint(pydantic.VERSION.split(\".\")[0])"}')
+
+    if [ "$(echo "$result" | jq -r '.stdout' | tr -d '[:space:]')" = "2" ]; then
+        echo "✅ Test 7 PASSED"
+    else
+        echo "❌ Test 7 FAILED"
+        echo "$result" | jq
+    fi
+else
+    echo "❌ Test 7 FAILED (setup)"
+fi
+
+echo "Creating a go session with packages (should be rejected)..."
+status=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$API_URL/api/sessions" \
+    -H "Content-Type: application/json" \
+    -d '{"language": "go", "packages": ["github.com/gin-gonic/gin"]}')
+if [ "$status" = "400" ]; then
+    echo "✅ Test 7b PASSED"
+else
+    echo "❌ Test 7b FAILED (status $status)"
+fi
+
 echo ""
 echo ""
 echo "=================================="
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// accountingSchemaVersion is bumped whenever AccountingRecord changes in a
+	// way aggregators need to know about.
+	accountingSchemaVersion = 1
+
+	accountingSinkLog = "log"
+
+	accountingSampleInterval = time.Second
+)
+
+// AccountingRecord is the per-run usage record written for chargeback. Unlike
+// metrics it is never aggregated, and unlike the shell audit it carries no
+// command content.
+type AccountingRecord struct {
+	Schema        int       `json:"schema"`
+	Timestamp     time.Time `json:"timestamp"`
+	VMID          string    `json:"vm_id"`
+	Owner         string    `json:"owner"`
+	Language      string    `json:"language"`
+	DurationMS    int64     `json:"duration_ms"`
+	PeakMemoryMiB *float64  `json:"peak_memory_mib,omitempty"`
+	ExitCode      int       `json:"exit_code"`
+	Status        string    `json:"status"`
+}
+
+// runAccountant writes AccountingRecords to the sink named by
+// AGENT_ACCOUNTING_SINK: "log" emits them through the agent logger, any other
+// value is a file that receives one JSON object per line. A nil
+// *runAccountant is valid and records nothing.
+type runAccountant struct {
+	mu     sync.Mutex
+	logger *Logger
+	file   *os.File
+
+	sampleInterval time.Duration
+}
+
+func newRunAccountant(logger *Logger) (*runAccountant, error) {
+	sink := strings.TrimSpace(os.Getenv("AGENT_ACCOUNTING_SINK"))
+	switch sink {
+	case "":
+		return nil, nil
+	case accountingSinkLog:
+		return &runAccountant{logger: logger, sampleInterval: accountingSampleInterval}, nil
+	}
+
+	file, err := os.OpenFile(sink, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	return &runAccountant{logger: logger, file: file, sampleInterval: accountingSampleInterval}, nil
+}
+
+func (a *runAccountant) Close() error {
+	if a == nil || a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+func (a *runAccountant) emit(record AccountingRecord) {
+	if a == nil {
+		return
+	}
+	record.Schema = accountingSchemaVersion
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		fields := map[string]any{
+			"schema":      record.Schema,
+			"timestamp":   record.Timestamp.Format(time.RFC3339Nano),
+			"vm_id":       record.VMID,
+			"owner":       record.Owner,
+			"language":    record.Language,
+			"duration_ms": record.DurationMS,
+			"exit_code":   record.ExitCode,
+			"status":      record.Status,
+		}
+		if record.PeakMemoryMiB != nil {
+			fields["peak_memory_mib"] = *record.PeakMemoryMiB
+		}
+		a.logger.Info("run accounting", fields)
+		return
+	}
+
+	line, err := json.Marshal(record)
+	if err == nil {
+		_, err = a.file.Write(append(line, '\n'))
+	}
+	if err != nil {
+		a.logger.Warn("failed to write accounting record", map[string]any{
+			"vm":    record.VMID,
+			"error": err.Error(),
+		})
+	}
+}
+
+// sampleMemory polls the launcher for the VM's memory use until the returned
+// stop function is called, which reports the peak seen, if any.
+func (a *runAccountant) sampleMemory(ctx context.Context, launcher VMLauncher, record VMRecord) func() *float64 {
+	if a == nil {
+		return func() *float64 { return nil }
+	}
+
+	var (
+		mu      sync.Mutex
+		peak    float64
+		sampled bool
+	)
+	sample := func() {
+		stats, err := launcher.Stats(ctx, record)
+		if err != nil {
+			return
+		}
+		mu.Lock()
+		if !sampled || stats.MemoryMiB > peak {
+			peak = stats.MemoryMiB
+			sampled = true
+		}
+		mu.Unlock()
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(a.sampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				sample()
+			}
+		}
+	}()
+
+	return func() *float64 {
+		close(done)
+		<-stopped
+		mu.Lock()
+		defer mu.Unlock()
+		if !sampled {
+			return nil
+		}
+		value := peak
+		return &value
+	}
+}
+
+// observeRun records a finished run.
+func (a *runAccountant) observeRun(record VMRecord, result VMRunResult, err error, started time.Time, duration time.Duration, peak *float64) {
+	// Runs rejected before reaching the guest use no resources.
+	if a == nil || (err != nil && result.StdoutPath == "") {
+		return
+	}
+
+	status := "ok"
+	switch {
+	case result.Aborted:
+		status = "aborted"
+	case result.TimedOut:
+		status = "timeout"
+	case err != nil || result.ExitCode != 0:
+		status = "failed"
+	}
+
+	a.emit(AccountingRecord{
+		Timestamp:     started.UTC(),
+		VMID:          record.ID,
+		Owner:         record.Owner,
+		Language:      record.Language,
+		DurationMS:    duration.Milliseconds(),
+		PeakMemoryMiB: peak,
+		ExitCode:      result.ExitCode,
+		Status:        status,
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunEmitsAccountingRecord(t *testing.T) {
+	launcher := newFakeLauncher()
+	launcher.statsFn = func(ctx context.Context, record VMRecord) (VMStats, error) {
+		return VMStats{PID: 42, MemoryMiB: 48.5}, nil
+	}
+	svc := newTestVMService(t, launcher)
+
+	sinkPath := filepath.Join(t.TempDir(), "accounting.jsonl")
+	t.Setenv("AGENT_ACCOUNTING_SINK", sinkPath)
+	accounting, err := newRunAccountant(svc.logger)
+	if err != nil {
+		t.Fatalf("open accounting sink: %v", err)
+	}
+	accounting.sampleInterval = 10 * time.Millisecond
+	svc.accounting = accounting
+
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Owner:       "team-data",
+	})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	before := time.Now().UTC()
+	if _, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "sleep 0.1; exit 2", Timeout: 5}); err == nil {
+		t.Fatal("expected non-zero exit to fail the run")
+	}
+	if err := svc.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	raw, err := os.ReadFile(sinkPath)
+	if err != nil {
+		t.Fatalf("read sink: %v", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(raw), []byte("\n"))
+	if len(lines) != 1 {
+		t.Fatalf("expected one accounting record, got %d: %s", len(lines), raw)
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(lines[0], &fields); err != nil {
+		t.Fatalf("decode record: %v", err)
+	}
+	for _, key := range []string{"schema", "timestamp", "vm_id", "owner", "language", "duration_ms", "peak_memory_mib", "exit_code", "status"} {
+		if _, ok := fields[key]; !ok {
+			t.Fatalf("record missing %q: %s", key, lines[0])
+		}
+	}
+
+	var got AccountingRecord
+	if err := json.Unmarshal(lines[0], &got); err != nil {
+		t.Fatalf("decode record: %v", err)
+	}
+	if got.Schema != accountingSchemaVersion || got.VMID != record.ID || got.Owner != "team-data" || got.Language != "python" {
+		t.Fatalf("unexpected identity fields: %+v", got)
+	}
+	if got.ExitCode != 2 || got.Status != "failed" {
+		t.Fatalf("exit_code/status = %d/%s, want 2/failed", got.ExitCode, got.Status)
+	}
+	if got.DurationMS < 100 {
+		t.Fatalf("duration_ms = %d, want at least 100", got.DurationMS)
+	}
+	if got.PeakMemoryMiB == nil || *got.PeakMemoryMiB != 48.5 {
+		t.Fatalf("peak_memory_mib = %v, want 48.5", got.PeakMemoryMiB)
+	}
+	if got.Timestamp.Before(before.Add(-time.Second)) {
+		t.Fatalf("timestamp %s predates the run", got.Timestamp)
+	}
+}
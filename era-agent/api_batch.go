@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const (
+	// maxBatchCreate caps the VMs one batch request may ask for.
+	maxBatchCreate = 64
+	// batchCreateConcurrency caps the creates of one batch running at once,
+	// since every one of them pulls and boots a VM.
+	batchCreateConcurrency = 8
+)
+
+// BatchCreateRequest asks for Count copies of Template. Alternatively the
+// body is a JSON array of create requests, one per VM.
+type BatchCreateRequest struct {
+	Count    int         `json:"count"`
+	Template *APIRequest `json:"template"`
+	// Rollback cleans the VMs that were created when any item fails.
+	Rollback bool `json:"rollback,omitempty"`
+}
+
+// BatchCreateItem reports the outcome of one spec, in request order.
+type BatchCreateItem struct {
+	Index      int     `json:"index"`
+	Success    bool    `json:"success"`
+	VM         *VMInfo `json:"vm,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	StatusCode int     `json:"status_code"`
+}
+
+// BatchCreateResult is the /api/vms/batch response.
+type BatchCreateResult struct {
+	Items   []BatchCreateItem `json:"items"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+	// RolledBack is set when the created VMs were cleaned again because an
+	// item failed and rollback was requested.
+	RolledBack bool `json:"rolled_back,omitempty"`
+}
+
+// parseBatchCreate reads either form of the batch body into one request per
+// VM.
+func parseBatchCreate(body io.Reader) ([]APIRequest, bool, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, false, err
+	}
+	data = bytes.TrimSpace(data)
+
+	var specs []APIRequest
+	rollback := false
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &specs); err != nil {
+			return nil, false, errors.New("invalid JSON")
+		}
+	} else {
+		var req BatchCreateRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			return nil, false, errors.New("invalid JSON")
+		}
+		if req.Template == nil {
+			return nil, false, errors.New("template is required with count")
+		}
+		if req.Count < 1 {
+			return nil, false, errors.New("count must be at least 1")
+		}
+		if req.Count > 1 && req.Template.Name != "" {
+			return nil, false, errors.New("template name would make every VM the same; omit it or send an array of specs")
+		}
+		if req.Count > maxBatchCreate {
+			return nil, false, fmt.Errorf("count %d exceeds the batch maximum of %d", req.Count, maxBatchCreate)
+		}
+		specs = make([]APIRequest, req.Count)
+		for i := range specs {
+			specs[i] = *req.Template
+		}
+		rollback = req.Rollback
+	}
+
+	if len(specs) == 0 {
+		return nil, false, errors.New("batch is empty")
+	}
+	if len(specs) > maxBatchCreate {
+		return nil, false, fmt.Errorf("batch of %d exceeds the maximum of %d", len(specs), maxBatchCreate)
+	}
+	return specs, rollback, nil
+}
+
+// handleBatchCreateVMs creates several VMs concurrently and reports each
+// one. Items fail independently: the successful VMs are kept unless
+// rollback was requested.
+func (api *APIServer) handleBatchCreateVMs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	specs, rollback, err := parseBatchCreate(r.Body)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if max := resourceLimitsFromEnv().MaxVMs; max > 0 && len(specs) > max {
+		api.sendJSONError(w, fmt.Sprintf("%v: batch of %d exceeds AGENT_MAX_VMS=%d", errVMLimit, len(specs), max), http.StatusBadRequest)
+		return
+	}
+
+	result := BatchCreateResult{Items: make([]BatchCreateItem, len(specs))}
+	records := make([]VMRecord, len(specs))
+	sem := make(chan struct{}, batchCreateConcurrency)
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		result.Items[i].Index = i
+		opts, err := createOptionsFromRequest(r, spec)
+		if err != nil {
+			result.Items[i].Error = err.Error()
+			result.Items[i].StatusCode = http.StatusBadRequest
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, opts VMCreateOptions) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			record, err := api.vmService.Create(r.Context(), opts)
+			if err != nil {
+				result.Items[i].Error = err.Error()
+				result.Items[i].StatusCode = statusCodeForVMError(err)
+				return
+			}
+			records[i] = record
+			info := vmRecordToInfo(record)
+			info.Timings = createTimingsToInfo(record.CreateTimings)
+			result.Items[i].Success = true
+			result.Items[i].VM = &info
+			result.Items[i].StatusCode = http.StatusCreated
+		}(i, opts)
+	}
+	wg.Wait()
+
+	for _, item := range result.Items {
+		if item.Success {
+			result.Created++
+		} else {
+			result.Failed++
+		}
+	}
+	if result.Failed > 0 && rollback && result.Created > 0 {
+		api.rollbackBatch(r.Context(), &result, records)
+	}
+
+	status := http.StatusCreated
+	if result.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	api.sendJSONResponse(w, APIResponse{
+		Success:    result.Failed == 0,
+		Data:       result,
+		StatusCode: status,
+	}, status)
+}
+
+// rollbackBatch cleans the VMs a failed batch created. An item whose VM
+// could not be cleaned keeps its VM and says so.
+func (api *APIServer) rollbackBatch(ctx context.Context, result *BatchCreateResult, records []VMRecord) {
+	result.RolledBack = true
+	for i := range result.Items {
+		item := &result.Items[i]
+		if !item.Success {
+			continue
+		}
+		if err := api.vmService.Clean(context.WithoutCancel(ctx), records[i].ID, false); err != nil {
+			loggerFor(ctx, api.logger).Warn("failed to roll back batch vm", map[string]any{
+				"vm":    records[i].ID,
+				"error": err.Error(),
+			})
+			item.Error = "rollback failed: " + err.Error()
+			continue
+		}
+		item.Success = false
+		item.VM = nil
+		item.Error = "rolled back"
+		result.Created--
+	}
+}
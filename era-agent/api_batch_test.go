@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func postBatch(t *testing.T, url, body string) (int, BatchCreateResult) {
+	t.Helper()
+	resp, err := http.Post(url+"/api/vms/batch", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("batch request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Data BatchCreateResult `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return resp.StatusCode, envelope.Data
+}
+
+func TestBatchCreateFromTemplate(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	status, result := postBatch(t, server.URL, `{"count":3,"template":{"language":"python","cpu":2,"labels":{"batch":"a"}}}`)
+	if status != http.StatusCreated || result.Created != 3 || result.Failed != 0 {
+		t.Fatalf("status = %d, result = %+v; want 3 created", status, result)
+	}
+	seen := map[string]bool{}
+	for i, item := range result.Items {
+		if item.Index != i || !item.Success || item.VM == nil || item.VM.CPUCount != 2 || item.VM.Labels["batch"] != "a" {
+			t.Fatalf("item %d = %+v", i, item)
+		}
+		seen[item.VM.ID] = true
+	}
+	if len(seen) != 3 {
+		t.Fatalf("batch created %d distinct VMs, want 3", len(seen))
+	}
+}
+
+func TestBatchCreatePartialFailure(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	status, result := postBatch(t, server.URL, `[{"language":"python"},{"language":"cobol"},{"language":"node"}]`)
+	if status != http.StatusMultiStatus || result.Created != 2 || result.Failed != 1 {
+		t.Fatalf("status = %d, result = %+v; want 207 with one failure", status, result)
+	}
+	bad := result.Items[1]
+	if bad.Success || bad.StatusCode != http.StatusBadRequest || !strings.Contains(bad.Error, "unsupported language") {
+		t.Fatalf("failed item = %+v", bad)
+	}
+	for _, i := range []int{0, 2} {
+		if _, ok := svc.Get(result.Items[i].VM.ID); !ok {
+			t.Fatalf("item %d's VM was not kept", i)
+		}
+	}
+}
+
+func TestBatchCreateRollback(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	body := `{"count":2,"template":{"language":"python"},"rollback":true}`
+	if status, result := postBatch(t, server.URL, body); status != http.StatusCreated || result.RolledBack {
+		t.Fatalf("all-success batch = %d, %+v", status, result)
+	}
+
+	t.Setenv("AGENT_MAX_VMS", "3")
+	status, result := postBatch(t, server.URL, `{"count":2,"template":{"language":"python"},"rollback":true}`)
+	if status != http.StatusMultiStatus || !result.RolledBack || result.Created != 0 {
+		t.Fatalf("status = %d, result = %+v; want a rolled back partial batch", status, result)
+	}
+	for _, item := range result.Items {
+		if item.Success || item.VM != nil {
+			t.Fatalf("item survived rollback: %+v", item)
+		}
+	}
+	if records, _ := svc.List(context.Background()); len(records) != 2 {
+		t.Fatalf("%d VMs after rollback, want the 2 from the first batch", len(records))
+	}
+}
+
+func TestBatchCreateBounds(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	for _, body := range []string{
+		`{"count":0,"template":{"language":"python"}}`,
+		`{"count":65,"template":{"language":"python"}}`,
+		`{"count":2}`,
+		`{"count":2,"template":{"name":"same"}}`,
+		`[]`,
+		`not json`,
+	} {
+		resp, err := http.Post(server.URL+"/api/vms/batch", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, resp.StatusCode)
+		}
+	}
+
+	t.Setenv("AGENT_MAX_VMS", "2")
+	resp, err := http.Post(server.URL+"/api/vms/batch", "application/json", strings.NewReader(`{"count":3,"template":{"language":"python"}}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("batch above AGENT_MAX_VMS status = %d, want 400", resp.StatusCode)
+	}
+	if records, _ := svc.List(context.Background()); len(records) != 0 {
+		t.Fatalf("rejected batch created %d VMs", len(records))
+	}
+}
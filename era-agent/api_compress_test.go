@@ -0,0 +1,112 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getWithGzip(t *testing.T, url string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Setting the header ourselves stops the transport from decoding
+	// the body transparently.
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+func TestVMListGzippedWhenLarge(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	resp, body := getWithGzip(t, server.URL+"/api/vm/list")
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("small list compressed: Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if !json.Valid(body) {
+		t.Fatalf("small list body is not JSON: %q", body)
+	}
+
+	for i := 0; i < 8; i++ {
+		createTestVM(t, svc)
+	}
+	resp, body = getWithGzip(t, server.URL+"/api/vm/list")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("large list: status %d, Content-Encoding %q; want gzip", resp.StatusCode, resp.Header.Get("Content-Encoding"))
+	}
+	if !strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("Vary = %q, want Accept-Encoding", resp.Header.Get("Vary"))
+	}
+	zr, err := gzip.NewReader(strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	var decoded APIResponse
+	if err := json.Unmarshal(plain, &decoded); err != nil || !decoded.Success {
+		t.Fatalf("decompressed body = %q, %v", plain, err)
+	}
+	if len(plain) < gzipMinBytes {
+		t.Fatalf("decompressed body is %d bytes, below the %d threshold", len(plain), gzipMinBytes)
+	}
+
+	// Clients that do not ask for gzip get the plain body.
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/vm/list", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	plainResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainResp.Body.Close()
+	if plainResp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("response compressed without Accept-Encoding: gzip")
+	}
+}
+
+func TestCompressMiddlewareSkipsCompressedTypes(t *testing.T) {
+	large := strings.Repeat("x", 4*gzipMinBytes)
+	for contentType, wantGzip := range map[string]bool{
+		"application/json":  true,
+		"application/gzip":  false,
+		"image/png":         false,
+		"text/event-stream": false,
+	} {
+		handler := compressMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			io.WriteString(w, large)
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/api/vm/x/files/archive", nil)
+		req.Header.Set("Accept-Encoding", "deflate, gzip")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding") == "gzip"; got != wantGzip {
+			t.Errorf("%s: gzipped = %v, want %v", contentType, got, wantGzip)
+		}
+		if !wantGzip && rec.Body.String() != large {
+			t.Errorf("%s: body altered when passed through", contentType)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIVersion is the version of the agent API described by /openapi.json.
+const openAPIVersion = "1.0.0"
+
+// openAPISchemas are the request and response types published under
+// components/schemas, keyed by their schema name. Their schemas are derived
+// from the structs, so the document follows the handlers' JSON.
+var openAPISchemas = map[string]reflect.Type{
+	"APIRequest":         reflect.TypeOf(APIRequest{}),
+	"APIResponse":        reflect.TypeOf(APIResponse{}),
+	"VMInfo":             reflect.TypeOf(VMInfo{}),
+	"VMListPage":         reflect.TypeOf(VMListPage{}),
+	"CreateTimingsInfo":  reflect.TypeOf(CreateTimingsInfo{}),
+	"ExecutionResult":    reflect.TypeOf(ExecutionResult{}),
+	"VMStatsInfo":        reflect.TypeOf(VMStatsInfo{}),
+	"VMLogsInfo":         reflect.TypeOf(VMLogsInfo{}),
+	"ImageCheckInfo":     reflect.TypeOf(ImageCheckInfo{}),
+	"VMFilesInfo":        reflect.TypeOf(VMFilesInfo{}),
+	"VMFileInfo":         reflect.TypeOf(VMFileInfo{}),
+	"ArchiveUploadInfo":  reflect.TypeOf(ArchiveUploadInfo{}),
+	"CompareRequest":     reflect.TypeOf(CompareRequest{}),
+	"CompareInfo":        reflect.TypeOf(CompareInfo{}),
+	"CompareRunInfo":     reflect.TypeOf(CompareRunInfo{}),
+	"RunInfo":            reflect.TypeOf(RunInfo{}),
+	"SnapshotRequest":    reflect.TypeOf(SnapshotRequest{}),
+	"REPLRequest":        reflect.TypeOf(REPLRequest{}),
+	"REPLEvalResult":     reflect.TypeOf(REPLEvalResult{}),
+	"BatchCreateRequest": reflect.TypeOf(BatchCreateRequest{}),
+	"BatchCreateItem":    reflect.TypeOf(BatchCreateItem{}),
+	"BatchCreateResult":  reflect.TypeOf(BatchCreateResult{}),
+	"DBCheckInfo":        reflect.TypeOf(DBCheckInfo{}),
+	"RuntimeHealthInfo":  reflect.TypeOf(RuntimeHealthInfo{}),
+	"CorruptEntryInfo":   reflect.TypeOf(CorruptEntryInfo{}),
+}
+
+// openAPIOperation describes one route. Request and Response name schemas in
+// openAPISchemas; Response is the type carried in APIResponse.data, and
+// Array marks a data field holding a list of it. RequestType is the content
+// type of a raw request body. Headers are request headers the handler reads
+// and ResponseHeaders the ones it sets on success. HEAD operations have no
+// response body.
+type openAPIOperation struct {
+	Method          string
+	Path            string
+	Summary         string
+	Request         string
+	Response        string
+	Array           bool
+	Status          int
+	Query           []string
+	Headers         []string
+	ResponseHeaders []string
+	ContentType     string
+	RequestType     string
+	Public          bool
+}
+
+var openAPIOperations = []openAPIOperation{
+	{Method: http.MethodPost, Path: "/api/vm/create", Summary: "Create and launch a VM", Request: "APIRequest", Response: "VMInfo", Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/vms/batch", Summary: "Create several VMs at once", Request: "BatchCreateRequest", Response: "BatchCreateResult", Status: http.StatusCreated},
+	{Method: http.MethodGet, Path: "/api/vm/list", Summary: "List VMs; a limit or offset returns a VMListPage instead of an array", Response: "VMInfo", Array: true, Query: []string{"all", "since", "until", "label", "limit", "offset"}},
+	{Method: http.MethodPost, Path: "/api/vm/execute", Summary: "Run a command in a VM (vm_id in the body) and return its output", Request: "APIRequest", Response: "ExecutionResult"},
+	{Method: http.MethodPost, Path: "/api/vm/temp", Summary: "Run a command in a temporary VM that is removed afterwards", Request: "APIRequest", Response: "ExecutionResult"},
+	{Method: http.MethodPost, Path: "/api/vm/compare", Summary: "Run one command across several images", Request: "CompareRequest", Response: "CompareInfo"},
+	{Method: http.MethodPost, Path: "/api/vm/stop", Summary: "Stop a VM (vm_id in the body) or every VM with all=true", Request: "APIRequest", Query: []string{"all"}},
+	{Method: http.MethodPost, Path: "/api/vm/clean", Summary: "Delete a VM and its storage (vm_id in the body) or every VM with all=true", Request: "APIRequest", Query: []string{"all"}},
+	{Method: http.MethodPatch, Path: "/api/vm/{id}", Summary: "Resize a VM; zero or missing cpu and memory keep their values, and a running VM is relaunched", Request: "APIRequest", Response: "VMInfo"},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/stats", Summary: "Report a VM's resource usage", Response: "VMStatsInfo"},
+	{Method: http.MethodPost, Path: "/api/vm/{id}/abort", Summary: "Abort the VM's in-flight runs"},
+	{Method: http.MethodPost, Path: "/api/vm/{id}/repl", Summary: "Evaluate code in the VM's REPL session, which keeps state between calls", Request: "REPLRequest", Response: "REPLEvalResult"},
+	{Method: http.MethodDelete, Path: "/api/vm/{id}/repl", Summary: "End the VM's REPL session"},
+	{Method: http.MethodPost, Path: "/api/vm/{id}/clone", Summary: "Clone a persistent VM", Request: "APIRequest", Response: "VMInfo", Status: http.StatusCreated},
+	{Method: http.MethodPost, Path: "/api/vm/{id}/snapshots", Summary: "Snapshot a persistent VM's persist directory", Request: "SnapshotRequest"},
+	{Method: http.MethodPost, Path: "/api/vm/{id}/snapshots/{name}/restore", Summary: "Restore a snapshot into the persist directory"},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/files", Summary: "List the files in the VM's storage, optionally under path and with sha256 checksums", Response: "VMFilesInfo", Query: []string{"path", "checksum"}},
+	{Method: http.MethodPut, Path: "/api/vm/{id}/files/{path}", Summary: "Upload a file into the VM's storage; a Content-Range header (bytes start-end/total) appends the body at start, and a start that does not match the current size answers 416 with Content-Range: bytes */size", Response: "VMFileInfo", Headers: []string{"Content-Range"}, RequestType: "application/octet-stream"},
+	{Method: http.MethodHead, Path: "/api/vm/{id}/files/{path}", Summary: "Report a stored file's size as Content-Length, so an interrupted upload can resume", ResponseHeaders: []string{"Content-Length"}},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/files/archive", Summary: "Download a directory of the VM's storage as a tar.gz", Query: []string{"path"}, ContentType: "application/gzip"},
+	{Method: http.MethodPost, Path: "/api/vm/{id}/files/archive", Summary: "Upload a tar or tar.gz archive into the VM's storage", Response: "ArchiveUploadInfo", Query: []string{"path"}},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/runs", Summary: "List the VM's run history, newest first", Response: "RunInfo", Array: true, Query: []string{"limit"}},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/history", Summary: "Alias of /api/vm/{id}/runs", Response: "RunInfo", Array: true, Query: []string{"limit"}},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/logs", Summary: "Read the output of the VM's most recent run", Response: "VMLogsInfo", Query: []string{"stream", "tail"}},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/logs/follow", Summary: "Stream appended log lines as server-sent events", ContentType: "text/event-stream"},
+	{Method: http.MethodGet, Path: "/api/vm/{id}/shell/ws", Summary: "Open an interactive shell over a WebSocket", Query: []string{"cmd"}},
+	{Method: http.MethodGet, Path: "/api/vm/shell", Summary: "Always answers 501; shells are served at /api/vm/{id}/shell/ws", Status: http.StatusNotImplemented, ContentType: "text/plain"},
+	{Method: http.MethodGet, Path: "/api/runs/recent", Summary: "List recent runs across VMs", Response: "RunInfo", Array: true, Query: []string{"limit"}},
+	{Method: http.MethodGet, Path: "/api/images/check", Summary: "Check that an image reference exists in its registry", Response: "ImageCheckInfo", Query: []string{"ref"}},
+	{Method: http.MethodGet, Path: "/api/admin/db-check", Summary: "Check the state database", Response: "DBCheckInfo"},
+	{Method: http.MethodGet, Path: "/health", Summary: "Liveness check", Public: true},
+	{Method: http.MethodGet, Path: "/health/runtime", Summary: "Launch a throwaway VM and run a command in it; answers 503 with the RuntimeHealthInfo when that fails", Response: "RuntimeHealthInfo"},
+	{Method: http.MethodGet, Path: "/metrics", Summary: "Prometheus metrics", ContentType: "text/plain", Public: true},
+	{Method: http.MethodGet, Path: "/openapi.json", Summary: "This OpenAPI document, served without an APIResponse envelope", ContentType: "application/json", Public: true},
+}
+
+// handleOpenAPI serves the OpenAPI 3 description of the API
+func (api *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The document is served bare, not in an APIResponse, so tools can load it.
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(openAPIDocument())
+}
+
+// openAPIDocument builds the OpenAPI 3 document for openAPIOperations.
+func openAPIDocument() map[string]any {
+	named := make(map[reflect.Type]string, len(openAPISchemas))
+	for name, typ := range openAPISchemas {
+		named[typ] = name
+	}
+	schemas := make(map[string]any, len(openAPISchemas))
+	for name, typ := range openAPISchemas {
+		schemas[name] = structSchema(typ, named)
+	}
+
+	paths := map[string]any{}
+	for _, op := range openAPIOperations {
+		item, _ := paths[op.Path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = op.document()
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "ERA agent API",
+			"version": openAPIVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]any{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An API key, required on /api/ routes and /health/runtime when the server runs with authentication enabled",
+				},
+			},
+		},
+		"security": []any{map[string]any{"bearerAuth": []any{}}},
+	}
+}
+
+func (op openAPIOperation) document() map[string]any {
+	doc := map[string]any{"summary": op.Summary}
+
+	var params []any
+	for _, segment := range strings.Split(op.Path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]any{
+				"name": strings.Trim(segment, "{}"), "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+	}
+	for _, name := range op.Query {
+		params = append(params, map[string]any{
+			"name": name, "in": "query",
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	for _, name := range op.Headers {
+		params = append(params, map[string]any{
+			"name": name, "in": "header",
+			"schema": map[string]any{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		doc["parameters"] = params
+	}
+
+	if op.Request != "" {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemaRef(op.Request)},
+			},
+		}
+	} else if op.RequestType != "" {
+		doc["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				op.RequestType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}},
+			},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var content map[string]any
+	if op.ContentType != "" {
+		content = map[string]any{op.ContentType: map[string]any{}}
+	} else {
+		envelope := schemaRef("APIResponse")
+		if op.Response != "" {
+			data := schemaRef(op.Response)
+			if op.Array {
+				data = map[string]any{"type": "array", "items": data}
+			}
+			envelope = map[string]any{"allOf": []any{
+				schemaRef("APIResponse"),
+				map[string]any{"type": "object", "properties": map[string]any{"data": data}},
+			}}
+		}
+		content = map[string]any{"application/json": map[string]any{"schema": envelope}}
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	failure := map[string]any{"description": "Error, with the message in APIResponse.error"}
+	if op.Method == http.MethodHead {
+		failure["description"] = "Error, reported by the status code alone"
+	} else {
+		success["content"] = content
+		failure["content"] = map[string]any{
+			"application/json": map[string]any{"schema": schemaRef("APIResponse")},
+		}
+	}
+	if len(op.ResponseHeaders) > 0 {
+		headers := map[string]any{}
+		for _, name := range op.ResponseHeaders {
+			headers[name] = map[string]any{"schema": map[string]any{"type": "string"}}
+		}
+		success["headers"] = headers
+	}
+	doc["responses"] = map[string]any{
+		strconv.Itoa(status): success,
+		"default":            failure,
+	}
+	if op.Public {
+		doc["security"] = []any{}
+	}
+	return doc
+}
+
+func schemaRef(name string) map[string]any {
+	return map[string]any{"$ref": "#/components/schemas/" + name}
+}
+
+// structSchema describes a struct's JSON encoding, following its json tags.
+// Fields without omitempty are listed as required.
+func structSchema(typ reflect.Type, named map[reflect.Type]string) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, named)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func typeSchema(typ reflect.Type, named map[reflect.Type]string) map[string]any {
+	if typ == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+	if name, ok := named[typ]; ok {
+		return schemaRef(name)
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		schema := typeSchema(typ.Elem(), named)
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": typeSchema(typ.Elem(), named)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": typeSchema(typ.Elem(), named)}
+	case reflect.Struct:
+		return structSchema(typ, named)
+	default:
+		// interface{} fields such as APIResponse.data hold any JSON value.
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestOpenAPIDocument(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	resp, err := http.Get(server.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas         map[string]map[string]any `json:"schemas"`
+			SecuritySchemes map[string]map[string]any `json:"securitySchemes"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("document is not valid JSON: %v", err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Fatalf("openapi = %q", doc.OpenAPI)
+	}
+	for path, method := range map[string]string{
+		"/api/vm/create":             "post",
+		"/api/vm/execute":            "post",
+		"/api/vm/{id}/logs/follow":   "get",
+		"/api/vm/{id}/files":         "get",
+		"/api/vm/{id}/files/archive": "post",
+	} {
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("paths[%q] has no %s operation", path, method)
+		}
+	}
+	// Resumable uploads document their headers.
+	upload := doc.Paths["/api/vm/{id}/files/{path}"]
+	if params, _ := upload["put"]["parameters"].([]any); !hasParameter(params, "Content-Range", "header") {
+		t.Errorf("PUT files/{path} parameters = %v, want a Content-Range header", params)
+	}
+	responses, _ := upload["head"]["responses"].(map[string]any)
+	headOK, _ := responses["200"].(map[string]any)
+	headers, _ := headOK["headers"].(map[string]any)
+	if _, ok := headers["Content-Length"]; !ok || headOK["content"] != nil {
+		t.Errorf("HEAD files/{path} 200 response = %v, want a Content-Length header and no body", headOK)
+	}
+	if scheme := doc.Components.SecuritySchemes["bearerAuth"]; scheme["scheme"] != "bearer" {
+		t.Errorf("bearerAuth = %v", scheme)
+	}
+
+	// Schemas come from the structs' json tags.
+	request := doc.Components.Schemas["APIRequest"]["properties"].(map[string]any)
+	if _, ok := request["vm_id"]; !ok {
+		t.Errorf("APIRequest schema lacks vm_id: %v", request)
+	}
+	info := doc.Components.Schemas["VMInfo"]["properties"].(map[string]any)
+	if created := info["created_at"].(map[string]any); created["format"] != "date-time" {
+		t.Errorf("VMInfo.created_at = %v, want a date-time string", created)
+	}
+
+	// Every $ref must point at a published schema.
+	var checkRefs func(v any)
+	checkRefs = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			if ref, ok := v["$ref"].(string); ok {
+				name := ref[len("#/components/schemas/"):]
+				if _, ok := doc.Components.Schemas[name]; !ok {
+					t.Errorf("dangling $ref %s", ref)
+				}
+			}
+			for _, child := range v {
+				checkRefs(child)
+			}
+		case []any:
+			for _, child := range v {
+				checkRefs(child)
+			}
+		}
+	}
+	for _, item := range doc.Paths {
+		for _, op := range item {
+			checkRefs(op)
+		}
+	}
+	for _, schema := range doc.Components.Schemas {
+		checkRefs(schema)
+	}
+}
+
+func hasParameter(params []any, name, in string) bool {
+	for _, param := range params {
+		if p, _ := param.(map[string]any); p["name"] == name && p["in"] == in {
+			return true
+		}
+	}
+	return false
+}
+
+// TestOpenAPICoversEveryRoute reads the routes off NewAPIServer's mux and
+// handleVMRoutes' switch, so a route added without an openAPIOperations
+// entry fails here.
+func TestOpenAPICoversEveryRoute(t *testing.T) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "api_server.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Served to browsers rather than API clients, or dispatching to the
+	// per-VM routes below.
+	skip := map[string]bool{"/": true, "/index.html": true, "/web/": true, "/api/vm/": true}
+	// Routes in handleVMRoutes' default branch, keyed by the prefix or
+	// matcher function they are cut with.
+	dynamic := map[string]string{
+		"files/":               "/api/vm/{id}/files/{path}",
+		"snapshotRestoreRoute": "/api/vm/{id}/snapshots/{name}/restore",
+	}
+
+	var routes []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		switch fn.Name.Name {
+		case "NewAPIServer":
+			ast.Inspect(fn, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) == 0 {
+					return true
+				}
+				if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "HandleFunc" {
+					return true
+				}
+				if path, ok := stringLiteral(call.Args[0]); ok && !skip[path] {
+					routes = append(routes, path)
+				}
+				return true
+			})
+		case "handleVMRoutes":
+			ast.Inspect(fn, func(n ast.Node) bool {
+				clause, ok := n.(*ast.CaseClause)
+				if !ok {
+					return true
+				}
+				for _, expr := range clause.List {
+					if action, ok := stringLiteral(expr); ok {
+						if action == "" {
+							routes = append(routes, "/api/vm/{id}")
+						} else {
+							routes = append(routes, "/api/vm/{id}/"+action)
+						}
+					}
+				}
+				if clause.List != nil {
+					return true
+				}
+				// The default branch: every call taking the action must be
+				// a known dynamic route.
+				ast.Inspect(clause, func(n ast.Node) bool {
+					call, ok := n.(*ast.CallExpr)
+					if !ok || len(call.Args) == 0 {
+						return true
+					}
+					if ident, ok := call.Args[0].(*ast.Ident); !ok || ident.Name != "action" {
+						return true
+					}
+					var key string
+					switch fun := call.Fun.(type) {
+					case *ast.SelectorExpr:
+						if len(call.Args) > 1 {
+							key, _ = stringLiteral(call.Args[1])
+						}
+					case *ast.Ident:
+						key = fun.Name
+					}
+					path, known := dynamic[key]
+					if !known {
+						t.Errorf("%s: unknown route matcher in handleVMRoutes; add it to this test and openAPIOperations", fset.Position(call.Pos()))
+						return true
+					}
+					routes = append(routes, path)
+					return true
+				})
+				return false
+			})
+		}
+	}
+	if len(routes) == 0 {
+		t.Fatal("found no routes in api_server.go")
+	}
+
+	documented := map[string]bool{}
+	for _, op := range openAPIOperations {
+		documented[op.Path] = true
+	}
+	for _, route := range routes {
+		if !documented[route] {
+			t.Errorf("route %s has no openAPIOperations entry", route)
+		}
+	}
+}
+
+func stringLiteral(expr ast.Expr) (string, bool) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	return value, err == nil
+}
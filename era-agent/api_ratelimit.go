@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIdleRateBuckets bounds how many client buckets are kept before full
+// (idle) ones are dropped, so unknown keys and IPs cannot grow the map
+// without limit.
+const maxIdleRateBuckets = 1024
+
+// rateLimiter is a token bucket per client: each client may burst up to
+// burst requests and then gets rate requests per second. A nil *rateLimiter
+// admits everything.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	now     func() time.Time
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// rateLimitFromEnv reads ERA_RATE_LIMIT (requests per second, off when unset
+// or zero) and ERA_RATE_BURST (defaults to the rate, rounded up).
+func rateLimitFromEnv() (float64, int) {
+	rate, err := strconv.ParseFloat(strings.TrimSpace(os.Getenv("ERA_RATE_LIMIT")), 64)
+	if err != nil || rate <= 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return 0, 0
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(os.Getenv("ERA_RATE_BURST")))
+	if err != nil {
+		burst = 0
+	}
+	return rate, burst
+}
+
+// allow takes a token from the client's bucket. When the bucket is empty it
+// returns false and how long until the next token is available.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxIdleRateBuckets {
+			l.pruneLocked(now)
+		}
+		bucket = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[client] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// pruneLocked drops buckets that have refilled completely; forgetting them
+// changes nothing for their clients.
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	for client, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitAPI applies the rate limit to /api/ routes, keyed like stream
+// accounting (a valid API key, or else the remote IP). Rejected requests get
+// HTTP 429 with a Retry-After header in whole seconds.
+func (api *APIServer) rateLimitAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := api.rateLimit.allow(api.streamClientKey(r))
+		if !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			api.logger.Warn("rate limit exceeded", map[string]any{
+				"path":   r.URL.Path,
+				"remote": r.RemoteAddr,
+			})
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			api.sendJSONError(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitRejectsBeyondBurst(t *testing.T) {
+	t.Setenv("ERA_RATE_LIMIT", "1")
+	t.Setenv("ERA_RATE_BURST", "3")
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	var ok, limited int
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get(server.URL + "/api/vm/list")
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+			ok++
+		case http.StatusTooManyRequests:
+			limited++
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || secs < 1 {
+				t.Fatalf("Retry-After = %q, want a positive number of seconds", resp.Header.Get("Retry-After"))
+			}
+		default:
+			t.Fatalf("request %d: unexpected status %d", i, resp.StatusCode)
+		}
+	}
+	if ok < 3 || limited == 0 {
+		t.Fatalf("got %d ok and %d limited, want the burst of 3 admitted and the rest limited", ok, limited)
+	}
+
+	// Only /api/ routes are limited.
+	resp, err := http.Get(server.URL + "/health")
+	if err != nil {
+		t.Fatalf("health request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("health status = %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	limiter := newRateLimiter(2, 1)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("first request rejected")
+	}
+	ok, wait := limiter.allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("second request = %v, wait %v; want rejected with 500ms wait", ok, wait)
+	}
+	if ok, _ := limiter.allow("b"); !ok {
+		t.Fatal("other client shares the bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("request rejected after refill")
+	}
+}
+
+func TestRateLimitKeysBadAPIKeysByIP(t *testing.T) {
+	t.Setenv("ERA_API_KEY", "good-key")
+	t.Setenv("ERA_RATE_LIMIT", "1")
+	t.Setenv("ERA_RATE_BURST", "3")
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	get := func(key string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/vm/list", nil)
+		if err != nil {
+			t.Fatalf("build request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Every request carries a different bad key; they all share the IP's
+	// bucket, so the burst runs out.
+	limited := 0
+	for i := 0; i < 10; i++ {
+		switch status := get("bad-key-" + strconv.Itoa(i)); status {
+		case http.StatusUnauthorized:
+		case http.StatusTooManyRequests:
+			limited++
+		default:
+			t.Fatalf("request %d: unexpected status %d", i, status)
+		}
+	}
+	if limited != 7 {
+		t.Fatalf("%d of 10 distinct bad keys were limited, want 7", limited)
+	}
+
+	// A valid key has a bucket of its own.
+	if status := get("good-key"); status != http.StatusOK {
+		t.Fatalf("valid key status = %d, want 200", status)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds inbound IDs so they cannot bloat every log
+	// line of the request.
+	maxRequestIDLength = 128
+)
+
+type requestIDContextKey struct{}
+
+// withRequestID attaches a request ID to ctx for the logs of everything the
+// request does.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// requestIDFrom returns the request ID carried by ctx, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// loggerFor returns logger with the request ID of ctx attached, or logger
+// itself when ctx carries none.
+func loggerFor(ctx context.Context, logger *Logger) *Logger {
+	if id := requestIDFrom(ctx); id != "" {
+		return logger.With(map[string]any{"request_id": id})
+	}
+	return logger
+}
+
+// newRequestID returns 16 random hex characters.
+func newRequestID() string {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// validRequestID accepts inbound IDs of printable ASCII without spaces, so
+// they are safe to echo in a header and to log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// loggingMiddleware gives every request an ID, reusing a valid inbound
+// X-Request-ID, echoes it in the response header and carries it in the
+// request context so API and VMService logs for the request can be
+// correlated.
+func (api *APIServer) loggingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(withRequestID(r.Context(), id))
+
+		loggerFor(r.Context(), api.logger).Debug("api request", map[string]any{
+			"method": r.Method,
+			"path":   r.URL.Path,
+			"remote": r.RemoteAddr,
+		})
+		next.ServeHTTP(w, r)
+	})
+}
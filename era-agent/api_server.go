@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,23 +20,47 @@ type APIServer struct {
 	vmService    *VMService
 	logger       *Logger
 	server       *http.Server
-	apiKey       string
+	apiKeys      []apiKey
 	enableAuth   bool
+	streams      *streamLimiter
+	rateLimit    *rateLimiter
+	metricsAddr  string
+	metrics      *http.Server
+	tlsCert      string
+	tlsKey       string
 }
 
 // APIRequest represents the structure for API requests
 type APIRequest struct {
-	Language  string `json:"language"`
-	Command   string `json:"command"`
-	Image     string `json:"image"`
-	CPU       int    `json:"cpu"`
-	Memory    int    `json:"memory"`
-	Network   string `json:"network"`
-	Persist   bool   `json:"persist"`
-	File      string `json:"file"`
-	Timeout   int    `json:"timeout"`
-	VMID      string `json:"vm_id"`
-	KeepPersist bool `json:"keep_persist"`
+	Language         string            `json:"language"`
+	Command          string            `json:"command"`
+	Commands         []string          `json:"commands,omitempty"`
+	ContinueOnError  bool              `json:"continue_on_error,omitempty"`
+	Image            string            `json:"image"`
+	CPU              int               `json:"cpu"`
+	Memory           int               `json:"memory"`
+	Network          string            `json:"network"`
+	Persist          bool              `json:"persist"`
+	File             string            `json:"file"`
+	Stdin            string            `json:"stdin"`
+	AutoInstall      bool              `json:"auto_install,omitempty"`
+	GuestTimeout     bool              `json:"guest_timeout,omitempty"`
+	CleanOutput      bool              `json:"clean_output,omitempty"`
+	PullPolicy       string            `json:"pull_policy"`
+	Ports            []string          `json:"ports"`
+	TTL              int               `json:"ttl"`
+	ExpirePersistent bool              `json:"expire_persistent"`
+	Volumes          []string          `json:"volumes"`
+	Mounts           GuestMounts       `json:"mounts"`
+	Workdir          string            `json:"workdir,omitempty"`
+	Timeout          int               `json:"timeout"`
+	VMID             string            `json:"vm_id"`
+	KeepPersist      bool              `json:"keep_persist"`
+	Force            bool              `json:"force,omitempty"`
+	Owner            string            `json:"owner,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Name             string            `json:"name,omitempty"`
+	Envs             map[string]string `json:"envs,omitempty"`
 }
 
 // APIResponse represents the structure for API responses
@@ -41,41 +69,181 @@ type APIResponse struct {
 	Error      string                 `json:"error,omitempty"`
 	Data       interface{}            `json:"data,omitempty"`
 	StatusCode int                    `json:"status_code,omitempty"`
+	// LauncherWarning is set when the launcher could not be asked which VMs
+	// exist, so the listed statuses may be stale.
+	LauncherWarning string `json:"launcher_warning,omitempty"`
 }
 
 // VMInfo represents information about a VM
 type VMInfo struct {
-	ID          string    `json:"id"`
-	Language    string    `json:"language"`
-	Status      string    `json:"status"`
-	CPUCount    int       `json:"cpu_count"`
-	MemoryMiB   int       `json:"memory_mib"`
-	NetworkMode string    `json:"network_mode"`
-	Persist     bool      `json:"persist"`
-	CreatedAt   time.Time `json:"created_at"`
-	LastRunAt   time.Time `json:"last_run_at"`
+	ID          string             `json:"id"`
+	Language    string             `json:"language"`
+	Status      string             `json:"status"`
+	CPUCount    int                `json:"cpu_count"`
+	MemoryMiB   int                `json:"memory_mib"`
+	NetworkMode string             `json:"network_mode"`
+	Ports       []string           `json:"ports,omitempty"`
+	Persist     bool               `json:"persist"`
+	CreatedAt   time.Time          `json:"created_at"`
+	LastRunAt   time.Time          `json:"last_run_at"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty"`
+	Owner       string             `json:"owner,omitempty"`
+	Labels      map[string]string  `json:"labels,omitempty"`
+	Name        string             `json:"name,omitempty"`
+	Timings     *CreateTimingsInfo `json:"timings,omitempty"`
+	// PresenceUnknown marks a status taken from the state database because
+	// the launcher could not be listed.
+	PresenceUnknown bool `json:"presence_unknown,omitempty"`
+}
+
+// VMListPage is the /api/vm/list response when limit or offset is given
+type VMListPage struct {
+	VMs   []VMInfo `json:"vms"`
+	Count int      `json:"count"`
+	// Total counts the VMs matching the filters across all pages
+	Total      int  `json:"total"`
+	NextOffset *int `json:"next_offset"`
+}
+
+// CreateTimingsInfo reports the duration of each create phase in milliseconds
+type CreateTimingsInfo struct {
+	ResolveMS float64 `json:"resolve_ms"`
+	StorageMS float64 `json:"storage_ms"`
+	LaunchMS  float64 `json:"launch_ms"`
+	PersistMS float64 `json:"persist_ms"`
+	TotalMS   float64 `json:"total_ms"`
 }
 
 // ExecutionResult represents the result of a command execution
 type ExecutionResult struct {
-	VMID     string `json:"vm_id"`
+	VMID          string `json:"vm_id"`
+	ExitCode      int    `json:"exit_code"`
+	Stdout        string `json:"stdout"`
+	Stderr        string `json:"stderr"`
+	Duration      string `json:"duration"`
+	Aborted       bool   `json:"aborted,omitempty"`
+	TimedOut      bool   `json:"timed_out,omitempty"`
+	Truncated     bool   `json:"truncated,omitempty"`
+	AutoInstalled string `json:"auto_installed,omitempty"`
+}
+
+// VMStatsInfo represents resource usage of a running VM
+type VMStatsInfo struct {
+	VMID       string  `json:"vm_id"`
+	PID        int     `json:"pid"`
+	CPUPercent float64 `json:"cpu_percent"`
+	MemoryMiB  float64 `json:"memory_mib"`
+	Uptime     string  `json:"uptime"`
+}
+
+// VMLogsInfo carries the output of a VM's most recent run; a stream that was
+// not asked for is omitted
+type VMLogsInfo struct {
+	VMID   string  `json:"vm_id"`
+	Stdout *string `json:"stdout,omitempty"`
+	Stderr *string `json:"stderr,omitempty"`
+}
+
+// ImageCheckInfo represents the result of an image existence check
+type ImageCheckInfo struct {
+	Ref       string `json:"ref"`
+	Exists    bool   `json:"exists"`
+	Digest    string `json:"digest,omitempty"`
+	SizeBytes int64  `json:"size_bytes,omitempty"`
+}
+
+// VMFilesInfo lists the files in a VM's storage directory
+type VMFilesInfo struct {
+	VMID  string       `json:"vm_id"`
+	Files []VMFileInfo `json:"files"`
+}
+
+// VMFileInfo represents one file, with its hash when ?checksum= was given
+type VMFileInfo struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ArchiveUploadInfo lists the files extracted from an uploaded archive
+type ArchiveUploadInfo struct {
+	VMID  string   `json:"vm_id"`
+	Paths []string `json:"paths"`
+	Bytes int64    `json:"bytes"`
+}
+
+// CompareRequest represents a request to run one program across languages
+type CompareRequest struct {
+	Languages []string          `json:"languages"`
+	Code      string            `json:"code"`
+	Sources   map[string]string `json:"sources,omitempty"`
+	CPU       int               `json:"cpu,omitempty"`
+	Memory    int               `json:"memory,omitempty"`
+	Timeout   int               `json:"timeout,omitempty"`
+}
+
+// CompareInfo represents the outputs of a cross-language comparison
+type CompareInfo struct {
+	Consistent bool             `json:"consistent"`
+	Runs       []CompareRunInfo `json:"runs"`
+}
+
+// CompareRunInfo represents the outcome in one language
+type CompareRunInfo struct {
+	Language string `json:"language"`
+	VMID     string `json:"vm_id,omitempty"`
 	ExitCode int    `json:"exit_code"`
 	Stdout   string `json:"stdout"`
 	Stderr   string `json:"stderr"`
 	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RunInfo represents one entry of the run history
+type RunInfo struct {
+	VMID      string    `json:"vm_id"`
+	Command   string    `json:"command"`
+	ExitCode  int       `json:"exit_code"`
+	Status    string    `json:"status"`
+	StartedAt time.Time `json:"started_at"`
+	Duration  string    `json:"duration"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+// DBCheckInfo represents the result of a state database integrity check
+type DBCheckInfo struct {
+	Healthy    bool               `json:"healthy"`
+	Path       string             `json:"path"`
+	Schema     int                `json:"schema_version"`
+	VMs        int                `json:"vms"`
+	Volumes    int                `json:"volumes"`
+	Corrupt    []CorruptEntryInfo `json:"corrupt"`
+	PageErrors []string           `json:"page_errors,omitempty"`
+}
+
+// CorruptEntryInfo identifies a stored entry that failed to decode
+type CorruptEntryInfo struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Error  string `json:"error"`
 }
 
 // NewAPIServer creates a new API server instance
 func NewAPIServer(vmService *VMService, logger *Logger, addr string) *APIServer {
-	// Check for API key in environment
-	apiKey := os.Getenv("ERA_API_KEY")
-	enableAuth := apiKey != ""
+	// Check for API keys in environment; several comma-separated keys may be
+	// accepted at once while rotating, and a tenant:key entry binds the key
+	// to a tenant
+	apiKeys := parseAPIKeys(os.Getenv("ERA_API_KEY"))
+	enableAuth := len(apiKeys) > 0
+	rate, burst := rateLimitFromEnv()
 
 	api := &APIServer{
 		vmService:  vmService,
 		logger:     logger,
-		apiKey:     apiKey,
+		apiKeys:    apiKeys,
 		enableAuth: enableAuth,
+		streams:    newStreamLimiter(maxStreamsPerClient()),
+		rateLimit:  newRateLimiter(rate, burst),
 	}
 
 	mux := http.NewServeMux()
@@ -84,10 +252,23 @@ func NewAPIServer(vmService *VMService, logger *Logger, addr string) *APIServer
 	mux.HandleFunc("/api/vm/create", api.handleCreateVM)
 	mux.HandleFunc("/api/vm/execute", api.handleExecuteInVM)
 	mux.HandleFunc("/api/vm/temp", api.handleRunTemp)
+	mux.HandleFunc("/api/vm/compare", api.handleCompare)
 	mux.HandleFunc("/api/vm/list", api.handleListVMs)
 	mux.HandleFunc("/api/vm/stop", api.handleStopVM)
 	mux.HandleFunc("/api/vm/clean", api.handleCleanVM)
-	mux.HandleFunc("/api/vm/shell", api.handleShell) // Note: shell might need websocket for interactivity
+	mux.HandleFunc("/api/vm/shell", api.handleShell) // Interactive shells are served at /api/vm/{id}/shell/ws
+	mux.HandleFunc("/api/vm/", api.handleVMRoutes)
+	mux.HandleFunc("/api/vms/batch", api.handleBatchCreateVMs)
+	mux.HandleFunc("/api/runs/recent", api.handleRecentRuns)
+	mux.HandleFunc("/api/images/check", api.handleImageCheck)
+	mux.HandleFunc("/api/admin/db-check", api.handleDBCheck)
+
+	// Prometheus metrics and liveness (unauthenticated, see requireAuthForAPI)
+	mux.HandleFunc("/metrics", api.handleMetrics)
+	mux.HandleFunc("/health", api.handleHealth)
+	// Launches a VM, so it needs an API key like the routes under /api/
+	mux.HandleFunc("/health/runtime", api.handleRuntimeHealth)
+	mux.HandleFunc("/openapi.json", api.handleOpenAPI)
 	
 	// Web interface routes
 	mux.HandleFunc("/", api.handleWebInterface)
@@ -101,6 +282,14 @@ func NewAPIServer(vmService *VMService, logger *Logger, addr string) *APIServer
 		// Create a custom handler that applies auth only to API routes
 		handler = api.requireAuthForAPI(mux)
 	}
+	if api.rateLimit != nil {
+		// Outside auth, so floods of bad keys are throttled as well; those are
+		// keyed by remote IP, since only accepted keys get their own bucket
+		handler = api.rateLimitAPI(handler)
+	}
+	handler = compressMiddleware(handler)
+	// Outermost, so rejected requests carry an ID too
+	handler = api.loggingMiddleware(handler)
 
 	api.server = &http.Server{
 		Addr:    addr,
@@ -131,20 +320,22 @@ func (api *APIServer) requireAuth(next http.Handler) http.Handler {
 		}
 
 		token := strings.TrimPrefix(authHeader, "Bearer ")
-		if token != api.apiKey {
+		tenant, ok := api.apiKeyTenant(token)
+		if !ok {
 			http.Error(w, "Invalid API key", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, withTenant(r, tenant))
 	})
 }
 
 // requireAuthForAPI is a middleware that requires API key authentication only for API routes
 func (api *APIServer) requireAuthForAPI(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Apply auth only to API routes (those starting with /api/)
-		if strings.HasPrefix(r.URL.Path, "/api/") {
+		// Apply auth only to API routes (those starting with /api/) and the
+		// runtime probe, which creates a VM
+		if strings.HasPrefix(r.URL.Path, "/api/") || r.URL.Path == "/health/runtime" {
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
 				http.Error(w, "Authorization header required for API access", http.StatusUnauthorized)
@@ -158,16 +349,97 @@ func (api *APIServer) requireAuthForAPI(next http.Handler) http.Handler {
 			}
 
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			if token != api.apiKey {
+			tenant, ok := api.apiKeyTenant(token)
+			if !ok {
 				http.Error(w, "Invalid API key", http.StatusUnauthorized)
 				return
 			}
+			r = withTenant(r, tenant)
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// apiKey is an accepted bearer token and the tenant whose VMs it may see
+type apiKey struct {
+	tenant string
+	key    string
+}
+
+// parseAPIKeys splits a comma-separated ERA_API_KEY value, ignoring blanks.
+// An entry of the form tenant:key binds the key to that tenant; plain keys
+// belong to the default tenant
+func parseAPIKeys(raw string) []apiKey {
+	var keys []apiKey
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parsed := apiKey{tenant: defaultTenant, key: entry}
+		if tenant, key, ok := strings.Cut(entry, ":"); ok && strings.TrimSpace(tenant) != "" && key != "" {
+			parsed = apiKey{tenant: strings.TrimSpace(tenant), key: key}
+		}
+		keys = append(keys, parsed)
+	}
+	return keys
+}
+
+// apiKeyTenant reports the tenant of the configured key matching token,
+// comparing every key in constant time
+func (api *APIServer) apiKeyTenant(token string) (string, bool) {
+	tenant, valid := "", false
+	for _, key := range api.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key.key)) == 1 {
+			tenant, valid = key.tenant, true
+		}
+	}
+	return tenant, valid
+}
+
+// handleHealth is an unauthenticated liveness probe
+func (api *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	api.sendJSONSuccess(w, map[string]interface{}{
+		"status": "ok",
+	}, http.StatusOK)
+}
+
+// RuntimeHealthInfo reports whether a VM could be launched and run
+type RuntimeHealthInfo struct {
+	Healthy   bool      `json:"healthy"`
+	Detail    string    `json:"detail"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// handleRuntimeHealth launches a throwaway VM and runs a command in it,
+// answering 503 when that fails
+func (api *APIServer) handleRuntimeHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := api.vmService.CheckRuntime(r.Context())
+	info := RuntimeHealthInfo{
+		Healthy:   health.Healthy,
+		Detail:    health.Detail,
+		Duration:  health.Duration.String(),
+		CheckedAt: health.Checked,
+	}
+	if !health.Healthy {
+		api.sendJSONResponse(w, APIResponse{
+			Success:    false,
+			Error:      "runtime unhealthy: " + health.Detail,
+			Data:       info,
+			StatusCode: http.StatusServiceUnavailable,
+		}, http.StatusServiceUnavailable)
+		return
+	}
+	api.sendJSONSuccess(w, info, http.StatusOK)
+}
+
 // handleWebInterface serves the main web interface
 func (api *APIServer) handleWebInterface(w http.ResponseWriter, r *http.Request) {
 	// Serve the main index.html file
@@ -197,13 +469,82 @@ func (api *APIServer) handleWebAssets(w http.ResponseWriter, r *http.Request) {
 
 // Start starts the API server
 func (api *APIServer) Start() error {
-	api.logger.Info("starting API server", map[string]any{"addr": api.server.Addr})
+	api.logger.Info("starting API server", map[string]any{"addr": api.server.Addr, "tls": api.tlsCert != ""})
+	if api.tlsCert != "" {
+		return api.server.ListenAndServeTLS(api.tlsCert, api.tlsKey)
+	}
 	return api.server.ListenAndServe()
 }
 
-// Stop stops the API server
+// serverShutdownTimeout bounds how long Serve waits for in-flight requests
+// to drain once asked to stop.
+const serverShutdownTimeout = 30 * time.Second
+
+// Serve runs the server until it fails or ctx is done, then stops it,
+// draining in-flight requests for up to serverShutdownTimeout. A clean
+// shutdown returns nil.
+func (api *APIServer) Serve(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() { errCh <- api.Start() }()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	if err := api.Stop(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// StartMetrics serves /metrics on a dedicated address instead of the API
+// address, e.g. to keep it on an internal interface.
+func (api *APIServer) StartMetrics(addr string) {
+	api.metricsAddr = addr
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", api.vmService.metrics.handler())
+	metricsServer := &http.Server{Addr: addr, Handler: mux}
+	api.metrics = metricsServer
+
+	go func() {
+		api.logger.Info("starting metrics server", map[string]any{"addr": addr})
+		var err error
+		if api.tlsCert != "" {
+			err = metricsServer.ListenAndServeTLS(api.tlsCert, api.tlsKey)
+		} else {
+			err = metricsServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			api.logger.Error("metrics server failed", map[string]any{"addr": addr, "error": err.Error()})
+		}
+	}()
+}
+
+// handleMetrics exposes Prometheus metrics unless they are served separately
+func (api *APIServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if api.metricsAddr != "" {
+		http.NotFound(w, r)
+		return
+	}
+	api.vmService.metrics.handler().ServeHTTP(w, r)
+}
+
+// Stop stops the API server, and the metrics server if one was started
 func (api *APIServer) Stop(ctx context.Context) error {
 	api.logger.Info("stopping API server", nil)
+	if api.metrics != nil {
+		if err := api.metrics.Shutdown(ctx); err != nil {
+			api.logger.Warn("failed to stop metrics server", map[string]any{"error": err.Error()})
+		}
+	}
 	return api.server.Shutdown(ctx)
 }
 
@@ -220,51 +561,72 @@ func (api *APIServer) handleCreateVM(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	opts, err := createOptionsFromRequest(r, req)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record, err := api.vmService.Create(r.Context(), opts)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	vmInfo := vmRecordToInfo(record)
+	vmInfo.Timings = createTimingsToInfo(record.CreateTimings)
+
+	api.sendJSONSuccess(w, vmInfo, http.StatusCreated)
+}
+
+// createOptionsFromRequest applies the create defaults to req and converts
+// it, rejecting malformed ports, volumes and labels.
+func createOptionsFromRequest(r *http.Request, req APIRequest) (VMCreateOptions, error) {
 	// Set defaults
 	if req.Language == "" {
 		req.Language = "python"
 	}
-	if req.CPU == 0 {
-		req.CPU = 1
-	}
-	if req.Memory == 0 {
-		req.Memory = 256
-	}
-	if req.Network == "" {
-		req.Network = "none"
+	network, err := normalizeNetworkMode(req.Network)
+	if err != nil {
+		return VMCreateOptions{}, err
 	}
+	req.Network = network
 	if req.Timeout == 0 {
 		req.Timeout = 30
 	}
 
-	opts := VMCreateOptions{
+	ports, err := parsePortMappings(req.Ports)
+	if err != nil {
+		return VMCreateOptions{}, err
+	}
+	volumes, err := parseVolumeMounts(req.Volumes)
+	if err != nil {
+		return VMCreateOptions{}, err
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		return VMCreateOptions{}, err
+	}
+
+	return VMCreateOptions{
 		Language:    req.Language,
 		Image:       req.Image,
 		CPUCount:    req.CPU,
 		MemoryMiB:   req.Memory,
 		NetworkMode: req.Network,
 		Persist:     req.Persist,
-	}
-
-	record, err := api.vmService.Create(r.Context(), opts)
-	if err != nil {
-		api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	vmInfo := VMInfo{
-		ID:          record.ID,
-		Language:    record.Language,
-		Status:      record.Status,
-		CPUCount:    record.CPUCount,
-		MemoryMiB:   record.MemoryMiB,
-		NetworkMode: record.NetworkMode,
-		Persist:     record.Persist,
-		CreatedAt:   record.CreatedAt,
-		LastRunAt:   record.LastRunAt,
-	}
-
-	api.sendJSONSuccess(w, vmInfo, http.StatusCreated)
+		PullPolicy:  req.PullPolicy,
+		Ports:       ports,
+
+		TTL:              time.Duration(req.TTL) * time.Second,
+		ExpirePersistent: req.ExpirePersistent,
+		Volumes:          volumes,
+		GuestMounts:      req.Mounts,
+		GuestWorkdir:     req.Workdir,
+		Owner:            req.Owner,
+		Labels:           req.Labels,
+		Tenant:           requestTenant(r),
+		Name:             req.Name,
+	}, nil
 }
 
 // handleExecuteInVM handles command execution in existing VMs
@@ -280,8 +642,12 @@ func (api *APIServer) handleExecuteInVM(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if req.VMID == "" || req.Command == "" {
-		api.sendJSONError(w, "vm_id and command are required", http.StatusBadRequest)
+	if req.VMID == "" || (req.Command == "" && req.File == "" && len(req.Commands) == 0) {
+		api.sendJSONError(w, "vm_id and command (or file or commands) are required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Commands) > 0 && (req.Command != "" || req.File != "") {
+		api.sendJSONError(w, "commands cannot be combined with command or file", http.StatusBadRequest)
 		return
 	}
 
@@ -289,51 +655,34 @@ func (api *APIServer) handleExecuteInVM(w http.ResponseWriter, r *http.Request)
 		req.Timeout = 30
 	}
 
-	opts := VMRunOptions{
-		VMID:    req.VMID,
-		Command: req.Command,
-		File:    req.File,
-		Timeout: req.Timeout,
-	}
-
-	result, err := api.vmService.Run(r.Context(), opts)
-	if err != nil {
-		var runErr *VMRunError
-		// Extract result from error if available
-		if errors.As(err, &runErr) {
-			result = runErr.Result
-		}
+	if !api.ownsVM(r, req.VMID) {
+		api.sendJSONError(w, errVMNotFound.Error(), http.StatusNotFound)
+		return
 	}
 
-	// Read the output files
-	stdoutContent := ""
-	stderrContent := ""
-
-	if result.StdoutPath != "" {
-		if data, err := os.ReadFile(result.StdoutPath); err == nil {
-			stdoutContent = string(data)
-		}
-	}
-	if result.StderrPath != "" {
-		if data, err := os.ReadFile(result.StderrPath); err == nil {
-			stderrContent = string(data)
-		}
+	opts := VMRunOptions{
+		VMID:            req.VMID,
+		Command:         req.Command,
+		Commands:        req.Commands,
+		ContinueOnError: req.ContinueOnError,
+		File:            req.File,
+		Stdin:           req.Stdin,
+		AutoInstall:     req.AutoInstall,
+		GuestTimeout:    req.GuestTimeout,
+		CleanOutput:     req.CleanOutput,
+		Envs:            req.Envs,
+		Timeout:         req.Timeout,
 	}
 
-	execResult := ExecutionResult{
-		VMID:     req.VMID,
-		ExitCode: result.ExitCode,
-		Stdout:   stdoutContent,
-		Stderr:   stderrContent,
-		Duration: result.Duration.String(),
-	}
+	output, err := api.vmService.Exec(r.Context(), opts)
+	execResult := execOutputToResult(req.VMID, output)
 
 	if err != nil {
 		api.sendJSONResponse(w, APIResponse{
 			Success: false,
 			Error:   err.Error(),
 			Data:    execResult,
-		}, http.StatusInternalServerError)
+		}, statusCodeForVMError(err))
 		return
 	}
 
@@ -358,16 +707,13 @@ func (api *APIServer) handleRunTemp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set defaults
-	if req.CPU == 0 {
-		req.CPU = 1
-	}
-	if req.Memory == 0 {
-		req.Memory = 256
-	}
-	if req.Network == "" {
-		req.Network = "none"
+	// Set defaults; zero CPU and memory take the language's resource profile
+	network, err := normalizeNetworkMode(req.Network)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
 	}
+	req.Network = network
 	if req.Timeout == 0 {
 		req.Timeout = 30
 	}
@@ -380,6 +726,13 @@ func (api *APIServer) handleRunTemp(w http.ResponseWriter, r *http.Request) {
 		MemoryMiB:   req.Memory,
 		NetworkMode: req.Network,
 		Persist:     req.Persist,
+		PullPolicy:  req.PullPolicy,
+		Owner:       req.Owner,
+		Labels:      req.Labels,
+		Tenant:      requestTenant(r),
+
+		GuestMounts:  req.Mounts,
+		GuestWorkdir: req.Workdir,
 	}
 
 	record, err := api.vmService.Create(r.Context(), opts)
@@ -392,39 +745,22 @@ func (api *APIServer) handleRunTemp(w http.ResponseWriter, r *http.Request) {
 
 	// Execute command in the temporary VM
 	runOpts := VMRunOptions{
-		VMID:    vmID,
-		Command: req.Command,
-		File:    req.File,
-		Timeout: req.Timeout,
+		VMID:         vmID,
+		Command:      req.Command,
+		File:         req.File,
+		Stdin:        req.Stdin,
+		AutoInstall:  req.AutoInstall,
+		GuestTimeout: req.GuestTimeout,
+		Envs:         req.Envs,
+		Timeout:      req.Timeout,
 	}
 
-	runResult, err := api.vmService.Run(r.Context(), runOpts)
+	output, err := api.vmService.Exec(r.Context(), runOpts)
 
 	// Clean up the temporary VM regardless of execution result
 	cleanupErr := api.vmService.Clean(r.Context(), vmID, false)
 
-	// Read the output files
-	stdoutContent := ""
-	stderrContent := ""
-
-	if runResult.StdoutPath != "" {
-		if data, err := os.ReadFile(runResult.StdoutPath); err == nil {
-			stdoutContent = string(data)
-		}
-	}
-	if runResult.StderrPath != "" {
-		if data, err := os.ReadFile(runResult.StderrPath); err == nil {
-			stderrContent = string(data)
-		}
-	}
-
-	execResult := ExecutionResult{
-		VMID:     vmID,
-		ExitCode: runResult.ExitCode,
-		Stdout:   stdoutContent,
-		Stderr:   stderrContent,
-		Duration: runResult.Duration.String(),
-	}
+	execResult := execOutputToResult(vmID, output)
 
 	if err != nil {
 		api.sendJSONResponse(w, APIResponse{
@@ -436,7 +772,7 @@ func (api *APIServer) handleRunTemp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if cleanupErr != nil {
-		api.logger.Warn("failed to cleanup temporary vm", map[string]any{
+		loggerFor(r.Context(), api.logger).Warn("failed to cleanup temporary vm", map[string]any{
 			"vm":    vmID,
 			"error": cleanupErr.Error(),
 		})
@@ -454,54 +790,100 @@ func (api *APIServer) handleListVMs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	statusFilter := r.URL.Query().Get("status")
-	includeAllStr := r.URL.Query().Get("all")
+	query := r.URL.Query()
+	includeAllStr := query.Get("all")
 	includeAll := includeAllStr == "true" || includeAllStr == "1"
 
-	records, err := api.vmService.List(r.Context())
+	now := time.Now()
+	createdAfter, err := parseTimeBound(query.Get("since"), now)
 	if err != nil {
-		api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
+		api.sendJSONError(w, "since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	createdBefore, err := parseTimeBound(query.Get("until"), now)
+	if err != nil {
+		api.sendJSONError(w, "until: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Filter by status if specified
-	if statusFilter != "" {
-		filtered := make([]VMRecord, 0)
-		for _, record := range records {
-			if string(record.Status) == statusFilter {
-				filtered = append(filtered, record)
-			}
-		}
-		records = filtered
+	labels, err := parseLabels(query["label"])
+	if err != nil {
+		api.sendJSONError(w, "label: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// If includeAll is false, only show active VMs
-	if !includeAll {
-		active := make([]VMRecord, 0)
-		for _, record := range records {
-			if record.Status == vmStatusReady || record.Status == vmStatusRunning {
-				active = append(active, record)
-			}
+	// Pagination is opt-in so that plain listings keep returning an array
+	paginate := query.Has("limit") || query.Has("offset")
+	limit, offset := 0, 0
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			api.sendJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
 		}
-		records = active
+		limit = parsed
+		if limit > maxVMListLimit {
+			limit = maxVMListLimit
+		}
+	}
+	if raw := query.Get("offset"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			api.sendJSONError(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		offset = parsed
+	}
+
+	records, err := api.vmService.ListTenant(r.Context(), requestTenant(r))
+	launcherWarning := ""
+	if errors.Is(err, errLauncherList) {
+		// Still list the cached records, flagged, rather than failing outright
+		launcherWarning = err.Error()
+	} else if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// All filters apply together; stopped VMs need all=true or a status
+	records = filterVMs(records, VMFilter{
+		Status:         query.Get("status"),
+		Owner:          query.Get("owner"),
+		Language:       query.Get("language"),
+		Labels:         labels,
+		CreatedAfter:   createdAfter,
+		CreatedBefore:  createdBefore,
+		IncludeStopped: includeAll,
+	})
+
+	// Records arrive sorted by CreatedAt then ID, so pages are stable
+	total := len(records)
+	next := -1
+	if paginate {
+		records, next = paginateVMs(records, offset, limit)
 	}
 
 	vmInfos := make([]VMInfo, len(records))
 	for i, record := range records {
-		vmInfos[i] = VMInfo{
-			ID:          record.ID,
-			Language:    record.Language,
-			Status:      record.Status,
-			CPUCount:    record.CPUCount,
-			MemoryMiB:   record.MemoryMiB,
-			NetworkMode: record.NetworkMode,
-			Persist:     record.Persist,
-			CreatedAt:   record.CreatedAt,
-			LastRunAt:   record.LastRunAt,
+		vmInfos[i] = vmRecordToInfo(record)
+		vmInfos[i].PresenceUnknown = launcherWarning != ""
+	}
+
+	var data interface{} = vmInfos
+	if paginate {
+		page := VMListPage{VMs: vmInfos, Count: len(vmInfos), Total: total}
+		if next >= 0 {
+			page.NextOffset = &next
 		}
+		data = page
 	}
 
-	api.sendJSONSuccess(w, vmInfos, http.StatusOK)
+	api.sendJSONResponse(w, APIResponse{
+		Success:         true,
+		Data:            data,
+		StatusCode:      http.StatusOK,
+		LauncherWarning: launcherWarning,
+	}, http.StatusOK)
 }
 
 // handleStopVM handles VM stopping requests
@@ -519,9 +901,10 @@ func (api *APIServer) handleStopVM(w http.ResponseWriter, r *http.Request) {
 
 	vmIDs := []string{req.VMID}
 	if r.URL.Query().Get("all") == "true" {
-		// Get all VM IDs
-		records, err := api.vmService.List(r.Context())
-		if err != nil {
+		// Get all of the tenant's VM IDs; the cached records suffice when
+		// the launcher cannot be listed
+		records, err := api.vmService.ListTenant(r.Context(), requestTenant(r))
+		if err != nil && !errors.Is(err, errLauncherList) {
 			api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -531,11 +914,18 @@ func (api *APIServer) handleStopVM(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	stop := api.vmService.Stop
+	if req.Force {
+		stop = api.vmService.ForceStop
+	}
+
 	successCount := 0
 	var errors []string
 
 	for _, vmID := range vmIDs {
-		if err := api.vmService.Stop(r.Context(), vmID); err != nil {
+		if !api.ownsVM(r, vmID) {
+			errors = append(errors, fmt.Sprintf("failed to stop VM %s: %v", vmID, errVMNotFound))
+		} else if err := stop(r.Context(), vmID); err != nil {
 			errors = append(errors, fmt.Sprintf("failed to stop VM %s: %v", vmID, err))
 		} else {
 			successCount++
@@ -574,9 +964,10 @@ func (api *APIServer) handleCleanVM(w http.ResponseWriter, r *http.Request) {
 
 	vmIDs := []string{req.VMID}
 	if r.URL.Query().Get("all") == "true" {
-		// Get all VM IDs
-		records, err := api.vmService.List(r.Context())
-		if err != nil {
+		// Get all of the tenant's VM IDs; the cached records suffice when
+		// the launcher cannot be listed
+		records, err := api.vmService.ListTenant(r.Context(), requestTenant(r))
+		if err != nil && !errors.Is(err, errLauncherList) {
 			api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -586,11 +977,18 @@ func (api *APIServer) handleCleanVM(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	clean := api.vmService.Clean
+	if req.Force {
+		clean = api.vmService.ForceClean
+	}
+
 	successCount := 0
 	var errors []string
 
 	for _, vmID := range vmIDs {
-		if err := api.vmService.Clean(r.Context(), vmID, req.KeepPersist); err != nil {
+		if !api.ownsVM(r, vmID) {
+			errors = append(errors, fmt.Sprintf("failed to clean VM %s: %v", vmID, errVMNotFound))
+		} else if err := clean(r.Context(), vmID, req.KeepPersist); err != nil {
 			errors = append(errors, fmt.Sprintf("failed to clean VM %s: %v", vmID, err))
 		} else {
 			successCount++
@@ -614,11 +1012,816 @@ func (api *APIServer) handleCleanVM(w http.ResponseWriter, r *http.Request) {
 	}, http.StatusOK)
 }
 
-// handleShell would handle shell requests (though this would require WebSocket for interactivity)
+// handleVMRoutes dispatches per-VM routes of the form /api/vm/{id}/{action}
+func (api *APIServer) handleVMRoutes(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/vm/")
+	vmID, action, _ := strings.Cut(rest, "/")
+	if vmID == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !api.ownsVM(r, vmID) {
+		api.sendJSONError(w, errVMNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "":
+		api.handleUpdateVM(w, r, vmID)
+	case "stats":
+		api.handleVMStats(w, r, vmID)
+	case "shell/ws":
+		api.handleShellWebSocket(w, r, vmID)
+	case "abort":
+		api.handleAbortVM(w, r, vmID)
+	case "repl":
+		api.handleVMREPL(w, r, vmID)
+	case "clone":
+		api.handleCloneVM(w, r, vmID)
+	case "snapshots":
+		api.handleSnapshotVM(w, r, vmID)
+	case "files":
+		api.handleListVMFiles(w, r, vmID)
+	case "files/archive":
+		if r.Method == http.MethodGet {
+			api.handleDownloadArchive(w, r, vmID)
+			return
+		}
+		api.handleUploadArchive(w, r, vmID)
+	case "runs", "history":
+		api.handleVMRuns(w, r, vmID)
+	case "logs":
+		api.handleVMLogs(w, r, vmID)
+	case "logs/follow":
+		api.handleFollowVMLogs(w, r, vmID)
+	default:
+		if rel, ok := strings.CutPrefix(action, "files/"); ok {
+			api.handleVMFile(w, r, vmID, rel)
+			return
+		}
+		if name, ok := snapshotRestoreRoute(action); ok {
+			api.handleRestoreVM(w, r, vmID, name)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// handleUpdateVM resizes a VM with PATCH /api/vm/{id}, taking cpu and memory
+// from the body; a field left out or zero keeps its current value
+func (api *APIServer) handleUpdateVM(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodPatch {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req APIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.sendJSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.CPU == 0 && req.Memory == 0 {
+		api.sendJSONError(w, "cpu or memory is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := api.vmService.UpdateResources(r.Context(), vmID, req.CPU, req.Memory); err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+	record, err := api.vmService.fetchRecord(vmID)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+	api.sendJSONSuccess(w, vmRecordToInfo(record), http.StatusOK)
+}
+
+// snapshotRestoreRoute matches the action snapshots/{name}/restore.
+func snapshotRestoreRoute(action string) (string, bool) {
+	rest, ok := strings.CutPrefix(action, "snapshots/")
+	if !ok {
+		return "", false
+	}
+	name, ok := strings.CutSuffix(rest, "/restore")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// SnapshotRequest names the snapshot to take.
+type SnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// handleSnapshotVM archives a persistent VM's persist directory under a name
+func (api *APIServer) handleSnapshotVM(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SnapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.sendJSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if _, err := snapshotPath(vmID, req.Name); err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.vmService.Snapshot(r.Context(), vmID, req.Name); err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	api.sendJSONSuccess(w, map[string]interface{}{
+		"vm_id":    vmID,
+		"snapshot": req.Name,
+	}, http.StatusCreated)
+}
+
+// handleRestoreVM replaces a VM's persist directory with a named snapshot
+func (api *APIServer) handleRestoreVM(w http.ResponseWriter, r *http.Request, vmID, name string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := snapshotPath(vmID, name); err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := api.vmService.Restore(r.Context(), vmID, name); err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	api.sendJSONSuccess(w, map[string]interface{}{
+		"vm_id":    vmID,
+		"restored": name,
+	}, http.StatusOK)
+}
+
+// handleVMStats reports CPU, memory and uptime for a running VM
+func (api *APIServer) handleVMStats(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats, err := api.vmService.Stats(r.Context(), vmID)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	api.sendJSONSuccess(w, VMStatsInfo{
+		VMID:       vmID,
+		PID:        stats.PID,
+		CPUPercent: stats.CPUPercent,
+		MemoryMiB:  stats.MemoryMiB,
+		Uptime:     stats.Uptime.String(),
+	}, http.StatusOK)
+}
+
+// handleAbortVM cancels in-flight runs on a VM while leaving it running
+func (api *APIServer) handleAbortVM(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	aborted, err := api.vmService.Abort(vmID)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	api.sendJSONSuccess(w, map[string]interface{}{
+		"vm_id":   vmID,
+		"aborted": aborted,
+	}, http.StatusOK)
+}
+
+// REPLRequest is the code for one eval in a VM's REPL session.
+type REPLRequest struct {
+	Code    string `json:"code"`
+	Timeout int    `json:"timeout"`
+}
+
+// REPLEvalResult is the API form of REPLResult.
+type REPLEvalResult struct {
+	VMID      string `json:"vm_id"`
+	ExitCode  int    `json:"exit_code"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Duration  string `json:"duration"`
+	Started   bool   `json:"started,omitempty"`
+	Exited    bool   `json:"exited,omitempty"`
+	TimedOut  bool   `json:"timed_out,omitempty"`
+	Aborted   bool   `json:"aborted,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// handleVMREPL evaluates code in the VM's REPL session with POST, starting
+// the interpreter on first use, and ends the session with DELETE
+func (api *APIServer) handleVMREPL(w http.ResponseWriter, r *http.Request, vmID string) {
+	switch r.Method {
+	case http.MethodPost:
+	case http.MethodDelete:
+		if err := api.vmService.StopREPL(vmID); err != nil {
+			api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+			return
+		}
+		api.sendJSONSuccess(w, map[string]interface{}{"vm_id": vmID}, http.StatusOK)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req REPLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.sendJSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Timeout == 0 {
+		req.Timeout = 30
+	}
+
+	result, err := api.vmService.EvalREPL(r.Context(), vmID, req.Code, time.Duration(req.Timeout)*time.Second)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+	api.sendJSONSuccess(w, REPLEvalResult{
+		VMID:      vmID,
+		ExitCode:  result.ExitCode,
+		Stdout:    result.Stdout,
+		Stderr:    result.Stderr,
+		Duration:  result.Duration.String(),
+		Started:   result.Started,
+		Exited:    result.Exited,
+		TimedOut:  result.TimedOut,
+		Aborted:   result.Aborted,
+		Truncated: result.Truncated,
+	}, http.StatusOK)
+}
+
+// handleCloneVM creates a copy of a persistent VM, including its persist
+// directory. The body is optional and may set cpu, memory and labels.
+func (api *APIServer) handleCloneVM(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req APIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		api.sendJSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if err := validateLabels(req.Labels); err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	record, err := api.vmService.Clone(r.Context(), vmID, CloneOptions{
+		CPUCount:  req.CPU,
+		MemoryMiB: req.Memory,
+		Labels:    req.Labels,
+	})
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	vmInfo := vmRecordToInfo(record)
+	vmInfo.Timings = createTimingsToInfo(record.CreateTimings)
+	api.sendJSONSuccess(w, vmInfo, http.StatusCreated)
+}
+
+// handleUploadArchive extracts a .tar or .tar.gz request body into the VM's /in
+func (api *APIServer) handleUploadArchive(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := api.vmService.ExtractArchive(vmID, r.Body)
+	if err != nil {
+		status := statusCodeForVMError(err)
+		if errors.Is(err, errUnsafeArchivePath) || errors.Is(err, errInvalidArchive) {
+			status = http.StatusBadRequest
+		}
+		api.sendJSONError(w, err.Error(), status)
+		return
+	}
+
+	api.sendJSONSuccess(w, ArchiveUploadInfo{
+		VMID:  vmID,
+		Paths: result.Paths,
+		Bytes: result.Bytes,
+	}, http.StatusOK)
+}
+
+// handleListVMFiles lists the files in the VM's storage directory, or in the
+// subtree named by ?path=, with their sizes; ?checksum=sha256 adds a hash of
+// each
+func (api *APIServer) handleListVMFiles(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	checksum := false
+	switch strings.ToLower(query.Get("checksum")) {
+	case "":
+	case "sha256":
+		checksum = true
+	default:
+		api.sendJSONError(w, "checksum must be sha256", http.StatusBadRequest)
+		return
+	}
+
+	files, err := api.vmService.ListFiles(vmID, strings.TrimSpace(query.Get("path")), checksum)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForFileError(err))
+		return
+	}
+
+	info := VMFilesInfo{VMID: vmID, Files: make([]VMFileInfo, 0, len(files))}
+	for _, file := range files {
+		info.Files = append(info.Files, VMFileInfo{Path: file.Path, Size: file.Size, SHA256: file.SHA256})
+	}
+	api.sendJSONSuccess(w, info, http.StatusOK)
+}
+
+// handleVMFile serves one file of the VM's storage directory, by its path
+// relative to the storage root. PUT uploads it, appending when a
+// Content-Range header says where the body goes; HEAD reports its current
+// size as Content-Length so an interrupted upload can resume
+func (api *APIServer) handleVMFile(w http.ResponseWriter, r *http.Request, vmID, rel string) {
+	switch r.Method {
+	case http.MethodHead:
+		size, err := api.vmService.FileSize(vmID, rel)
+		if err != nil {
+			w.WriteHeader(statusCodeForFileError(err))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		var rng *UploadRange
+		if header := r.Header.Get("Content-Range"); header != "" {
+			parsed, err := parseContentRange(header)
+			if err != nil {
+				api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rng = &parsed
+		}
+
+		size, err := api.vmService.WriteFile(vmID, rel, r.Body, rng)
+		if errors.Is(err, errUploadRangeMismatch) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			api.sendJSONError(w, fmt.Sprintf("%s (%d bytes)", err.Error(), size), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err != nil {
+			api.sendJSONError(w, err.Error(), statusCodeForFileError(err))
+			return
+		}
+		api.sendJSONSuccess(w, VMFileInfo{Path: rel, Size: size}, http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// parseContentRange parses a "bytes start-end/total" Content-Range header;
+// the total may be "*"
+func parseContentRange(header string) (UploadRange, error) {
+	invalid := fmt.Errorf("invalid Content-Range %q: want bytes start-end/total", header)
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return UploadRange{}, invalid
+	}
+	span, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return UploadRange{}, invalid
+	}
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return UploadRange{}, invalid
+	}
+
+	rng := UploadRange{Total: -1}
+	var err error
+	if rng.Start, err = strconv.ParseInt(first, 10, 64); err != nil || rng.Start < 0 {
+		return UploadRange{}, invalid
+	}
+	if rng.End, err = strconv.ParseInt(last, 10, 64); err != nil || rng.End < rng.Start {
+		return UploadRange{}, invalid
+	}
+	if total != "*" {
+		if rng.Total, err = strconv.ParseInt(total, 10, 64); err != nil || rng.Total <= rng.End {
+			return UploadRange{}, invalid
+		}
+	}
+	return rng, nil
+}
+
+// statusCodeForFileError maps storage path errors on top of
+// statusCodeForVMError: unsafe paths and mismatched bodies are the client's
+// fault, and missing files are not found
+func statusCodeForFileError(err error) int {
+	switch {
+	case errors.Is(err, errUnsafeArchivePath), errors.Is(err, errUploadLength):
+		return http.StatusBadRequest
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	default:
+		return statusCodeForVMError(err)
+	}
+}
+
+// handleDownloadArchive streams a .tar.gz of a subtree of the VM's storage
+// directory, "out" unless ?path= names another one
+func (api *APIServer) handleDownloadArchive(w http.ResponseWriter, r *http.Request, vmID string) {
+	rel := strings.TrimSpace(r.URL.Query().Get("path"))
+	if rel == "" {
+		rel = "out"
+	}
+
+	root, err := api.vmService.ResolveVMPath(vmID, rel)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForFileError(err))
+		return
+	}
+
+	release, ok := api.acquireStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", vmID+"-"+filepath.Base(root)+".tar.gz"))
+	w.WriteHeader(http.StatusOK)
+
+	// Headers are already sent, so a failure can only truncate the stream.
+	if err := writeDirArchive(root, w); err != nil {
+		loggerFor(r.Context(), api.logger).Warn("archive download failed", map[string]any{
+			"vm":    vmID,
+			"path":  rel,
+			"error": err.Error(),
+		})
+	}
+}
+
+// handleImageCheck reports whether an image reference exists in its registry
+func (api *APIServer) handleImageCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref := strings.TrimSpace(r.URL.Query().Get("ref"))
+	if ref == "" {
+		api.sendJSONError(w, "ref query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.vmService.CheckImage(r.Context(), ref)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	info := ImageCheckInfo{
+		Ref:       result.Ref,
+		Exists:    result.Exists,
+		Digest:    result.Digest,
+		SizeBytes: result.SizeBytes,
+	}
+	if !result.Exists {
+		api.sendJSONResponse(w, APIResponse{
+			Success:    false,
+			Error:      fmt.Sprintf("image %s not found", ref),
+			Data:       info,
+			StatusCode: http.StatusNotFound,
+		}, http.StatusNotFound)
+		return
+	}
+
+	api.sendJSONSuccess(w, info, http.StatusOK)
+}
+
+// handleCompare runs a program in a temporary VM per language and returns the
+// outputs side by side
+func (api *APIServer) handleCompare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.sendJSONError(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if len(req.Languages) == 0 {
+		api.sendJSONError(w, "languages are required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := api.vmService.Compare(r.Context(), CompareOptions{
+		Languages: req.Languages,
+		Code:      req.Code,
+		Sources:   req.Sources,
+		CPUCount:  req.CPU,
+		MemoryMiB: req.Memory,
+		Timeout:   req.Timeout,
+		Tenant:    requestTenant(r),
+	})
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	info := CompareInfo{
+		Consistent: result.Consistent,
+		Runs:       make([]CompareRunInfo, 0, len(result.Runs)),
+	}
+	for _, run := range result.Runs {
+		info.Runs = append(info.Runs, CompareRunInfo{
+			Language: run.Language,
+			VMID:     run.VMID,
+			ExitCode: run.ExitCode,
+			Stdout:   run.Stdout,
+			Stderr:   run.Stderr,
+			Duration: run.Duration.String(),
+			Error:    run.Error,
+		})
+	}
+	api.sendJSONSuccess(w, info, http.StatusOK)
+}
+
+// handleRecentRuns lists the most recent runs across the tenant's VMs, newest
+// first
+func (api *APIServer) handleRecentRuns(w http.ResponseWriter, r *http.Request) {
+	api.serveRunHistory(w, r, "")
+}
+
+// handleVMLogs returns the stdout and stderr of the VM's most recent run,
+// optionally only the last ?tail=N lines of ?stream=stdout|stderr|both
+func (api *APIServer) handleVMLogs(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	stream, err := parseLogStream(query.Get("stream"))
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tail := 0
+	if raw := query.Get("tail"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			api.sendJSONError(w, "tail must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		tail = parsed
+	}
+
+	logs, err := api.vmService.Logs(vmID, tail)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+
+	info := VMLogsInfo{VMID: vmID}
+	if stream != "stderr" {
+		info.Stdout = &logs.Stdout
+	}
+	if stream != "stdout" {
+		info.Stderr = &logs.Stderr
+	}
+	api.sendJSONSuccess(w, info, http.StatusOK)
+}
+
+// handleFollowVMLogs streams lines appended to the VM's logs as server-sent
+// events named stdout or stderr, until the client disconnects
+func (api *APIServer) handleFollowVMLogs(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.sendJSONError(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	release, ok := api.acquireStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	err := api.vmService.FollowLogs(r.Context(), vmID, LogFollowOptions{}, func(stream, line string) error {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", stream, line); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil && r.Context().Err() == nil {
+		loggerFor(r.Context(), api.logger).Warn("log follow ended", map[string]any{
+			"vm":    vmID,
+			"error": err.Error(),
+		})
+	}
+}
+
+// handleVMRuns lists the most recent runs on a single VM, newest first
+func (api *APIServer) handleVMRuns(w http.ResponseWriter, r *http.Request, vmID string) {
+	api.serveRunHistory(w, r, vmID)
+}
+
+func (api *APIServer) serveRunHistory(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 0
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			api.sendJSONError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	entries, err := api.vmService.RecentRuns(requestTenant(r), vmID, limit)
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	runs := make([]RunInfo, 0, len(entries))
+	for _, entry := range entries {
+		runs = append(runs, RunInfo{
+			VMID:      entry.VMID,
+			Command:   entry.Command,
+			ExitCode:  entry.ExitCode,
+			Status:    entry.Status,
+			StartedAt: entry.StartedAt,
+			Duration:  entry.Duration.String(),
+			Truncated: entry.Truncated,
+		})
+	}
+	api.sendJSONSuccess(w, runs, http.StatusOK)
+}
+
+// handleDBCheck reports state database integrity; unhealthy stores answer 503
+// with the findings attached. The report spans every tenant's records, so only
+// the default tenant may ask for it.
+func (api *APIServer) handleDBCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if requestTenant(r) != defaultTenant {
+		api.sendJSONError(w, "db-check is limited to the default tenant", http.StatusForbidden)
+		return
+	}
+
+	result, err := api.vmService.CheckStore()
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info := DBCheckInfo{
+		Healthy:    result.Healthy(),
+		Path:       result.Path,
+		Schema:     result.SchemaVersion,
+		VMs:        result.VMs,
+		Volumes:    result.Volumes,
+		Corrupt:    make([]CorruptEntryInfo, 0, len(result.Corrupt)),
+		PageErrors: result.PageErrors,
+	}
+	for _, entry := range result.Corrupt {
+		info.Corrupt = append(info.Corrupt, CorruptEntryInfo{
+			Bucket: entry.Bucket,
+			Key:    entry.Key,
+			Error:  entry.Err,
+		})
+	}
+
+	if !info.Healthy {
+		api.sendJSONResponse(w, APIResponse{
+			Success:    false,
+			Error:      "state database has integrity problems",
+			Data:       info,
+			StatusCode: http.StatusServiceUnavailable,
+		}, http.StatusServiceUnavailable)
+		return
+	}
+
+	api.sendJSONSuccess(w, info, http.StatusOK)
+}
+
+// execOutputToResult converts captured run output into its API representation
+func execOutputToResult(vmID string, output ExecOutput) ExecutionResult {
+	return ExecutionResult{
+		VMID:          vmID,
+		ExitCode:      output.ExitCode,
+		Stdout:        output.Stdout,
+		Stderr:        output.Stderr,
+		Duration:      output.Duration.String(),
+		Aborted:       output.Aborted,
+		TimedOut:      output.TimedOut,
+		Truncated:     output.Truncated,
+		AutoInstalled: output.AutoInstalled,
+	}
+}
+
+// vmRecordToInfo converts a stored VM record into its API representation
+func vmRecordToInfo(record VMRecord) VMInfo {
+	info := VMInfo{
+		ID:          record.ID,
+		Language:    record.Language,
+		Status:      string(record.Status),
+		CPUCount:    record.CPUCount,
+		MemoryMiB:   record.MemoryMiB,
+		NetworkMode: record.NetworkMode,
+		Persist:     record.Persist,
+		CreatedAt:   record.CreatedAt,
+		LastRunAt:   record.LastRunAt,
+		Owner:       record.Owner,
+		Labels:      record.Labels,
+		Name:        record.Name,
+	}
+	if !record.ExpiresAt.IsZero() {
+		expiresAt := record.ExpiresAt
+		info.ExpiresAt = &expiresAt
+	}
+	for _, port := range record.Ports {
+		info.Ports = append(info.Ports, port.String())
+	}
+	return info
+}
+
+func createTimingsToInfo(timings VMCreateTimings) *CreateTimingsInfo {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return &CreateTimingsInfo{
+		ResolveMS: ms(timings.Resolve),
+		StorageMS: ms(timings.Storage),
+		LaunchMS:  ms(timings.Launch),
+		PersistMS: ms(timings.Persist),
+		TotalMS:   ms(timings.Total),
+	}
+}
+
+// statusCodeForVMError maps VM service errors to HTTP status codes
+func statusCodeForVMError(err error) int {
+	switch {
+	case errors.Is(err, errVMNotFound), errors.Is(err, errSnapshotNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errVMNotRunning), errors.Is(err, errVMNotPersistent), errors.Is(err, errREPLClosed), errors.Is(err, errVMExists):
+		return http.StatusConflict
+	case errors.Is(err, errResourceLimit), errors.Is(err, errUnsupportedLang), errors.Is(err, errInvalidNetworkMode):
+		return http.StatusBadRequest
+	case errors.Is(err, errVMLimit):
+		return http.StatusTooManyRequests
+	case errors.Is(err, errCommandBlocked):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// handleShell points clients at the WebSocket shell endpoint
 func (api *APIServer) handleShell(w http.ResponseWriter, r *http.Request) {
-	// Shell functionality would require WebSocket connection for interactivity
-	// For now, return not implemented
-	http.Error(w, "shell endpoint requires WebSocket connection, not implemented yet", http.StatusNotImplemented)
+	// Interactive shells need a bidirectional stream, see handleShellWebSocket
+	http.Error(w, "shell requires a WebSocket connection to /api/vm/{id}/shell/ws", http.StatusNotImplemented)
 }
 
 // sendJSONSuccess sends a successful JSON response
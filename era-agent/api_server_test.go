@@ -0,0 +1,1291 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestAPIServer(t *testing.T, svc *VMService) (*APIServer, *httptest.Server) {
+	t.Helper()
+	api := NewAPIServer(svc, svc.logger, ":0")
+	server := httptest.NewServer(api.server.Handler)
+	t.Cleanup(server.Close)
+	return api, server
+}
+
+func TestShellWebSocketEcho(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/" + record.ID + "/shell/ws?cmd=/bin/sh"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"resize","cols":120,"rows":40}`)); err != nil {
+		t.Fatalf("write resize failed: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("echo hi\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var received strings.Builder
+	resizeRejected := false
+	isResizeError := func(payload []byte) bool {
+		var msg shellErrorMessage
+		return json.Unmarshal(payload, &msg) == nil && msg.Type == "error" && strings.Contains(msg.Error, "resize")
+	}
+	for !strings.Contains(received.String(), "hi\n") {
+		msgType, payload, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read failed before output arrived (got %q): %v", received.String(), err)
+		}
+		if msgType == websocket.BinaryMessage {
+			received.Write(payload)
+		} else if isResizeError(payload) {
+			resizeRejected = true
+		}
+	}
+
+	if strings.Contains(received.String(), "resize") {
+		t.Fatalf("resize control message leaked into shell stdin: %q", received.String())
+	}
+
+	// Closing stdin ends the shell, which reports its exit status.
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("exit 3\n")); err != nil {
+		t.Fatalf("write exit failed: %v", err)
+	}
+	for {
+		var exitMsg shellExitMessage
+		msgType, payload, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection closed without exit message: %v", err)
+		}
+		if msgType != websocket.TextMessage {
+			continue
+		}
+		if isResizeError(payload) {
+			resizeRejected = true
+			continue
+		}
+		if err := json.Unmarshal(payload, &exitMsg); err != nil {
+			t.Fatalf("invalid exit message %q: %v", payload, err)
+		}
+		if exitMsg.Type != "exit" || exitMsg.ExitCode != 3 {
+			t.Fatalf("unexpected exit message: %+v", exitMsg)
+		}
+		break
+	}
+	if !resizeRejected {
+		t.Fatal("resize message was not answered with an error")
+	}
+}
+
+func TestShellWebSocketChecksOrigin(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/" + record.ID + "/shell/ws?cmd=/bin/sh"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil {
+		t.Fatal("cross-origin dial succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross-origin dial: expected 403, got %+v", resp)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {server.URL}})
+	if err != nil {
+		t.Fatalf("same-origin dial failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestShellWebSocketNeedsAPIKey(t *testing.T) {
+	t.Setenv("ERA_API_KEY", "shell-key")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/" + record.ID + "/shell/ws?cmd=/bin/sh"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Origin": {"http://evil.example"}})
+	if err == nil {
+		t.Fatal("dial without an API key succeeded")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("dial without an API key: expected 401, got %+v", resp)
+	}
+
+	// The key, not the origin, is what admits a client once auth is on.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{
+		"Authorization": {"Bearer shell-key"},
+		"Origin":        {"http://tool.example"},
+	})
+	if err != nil {
+		t.Fatalf("dial with the API key failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestStopEndsOpenShell(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/" + record.ID + "/shell/ws?cmd=/bin/sh"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Wait until the shell is up before stopping the VM.
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("echo up\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, payload, err := conn.ReadMessage(); err != nil || !strings.Contains(string(payload), "up") {
+		t.Fatalf("shell did not start: %q, %v", payload, err)
+	}
+
+	if err := svc.Stop(context.Background(), record.ID); err != nil {
+		t.Fatalf("stop failed: %v", err)
+	}
+	for {
+		msgType, payload, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection closed without exit message: %v", err)
+		}
+		var exitMsg shellExitMessage
+		if msgType == websocket.TextMessage && json.Unmarshal(payload, &exitMsg) == nil && exitMsg.Type == "exit" {
+			break
+		}
+	}
+}
+
+func TestShellWebSocketUnknownVM(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/missing/shell/ws"
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("expected dial to fail for unknown vm")
+	}
+	if resp == nil || resp.StatusCode != 404 {
+		t.Fatalf("expected 404, got %+v", resp)
+	}
+}
+
+func TestShellWebSocketRefusedUnderCommandPolicy(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(`{"allow": ["echo"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGENT_COMMAND_POLICY", policyPath)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/" + record.ID + "/shell/ws?cmd=/bin/sh"
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("shell opened while a command policy is set")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403, got %+v", resp)
+	}
+}
+
+func TestCreateResponseIncludesTimings(t *testing.T) {
+	launcher := newFakeLauncher()
+	launcher.launchFn = func(ctx context.Context, record VMRecord) error {
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	}
+	svc := newTestVMService(t, launcher)
+	_, server := newTestAPIServer(t, svc)
+
+	resp, err := http.Post(server.URL+"/api/vm/create", "application/json", strings.NewReader(`{"language":"python"}`))
+	if err != nil {
+		t.Fatalf("create request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("status = %d, want 201", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			ID      string             `json:"id"`
+			Timings map[string]float64 `json:"timings"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if stored, err := svc.store.Get(body.Data.ID); err != nil || stored.CreateTimings != (VMCreateTimings{}) {
+		t.Fatalf("stored record = %+v, %v; want no create timings", stored.CreateTimings, err)
+	}
+
+	timings := body.Data.Timings
+	for _, field := range []string{"resolve_ms", "storage_ms", "launch_ms", "persist_ms", "total_ms"} {
+		if _, ok := timings[field]; !ok {
+			t.Fatalf("timings missing %s: %v", field, timings)
+		}
+	}
+	if timings["launch_ms"] < 20 {
+		t.Fatalf("launch_ms = %v, want at least the simulated 20ms", timings["launch_ms"])
+	}
+
+	sum := timings["resolve_ms"] + timings["storage_ms"] + timings["launch_ms"] + timings["persist_ms"]
+	if diff := timings["total_ms"] - sum; diff < 0 || diff > 5 {
+		t.Fatalf("phases sum to %vms but total is %vms", sum, timings["total_ms"])
+	}
+}
+
+func TestDBCheckReportsCorruptEntry(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	if err := svc.store.(*BoltVMStore).db.Update(func(tx *bolt.Tx) error {
+		return tenantBucket(tx, defaultTenant).Put([]byte("corrupt"), []byte("garbage"))
+	}); err != nil {
+		t.Fatalf("write corrupt value: %v", err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/admin/db-check")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", resp.StatusCode)
+	}
+	var body struct {
+		Data DBCheckInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Data.Healthy || body.Data.VMs != 2 || len(body.Data.Corrupt) != 1 || body.Data.Corrupt[0].Key != "corrupt" {
+		t.Fatalf("unexpected report: %+v", body.Data)
+	}
+}
+
+func TestDBCheckRejectsOtherTenants(t *testing.T) {
+	t.Setenv("ERA_API_KEY", "admin-key,acme:acme-key")
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	for key, want := range map[string]int{"acme-key": http.StatusForbidden, "admin-key": http.StatusOK} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/api/admin/db-check", nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s: status = %d, want %d", key, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestRunTempReturnsOutputBeforeCleanup(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	resp, err := http.Post(server.URL+"/api/vm/temp", "application/json", strings.NewReader(`{"language":"python","command":"echo hello"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var body struct {
+		Data ExecutionResult `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if body.Data.Stdout != "hello\n" {
+		t.Fatalf("stdout = %q, want %q", body.Data.Stdout, "hello\n")
+	}
+}
+
+func TestUploadArchiveEndpoint(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	post := func(body []byte) *http.Response {
+		t.Helper()
+		resp, err := http.Post(server.URL+"/api/vm/"+record.ID+"/files/archive", "application/x-tar", bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post(buildTar(t, []tarEntry{{name: "main.py", body: "print(1)\n"}}))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	var body struct {
+		Data ArchiveUploadInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Data.Paths) != 1 || body.Data.Paths[0] != "/in/main.py" || body.Data.Bytes != 9 {
+		t.Fatalf("unexpected response: %+v", body.Data)
+	}
+
+	if resp := post(buildTar(t, []tarEntry{{name: "../escape", body: "x"}})); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("traversal status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDownloadArchiveRoundTrip(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	upload := buildTar(t, []tarEntry{
+		{name: "main.py", body: "print(1)\n"},
+		{name: "data/input.csv", body: "a,b\n"},
+	})
+	resp, err := http.Post(server.URL+"/api/vm/"+record.ID+"/files/archive", "application/x-tar", bytes.NewReader(upload))
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload status = %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + "/api/vm/" + record.ID + "/files/archive?path=in")
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("download status = %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/gzip" {
+		t.Fatalf("Content-Type = %q, want application/gzip", ct)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("read %s: %v", hdr.Name, err)
+		}
+		files[hdr.Name] = string(data)
+	}
+
+	want := map[string]string{"main.py": "print(1)\n", "data/input.csv": "a,b\n"}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("archive files = %v, want %v", files, want)
+	}
+
+	for path, status := range map[string]int{"../": http.StatusBadRequest, "missing": http.StatusNotFound} {
+		resp, err := http.Get(server.URL + "/api/vm/" + record.ID + "/files/archive?path=" + path)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("path %q status = %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestListVMFilesChecksums(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	results := filepath.Join(record.Storage.Root, "out", "results")
+	if err := os.MkdirAll(results, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(results, "hello.txt"), []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/passwd", filepath.Join(results, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) []VMFileInfo {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/api/vm/" + record.ID + "/files" + query)
+		if err != nil {
+			t.Fatalf("list request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Data VMFilesInfo `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("list %q status %d: %v", query, resp.StatusCode, err)
+		}
+		return body.Data.Files
+	}
+
+	want := []VMFileInfo{{Path: "out/results/hello.txt", Size: 6}}
+	if files := list("?path=out"); !reflect.DeepEqual(files, want) {
+		t.Fatalf("files without checksum = %+v, want %+v", files, want)
+	}
+	// sha256 of "hello\n"
+	want[0].SHA256 = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	if files := list("?path=out&checksum=sha256"); !reflect.DeepEqual(files, want) {
+		t.Fatalf("files with checksum = %+v, want %+v", files, want)
+	}
+	if files := list(""); len(files) != 1 || files[0].Path != want[0].Path || files[0].SHA256 != "" {
+		t.Fatalf("whole storage listing = %+v, want only %s without a hash", files, want[0].Path)
+	}
+
+	for query, status := range map[string]int{"?checksum=md5": http.StatusBadRequest, "?path=../": http.StatusBadRequest, "?path=missing": http.StatusNotFound} {
+		resp, err := http.Get(server.URL + "/api/vm/" + record.ID + "/files" + query)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Fatalf("%s status = %d, want %d", query, resp.StatusCode, status)
+		}
+	}
+}
+
+func TestResumableFileUpload(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+	url := server.URL + "/api/vm/" + record.ID + "/files/in/data/blob.bin"
+
+	put := func(body, contentRange string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentRange != "" {
+			req.Header.Set("Content-Range", contentRange)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("upload failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	head := func() int64 {
+		t.Helper()
+		resp, err := http.Head(url)
+		if err != nil {
+			t.Fatalf("head failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("head status = %d", resp.StatusCode)
+		}
+		return resp.ContentLength
+	}
+
+	if resp := put("hello", "bytes 0-4/10"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("first part status = %d", resp.StatusCode)
+	}
+	if size := head(); size != 5 {
+		t.Fatalf("size after first part = %d, want 5", size)
+	}
+
+	resp := put("lowor", "bytes 3-7/10")
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("misaligned part status = %d, want 416", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes */5" {
+		t.Fatalf("misaligned Content-Range = %q, want the current size", got)
+	}
+
+	if resp := put("world", "bytes 5-9/10"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("second part status = %d", resp.StatusCode)
+	}
+	data, err := os.ReadFile(filepath.Join(record.Storage.Root, "in", "data", "blob.bin"))
+	if err != nil || string(data) != "helloworld" {
+		t.Fatalf("uploaded file = %q (err %v), want %q", data, err, "helloworld")
+	}
+
+	if resp := put("replaced", ""); resp.StatusCode != http.StatusOK || head() != int64(len("replaced")) {
+		t.Fatalf("plain upload status = %d, want the file replaced", resp.StatusCode)
+	}
+	if resp := put("x", "bytes 5-1/10"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("malformed range status = %d, want 400", resp.StatusCode)
+	}
+	resp, err = http.Head(server.URL + "/api/vm/" + record.ID + "/files/in/missing")
+	if err != nil {
+		t.Fatalf("head failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("head of a missing file status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestRecentRunsOrderedAcrossVMs(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	first := createTestVM(t, svc)
+	second := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+	seed := []struct {
+		vm      VMRecord
+		command string
+	}{
+		{first, "echo one"},
+		{second, "echo two"},
+		{first, "exit 3"},
+		{second, "echo four"},
+	}
+	for _, run := range seed {
+		now = now.Add(time.Minute)
+		_, _ = svc.Run(context.Background(), VMRunOptions{VMID: run.vm.ID, Command: run.command, Timeout: 5})
+	}
+
+	resp, err := http.Get(server.URL + "/api/runs/recent?limit=3")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	var body struct {
+		Data []RunInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	var got []string
+	for _, run := range body.Data {
+		got = append(got, run.VMID+" "+run.Command)
+	}
+	want := []string{second.ID + " echo four", first.ID + " exit 3", second.ID + " echo two"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("recent runs = %v, want %v", got, want)
+	}
+	if body.Data[1].ExitCode != 3 || body.Data[1].Status != "failed" {
+		t.Fatalf("unexpected failed run entry: %+v", body.Data[1])
+	}
+
+	resp, err = http.Get(server.URL + "/api/vm/" + first.ID + "/runs")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body.Data = nil
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(body.Data) != 2 || body.Data[0].Command != "exit 3" || body.Data[1].Command != "echo one" {
+		t.Fatalf("per-vm runs = %+v", body.Data)
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	t.Setenv("ERA_API_KEY", "old-key, new-key")
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	get := func(path, auth string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	cases := []struct {
+		name, path, auth string
+		want             int
+	}{
+		{"missing header", "/api/vm/list", "", http.StatusUnauthorized},
+		{"wrong key", "/api/vm/list", "Bearer nope", http.StatusUnauthorized},
+		{"not bearer", "/api/vm/list", "Basic new-key", http.StatusUnauthorized},
+		{"current key", "/api/vm/list", "Bearer new-key", http.StatusOK},
+		{"rotated key", "/api/vm/list", "Bearer old-key", http.StatusOK},
+		{"health open", "/health", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		if got := get(tc.path, tc.auth); got != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Setenv("ERA_API_KEY", "acme:acme-key,globex:globex-key")
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	do := func(method, path, key, body string) (int, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("new request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		payload, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, payload
+	}
+
+	status, payload := do(http.MethodPost, "/api/vm/create", "acme-key", `{"language":"python"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", status, payload)
+	}
+	var created struct {
+		Data VMInfo `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &created); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	vmID := created.Data.ID
+
+	listIDs := func(key string) []string {
+		t.Helper()
+		status, payload := do(http.MethodGet, "/api/vm/list", key, "")
+		if status != http.StatusOK {
+			t.Fatalf("list status = %d: %s", status, payload)
+		}
+		var body struct {
+			Data []VMInfo `json:"data"`
+		}
+		if err := json.Unmarshal(payload, &body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		var ids []string
+		for _, vm := range body.Data {
+			ids = append(ids, vm.ID)
+		}
+		return ids
+	}
+	if ids := listIDs("acme-key"); !reflect.DeepEqual(ids, []string{vmID}) {
+		t.Fatalf("acme sees %v, want [%s]", ids, vmID)
+	}
+	if ids := listIDs("globex-key"); len(ids) != 0 {
+		t.Fatalf("globex enumerated another tenant's vms: %v", ids)
+	}
+
+	if status, _ := do(http.MethodGet, "/api/vm/"+vmID+"/runs", "acme-key", ""); status != http.StatusOK {
+		t.Fatalf("owner lookup status = %d, want 200", status)
+	}
+	if status, _ := do(http.MethodGet, "/api/vm/"+vmID+"/runs", "globex-key", ""); status != http.StatusNotFound {
+		t.Fatalf("cross-tenant lookup status = %d, want 404", status)
+	}
+	if status, _ := do(http.MethodPost, "/api/vm/execute", "globex-key", `{"vm_id":"`+vmID+`","command":"true"}`); status != http.StatusNotFound {
+		t.Fatalf("cross-tenant execute status = %d, want 404", status)
+	}
+	do(http.MethodPost, "/api/vm/clean?all=true", "globex-key", `{}`)
+	if _, ok := svc.Get(vmID); !ok {
+		t.Fatal("another tenant's clean removed the vm")
+	}
+}
+
+func TestListVMsSurfacesLauncherWarning(t *testing.T) {
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	launcher.listFn = func(context.Context) ([]string, error) {
+		return nil, errors.New("krunvm list exploded")
+	}
+	_, server := newTestAPIServer(t, svc)
+
+	resp, err := http.Get(server.URL + "/api/vm/list")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var body struct {
+		Data            []VMInfo `json:"data"`
+		LauncherWarning string   `json:"launcher_warning"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !strings.Contains(body.LauncherWarning, "krunvm list exploded") {
+		t.Fatalf("launcher_warning = %q, want the launcher error", body.LauncherWarning)
+	}
+	if len(body.Data) != 1 || body.Data[0].ID != record.ID {
+		t.Fatalf("data = %+v, want the cached vm %s", body.Data, record.ID)
+	}
+	if !body.Data[0].PresenceUnknown || body.Data[0].Status != string(VMStatusReady) {
+		t.Fatalf("vm = %+v, want last known status with presence_unknown", body.Data[0])
+	}
+}
+
+func TestListVMsPaginatesAndFilters(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var ids []string
+	for i, language := range []string{"python", "python", "node", "python"} {
+		created := base.Add(time.Duration(i) * time.Minute)
+		svc.now = func() time.Time { return created }
+		record, err := svc.Create(context.Background(), VMCreateOptions{
+			Language:    language,
+			CPUCount:    1,
+			MemoryMiB:   256,
+			NetworkMode: "none",
+		})
+		if err != nil {
+			t.Fatalf("create %s vm: %v", language, err)
+		}
+		ids = append(ids, record.ID)
+	}
+	if err := svc.Stop(context.Background(), ids[1]); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	_, server := newTestAPIServer(t, svc)
+
+	list := func(query string) VMListPage {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/api/vm/list?" + query)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d", query, resp.StatusCode)
+		}
+		var body struct {
+			Data VMListPage `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s: decode: %v", query, err)
+		}
+		return body.Data
+	}
+	pageIDs := func(page VMListPage) []string {
+		var out []string
+		for _, vm := range page.VMs {
+			out = append(out, vm.ID)
+		}
+		return out
+	}
+	nextOffset := func(page VMListPage) int {
+		if page.NextOffset == nil {
+			return -1
+		}
+		return *page.NextOffset
+	}
+
+	first := list("all=true&limit=3")
+	if got := pageIDs(first); !reflect.DeepEqual(got, ids[:3]) || first.Count != 3 || first.Total != 4 || nextOffset(first) != 3 {
+		t.Fatalf("first page = %v (count %d, total %d, next %d), want %v/3/4/3", got, first.Count, first.Total, nextOffset(first), ids[:3])
+	}
+	last := list("all=true&limit=3&offset=3")
+	if got := pageIDs(last); !reflect.DeepEqual(got, ids[3:]) || nextOffset(last) != -1 {
+		t.Fatalf("last page = %v (next %d), want %v and no next page", got, nextOffset(last), ids[3:])
+	}
+	huge := list("all=true&limit=9223372036854775807&offset=1")
+	if got := pageIDs(huge); !reflect.DeepEqual(got, ids[1:]) || nextOffset(huge) != -1 {
+		t.Fatalf("max-int limit page = %v (next %d), want %v", got, nextOffset(huge), ids[1:])
+	}
+	if past := list("all=true&offset=4"); len(past.VMs) != 0 || past.Total != 4 || nextOffset(past) != -1 {
+		t.Fatalf("page past the end = %+v, want empty with total 4", past)
+	}
+
+	if stopped := list("status=stopped&limit=10"); !reflect.DeepEqual(pageIDs(stopped), []string{ids[1]}) {
+		t.Fatalf("status filter = %v, want [%s]", pageIDs(stopped), ids[1])
+	}
+	python := list("language=python&limit=1&offset=1")
+	if got := pageIDs(python); !reflect.DeepEqual(got, []string{ids[3]}) || python.Total != 2 || nextOffset(python) != -1 {
+		t.Fatalf("language filter = %v (total %d), want [%s] of 2 running python vms", got, python.Total, ids[3])
+	}
+
+	resp, err := http.Get(server.URL + "/api/vm/list?limit=0")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("limit=0 status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestServerShutdownReturnsCleanly(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+
+	api := NewAPIServer(svc, svc.logger, "127.0.0.1:0")
+	startErr := make(chan error, 1)
+	go func() { startErr <- api.Start() }()
+	time.Sleep(50 * time.Millisecond)
+
+	if err := api.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	select {
+	case err := <-startErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("Start returned %v, want http.ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+
+	// Serve treats the shutdown that follows cancellation as success.
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- NewAPIServer(svc, svc.logger, "127.0.0.1:0").Serve(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-serveErr:
+		if err != nil {
+			t.Fatalf("Serve returned %v after cancellation, want nil", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after cancellation")
+	}
+}
+
+func TestCreateAndListVMsByLabel(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	create := func(labels string) string {
+		t.Helper()
+		body := `{"language":"python","cpu":1,"memory":256,"network":"none","labels":` + labels + `}`
+		resp, err := http.Post(server.URL+"/api/vm/create", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("create request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var created struct {
+			Data VMInfo `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&created); err != nil || resp.StatusCode != http.StatusCreated {
+			t.Fatalf("create status %d: %v", resp.StatusCode, err)
+		}
+		return created.Data.ID
+	}
+	ciID := create(`{"env":"ci","project":"web"}`)
+	create(`{"env":"prod"}`)
+
+	stored, err := svc.store.Get(ciID)
+	if err != nil || !reflect.DeepEqual(stored.Labels, map[string]string{"env": "ci", "project": "web"}) {
+		t.Fatalf("stored labels = %v (err %v)", stored.Labels, err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/vm/list?label=env=ci&label=project=web")
+	if err != nil {
+		t.Fatalf("list request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var listed struct {
+		Data []VMInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(listed.Data) != 1 || listed.Data[0].ID != ciID || listed.Data[0].Labels["project"] != "web" {
+		t.Fatalf("label filter returned %+v, want only %s with its labels", listed.Data, ciID)
+	}
+
+	bad, err := http.Post(server.URL+"/api/vm/create", "application/json", strings.NewReader(`{"language":"python","cpu":1,"memory":256,"labels":{"":"x"}}`))
+	if err != nil {
+		t.Fatalf("create request failed: %v", err)
+	}
+	bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Fatalf("empty label key status = %d, want 400", bad.StatusCode)
+	}
+}
+
+func TestCloneVMEndpoint(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	src, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", CPUCount: 1, MemoryMiB: 256, NetworkMode: "none", Persist: true})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src.Storage.PersistPath, "notes.txt"), []byte("prepared"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	resp, err := http.Post(server.URL+"/api/vm/"+src.ID+"/clone", "application/json", nil)
+	if err != nil {
+		t.Fatalf("clone request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var cloned struct {
+		Data VMInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&cloned); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("clone status %d: %v", resp.StatusCode, err)
+	}
+	record, ok := svc.Get(cloned.Data.ID)
+	if !ok || record.ID == src.ID {
+		t.Fatalf("clone %q not found as a new vm", cloned.Data.ID)
+	}
+	if data, err := os.ReadFile(filepath.Join(record.Storage.PersistPath, "notes.txt")); err != nil || string(data) != "prepared" {
+		t.Fatalf("cloned file = %q, %v", data, err)
+	}
+
+	plain := createTestVM(t, svc)
+	conflict, err := http.Post(server.URL+"/api/vm/"+plain.ID+"/clone", "application/json", nil)
+	if err != nil {
+		t.Fatalf("clone request failed: %v", err)
+	}
+	conflict.Body.Close()
+	if conflict.StatusCode != http.StatusConflict {
+		t.Fatalf("clone of non-persistent vm status = %d, want 409", conflict.StatusCode)
+	}
+}
+
+func TestSnapshotEndpoints(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+	record := createPersistentTestVM(t, svc)
+	file := filepath.Join(record.Storage.PersistPath, "notes.txt")
+
+	post := func(path, body string) int {
+		t.Helper()
+		resp, err := http.Post(server.URL+"/api/vm/"+record.ID+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("post %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if err := os.WriteFile(file, []byte("kept"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if status := post("/snapshots", `{"name":"base"}`); status != http.StatusCreated {
+		t.Fatalf("snapshot status = %d, want 201", status)
+	}
+	if err := os.WriteFile(file, []byte("changed"), 0o644); err != nil {
+		t.Fatalf("modify: %v", err)
+	}
+	if status := post("/snapshots/base/restore", ""); status != http.StatusOK {
+		t.Fatalf("restore status = %d, want 200", status)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "kept" {
+		t.Fatalf("restored file = %q, want kept", data)
+	}
+
+	if status := post("/snapshots/nope/restore", ""); status != http.StatusNotFound {
+		t.Fatalf("restore of unknown snapshot status = %d, want 404", status)
+	}
+	if status := post("/snapshots", `{"name":""}`); status != http.StatusBadRequest {
+		t.Fatalf("empty snapshot name status = %d, want 400", status)
+	}
+}
+
+func TestVMLogsEndpoint(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+	record := createTestVM(t, svc)
+
+	logs := func(query string) (int, VMLogsInfo) {
+		t.Helper()
+		resp, err := http.Get(server.URL + "/api/vm/" + record.ID + "/logs" + query)
+		if err != nil {
+			t.Fatalf("logs request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Data VMLogsInfo `json:"data"`
+		}
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+		return resp.StatusCode, body.Data
+	}
+
+	// A VM that has never run has empty logs, not missing ones.
+	status, info := logs("")
+	if status != http.StatusOK || info.Stdout == nil || *info.Stdout != "" || info.Stderr == nil || *info.Stderr != "" {
+		t.Fatalf("logs before any run = %d %+v, want 200 with empty streams", status, info)
+	}
+
+	if _, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "printf 'one\\ntwo\\nthree\\n'; echo oops >&2",
+		Timeout: 5,
+	}); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	status, info = logs("?tail=2")
+	if status != http.StatusOK || *info.Stdout != "two\nthree\n" || *info.Stderr != "oops\n" {
+		t.Fatalf("tailed logs = %d %+v", status, info)
+	}
+	status, info = logs("?stream=stderr")
+	if status != http.StatusOK || info.Stdout != nil || info.Stderr == nil || *info.Stderr != "oops\n" {
+		t.Fatalf("stderr-only logs = %d %+v", status, info)
+	}
+	if status, _ := logs("?stream=both&tail=0"); status != http.StatusBadRequest {
+		t.Fatalf("tail=0 status = %d, want 400", status)
+	}
+	if status, _ := logs("?stream=stdin"); status != http.StatusBadRequest {
+		t.Fatalf("bad stream status = %d, want 400", status)
+	}
+}
+
+func TestFollowVMLogsStreamsNewLines(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+	record := createTestVM(t, svc)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/vm/"+record.ID+"/logs/follow", nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("follow request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("follow = %d %q, want 200 text/event-stream", resp.StatusCode, ct)
+	}
+
+	go func() {
+		_, _ = svc.Run(context.Background(), VMRunOptions{
+			VMID:    record.ID,
+			Command: "for i in 1 2 3; do echo line$i; sleep 0.3; done; echo done >&2",
+			Timeout: 10,
+		})
+	}()
+
+	type event struct{ name, data string }
+	var got []event
+	scanner := bufio.NewScanner(resp.Body)
+	var name string
+	for len(got) < 4 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			got = append(got, event{name, strings.TrimPrefix(line, "data: ")})
+		}
+	}
+	want := []event{{"stdout", "line1"}, {"stdout", "line2"}, {"stdout", "line3"}, {"stderr", "done"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("followed events = %v, want %v", got, want)
+	}
+}
+
+func TestRuntimeHealthProbe(t *testing.T) {
+	probe := func(launcher *fakeLauncher) (int, RuntimeHealthInfo, *VMService) {
+		t.Helper()
+		svc := newTestVMService(t, launcher)
+		_, server := newTestAPIServer(t, svc)
+		resp, err := http.Get(server.URL + "/health/runtime")
+		if err != nil {
+			t.Fatalf("probe request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Data RuntimeHealthInfo `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp.StatusCode, body.Data, svc
+	}
+
+	status, info, svc := probe(newFakeLauncher())
+	if status != http.StatusOK || !info.Healthy || info.Detail != "ok" {
+		t.Fatalf("healthy probe = %d %+v", status, info)
+	}
+	if records, _ := svc.List(context.Background()); len(records) != 0 {
+		t.Fatalf("probe left %d vms behind", len(records))
+	}
+
+	broken := newFakeLauncher()
+	broken.launchFn = func(ctx context.Context, record VMRecord) error {
+		return errors.New("krunvm: cannot create vm")
+	}
+	status, info, _ = probe(broken)
+	if status != http.StatusServiceUnavailable || info.Healthy || !strings.Contains(info.Detail, "cannot create vm") {
+		t.Fatalf("failing probe = %d %+v, want 503 with the launch error", status, info)
+	}
+
+	silent := newFakeLauncher()
+	silent.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		return 0, nil
+	}
+	if status, info, _ = probe(silent); status != http.StatusServiceUnavailable || !strings.Contains(info.Detail, "want \"ok\"") {
+		t.Fatalf("probe with wrong output = %d %+v", status, info)
+	}
+}
+
+func TestRuntimeHealthProbeNeedsAPIKey(t *testing.T) {
+	t.Setenv("ERA_API_KEY", "probe-key")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	_, server := newTestAPIServer(t, svc)
+
+	resp, err := http.Get(server.URL + "/health/runtime")
+	if err != nil {
+		t.Fatalf("probe request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || launcher.launchCalls != 0 {
+		t.Fatalf("unauthenticated probe = %d with %d launches, want 401 and none", resp.StatusCode, launcher.launchCalls)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/health/runtime", nil)
+	req.Header.Set("Authorization", "Bearer probe-key")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("probe request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("authenticated probe status = %d, want 200", resp.StatusCode)
+	}
+	if resp, err := http.Get(server.URL + "/health"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("/health without a key = %v, %v; want 200", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+}
+
+func TestRuntimeHealthProbeOutlivesCaller(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if health := svc.CheckRuntime(ctx); !health.Healthy {
+		t.Fatalf("probe for a departed caller = %+v, want it to finish healthy", health)
+	}
+}
+
+func TestRequestIDHeaderAndLogs(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	logPath := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := NewLogger("debug", logPath)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	if err := logger.SetFormat("json"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+	svc.logger = logger
+	_, server := newTestAPIServer(t, svc)
+	record := createPersistentTestVM(t, svc)
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/vm/"+record.ID+"/snapshots", strings.NewReader(`{"name":"base"}`))
+	req.Header.Set("X-Request-ID", "req-abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("snapshot status = %d, want 201", resp.StatusCode)
+	}
+	if got := resp.Header.Get("X-Request-ID"); got != "req-abc" {
+		t.Fatalf("X-Request-ID = %q, want the inbound req-abc", got)
+	}
+
+	// An ID that is unsafe to echo is replaced by a generated one.
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/api/vm/list", nil)
+	req.Header.Set("X-Request-ID", "has space")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Request-ID"); len(got) != 16 {
+		t.Fatalf("generated X-Request-ID = %q, want 16 hex characters", got)
+	}
+	logger.Close()
+
+	messages := map[string]bool{}
+	for _, line := range readLogLines(t, logPath) {
+		if line["request_id"] == "req-abc" {
+			messages[line["msg"].(string)] = true
+		}
+	}
+	if !messages["api request"] || !messages["vm snapshot saved"] {
+		t.Fatalf("messages logged with req-abc = %v, want the API and VMService logs", messages)
+	}
+}
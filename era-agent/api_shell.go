@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	shellWSWriteTimeout = 10 * time.Second
+	defaultShellCommand = "/bin/bash"
+)
+
+var shellUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	// handleShellWebSocket checks the origin itself before upgrading, see
+	// shellOriginAllowed.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// shellControlMessage is a JSON text frame sent by the client to control the
+// session rather than feed stdin.
+type shellControlMessage struct {
+	Type string `json:"type"`
+	Cols int    `json:"cols"`
+	Rows int    `json:"rows"`
+}
+
+// shellExitMessage is sent to the client once the shell process exits.
+type shellExitMessage struct {
+	Type     string `json:"type"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// shellErrorMessage answers a control message the session cannot honour.
+type shellErrorMessage struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+// errShellResizeUnsupported answers resize messages: WebSocket shells are
+// attached to pipes rather than a terminal, so there is no window to size.
+var errShellResizeUnsupported = errors.New("resize is not supported: the shell runs without a terminal")
+
+// shellOriginAllowed guards against cross-site WebSocket hijacking. A
+// browser attaches no API key to a WebSocket, so with authentication on a
+// foreign page cannot get in anyway; without it, a request carrying an
+// Origin must come from the host it is addressed to.
+func (api *APIServer) shellOriginAllowed(r *http.Request) bool {
+	if api.enableAuth {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, r.Host)
+}
+
+// wsStreamWriter relays process output as binary WebSocket frames. Gorilla
+// connections allow a single concurrent writer, so writes are serialized.
+type wsStreamWriter struct {
+	conn *websocket.Conn
+	mu   *sync.Mutex
+}
+
+func (w *wsStreamWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_ = w.conn.SetWriteDeadline(time.Now().Add(shellWSWriteTimeout))
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// handleShellWebSocket upgrades the connection and bridges it to an interactive
+// shell inside the VM. Binary and plain text frames are written to stdin, JSON
+// text frames of type "resize" are answered with an error message, and
+// stdout/stderr are relayed back as binary frames.
+func (api *APIServer) handleShellWebSocket(w http.ResponseWriter, r *http.Request, vmID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	record, ok := api.vmService.Get(vmID)
+	if !ok {
+		api.sendJSONError(w, errVMNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if record.Status != VMStatusReady && record.Status != VMStatusRunning {
+		api.sendJSONError(w, "vm is not ready or running", http.StatusConflict)
+		return
+	}
+	if err := checkShellAllowed(); err != nil {
+		api.sendJSONError(w, err.Error(), statusCodeForVMError(err))
+		return
+	}
+	if !api.shellOriginAllowed(r) {
+		api.sendJSONError(w, "websocket origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	shellCmd := r.URL.Query().Get("cmd")
+	if shellCmd == "" {
+		shellCmd = defaultShellCommand
+	}
+
+	release, ok := api.acquireStream(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// An *os.File is handed to the child directly, so the shell's exit is not
+	// held up by a copy goroutine blocked on the next WebSocket frame.
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		api.sendJSONError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stdinReader.Close()
+
+	conn, err := shellUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response.
+		_ = stdinWriter.Close()
+		loggerFor(r.Context(), api.logger).Warn("shell websocket upgrade failed", map[string]any{"vm": vmID, "error": err.Error()})
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	loggerFor(ctx, api.logger).Info("vm shell websocket opened", map[string]any{"vm": vmID, "shell": shellCmd})
+
+	writeMu := &sync.Mutex{}
+	output := &wsStreamWriter{conn: conn, mu: writeMu}
+
+	go func() {
+		// Cancelling the context kills the shell process once the socket goes away.
+		defer cancel()
+		defer stdinWriter.Close()
+		for {
+			msgType, payload, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if msgType == websocket.TextMessage {
+				var control shellControlMessage
+				if json.Unmarshal(payload, &control) == nil && control.Type == "resize" {
+					writeMu.Lock()
+					_ = conn.SetWriteDeadline(time.Now().Add(shellWSWriteTimeout))
+					err := conn.WriteJSON(shellErrorMessage{Type: "error", Error: errShellResizeUnsupported.Error()})
+					writeMu.Unlock()
+					if err != nil {
+						return
+					}
+					continue
+				}
+			}
+			if _, err := stdinWriter.Write(payload); err != nil {
+				return
+			}
+		}
+	}()
+
+	exitCode, shellErr := api.vmService.Shell(ctx, record.ID, shellCmd, stdinReader, output, output)
+
+	exitMsg := shellExitMessage{Type: "exit", ExitCode: exitCode}
+	var cmdErr *commandError
+	if shellErr != nil && !errors.As(shellErr, &cmdErr) {
+		exitMsg.Error = shellErr.Error()
+	}
+
+	writeMu.Lock()
+	_ = conn.SetWriteDeadline(time.Now().Add(shellWSWriteTimeout))
+	_ = conn.WriteJSON(exitMsg)
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	writeMu.Unlock()
+
+	loggerFor(ctx, api.logger).Info("vm shell websocket closed", map[string]any{"vm": vmID, "exit_code": exitCode})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultMaxStreamsPerClient caps concurrent long-lived responses (shell
+// WebSockets and archive downloads) per client unless overridden with
+// AGENT_MAX_STREAMS_PER_CLIENT. Zero or a negative value disables the cap.
+const defaultMaxStreamsPerClient = 16
+
+// streamLimiter counts active streams per client. A nil *streamLimiter
+// admits everything.
+type streamLimiter struct {
+	mu     sync.Mutex
+	max    int
+	active map[string]int
+}
+
+func newStreamLimiter(max int) *streamLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &streamLimiter{max: max, active: make(map[string]int)}
+}
+
+func maxStreamsPerClient() int {
+	raw := strings.TrimSpace(os.Getenv("AGENT_MAX_STREAMS_PER_CLIENT"))
+	if raw == "" {
+		return defaultMaxStreamsPerClient
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return defaultMaxStreamsPerClient
+	}
+	return value
+}
+
+// acquire reserves a stream slot for client and returns the function that
+// releases it, or false when the client is already at the cap.
+func (l *streamLimiter) acquire(client string) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[client] >= l.max {
+		return nil, false
+	}
+	l.active[client]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.active[client]--; l.active[client] <= 0 {
+				delete(l.active, client)
+			}
+		})
+	}, true
+}
+
+// streamClientKey identifies the caller for stream and rate accounting: the
+// API key when authentication is enabled and the key is valid, otherwise the
+// remote IP. Unknown keys fall back to the IP so a client cannot get a fresh
+// bucket per made-up key.
+func (api *APIServer) streamClientKey(r *http.Request) string {
+	if api.enableAuth {
+		if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != "" {
+			if _, valid := api.apiKeyTenant(token); valid {
+				return "key:" + token
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// acquireStream admits a new stream for the request's client, answering 429
+// when the cap is reached. Callers must invoke the returned release once the
+// stream ends.
+func (api *APIServer) acquireStream(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	release, ok := api.streams.acquire(api.streamClientKey(r))
+	if !ok {
+		api.logger.Warn("stream limit reached", map[string]any{
+			"path":   r.URL.Path,
+			"remote": r.RemoteAddr,
+		})
+		api.sendJSONError(w, "too many concurrent streams for this client", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return release, true
+}
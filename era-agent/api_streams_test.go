@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStreamLimitRejectsBeyondCap(t *testing.T) {
+	t.Setenv("AGENT_MAX_STREAMS_PER_CLIENT", "2")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/vm/" + record.ID + "/shell/ws?cmd=/bin/sh"
+	var open []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("stream %d rejected below the cap: %v", i, err)
+		}
+		defer conn.Close()
+		open = append(open, conn)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("expected stream beyond the cap to be rejected")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %+v", resp)
+	}
+
+	// Ending a stream frees its slot.
+	open[0].Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("slot was not released after disconnect: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestStreamLimiterRelease(t *testing.T) {
+	limiter := newStreamLimiter(1)
+	release, ok := limiter.acquire("a")
+	if !ok {
+		t.Fatal("first acquire rejected")
+	}
+	if _, ok := limiter.acquire("a"); ok {
+		t.Fatal("second acquire for the same client admitted")
+	}
+	if _, ok := limiter.acquire("b"); !ok {
+		t.Fatal("other client rejected")
+	}
+	release()
+	release()
+	if _, ok := limiter.acquire("a"); !ok {
+		t.Fatal("acquire after release rejected")
+	}
+	if limiter.active["a"] != 1 {
+		t.Fatalf("double release miscounted: %d", limiter.active["a"])
+	}
+
+	if _, ok := newStreamLimiter(0).acquire("a"); !ok {
+		t.Fatal("disabled limiter rejected a stream")
+	}
+}
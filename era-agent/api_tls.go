@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// resolveTLSFiles picks the server certificate and key from the --tls-cert
+// and --tls-key flags, falling back to ERA_TLS_CERT and ERA_TLS_KEY. Both or
+// neither must be set; when set, the pair is loaded once so a bad file fails
+// at startup rather than on the first handshake.
+func resolveTLSFiles(certFile, keyFile string) (string, string, error) {
+	if certFile = strings.TrimSpace(certFile); certFile == "" {
+		certFile = strings.TrimSpace(os.Getenv("ERA_TLS_CERT"))
+	}
+	if keyFile = strings.TrimSpace(keyFile); keyFile == "" {
+		keyFile = strings.TrimSpace(os.Getenv("ERA_TLS_KEY"))
+	}
+
+	switch {
+	case certFile == "" && keyFile == "":
+		return "", "", nil
+	case certFile == "":
+		return "", "", fmt.Errorf("--tls-key (ERA_TLS_KEY) requires --tls-cert (ERA_TLS_CERT)")
+	case keyFile == "":
+		return "", "", fmt.Errorf("--tls-cert (ERA_TLS_CERT) requires --tls-key (ERA_TLS_KEY)")
+	}
+
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return "", "", fmt.Errorf("load TLS key pair: %w", err)
+	}
+	return certFile, keyFile, nil
+}
+
+// EnableTLS makes Start and StartMetrics serve HTTPS with the given
+// certificate and key files, which resolveTLSFiles has validated.
+func (api *APIServer) EnableTLS(certFile, keyFile string) {
+	api.tlsCert = certFile
+	api.tlsKey = keyFile
+}
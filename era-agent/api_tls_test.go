@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedPair writes a certificate for 127.0.0.1 and its key into
+// dir and returns the certificate for clients to trust.
+func writeSelfSignedPair(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "era-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestResolveTLSFilesRequiresBoth(t *testing.T) {
+	t.Setenv("ERA_TLS_CERT", "")
+	t.Setenv("ERA_TLS_KEY", "")
+	certFile, keyFile, _ := writeSelfSignedPair(t, t.TempDir())
+
+	if cert, key, err := resolveTLSFiles("", ""); err != nil || cert != "" || key != "" {
+		t.Fatalf("no TLS = %q, %q, %v; want plain HTTP", cert, key, err)
+	}
+	if _, _, err := resolveTLSFiles(certFile, ""); err == nil {
+		t.Fatal("cert without key accepted")
+	}
+	if _, _, err := resolveTLSFiles("", keyFile); err == nil {
+		t.Fatal("key without cert accepted")
+	}
+	if _, _, err := resolveTLSFiles(keyFile, certFile); err == nil {
+		t.Fatal("swapped pair accepted")
+	}
+
+	t.Setenv("ERA_TLS_CERT", certFile)
+	t.Setenv("ERA_TLS_KEY", keyFile)
+	if cert, key, err := resolveTLSFiles("", ""); err != nil || cert != certFile || key != keyFile {
+		t.Fatalf("env pair = %q, %q, %v; want %q, %q", cert, key, err, certFile, keyFile)
+	}
+}
+
+func TestServerServesHealthOverTLS(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	certFile, keyFile, cert := writeSelfSignedPair(t, t.TempDir())
+
+	// Reserve a free port for Start, which listens on its configured address.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("reserve port: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	api := NewAPIServer(svc, svc.logger, addr)
+	api.EnableTLS(certFile, keyFile)
+	startErr := make(chan error, 1)
+	go func() { startErr <- api.Start() }()
+	t.Cleanup(func() {
+		_ = api.Stop(context.Background())
+		<-startErr
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := client.Get("https://" + addr + "/health")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+				t.Fatalf("health over TLS = %d (tls %v), want 200 over TLS", resp.StatusCode, resp.TLS != nil)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("TLS handshake against /health failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
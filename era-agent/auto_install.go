@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	pythonMissingModuleRe = regexp.MustCompile(`ModuleNotFoundError: No module named '([^']+)'`)
+	nodeMissingModuleRe   = regexp.MustCompile(`Cannot find module '([^']+)'`)
+
+	pythonPackageNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	nodePackageNameRe   = regexp.MustCompile(`^(@[a-z0-9][a-z0-9._-]*/)?[a-z0-9][a-z0-9._-]*$`)
+
+	// pythonPackageAliases maps common import names to the PyPI package that
+	// provides them when the two differ.
+	pythonPackageAliases = map[string]string{
+		"bs4":      "beautifulsoup4",
+		"cv2":      "opencv-python",
+		"PIL":      "Pillow",
+		"sklearn":  "scikit-learn",
+		"yaml":     "PyYAML",
+		"dateutil": "python-dateutil",
+	}
+)
+
+// missingPackage inspects a failed run's stderr for a missing-import error and
+// returns the package to install along with the guest install command.
+func missingPackage(language, stderr string) (string, string, bool) {
+	switch normalizeLanguage(language) {
+	case "python":
+		match := pythonMissingModuleRe.FindStringSubmatch(stderr)
+		if match == nil {
+			return "", "", false
+		}
+		module := strings.SplitN(match[1], ".", 2)[0]
+		pkg := module
+		if alias, ok := pythonPackageAliases[module]; ok {
+			pkg = alias
+		}
+		if !pythonPackageNameRe.MatchString(pkg) {
+			return "", "", false
+		}
+		interpreter, err := interpreterFor("python")
+		if err != nil {
+			return "", "", false
+		}
+		return pkg, interpreter + " -m pip install --quiet --disable-pip-version-check " + shellQuote(pkg), true
+
+	case "node", "javascript", "js":
+		if !strings.Contains(stderr, "MODULE_NOT_FOUND") {
+			return "", "", false
+		}
+		match := nodeMissingModuleRe.FindStringSubmatch(stderr)
+		if match == nil {
+			return "", "", false
+		}
+		pkg := nodePackageRoot(match[1])
+		if !nodePackageNameRe.MatchString(pkg) {
+			return "", "", false
+		}
+		// Installing under / puts the package on every script's resolution path.
+		return pkg, "npm install --prefix / --no-save --no-audit --no-fund --silent " + shellQuote(pkg), true
+	}
+	return "", "", false
+}
+
+// nodePackageRoot reduces a require specifier such as "lodash/fp" or
+// "@scope/pkg/sub" to its package name. Relative and absolute paths yield "".
+func nodePackageRoot(specifier string) string {
+	if specifier == "" || strings.HasPrefix(specifier, ".") || strings.HasPrefix(specifier, "/") || strings.HasPrefix(specifier, "node:") {
+		return ""
+	}
+	parts := strings.Split(specifier, "/")
+	if strings.HasPrefix(specifier, "@") {
+		if len(parts) < 2 {
+			return ""
+		}
+		return parts[0] + "/" + parts[1]
+	}
+	return parts[0]
+}
+
+// autoInstallAndRetry installs the package a failed run was missing and runs
+// the command once more. It reports false when the failure was not a missing
+// import or the install did not succeed, leaving the original result in place.
+func (s *VMService) autoInstallAndRetry(ctx context.Context, opts VMRunOptions, runErr error) (VMRunResult, bool, error) {
+	var failed *VMRunError
+	if !errors.As(runErr, &failed) || failed.Result.Aborted {
+		return VMRunResult{}, false, nil
+	}
+	record, ok := s.Get(opts.VMID)
+	if !ok {
+		return VMRunResult{}, false, nil
+	}
+
+	stderr, err := readCapturedOutput(failed.Result.StderrPath, maxOutputBytes(opts.MaxOutputBytes))
+	if err != nil {
+		return VMRunResult{}, false, nil
+	}
+	pkg, installCmd, ok := missingPackage(record.Language, stderr)
+	if !ok {
+		return VMRunResult{}, false, nil
+	}
+	if record.NetworkMode == "" || record.NetworkMode == "none" {
+		s.logger.Warn("auto install skipped: vm has no network access", map[string]any{
+			"vm":      record.ID,
+			"package": pkg,
+		})
+		return VMRunResult{}, false, nil
+	}
+
+	s.logger.Info("installing missing package", map[string]any{
+		"vm":      record.ID,
+		"package": pkg,
+	})
+
+	installCtx, cancel := context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Second)
+	defer cancel()
+	var installOutput bytes.Buffer
+	exitCode, err := s.launcher.Run(installCtx, record, VMRunOptions{
+		VMID:    record.ID,
+		Command: installCmd,
+		Timeout: opts.Timeout,
+	}, &installOutput, &installOutput)
+	if err != nil || exitCode != 0 {
+		fields := map[string]any{
+			"vm":        record.ID,
+			"package":   pkg,
+			"exit_code": exitCode,
+			"output":    strings.TrimSpace(installOutput.String()),
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		s.logger.Warn("auto install failed", fields)
+		return VMRunResult{}, false, nil
+	}
+
+	retryOpts := opts
+	retryOpts.AutoInstall = false
+	result, err := s.run(ctx, retryOpts)
+	result.AutoInstalled = pkg
+	var retryErr *VMRunError
+	if errors.As(err, &retryErr) {
+		retryErr.Result.AutoInstalled = pkg
+	}
+	return result, true, err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMissingPackageParsesImportErrors(t *testing.T) {
+	cases := []struct {
+		language string
+		stderr   string
+		want     string
+	}{
+		{"python", "Traceback (most recent call last):\nModuleNotFoundError: No module named 'requests'\n", "requests"},
+		{"python", "ModuleNotFoundError: No module named 'yaml.constructor'\n", "PyYAML"},
+		{"node", "Error: Cannot find module 'lodash/fp'\nRequire stack:\n- /in/main.js {\n  code: 'MODULE_NOT_FOUND'\n}", "lodash"},
+		{"javascript", "Error: Cannot find module '@scope/pkg/sub'\n  code: 'MODULE_NOT_FOUND'", "@scope/pkg"},
+		{"node", "Error: Cannot find module './local'\n  code: 'MODULE_NOT_FOUND'", ""},
+		{"python", "NameError: name 'x' is not defined\n", ""},
+		{"ruby", "cannot load such file -- json", ""},
+	}
+	for _, tc := range cases {
+		got, _, ok := missingPackage(tc.language, tc.stderr)
+		if got != tc.want || ok != (tc.want != "") {
+			t.Errorf("missingPackage(%s, %q) = %q, %v; want %q", tc.language, tc.stderr, got, ok, tc.want)
+		}
+	}
+}
+
+func TestRunAutoInstallRetriesAfterMissingModule(t *testing.T) {
+	launcher := newFakeLauncher()
+	installed := false
+	var commands []string
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		commands = append(commands, opts.Command)
+		switch {
+		case strings.Contains(opts.Command, "pip install"):
+			installed = true
+			return 0, nil
+		case !installed:
+			fmt.Fprintln(stderr, "ModuleNotFoundError: No module named 'requests'")
+			return 1, nil
+		default:
+			fmt.Fprintln(stdout, "ok")
+			return 0, nil
+		}
+	}
+	svc := newTestVMService(t, launcher)
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "allow_all",
+	})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:        record.ID,
+		Command:     "python3 main.py",
+		Timeout:     5,
+		AutoInstall: true,
+	})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if output.Stdout != "ok\n" || output.ExitCode != 0 {
+		t.Fatalf("unexpected output after retry: %+v", output)
+	}
+	if output.AutoInstalled != "requests" {
+		t.Fatalf("AutoInstalled = %q, want requests", output.AutoInstalled)
+	}
+	if len(commands) != 3 || !strings.Contains(commands[1], "pip install --quiet --disable-pip-version-check 'requests'") {
+		t.Fatalf("unexpected command sequence: %q", commands)
+	}
+}
+
+func TestRunAutoInstallSkippedWithoutNetwork(t *testing.T) {
+	launcher := newFakeLauncher()
+	calls := 0
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		calls++
+		fmt.Fprintln(stderr, "ModuleNotFoundError: No module named 'requests'")
+		return 1, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	_, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:        record.ID,
+		Command:     "python3 main.py",
+		Timeout:     5,
+		AutoInstall: true,
+	})
+	if err == nil {
+		t.Fatal("expected the original failure")
+	}
+	if calls != 1 {
+		t.Fatalf("launcher ran %d times, want 1", calls)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 )
 
 type GlobalOptions struct {
 	LogLevel  string
 	LogFile   string
+	LogFormat string
 	VMRuntime string
+	// AllowMultiple skips the agent.pid single-instance lock.
+	AllowMultiple bool
 }
 
 type CLI struct {
@@ -29,7 +33,10 @@ func parseGlobalOptions(args []string) (GlobalOptions, []string, error) {
 	opts := GlobalOptions{
 		LogLevel:  strings.ToLower(strings.TrimSpace(getenvOrDefault("AGENT_LOG_LEVEL", ""))),
 		LogFile:   strings.TrimSpace(getenvOrDefault("AGENT_LOG_FILE", "")),
+		LogFormat: strings.ToLower(strings.TrimSpace(getenvOrDefault("AGENT_LOG_FORMAT", ""))),
 		VMRuntime: strings.ToLower(strings.TrimSpace(getenvOrDefault("AGENT_VM_RUNTIME", ""))),
+
+		AllowMultiple: allowMultipleInstances(),
 	}
 	remaining := make([]string, 0, len(args))
 
@@ -52,6 +59,14 @@ func parseGlobalOptions(args []string) (GlobalOptions, []string, error) {
 			i++
 		case strings.HasPrefix(arg, "--log-file="):
 			opts.LogFile = strings.TrimSpace(strings.TrimPrefix(arg, "--log-file="))
+		case arg == "--log-format":
+			if i+1 >= len(args) {
+				return opts, nil, errors.New("missing value for --log-format")
+			}
+			opts.LogFormat = strings.ToLower(strings.TrimSpace(args[i+1]))
+			i++
+		case strings.HasPrefix(arg, "--log-format="):
+			opts.LogFormat = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(arg, "--log-format=")))
 		case arg == "--vm-runtime":
 			if i+1 >= len(args) {
 				return opts, nil, errors.New("missing value for --vm-runtime")
@@ -60,6 +75,8 @@ func parseGlobalOptions(args []string) (GlobalOptions, []string, error) {
 			i++
 		case strings.HasPrefix(arg, "--vm-runtime="):
 			opts.VMRuntime = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(arg, "--vm-runtime=")))
+		case arg == "--allow-multiple":
+			opts.AllowMultiple = true
 		default:
 			remaining = append(remaining, arg)
 		}
@@ -68,6 +85,13 @@ func parseGlobalOptions(args []string) (GlobalOptions, []string, error) {
 	if opts.LogLevel == "" {
 		opts.LogLevel = "info"
 	}
+	switch opts.LogFormat {
+	case "":
+		opts.LogFormat = LogFormatText
+	case LogFormatText, LogFormatJSON:
+	default:
+		return opts, nil, fmt.Errorf("invalid log format %q (want %s or %s)", opts.LogFormat, LogFormatText, LogFormatJSON)
+	}
 
 	return opts, remaining, nil
 }
@@ -81,6 +105,14 @@ func (c *CLI) Execute(ctx context.Context, args []string) error {
 	switch args[0] {
 	case "vm":
 		return c.executeVM(ctx, args[1:])
+	case "image":
+		return c.executeImage(ctx, args[1:])
+	case "volume":
+		return c.executeVolume(ctx, args[1:])
+	case "server":
+		return c.handleServer(ctx, args[1:])
+	case "completion":
+		return writeCompletion(os.Stdout, args[1:])
 	case "-h", "--help", "help":
 		c.printUsage()
 		return nil
@@ -94,18 +126,40 @@ func (c *CLI) printUsage() {
 		"Agent CLI",
 		"",
 		"Usage:",
-		"  agent vm create --language <python|javascript|node|ruby|golang> [--image <override>] --cpu <n> --mem <MiB> --network <none|allow_all> [--persist]",
-		`  agent vm run    --vm <id> --cmd "python main.py" [--file ./main.py] --timeout <seconds>`,
-		`  agent vm exec   --cmd "echo hello" [--file ./script.py] [--vm <id> ... | --all] [--timeout <seconds>]`,
+		"  agent vm create --language <python|javascript|node|ruby|golang> [--image <override>] [--pull <always|ifnotpresent|never>] [--cpu <n>] [--mem <MiB>] --network <none|allow_all> [--port <host:guest> ...] [--volume <name:/path> ...] [--guest-in|--guest-out|--guest-persist <path>] [--guest-workdir <path>] [--persist] [--ttl <duration> [--expire-persistent]] [--owner <label>] [--label <key=value> ...] [--name <name>] [--dry-run]",
+		`  agent vm run    --vm <id> (--cmd "python main.py" [--file ./main.py] | --file ./main.py) [--stdin-file ./input.txt] [--auto-install] [--guest-timeout] [--clean-output] [--env KEY=VALUE ...] [--env-file <path>] [--quiet] [--dry-run] --timeout <seconds>`,
+		`  agent vm exec   --cmd "echo hello" [--file ./script.py] [--vm <id> ... | --all] [--env KEY=VALUE ...] [--env-file <path>] [--timeout <seconds>]`,
 		"  agent vm shell  --vm <id> [--cmd /bin/bash]",
-		"  agent vm temp   --language <python> --cmd \"python -c 'print(1) '\" [--timeout <seconds>] --cpu <n> --mem <MiB>",
-		"  agent vm list   [--status <state>] [--all]",
-		"  agent vm stop   [--vm <id> ... | --all]",
-		"  agent vm clean  [--vm <id> ... | --all] [--keep-persist]",
+		"  agent vm temp   --language <python> --cmd \"python -c 'print(1) '\" [--timeout <seconds>] [--env KEY=VALUE ...] [--env-file <path>] [--cpu <n>] [--mem <MiB>]",
+		"  agent vm list   [--status <state>] [--owner <label>] [--language <lang>] [--label <key=value> ...] [--since <time>] [--until <time>] [--all] [--format '{{.ID}} {{.Status}}' | --output table|json|csv]",
+		"  agent vm inspect --vm <id> [--json]",
+		"  agent vm cp     <src> <vm>:<dest> | <vm>:<src> <dest>",
+		`  agent vm compare --language <lang> --language <lang> ... (--code "<source>" | --file ./prog) [--source <lang>=./prog.ext ...] [--timeout <seconds>]`,
+		"  agent vm stop   [--vm <id> ... | --all] [--force]",
+		"  agent vm clean  [--vm <id> ... | --all] [--keep-persist] [--force]",
+		"  agent vm stats  --vm <id>",
+		"  agent vm adopt",
+		"  agent vm snapshot --vm <id> --name <snapshot>",
+		"  agent vm restore --vm <id> --name <snapshot>",
+		"  agent vm history --vm <id> [--limit <n>]",
+		"  agent vm logs   --vm <id> [--tail <n>] [--stream stdout|stderr|both] [--follow [--since <time>]]",
+		"  agent image check <ref>",
+		"  agent volume create <name> | list | rm <name>",
+		"  agent server    [--addr <host:port>] [--metrics-addr <host:port>] [--tls-cert <file> --tls-key <file>]",
+		"  agent completion bash|zsh|fish",
 		"",
-		"Set AGENT_LOG_LEVEL=debug for verbose logs, and use --log-file or AGENT_LOG_FILE=/path to mirror output to disk. Override AGENT_STATE_DIR to change where VM state is stored.",
+		"Set AGENT_LOG_LEVEL=debug for verbose logs, use --log-file or AGENT_LOG_FILE=/path to mirror output to disk, and --log-format=json or AGENT_LOG_FORMAT=json for JSON lines. Override AGENT_STATE_DIR to change where VM state is stored.",
 		"Set AGENT_ENABLE_GUEST_VOLUMES=1 to mount /in and /out into the guest (required for --file).",
-		"Select a virtualization backend with --vm-runtime=<krunvm|libkrun> or AGENT_VM_RUNTIME (defaults to krunvm).",
+		"Override interpreters used for --file without --cmd with AGENT_PYTHON_BIN, AGENT_NODE_BIN, AGENT_RUBY_BIN or AGENT_GO_BIN.",
+		"Set AGENT_GUEST_TIMEOUT=1 to enforce run timeouts inside the guest with timeout(1) as well.",
+		"Set AGENT_ACCOUNTING_SINK=<file|log> to emit a per-run accounting record.",
+		"Cap creates with AGENT_MAX_CPU, AGENT_MAX_MEM_MIB (per VM) and AGENT_MAX_VMS (VMs not stopped).",
+		"Set AGENT_SHELL_AUDIT=1 to also record interactive shell output to the VM's out/shell.log.",
+		"Select a virtualization backend with --vm-runtime=<krunvm|libkrun|firecracker|docker> or AGENT_VM_RUNTIME (defaults to krunvm).",
+		"One agent runs per state directory (locked via agent.pid); pass --allow-multiple or set AGENT_ALLOW_MULTIPLE=1 to bypass.",
+		"Set AGENT_OFFLINE=1 to never pull images, and AGENT_IMAGE_DIR=<dir> to add a pre-loaded image store.",
+		"Set AGENT_COMMAND_POLICY=<file> to allow or deny run commands by binary and pattern.",
+		"Creates without --cpu/--mem use per-language profiles; override them with AGENT_PROFILES=<file>.",
 	}, "\n")
 
 	fmt.Println(usage)
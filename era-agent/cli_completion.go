@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// completionCommand is a command, its flags and its subcommands as offered
+// by the generated completion scripts.
+type completionCommand struct {
+	Name        string
+	Flags       []string
+	Subcommands []completionCommand
+}
+
+// agentCompletion mirrors the CLI's commands and flag sets. The CLI is
+// hand-rolled, so adding a command or flag means adding it here too.
+var agentCompletion = completionCommand{
+	Name:  "agent",
+	Flags: []string{"log-level", "log-file", "log-format", "vm-runtime", "allow-multiple"},
+	Subcommands: []completionCommand{
+		{Name: "vm", Subcommands: []completionCommand{
+			{Name: "create", Flags: []string{"language", "image", "pull", "cpu", "mem", "network", "persist", "port", "ttl", "volume", "guest-in", "guest-out", "guest-persist", "guest-workdir", "expire-persistent", "owner", "label", "name", "dry-run"}},
+			{Name: "run", Flags: []string{"vm", "cmd", "file", "stdin-file", "auto-install", "guest-timeout", "clean-output", "timeout", "env-file", "env", "dry-run", "quiet"}},
+			{Name: "exec", Flags: []string{"cmd", "file", "timeout", "all", "vm", "env-file", "env"}},
+			{Name: "shell", Flags: []string{"vm", "cmd"}},
+			{Name: "temp", Flags: []string{"language", "image", "pull", "cmd", "file", "timeout", "cpu", "mem", "network", "persist", "env-file", "env"}},
+			{Name: "list", Flags: []string{"status", "owner", "language", "since", "until", "all", "label", "format", "output"}},
+			{Name: "inspect", Flags: []string{"vm", "json"}},
+			{Name: "cp"},
+			{Name: "compare", Flags: []string{"language", "code", "file", "source", "timeout", "cpu", "mem"}},
+			{Name: "stop", Flags: []string{"all", "vm", "force"}},
+			{Name: "clean", Flags: []string{"all", "vm", "keep-persist", "force"}},
+			{Name: "stats", Flags: []string{"vm"}},
+			{Name: "adopt"},
+			{Name: "snapshot", Flags: []string{"vm", "name"}},
+			{Name: "restore", Flags: []string{"vm", "name"}},
+			{Name: "history", Flags: []string{"vm", "limit"}},
+			{Name: "logs", Flags: []string{"vm", "follow", "tail", "since", "stream"}},
+		}},
+		{Name: "image", Subcommands: []completionCommand{{Name: "check"}}},
+		{Name: "volume", Subcommands: []completionCommand{{Name: "create"}, {Name: "list"}, {Name: "rm"}}},
+		{Name: "server", Flags: []string{"addr", "metrics-addr", "tls-cert", "tls-key"}},
+		{Name: "completion", Subcommands: []completionCommand{{Name: "bash"}, {Name: "zsh"}, {Name: "fish"}}},
+		{Name: "help"},
+	},
+}
+
+// writeCompletion prints the completion script for the shell named by args,
+// for `agent completion bash|zsh|fish`.
+func writeCompletion(w io.Writer, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: agent completion bash|zsh|fish")
+	}
+	var script string
+	switch args[0] {
+	case "bash":
+		script = bashCompletion(agentCompletion)
+	case "zsh":
+		script = zshCompletion(agentCompletion)
+	case "fish":
+		script = fishCompletion(agentCompletion)
+	default:
+		return fmt.Errorf("unsupported shell %q (want bash, zsh or fish)", args[0])
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+func (c completionCommand) names() string {
+	names := make([]string, len(c.Subcommands))
+	for i, sub := range c.Subcommands {
+		names[i] = sub.Name
+	}
+	return strings.Join(names, " ")
+}
+
+func (c completionCommand) subcommandsHaveFlags() bool {
+	for _, sub := range c.Subcommands {
+		if len(sub.Flags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c completionCommand) flagWords() string {
+	words := make([]string, len(c.Flags))
+	for i, name := range c.Flags {
+		words[i] = "--" + name
+	}
+	return strings.Join(words, " ")
+}
+
+// bashCompletion completes commands by position: the command is the first
+// word and the subcommand the second. Leading global flags are not skipped.
+func bashCompletion(root completionCommand) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s; load with: source <(%s completion bash)\n", root.Name, root.Name)
+	fmt.Fprintf(&b, "_%s() {\n", root.Name)
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    local words\n")
+	b.WriteString("    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(&b, "        words=%q\n", root.names()+" "+root.flagWords())
+	b.WriteString("    else\n")
+	b.WriteString("        case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range root.Subcommands {
+		if len(cmd.Subcommands) == 0 && len(cmd.Flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "        %s)\n", cmd.Name)
+		if len(cmd.Subcommands) == 0 {
+			fmt.Fprintf(&b, "            words=%q\n", cmd.flagWords())
+			b.WriteString("            ;;\n")
+			continue
+		}
+		b.WriteString("            if [ \"$COMP_CWORD\" -eq 2 ]; then\n")
+		fmt.Fprintf(&b, "                words=%q\n", cmd.names())
+		if cmd.subcommandsHaveFlags() {
+			b.WriteString("            else\n")
+			b.WriteString("                case \"${COMP_WORDS[2]}\" in\n")
+			for _, sub := range cmd.Subcommands {
+				if len(sub.Flags) > 0 {
+					fmt.Fprintf(&b, "                %s) words=%q ;;\n", sub.Name, sub.flagWords())
+				}
+			}
+			b.WriteString("                esac\n")
+		}
+		b.WriteString("            fi\n")
+		b.WriteString("            ;;\n")
+	}
+	b.WriteString("        esac\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o default -F _%s %s\n", root.Name, root.Name)
+	return b.String()
+}
+
+// zshCompletion follows the same positional scheme as bashCompletion.
+func zshCompletion(root completionCommand) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n", root.Name)
+	fmt.Fprintf(&b, "# zsh completion for %s; load with: source <(%s completion zsh)\n", root.Name, root.Name)
+	fmt.Fprintf(&b, "_%s() {\n", root.Name)
+	b.WriteString("    if (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(&b, "        compadd -- %s %s\n", root.names(), root.flagWords())
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    case $words[2] in\n")
+	for _, cmd := range root.Subcommands {
+		if len(cmd.Subcommands) == 0 && len(cmd.Flags) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "    %s)\n", cmd.Name)
+		if len(cmd.Subcommands) == 0 {
+			fmt.Fprintf(&b, "        compadd -- %s\n", cmd.flagWords())
+			b.WriteString("        ;;\n")
+			continue
+		}
+		b.WriteString("        if (( CURRENT == 3 )); then\n")
+		fmt.Fprintf(&b, "            compadd -- %s\n", cmd.names())
+		if cmd.subcommandsHaveFlags() {
+			b.WriteString("        else\n")
+			b.WriteString("            case $words[3] in\n")
+			for _, sub := range cmd.Subcommands {
+				if len(sub.Flags) > 0 {
+					fmt.Fprintf(&b, "            %s) compadd -- %s ;;\n", sub.Name, sub.flagWords())
+				}
+			}
+			b.WriteString("            esac\n")
+		}
+		b.WriteString("        fi\n")
+		b.WriteString("        ;;\n")
+	}
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	b.WriteString("if [ \"$funcstack[1]\" = \"_" + root.Name + "\" ]; then\n")
+	fmt.Fprintf(&b, "    _%s \"$@\"\n", root.Name)
+	b.WriteString("else\n")
+	fmt.Fprintf(&b, "    compdef _%s %s\n", root.Name, root.Name)
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+// fishCompletion uses fish's subcommand conditions, so unlike the bash and
+// zsh scripts it copes with global flags before the command.
+func fishCompletion(root completionCommand) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s; load with: %s completion fish | source\n", root.Name, root.Name)
+	fmt.Fprintf(&b, "complete -c %s -f\n", root.Name)
+	fmt.Fprintf(&b, "complete -c %s -n '__fish_use_subcommand' -a '%s'\n", root.Name, root.names())
+	for _, name := range root.Flags {
+		fmt.Fprintf(&b, "complete -c %s -n '__fish_use_subcommand' -l %s\n", root.Name, name)
+	}
+	for _, cmd := range root.Subcommands {
+		for _, name := range cmd.Flags {
+			fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s' -l %s\n", root.Name, cmd.Name, name)
+		}
+		if len(cmd.Subcommands) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s; and not __fish_seen_subcommand_from %s' -a '%s'\n",
+			root.Name, cmd.Name, cmd.names(), cmd.names())
+		for _, sub := range cmd.Subcommands {
+			for _, name := range sub.Flags {
+				fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from %s; and __fish_seen_subcommand_from %s' -l %s\n",
+					root.Name, cmd.Name, sub.Name, name)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBashCompletionCoversVMSubcommands(t *testing.T) {
+	var out bytes.Buffer
+	if err := writeCompletion(&out, []string{"bash"}); err != nil {
+		t.Fatalf("bash completion: %v", err)
+	}
+	script := out.String()
+	for _, want := range []string{
+		"complete -o default -F _agent agent",
+		`words="create run exec shell temp list`,
+		`create) words="--language --image`,
+		`run) words="--vm --cmd --file`,
+		`clean) words="--all --vm --keep-persist --force" ;;`,
+		`words="--addr --metrics-addr --tls-cert --tls-key"`,
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("bash completion is missing %q", want)
+		}
+	}
+}
+
+func TestCompletionShells(t *testing.T) {
+	for shell, want := range map[string]string{
+		"zsh":  "run) compadd -- --vm --cmd",
+		"fish": "__fish_seen_subcommand_from vm; and __fish_seen_subcommand_from clean' -l keep-persist",
+	} {
+		var out bytes.Buffer
+		if err := writeCompletion(&out, []string{shell}); err != nil {
+			t.Fatalf("%s completion: %v", shell, err)
+		}
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("%s completion is missing %q", shell, want)
+		}
+	}
+	for _, args := range [][]string{nil, {"powershell"}, {"bash", "zsh"}} {
+		if err := writeCompletion(&bytes.Buffer{}, args); err == nil {
+			t.Errorf("completion %q succeeded", args)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+)
+
+func (c *CLI) executeImage(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("image subcommand required")
+	}
+
+	switch args[0] {
+	case "check":
+		return c.handleImageCheck(ctx, args[1:])
+	default:
+		return errors.New("unknown image subcommand")
+	}
+}
+
+func (c *CLI) handleImageCheck(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent image check", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		return errors.New("exactly one image reference is required")
+	}
+
+	result, err := c.vmService.CheckImage(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if !result.Exists {
+		return fmt.Errorf("%w: %s", errImageNotFound, result.Ref)
+	}
+
+	c.logger.Info("image found", map[string]any{
+		"ref":       result.Ref,
+		"digest":    result.Digest,
+		"sizeBytes": result.SizeBytes,
+	})
+
+	return nil
+}
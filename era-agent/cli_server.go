@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+const defaultServerAddr = ":8080"
+
+// ServerOptions are the flags of `agent server`.
+type ServerOptions struct {
+	Addr        string
+	MetricsAddr string
+	TLSCert     string
+	TLSKey      string
+}
+
+// parseServerFlags parses the `agent server` flags, rejecting listen
+// addresses that are not host:port.
+func parseServerFlags(args []string) (ServerOptions, error) {
+	fs := flag.NewFlagSet("agent server", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var opts ServerOptions
+	fs.StringVar(&opts.Addr, "addr", defaultServerAddr, "address the API listens on, as host:port")
+	fs.StringVar(&opts.MetricsAddr, "metrics-addr", "", "serve /metrics on a separate host:port")
+	fs.StringVar(&opts.TLSCert, "tls-cert", "", "serve HTTPS with this certificate file (or ERA_TLS_CERT)")
+	fs.StringVar(&opts.TLSKey, "tls-key", "", "private key for --tls-cert (or ERA_TLS_KEY)")
+
+	if err := fs.Parse(args); err != nil {
+		return ServerOptions{}, err
+	}
+	if fs.NArg() > 0 {
+		return ServerOptions{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if err := validateListenAddr("--addr", opts.Addr); err != nil {
+		return ServerOptions{}, err
+	}
+	if opts.MetricsAddr != "" {
+		if err := validateListenAddr("--metrics-addr", opts.MetricsAddr); err != nil {
+			return ServerOptions{}, err
+		}
+	}
+	return opts, nil
+}
+
+func validateListenAddr(flagName, addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", flagName, addr, err)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 0 || number > 65535 {
+		return fmt.Errorf("invalid %s %q: port must be a number from 0 to 65535", flagName, addr)
+	}
+	return nil
+}
+
+func (c *CLI) handleServer(ctx context.Context, args []string) error {
+	opts, err := parseServerFlags(args)
+	if err != nil {
+		return err
+	}
+	tlsCert, tlsKey, err := resolveTLSFiles(opts.TLSCert, opts.TLSKey)
+	if err != nil {
+		return err
+	}
+
+	apiServer := NewAPIServer(c.vmService, c.logger, opts.Addr)
+	if tlsCert != "" {
+		apiServer.EnableTLS(tlsCert, tlsKey)
+	}
+	if opts.MetricsAddr != "" {
+		apiServer.StartMetrics(opts.MetricsAddr)
+	}
+
+	// SIGINT/SIGTERM drain the server; the deferred vmService.Close in run
+	// then stops the reaper and closes the store
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := apiServer.Serve(ctx); err != nil {
+		return fmt.Errorf("api server failed: %w", err)
+	}
+	c.logger.Info("api server stopped", nil)
+	return nil
+}
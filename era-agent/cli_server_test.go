@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseServerFlags(t *testing.T) {
+	opts, err := parseServerFlags(nil)
+	if err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	if opts != (ServerOptions{Addr: defaultServerAddr}) {
+		t.Fatalf("defaults = %+v", opts)
+	}
+
+	opts, err = parseServerFlags([]string{
+		"--addr", "127.0.0.1:9000",
+		"--metrics-addr=[::1]:9090",
+		"--tls-cert", "cert.pem",
+		"--tls-key", "key.pem",
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := ServerOptions{Addr: "127.0.0.1:9000", MetricsAddr: "[::1]:9090", TLSCert: "cert.pem", TLSKey: "key.pem"}
+	if opts != want {
+		t.Fatalf("opts = %+v, want %+v", opts, want)
+	}
+}
+
+func TestParseServerFlagsRejectsBadInput(t *testing.T) {
+	for _, args := range [][]string{
+		{"--addr", "8080"},
+		{"--addr", "localhost"},
+		{"--addr", ":http"},
+		{"--addr", ":70000"},
+		{"--metrics-addr", "9090"},
+		{"--addr"},
+		{"--port", "8080"},
+		{"extra"},
+	} {
+		if _, err := parseServerFlags(args); err == nil {
+			t.Errorf("parseServerFlags(%q) succeeded", args)
+		}
+	}
+	if _, err := parseServerFlags([]string{"-h"}); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("-h error = %v, want flag.ErrHelp", err)
+	}
+}
+
+func TestServerSubcommandValidatesBeforeListening(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	cli := NewCLI(svc.logger, svc)
+
+	err := cli.Execute(context.Background(), []string{"server", "--addr", "nope"})
+	if err == nil || !strings.Contains(err.Error(), "--addr") {
+		t.Fatalf("server with a bad address: %v", err)
+	}
+	t.Setenv("ERA_TLS_CERT", "")
+	t.Setenv("ERA_TLS_KEY", "")
+	err = cli.Execute(context.Background(), []string{"server", "--tls-cert", "cert.pem"})
+	if err == nil || !strings.Contains(err.Error(), "--tls-key") {
+		t.Fatalf("server with only a certificate: %v", err)
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"text/template"
 	"time"
 )
 
@@ -32,7 +39,7 @@ func (c *CLI) executeVM(ctx context.Context, args []string) error {
 	case "create":
 		return c.handleVMCreate(ctx, args[1:])
 	case "run":
-		return c.handleVMRun(ctx, args[1:])
+		return c.handleVMRun(ctx, os.Stdout, os.Stderr, args[1:])
 	case "exec":
 		return c.handleVMExec(ctx, args[1:])
 	case "shell":
@@ -45,6 +52,24 @@ func (c *CLI) executeVM(ctx context.Context, args []string) error {
 		return c.handleVMStop(ctx, args[1:])
 	case "clean":
 		return c.handleVMClean(ctx, args[1:])
+	case "stats":
+		return c.handleVMStats(ctx, args[1:])
+	case "inspect":
+		return c.handleVMInspect(ctx, args[1:])
+	case "compare":
+		return c.handleVMCompare(ctx, args[1:])
+	case "cp":
+		return c.handleVMCopy(ctx, args[1:])
+	case "adopt":
+		return c.handleVMAdopt(ctx, args[1:])
+	case "snapshot":
+		return c.handleVMSnapshot(ctx, args[1:], false)
+	case "restore":
+		return c.handleVMSnapshot(ctx, args[1:], true)
+	case "history":
+		return c.handleVMHistory(os.Stdout, args[1:])
+	case "logs":
+		return c.handleVMLogs(ctx, os.Stdout, os.Stderr, args[1:])
 	default:
 		return errors.New("unknown vm subcommand")
 	}
@@ -56,10 +81,26 @@ func (c *CLI) handleVMCreate(ctx context.Context, args []string) error {
 
 	language := fs.String("language", "", "guest language runtime")
 	image := fs.String("image", "", "override rootfs image")
-	cpu := fs.Int("cpu", 1, "virtual CPUs")
-	memMiB := fs.Int("mem", 256, "memory in MiB")
+	pullPolicy := fs.String("pull", pullPolicyIfNotPresent, "image pull policy (always|ifnotpresent|never)")
+	cpu := fs.Int("cpu", 0, "virtual CPUs (0 uses the language's resource profile)")
+	memMiB := fs.Int("mem", 0, "memory in MiB (0 uses the language's resource profile)")
 	network := fs.String("network", "none", "network policy (none|allow_all)")
 	persist := fs.Bool("persist", false, "enable persistent volume")
+	var portFlags stringListFlag
+	fs.Var(&portFlags, "port", "forward a host port to the guest as host:guest (repeatable)")
+	ttl := fs.Duration("ttl", 0, "expire and clean the VM after this duration (e.g. 30m)")
+	var volumeFlags stringListFlag
+	fs.Var(&volumeFlags, "volume", "mount a named volume as name:/guest/path (repeatable)")
+	guestIn := fs.String("guest-in", "", "guest mount point for the input directory (default /in)")
+	guestOut := fs.String("guest-out", "", "guest mount point for the output directory (default /out)")
+	guestPersist := fs.String("guest-persist", "", "guest mount point for the persist directory (default /persist)")
+	guestWorkdir := fs.String("guest-workdir", "", "working directory for runs inside the guest")
+	expirePersistent := fs.Bool("expire-persistent", false, "let the TTL reaper remove a --persist VM and its volume")
+	owner := fs.String("owner", "", "owner label recorded in run accounting")
+	var labelFlags stringListFlag
+	fs.Var(&labelFlags, "label", "attach a key=value label (repeatable)")
+	name := fs.String("name", "", "reuse the VM of this name if it exists and is not stopped")
+	dryRun := fs.Bool("dry-run", false, "log the runtime commands instead of creating the VM")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -68,22 +109,61 @@ func (c *CLI) handleVMCreate(ctx context.Context, args []string) error {
 	if *language == "" {
 		return errors.New("--language is required")
 	}
+	networkMode, err := normalizeNetworkMode(*network)
+	if err != nil {
+		return fmt.Errorf("--network: %w", err)
+	}
+	labels, err := parseLabels(labelFlags)
+	if err != nil {
+		return err
+	}
+
+	ports, err := parsePortMappings(portFlags)
+	if err != nil {
+		return err
+	}
+	volumes, err := parseVolumeMounts(volumeFlags)
+	if err != nil {
+		return err
+	}
 
 	createOpts := VMCreateOptions{
 		Language:    *language,
 		Image:       *image,
 		CPUCount:    *cpu,
 		MemoryMiB:   *memMiB,
-		NetworkMode: *network,
+		NetworkMode: networkMode,
 		Persist:     *persist,
-	}
-
-	record, err := c.vmService.Create(ctx, createOpts)
+		PullPolicy:  *pullPolicy,
+		Ports:       ports,
+
+		TTL:              *ttl,
+		ExpirePersistent: *expirePersistent,
+		Volumes:          volumes,
+		GuestMounts:      GuestMounts{Input: *guestIn, Output: *guestOut, Persist: *guestPersist},
+		GuestWorkdir:     *guestWorkdir,
+		Owner:            *owner,
+		Labels:           labels,
+		Name:             *name,
+		DryRun:           *dryRun,
+	}
+
+	// Ctrl-C during a long image pull cancels the create, which removes the
+	// partial VM and its storage instead of leaving them behind.
+	createCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	record, err := c.vmService.Create(createCtx, createOpts)
 	if err != nil {
+		if createCtx.Err() != nil && ctx.Err() == nil {
+			c.logger.Warn("vm create interrupted", map[string]any{
+				"language": createOpts.Language,
+			})
+		}
 		return err
 	}
 
-	c.logger.Info("vm created", map[string]any{
+	fields := map[string]any{
 		"id":         record.ID,
 		"language":   record.Language,
 		"rootfs":     record.RootFSImage,
@@ -92,19 +172,46 @@ func (c *CLI) handleVMCreate(ctx context.Context, args []string) error {
 		"network":    record.NetworkMode,
 		"persisted":  record.Persist,
 		"created_at": record.CreatedAt,
-	})
+	}
+	if !record.ExpiresAt.IsZero() {
+		fields["expires_at"] = record.ExpiresAt
+	}
+	if record.Name != "" {
+		fields["name"] = record.Name
+	}
+	if len(record.Ports) > 0 {
+		mappings := make([]string, 0, len(record.Ports))
+		for _, port := range record.Ports {
+			mappings = append(mappings, port.String())
+		}
+		fields["ports"] = strings.Join(mappings, ",")
+	}
+	if createOpts.DryRun {
+		c.logger.Info("vm create dry run", fields)
+		return nil
+	}
+	c.logger.Info("vm created", fields)
 
 	return nil
 }
 
-func (c *CLI) handleVMRun(ctx context.Context, args []string) error {
+func (c *CLI) handleVMRun(ctx context.Context, stdout, stderr io.Writer, args []string) error {
 	fs := flag.NewFlagSet("agent vm run", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 
 	vmID := fs.String("vm", "", "target VM identifier")
 	cmd := fs.String("cmd", "", "command to execute inside the guest")
 	file := fs.String("file", "", "optional file to stage inside /in")
+	stdinFile := fs.String("stdin-file", "", "optional file fed to the command's standard input")
+	autoInstall := fs.Bool("auto-install", false, "install a missing python/node package and retry once (needs network)")
+	guestTimeout := fs.Bool("guest-timeout", false, "also enforce --timeout inside the guest with timeout(1)")
+	cleanOutput := fs.Bool("clean-output", false, "empty /out before the run (always done for non-persistent VMs)")
 	timeout := fs.Int("timeout", 0, "execution timeout in seconds (required)")
+	envFile := fs.String("env-file", "", "file of KEY=VALUE lines exported before the command")
+	var envFlags stringListFlag
+	fs.Var(&envFlags, "env", "export KEY=VALUE before the command (repeatable, overrides --env-file)")
+	dryRun := fs.Bool("dry-run", false, "log the runtime command instead of running it")
+	quiet := fs.Bool("quiet", false, "do not print the program's stdout and stderr")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -113,18 +220,38 @@ func (c *CLI) handleVMRun(ctx context.Context, args []string) error {
 	if *vmID == "" {
 		return errors.New("--vm is required")
 	}
-	if *cmd == "" {
-		return errors.New("--cmd is required")
+	if *cmd == "" && *file == "" {
+		return errors.New("--cmd is required (or pass --file to run it with the VM's interpreter)")
 	}
 	if *timeout <= 0 {
 		return errors.New("--timeout must be greater than zero")
 	}
 
+	var stdin string
+	if *stdinFile != "" {
+		data, err := os.ReadFile(*stdinFile)
+		if err != nil {
+			return fmt.Errorf("read --stdin-file: %w", err)
+		}
+		stdin = string(data)
+	}
+	envs, err := runEnvsFromFlags(*envFile, envFlags)
+	if err != nil {
+		return err
+	}
+
 	runOpts := VMRunOptions{
 		VMID:    *vmID,
 		Command: *cmd,
 		File:    *file,
+		Stdin:   stdin,
+		Envs:    envs,
 		Timeout: *timeout,
+
+		AutoInstall:  *autoInstall,
+		GuestTimeout: *guestTimeout,
+		CleanOutput:  *cleanOutput,
+		DryRun:       *dryRun,
 	}
 
 	runResult, err := c.vmService.Run(ctx, runOpts)
@@ -140,22 +267,46 @@ func (c *CLI) handleVMRun(ctx context.Context, args []string) error {
 				"duration":  runResult.Duration.String(),
 				"error":     err.Error(),
 			})
+			// A failing program's output usually says why.
+			if !*quiet {
+				c.printRunOutput(stdout, stderr, runOpts.VMID, runResult)
+			}
 			return err
 		}
 		return err
 	}
 
-	c.logger.Info("vm run", map[string]any{
+	fields := map[string]any{
 		"vm":        runOpts.VMID,
 		"exit_code": runResult.ExitCode,
 		"stdout":    runResult.StdoutPath,
 		"stderr":    runResult.StderrPath,
 		"duration":  runResult.Duration.String(),
-	})
+	}
+	if runResult.AutoInstalled != "" {
+		fields["auto_installed"] = runResult.AutoInstalled
+	}
+	if runOpts.DryRun {
+		c.logger.Info("vm run dry run", map[string]any{"vm": runOpts.VMID})
+		return nil
+	}
+	c.logger.Info("vm run", fields)
+	if !*quiet {
+		c.printRunOutput(stdout, stderr, runOpts.VMID, runResult)
+	}
 
 	return nil
 }
 
+func (c *CLI) printRunOutput(stdout, stderr io.Writer, vmID string, result VMRunResult) {
+	if err := printExecOutput(stdout, stderr, result.StdoutPath, result.StderrPath); err != nil {
+		c.logger.Warn("failed to print run output", map[string]any{
+			"vm":    vmID,
+			"error": err.Error(),
+		})
+	}
+}
+
 func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("agent vm exec", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
@@ -166,6 +317,9 @@ func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 	all := fs.Bool("all", false, "execute on all ready VMs")
 	var vmIDs stringListFlag
 	fs.Var(&vmIDs, "vm", "target VM identifier (repeatable)")
+	envFile := fs.String("env-file", "", "file of KEY=VALUE lines exported before the command")
+	var envFlags stringListFlag
+	fs.Var(&envFlags, "env", "export KEY=VALUE before the command (repeatable, overrides --env-file)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -180,6 +334,10 @@ func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 	if len(vmIDs) == 0 && !*all {
 		return errors.New("specify at least one --vm or use --all")
 	}
+	envs, err := runEnvsFromFlags(*envFile, envFlags)
+	if err != nil {
+		return err
+	}
 
 	targets := make([]VMRecord, 0)
 	seen := make(map[string]struct{})
@@ -190,7 +348,7 @@ func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 			c.logger.Warn("partial vm list", map[string]any{"error": listErr.Error()})
 		}
 		for _, record := range records {
-			if record.Status != vmStatusReady && record.Status != vmStatusRunning {
+			if record.Status != VMStatusReady && record.Status != VMStatusRunning {
 				continue
 			}
 			if _, exists := seen[record.ID]; exists {
@@ -206,7 +364,7 @@ func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 		if !ok {
 			return fmt.Errorf("vm not found: %s", id)
 		}
-		if record.Status != vmStatusReady && record.Status != vmStatusRunning {
+		if record.Status != VMStatusReady && record.Status != VMStatusRunning {
 			return fmt.Errorf("vm %s is not ready or running", id)
 		}
 		if _, exists := seen[record.ID]; exists {
@@ -228,6 +386,7 @@ func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 			VMID:    target.ID,
 			Command: command,
 			File:    *file,
+			Envs:    envs,
 			Timeout: *timeout,
 		}
 
@@ -259,7 +418,7 @@ func (c *CLI) handleVMExec(ctx context.Context, args []string) error {
 			"duration":  runResult.Duration.String(),
 		})
 
-		if err := printExecOutput(runResult.StdoutPath, runResult.StderrPath); err != nil {
+		if err := printExecOutput(os.Stdout, os.Stderr, runResult.StdoutPath, runResult.StderrPath); err != nil {
 			c.logger.Warn("failed to print exec output", map[string]any{
 				"vm":    target.ID,
 				"error": err.Error(),
@@ -294,7 +453,7 @@ func (c *CLI) handleVMShell(ctx context.Context, args []string) error {
 		return fmt.Errorf("vm not found: %s", *vmID)
 	}
 
-	if record.Status != vmStatusReady && record.Status != vmStatusRunning {
+	if record.Status != VMStatusReady && record.Status != VMStatusRunning {
 		return fmt.Errorf("vm %s is not ready or running", *vmID)
 	}
 
@@ -305,8 +464,8 @@ func (c *CLI) handleVMShell(ctx context.Context, args []string) error {
 		"shell":    *shellCmd,
 	})
 
-	// Use the launcher's Shell method for interactive execution
-	exitCode, err := c.vmService.launcher.Shell(ctx, record, *shellCmd, os.Stdin, os.Stdout, os.Stderr)
+	// Run through the service so audit mode can tee the session to out/
+	exitCode, err := c.vmService.Shell(ctx, *vmID, *shellCmd, os.Stdin, os.Stdout, os.Stderr)
 	if err != nil {
 		c.logger.Error("vm shell failed", map[string]any{
 			"vm":        *vmID,
@@ -330,13 +489,17 @@ func (c *CLI) handleVMTemp(ctx context.Context, args []string) error {
 
 	language := fs.String("language", "python", "guest language runtime")
 	image := fs.String("image", "", "override rootfs image") 
+	pullPolicy := fs.String("pull", pullPolicyIfNotPresent, "image pull policy (always|ifnotpresent|never)")
 	cmd := fs.String("cmd", "", "command to execute inside the guest")
 	file := fs.String("file", "", "optional file to stage inside /in")
 	timeout := fs.Int("timeout", 30, "execution timeout in seconds")
-	cpu := fs.Int("cpu", 1, "virtual CPUs")
-	memMiB := fs.Int("mem", 256, "memory in MiB")
+	cpu := fs.Int("cpu", 0, "virtual CPUs (0 uses the language's resource profile)")
+	memMiB := fs.Int("mem", 0, "memory in MiB (0 uses the language's resource profile)")
 	network := fs.String("network", "none", "network policy (none|allow_all)")
 	persist := fs.Bool("persist", false, "enable persistent volume")
+	envFile := fs.String("env-file", "", "file of KEY=VALUE lines exported before the command")
+	var envFlags stringListFlag
+	fs.Var(&envFlags, "env", "export KEY=VALUE before the command (repeatable, overrides --env-file)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -348,11 +511,19 @@ func (c *CLI) handleVMTemp(ctx context.Context, args []string) error {
 	if *timeout <= 0 {
 		return errors.New("--timeout must be greater than zero")
 	}
-	if *cpu <= 0 {
-		return errors.New("--cpu must be greater than zero")
+	if *cpu < 0 {
+		return errors.New("--cpu must not be negative")
 	}
-	if *memMiB <= 0 {
-		return errors.New("--mem must be greater than zero")
+	if *memMiB < 0 {
+		return errors.New("--mem must not be negative")
+	}
+	networkMode, err := normalizeNetworkMode(*network)
+	if err != nil {
+		return fmt.Errorf("--network: %w", err)
+	}
+	envs, err := runEnvsFromFlags(*envFile, envFlags)
+	if err != nil {
+		return err
 	}
 
 	// Create temporary VM
@@ -361,8 +532,9 @@ func (c *CLI) handleVMTemp(ctx context.Context, args []string) error {
 		Image:       *image,
 		CPUCount:    *cpu,
 		MemoryMiB:   *memMiB,
-		NetworkMode: *network,
+		NetworkMode: networkMode,
 		Persist:     *persist,
+		PullPolicy:  *pullPolicy,
 	}
 
 	record, err := c.vmService.Create(ctx, createOpts)
@@ -386,6 +558,7 @@ func (c *CLI) handleVMTemp(ctx context.Context, args []string) error {
 		VMID:    vmID,
 		Command: *cmd,
 		File:    *file,
+		Envs:    envs,
 		Timeout: *timeout,
 	}
 
@@ -424,7 +597,7 @@ func (c *CLI) handleVMTemp(ctx context.Context, args []string) error {
 	})
 
 	// Print execution output
-	if err := printExecOutput(runResult.StdoutPath, runResult.StderrPath); err != nil {
+	if err := printExecOutput(os.Stdout, os.Stderr, runResult.StdoutPath, runResult.StderrPath); err != nil {
 		c.logger.Warn("failed to print exec output", map[string]any{
 			"vm":    vmID,
 			"error": err.Error(),
@@ -452,11 +625,51 @@ func (c *CLI) handleVMList(ctx context.Context, args []string) error {
 	fs.SetOutput(io.Discard)
 
 	statusFilter := fs.String("status", "", "filter by VM status")
+	owner := fs.String("owner", "", "only VMs created with this --owner label")
+	language := fs.String("language", "", "only VMs of this language")
+	since := fs.String("since", "", "only VMs created at or after this time (RFC 3339 or a duration ago, e.g. 2h)")
+	until := fs.String("until", "", "only VMs created before this time (RFC 3339 or a duration ago)")
 	includeAll := fs.Bool("all", false, "include stopped VMs")
+	var labelFlags stringListFlag
+	fs.Var(&labelFlags, "label", "only VMs carrying this key=value label (repeatable, all must match)")
+	format := fs.String("format", "", "Go template rendered per VM, e.g. '{{.ID}} {{.Status}}'")
+	output := fs.String("output", vmListOutputTable, "output format (table|json|csv)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	outputFormat := strings.ToLower(strings.TrimSpace(*output))
+	switch outputFormat {
+	case vmListOutputTable, vmListOutputJSON, vmListOutputCSV:
+	default:
+		return fmt.Errorf("invalid --output %q: want table, json or csv", *output)
+	}
+	if *format != "" && outputFormat != vmListOutputTable {
+		return errors.New("--format and --output are mutually exclusive")
+	}
+
+	now := time.Now()
+	createdAfter, err := parseTimeBound(*since, now)
+	if err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	createdBefore, err := parseTimeBound(*until, now)
+	if err != nil {
+		return fmt.Errorf("--until: %w", err)
+	}
+	labels, err := parseLabels(labelFlags)
+	if err != nil {
+		return fmt.Errorf("--label: %w", err)
+	}
+
+	var rowTemplate *template.Template
+	if *format != "" {
+		tmpl, err := parseVMListFormat(*format)
+		if err != nil {
+			return err
+		}
+		rowTemplate = tmpl
+	}
 
 	records, listErr := c.vmService.List(ctx)
 	if listErr != nil {
@@ -464,17 +677,25 @@ func (c *CLI) handleVMList(ctx context.Context, args []string) error {
 	}
 
 	filter := strings.ToLower(strings.TrimSpace(*statusFilter))
-	showStoppedByDefault := filter != ""
-	rows := make([]VMRecord, 0)
+	rows := filterVMs(records, VMFilter{
+		Status:         filter,
+		Owner:          *owner,
+		Language:       *language,
+		Labels:         labels,
+		CreatedAfter:   createdAfter,
+		CreatedBefore:  createdBefore,
+		IncludeStopped: *includeAll,
+	})
 
-	for _, record := range records {
-		if !*includeAll && !showStoppedByDefault && record.Status == vmStatusStopped {
-			continue
-		}
-		if filter != "" && strings.ToLower(record.Status) != filter {
-			continue
-		}
-		rows = append(rows, record)
+	if rowTemplate != nil {
+		// Scripted output: only the rendered rows, nothing else on stdout.
+		return renderVMTemplate(os.Stdout, rowTemplate, rows)
+	}
+	switch outputFormat {
+	case vmListOutputJSON:
+		return renderVMJSON(os.Stdout, rows)
+	case vmListOutputCSV:
+		return renderVMCSV(os.Stdout, rows)
 	}
 
 	if len(rows) == 0 {
@@ -509,6 +730,7 @@ func (c *CLI) handleVMStop(ctx context.Context, args []string) error {
 	all := fs.Bool("all", false, "stop all VMs")
 	var vmIDs stringListFlag
 	fs.Var(&vmIDs, "vm", "target VM identifier (repeatable)")
+	force := fs.Bool("force", false, "abort in-flight runs instead of waiting for them")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -558,8 +780,12 @@ func (c *CLI) handleVMStop(ctx context.Context, args []string) error {
 		return errors.New("no matching VMs to stop")
 	}
 
+	stop := c.vmService.Stop
+	if *force {
+		stop = c.vmService.ForceStop
+	}
 	for _, record := range targets {
-		if err := c.vmService.Stop(ctx, record.ID); err != nil {
+		if err := stop(ctx, record.ID); err != nil {
 			c.logger.Error("vm stop failed", map[string]any{
 				"vm":     record.ID,
 				"error":  err.Error(),
@@ -590,6 +816,7 @@ func (c *CLI) handleVMClean(ctx context.Context, args []string) error {
 	var vmIDs stringListFlag
 	fs.Var(&vmIDs, "vm", "target VM identifier (repeatable)")
 	keepPersist := fs.Bool("keep-persist", false, "retain persistent volume")
+	force := fs.Bool("force", false, "abort in-flight runs instead of waiting for them")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -639,8 +866,12 @@ func (c *CLI) handleVMClean(ctx context.Context, args []string) error {
 		return errors.New("no matching VMs to clean")
 	}
 
+	clean := c.vmService.Clean
+	if *force {
+		clean = c.vmService.ForceClean
+	}
 	for _, record := range targets {
-		if err := c.vmService.Clean(ctx, record.ID, *keepPersist); err != nil {
+		if err := clean(ctx, record.ID, *keepPersist); err != nil {
 			c.logger.Error("vm clean failed", map[string]any{
 				"vm":     record.ID,
 				"error":  err.Error(),
@@ -664,6 +895,431 @@ func (c *CLI) handleVMClean(ctx context.Context, args []string) error {
 	return nil
 }
 
+func (c *CLI) handleVMStats(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent vm stats", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	vmID := fs.String("vm", "", "target VM identifier")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *vmID == "" {
+		return errors.New("--vm is required")
+	}
+
+	stats, err := c.vmService.Stats(ctx, *vmID)
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("vm stats", map[string]any{
+		"vm":          *vmID,
+		"pid":         stats.PID,
+		"cpu_percent": fmt.Sprintf("%.1f", stats.CPUPercent),
+		"memoryMiB":   fmt.Sprintf("%.1f", stats.MemoryMiB),
+		"uptime":      stats.Uptime.Round(time.Second).String(),
+	})
+
+	return nil
+}
+
+func (c *CLI) handleVMCompare(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent vm compare", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	var languages stringListFlag
+	fs.Var(&languages, "language", "guest language runtime (repeatable)")
+	code := fs.String("code", "", "program source to run in every language")
+	file := fs.String("file", "", "file holding the program source")
+	var sourceFiles stringListFlag
+	fs.Var(&sourceFiles, "source", "per-language source file as <language>=<path> (repeatable)")
+	timeout := fs.Int("timeout", 30, "execution timeout in seconds")
+	cpu := fs.Int("cpu", 0, "virtual CPUs per VM (0 uses each language's resource profile)")
+	memMiB := fs.Int("mem", 0, "memory in MiB per VM (0 uses each language's resource profile)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if len(languages) < 2 {
+		return errors.New("at least two --language flags are required")
+	}
+	if *code != "" && *file != "" {
+		return errors.New("use either --code or --file, not both")
+	}
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("read --file: %w", err)
+		}
+		*code = string(data)
+	}
+	sources := make(map[string]string, len(sourceFiles))
+	for _, spec := range sourceFiles {
+		language, path, ok := strings.Cut(spec, "=")
+		if !ok || language == "" || path == "" {
+			return fmt.Errorf("invalid --source %q (want <language>=<path>)", spec)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read --source %s: %w", language, err)
+		}
+		sources[language] = string(data)
+	}
+
+	result, err := c.vmService.Compare(ctx, CompareOptions{
+		Languages: languages,
+		Code:      *code,
+		Sources:   sources,
+		CPUCount:  *cpu,
+		MemoryMiB: *memMiB,
+		Timeout:   *timeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, run := range result.Runs {
+		status := fmt.Sprintf("exit %d, %s", run.ExitCode, run.Duration.Round(time.Millisecond))
+		if run.Error != "" {
+			status = "error: " + run.Error
+		}
+		fmt.Printf("== %s (%s) ==\n", run.Language, status)
+		fmt.Print(run.Stdout)
+		if run.Stderr != "" {
+			fmt.Printf("-- stderr --\n%s", run.Stderr)
+		}
+	}
+	if result.Consistent {
+		fmt.Println("All languages produced the same output and exit code.")
+		return nil
+	}
+	fmt.Println("Outputs differ between languages.")
+	return errors.New("outputs differ between languages")
+}
+
+func (c *CLI) handleVMCopy(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent vm cp", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: agent vm cp <src> <vm>:<dest> | <vm>:<src> <dest>")
+	}
+
+	srcVM, srcPath := splitCopyOperand(fs.Arg(0))
+	destVM, destPath := splitCopyOperand(fs.Arg(1))
+
+	var (
+		copied int64
+		err    error
+	)
+	switch {
+	case srcVM == "" && destVM != "":
+		copied, err = c.vmService.CopyToVM(destVM, srcPath, destPath)
+	case srcVM != "" && destVM == "":
+		copied, err = c.vmService.CopyFromVM(srcVM, srcPath, destPath)
+	default:
+		return errors.New("exactly one of <src> and <dest> must be a <vm>:<path> operand")
+	}
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("files copied", map[string]any{
+		"src":   fs.Arg(0),
+		"dest":  fs.Arg(1),
+		"bytes": copied,
+	})
+	return nil
+}
+
+// splitCopyOperand splits a "<vm>:<path>" cp operand. Host paths, including
+// ones that contain a colon after a slash (./a:b), yield an empty VM ID.
+func splitCopyOperand(arg string) (string, string) {
+	vmID, rest, ok := strings.Cut(arg, ":")
+	if !ok || vmID == "" || strings.ContainsAny(vmID, `/\`) || vmID == "." || vmID == ".." {
+		return "", arg
+	}
+	return vmID, rest
+}
+
+func (c *CLI) handleVMInspect(ctx context.Context, args []string) error {
+	return c.inspectVM(os.Stdout, args)
+}
+
+func (c *CLI) inspectVM(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("agent vm inspect", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	vmID := fs.String("vm", "", "target VM identifier")
+	asJSON := fs.Bool("json", false, "print the record as JSON")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *vmID == "" {
+		return errors.New("--vm is required")
+	}
+
+	record, ok := c.vmService.Get(*vmID)
+	if !ok {
+		return fmt.Errorf("%w: %s", errVMNotFound, *vmID)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(record)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	writeInspectFields(tw, "", reflect.ValueOf(record))
+	return tw.Flush()
+}
+
+// writeInspectFields prints one "Key:<tab>value" line per leaf field of v,
+// naming nested struct fields by their dotted path (e.g. Storage.Root).
+func writeInspectFields(w io.Writer, prefix string, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + field.Name
+		value := v.Field(i)
+
+		if value.Kind() == reflect.Struct {
+			if _, isTime := value.Interface().(time.Time); !isTime {
+				writeInspectFields(w, name+".", value)
+				continue
+			}
+		}
+		fmt.Fprintf(w, "%s:\t%s\n", name, formatInspectValue(value))
+	}
+}
+
+func formatInspectValue(value reflect.Value) string {
+	switch v := value.Interface().(type) {
+	case time.Time:
+		if v.IsZero() {
+			return "-"
+		}
+		return v.Format(time.RFC3339)
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case map[string]string:
+		if len(v) == 0 {
+			return "-"
+		}
+		return formatLabels(v)
+	}
+
+	if value.Kind() == reflect.Slice {
+		if value.Len() == 0 {
+			return "-"
+		}
+		items := make([]string, 0, value.Len())
+		for i := 0; i < value.Len(); i++ {
+			item := value.Index(i).Interface()
+			if stringer, ok := item.(fmt.Stringer); ok {
+				items = append(items, stringer.String())
+			} else {
+				items = append(items, fmt.Sprintf("%+v", item))
+			}
+		}
+		return strings.Join(items, ", ")
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func (c *CLI) handleVMAdopt(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent vm adopt", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	adopted, err := c.vmService.Adopt(ctx)
+	for _, record := range adopted {
+		fmt.Printf("Adopted %s\n", record.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if len(adopted) == 0 {
+		fmt.Println("No orphaned VMs found.")
+	}
+	return nil
+}
+
+// handleVMSnapshot serves both `agent vm snapshot` and `agent vm restore`,
+// which take the same flags.
+func (c *CLI) handleVMSnapshot(ctx context.Context, args []string, restore bool) error {
+	command := "snapshot"
+	if restore {
+		command = "restore"
+	}
+	fs := flag.NewFlagSet("agent vm "+command, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	vmID := fs.String("vm", "", "target VM identifier")
+	name := fs.String("name", "", "snapshot name")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *vmID == "" {
+		return errors.New("--vm is required")
+	}
+	if *name == "" {
+		return errors.New("--name is required")
+	}
+
+	if restore {
+		if err := c.vmService.Restore(ctx, *vmID, *name); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s from snapshot %s\n", *vmID, *name)
+		return nil
+	}
+	if err := c.vmService.Snapshot(ctx, *vmID, *name); err != nil {
+		return err
+	}
+	fmt.Printf("Saved snapshot %s of %s\n", *name, *vmID)
+	return nil
+}
+
+// handleVMHistory prints the VM's recorded runs, newest first. History
+// outlives the VM, so a cleaned VM's runs can still be listed.
+func (c *CLI) handleVMHistory(w io.Writer, args []string) error {
+	fs := flag.NewFlagSet("agent vm history", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	vmID := fs.String("vm", "", "target VM identifier")
+	limit := fs.Int("limit", defaultRecentRuns, "maximum number of runs to show")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *vmID == "" {
+		return errors.New("--vm is required")
+	}
+	if *limit <= 0 {
+		return errors.New("--limit must be greater than zero")
+	}
+
+	tenant := defaultTenant
+	if record, ok := c.vmService.Get(*vmID); ok {
+		tenant = record.Tenant
+	}
+	entries, err := c.vmService.RecentRuns(tenant, *vmID, *limit)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(w, "No runs recorded for %s.\n", *vmID)
+		return nil
+	}
+	renderRunHistory(w, entries)
+	return nil
+}
+
+// handleVMLogs prints the output of the VM's most recent run, stdout lines
+// to stdout and stderr lines to stderr. With --follow it keeps printing
+// lines as they are written, starting over on each new run, until Ctrl-C.
+func (c *CLI) handleVMLogs(ctx context.Context, stdout, stderr io.Writer, args []string) error {
+	fs := flag.NewFlagSet("agent vm logs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	vmID := fs.String("vm", "", "target VM identifier")
+	follow := fs.Bool("follow", false, "keep printing new lines until interrupted")
+	tail := fs.Int("tail", 0, "start from the last N lines of each stream")
+	since := fs.String("since", "", "with --follow, skip logs last written before this time (RFC 3339 or a duration ago, e.g. 10m)")
+	streamFlag := fs.String("stream", "both", "stdout, stderr or both")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *vmID == "" {
+		return errors.New("--vm is required")
+	}
+	if *tail < 0 {
+		return errors.New("--tail must not be negative")
+	}
+	stream, err := parseLogStream(*streamFlag)
+	if err != nil {
+		return fmt.Errorf("--stream: %w", err)
+	}
+	sinceTime, err := parseTimeBound(*since, time.Now())
+	if err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	if !sinceTime.IsZero() && !*follow {
+		return errors.New("--since needs --follow")
+	}
+
+	if !*follow {
+		logs, err := c.vmService.Logs(*vmID, *tail)
+		if err != nil {
+			return err
+		}
+		if stream != "stderr" {
+			io.WriteString(stdout, logs.Stdout)
+		}
+		if stream != "stdout" {
+			io.WriteString(stderr, logs.Stderr)
+		}
+		return nil
+	}
+
+	followCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+	return c.vmService.FollowLogs(followCtx, *vmID, LogFollowOptions{Tail: *tail, Since: sinceTime}, func(name, line string) error {
+		if stream != "both" && stream != name {
+			return nil
+		}
+		dest := stdout
+		if name == "stderr" {
+			dest = stderr
+		}
+		_, err := fmt.Fprintln(dest, line)
+		return err
+	})
+}
+
+func renderRunHistory(w io.Writer, entries []RunHistoryEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Started\tDuration\tExit\tStatus\tCommand")
+	for _, entry := range entries {
+		status := entry.Status
+		if entry.Truncated {
+			status += " (truncated)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n",
+			formatTimestamp(entry.StartedAt),
+			entry.Duration.Round(time.Millisecond),
+			entry.ExitCode,
+			status,
+			strings.ReplaceAll(entry.Command, "\n", " "),
+		)
+	}
+	_ = tw.Flush()
+}
+
 func renderVMTable(records []VMRecord) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
 	fmt.Fprintln(w, "ID\tLanguage\tStatus\tCPU\tMem(MiB)\tPersist\tCreated\tLast Run")
@@ -677,7 +1333,7 @@ func renderVMTable(records []VMRecord) {
 			"%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
 			record.ID,
 			record.Language,
-			strings.ToLower(record.Status),
+			strings.ToLower(string(record.Status)),
 			record.CPUCount,
 			record.MemoryMiB,
 			persist,
@@ -688,6 +1344,90 @@ func renderVMTable(records []VMRecord) {
 	_ = w.Flush()
 }
 
+const (
+	vmListOutputTable = "table"
+	vmListOutputJSON  = "json"
+	vmListOutputCSV   = "csv"
+)
+
+// renderVMJSON writes records as a JSON array of the objects /api/vm/list
+// returns.
+func renderVMJSON(w io.Writer, records []VMRecord) error {
+	infos := make([]VMInfo, 0, len(records))
+	for _, record := range records {
+		infos = append(infos, vmRecordToInfo(record))
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(infos)
+}
+
+// vmCSVHeader names the columns written by renderVMCSV.
+var vmCSVHeader = []string{"id", "name", "language", "status", "cpu_count", "memory_mib", "network_mode", "persist", "owner", "labels", "created_at", "last_run_at"}
+
+// renderVMCSV writes a header row and one row per record. Times are RFC 3339
+// and empty when unset; labels are sorted key=value pairs.
+func renderVMCSV(w io.Writer, records []VMRecord) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(vmCSVHeader); err != nil {
+		return err
+	}
+	for _, record := range records {
+		row := []string{
+			record.ID,
+			record.Name,
+			record.Language,
+			strings.ToLower(string(record.Status)),
+			strconv.Itoa(record.CPUCount),
+			strconv.Itoa(record.MemoryMiB),
+			record.NetworkMode,
+			strconv.FormatBool(record.Persist),
+			record.Owner,
+			formatLabels(record.Labels),
+			formatCSVTime(record.CreatedAt),
+			formatCSVTime(record.LastRunAt),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func parseVMListFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderVMTemplate executes tmpl once per record, writing one line each.
+func renderVMTemplate(w io.Writer, tmpl *template.Template, records []VMRecord) error {
+	var line bytes.Buffer
+	for _, record := range records {
+		line.Reset()
+		if err := tmpl.Execute(&line, record); err != nil {
+			return fmt.Errorf("--format failed for vm %s: %w", record.ID, err)
+		}
+		if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+			line.WriteByte('\n')
+		}
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func formatTimestamp(ts time.Time) string {
 	if ts.IsZero() {
 		return "-"
@@ -695,17 +1435,17 @@ func formatTimestamp(ts time.Time) string {
 	return ts.Local().Format("2006-01-02 15:04:05")
 }
 
-func printExecOutput(stdoutPath, stderrPath string) error {
-	if err := streamFile(stdoutPath, os.Stdout); err != nil {
+func printExecOutput(stdout, stderr io.Writer, stdoutPath, stderrPath string) error {
+	if err := streamFile(stdoutPath, stdout); err != nil {
 		return err
 	}
-	if err := streamFile(stderrPath, os.Stderr); err != nil {
+	if err := streamFile(stderrPath, stderr); err != nil {
 		return err
 	}
 	return nil
 }
 
-func streamFile(path string, dest *os.File) error {
+func streamFile(path string, dest io.Writer) error {
 	cleaned := strings.TrimSpace(path)
 	if cleaned == "" {
 		return nil
@@ -723,6 +1463,6 @@ func streamFile(path string, dest *os.File) error {
 	if len(data) == 0 || data[len(data)-1] == '\n' {
 		return nil
 	}
-	_, err = dest.WriteString("\n")
+	_, err = io.WriteString(dest, "\n")
 	return err
 }
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRenderVMTemplate(t *testing.T) {
+	tmpl, err := parseVMListFormat(`{{.ID}} {{.Status}} {{.MemoryMiB}}`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	records := []VMRecord{
+		{ID: "python-1", Status: VMStatusReady, MemoryMiB: 256},
+		{ID: "node-2", Status: VMStatusStopped, MemoryMiB: 512},
+	}
+
+	var out bytes.Buffer
+	if err := renderVMTemplate(&out, tmpl, records); err != nil {
+		t.Fatalf("render failed: %v", err)
+	}
+
+	want := "python-1 ready 256\nnode-2 stopped 512\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestVMListFormatErrors(t *testing.T) {
+	if _, err := parseVMListFormat(`{{.ID`); err == nil || !strings.Contains(err.Error(), "invalid --format") {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+
+	tmpl, err := parseVMListFormat(`{{.NoSuchField}}`)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	err = renderVMTemplate(&bytes.Buffer{}, tmpl, []VMRecord{{ID: "python-1"}})
+	if err == nil || !strings.Contains(err.Error(), "python-1") {
+		t.Fatalf("expected execution error naming the vm, got %v", err)
+	}
+}
+
+func TestRenderVMJSONAndCSV(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []VMRecord{
+		{ID: "python-1", Language: "python", Status: VMStatusReady, CPUCount: 1, MemoryMiB: 256, NetworkMode: "none", CreatedAt: created, Labels: map[string]string{"team": "ml", "env": "ci"}},
+		{ID: "node-2", Name: "worker", Language: "node", Status: VMStatusStopped, CPUCount: 2, MemoryMiB: 512, Persist: true, CreatedAt: created, LastRunAt: created.Add(time.Minute)},
+	}
+
+	var out bytes.Buffer
+	if err := renderVMJSON(&out, records); err != nil {
+		t.Fatalf("render json: %v", err)
+	}
+	var got []VMInfo
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, out.String())
+	}
+	if len(got) != 2 || !reflect.DeepEqual(got[0], vmRecordToInfo(records[0])) || got[1].Name != "worker" {
+		t.Fatalf("json = %+v", got)
+	}
+	out.Reset()
+	if err := renderVMJSON(&out, nil); err != nil || strings.TrimSpace(out.String()) != "[]" {
+		t.Fatalf("empty json = %q, %v; want []", out.String(), err)
+	}
+
+	out.Reset()
+	if err := renderVMCSV(&out, records); err != nil {
+		t.Fatalf("render csv: %v", err)
+	}
+	rows, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not CSV: %v", err)
+	}
+	if len(rows) != 3 || !reflect.DeepEqual(rows[0], vmCSVHeader) {
+		t.Fatalf("csv rows = %v, want a header and two records", rows)
+	}
+	want := []string{"python-1", "", "python", "ready", "1", "256", "none", "false", "", "env=ci, team=ml", "2024-01-02T03:04:05Z", ""}
+	if !reflect.DeepEqual(rows[1], want) {
+		t.Fatalf("csv row = %q, want %q", rows[1], want)
+	}
+	if rows[2][1] != "worker" || rows[2][7] != "true" || rows[2][11] != "2024-01-02T03:05:05Z" {
+		t.Fatalf("csv row = %q", rows[2])
+	}
+}
+
+func TestVMListRejectsUnknownOutput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	cli := NewCLI(svc.logger, svc)
+	if err := cli.handleVMList(context.Background(), []string{"--output", "yaml"}); err == nil || !strings.Contains(err.Error(), "--output") {
+		t.Fatalf("err = %v, want an --output error", err)
+	}
+	if err := cli.handleVMList(context.Background(), []string{"--output", "json", "--format", "{{.ID}}"}); err == nil {
+		t.Fatal("--format with --output json accepted")
+	}
+}
+
+func TestVMHistoryAccumulates(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	cli := NewCLI(svc.logger, svc)
+
+	var out bytes.Buffer
+	if err := cli.handleVMHistory(&out, []string{"--vm", record.ID}); err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if !strings.Contains(out.String(), "No runs recorded") {
+		t.Fatalf("empty history = %q", out.String())
+	}
+
+	for _, command := range []string{"echo one", "exit 3"} {
+		_, _ = svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: command, Timeout: 5})
+	}
+	out.Reset()
+	if err := cli.handleVMHistory(&out, []string{"--vm", record.ID}); err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "exit 3") || !strings.Contains(lines[1], "failed") || !strings.Contains(lines[2], "echo one") {
+		t.Fatalf("history output:\n%s", out.String())
+	}
+
+	if err := cli.handleVMHistory(&out, nil); err == nil {
+		t.Fatal("history without --vm accepted")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to write from a follower goroutine
+// while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestVMLogsFollowEmitsAppendedLines(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	cli := NewCLI(svc.logger, svc)
+
+	logPath := filepath.Join(record.Storage.Root, "out", stdoutLogName)
+	if err := os.WriteFile(logPath, []byte("old 1\nold 2\nold 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr lockedBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- cli.handleVMLogs(ctx, &stdout, &stderr, []string{"--vm", record.ID, "--follow", "--tail", "1"})
+	}()
+	waitFor := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for stdout.String() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("followed stdout = %q, want %q", stdout.String(), want)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	waitFor("old 3\n")
+	file, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"new 1\n", "new 2\n", "new 3\n"} {
+		if _, err := file.WriteString(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file.Close()
+	waitFor("old 3\nnew 1\nnew 2\nnew 3\n")
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("follow: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("follow did not stop when its context was cancelled")
+	}
+	if stderr.String() != "" {
+		t.Fatalf("stderr = %q, want nothing", stderr.String())
+	}
+
+	var plain, errOut bytes.Buffer
+	if err := cli.handleVMLogs(context.Background(), &plain, &errOut, []string{"--vm", record.ID, "--tail", "2"}); err != nil {
+		t.Fatalf("logs: %v", err)
+	}
+	if plain.String() != "new 2\nnew 3\n" {
+		t.Fatalf("logs --tail 2 = %q", plain.String())
+	}
+	if err := cli.handleVMLogs(context.Background(), &plain, &errOut, []string{"--vm", record.ID, "--since", "1h"}); err == nil {
+		t.Fatal("--since without --follow succeeded")
+	}
+}
+
+func TestVMRunPrintsOutput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	cli := NewCLI(svc.logger, svc)
+
+	run := func(extra ...string) (string, string, error) {
+		t.Helper()
+		var stdout, stderr bytes.Buffer
+		args := append([]string{"--vm", record.ID, "--timeout", "5"}, extra...)
+		err := cli.handleVMRun(context.Background(), &stdout, &stderr, args)
+		return stdout.String(), stderr.String(), err
+	}
+
+	stdout, stderr, err := run("--cmd", "echo hello; echo oops >&2")
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if stdout != "hello\n" || stderr != "oops\n" {
+		t.Fatalf("printed stdout %q and stderr %q, want the program output", stdout, stderr)
+	}
+
+	// A failing program's output is printed too.
+	if stdout, _, err := run("--cmd", "echo partial; exit 3"); err == nil || stdout != "partial\n" {
+		t.Fatalf("failing run printed %q (err %v), want its output and an error", stdout, err)
+	}
+
+	if stdout, stderr, err := run("--cmd", "echo hello", "--quiet"); err != nil || stdout != "" || stderr != "" {
+		t.Fatalf("--quiet printed %q / %q (err %v), want nothing", stdout, stderr, err)
+	}
+}
+
+func TestVMInspectJSON(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	cli := NewCLI(svc.logger, svc)
+
+	var out bytes.Buffer
+	if err := cli.inspectVM(&out, []string{"--vm", record.ID, "--json"}); err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	for _, key := range []string{"ID", "Language", "RootFSImage", "NetworkMode", "Status", "CreatedAt", "Storage"} {
+		if _, ok := got[key]; !ok {
+			t.Fatalf("JSON output missing %s: %s", key, out.String())
+		}
+	}
+	storage, ok := got["Storage"].(map[string]any)
+	if !ok || storage["Root"] != record.Storage.Root || storage["InputPath"] != record.Storage.InputPath {
+		t.Fatalf("unexpected storage layout: %v", got["Storage"])
+	}
+
+	var decoded VMRecord
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.ID != record.ID {
+		t.Fatalf("output does not round-trip to the record: %v (%+v)", err, decoded)
+	}
+}
+
+func TestVMInspectText(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	cli := NewCLI(svc.logger, svc)
+
+	var out bytes.Buffer
+	if err := cli.inspectVM(&out, []string{"--vm", record.ID}); err != nil {
+		t.Fatalf("inspect failed: %v", err)
+	}
+	for _, want := range []string{"ID:", record.ID, "Storage.Root:", record.Storage.Root, "NetworkMode:", "none", "LastRunAt:"} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("text output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestVMInspectNotFound(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	cli := NewCLI(svc.logger, svc)
+
+	err := cli.inspectVM(&bytes.Buffer{}, []string{"--vm", "missing"})
+	if !errors.Is(err, errVMNotFound) || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected not-found error naming the vm, got %v", err)
+	}
+	if err := cli.inspectVM(&bytes.Buffer{}, nil); err == nil {
+		t.Fatal("expected error without --vm")
+	}
+}
+
+func TestSplitCopyOperand(t *testing.T) {
+	cases := []struct {
+		arg, vm, path string
+	}{
+		{"python-1:in/data.csv", "python-1", "in/data.csv"},
+		{"python-1:/out", "python-1", "/out"},
+		{"./local.txt", "", "./local.txt"},
+		{"./dir:with:colons", "", "./dir:with:colons"},
+		{"/abs/path", "", "/abs/path"},
+	}
+	for _, tc := range cases {
+		vm, path := splitCopyOperand(tc.arg)
+		if vm != tc.vm || path != tc.path {
+			t.Errorf("splitCopyOperand(%q) = %q, %q; want %q, %q", tc.arg, vm, path, tc.vm, tc.path)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+)
+
+func (c *CLI) executeVolume(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("volume subcommand required")
+	}
+
+	switch args[0] {
+	case "create":
+		return c.handleVolumeCreate(ctx, args[1:])
+	case "list":
+		return c.handleVolumeList(ctx, args[1:])
+	case "rm":
+		return c.handleVolumeRemove(ctx, args[1:])
+	default:
+		return errors.New("unknown volume subcommand")
+	}
+}
+
+func (c *CLI) handleVolumeCreate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent volume create", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("exactly one volume name is required")
+	}
+
+	volume, err := c.vmService.CreateVolume(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	c.logger.Info("volume created", map[string]any{
+		"name": volume.Name,
+		"path": volume.Path,
+	})
+	return nil
+}
+
+func (c *CLI) handleVolumeList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent volume list", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	volumes, err := c.vmService.ListVolumes()
+	if err != nil {
+		return err
+	}
+	if len(volumes) == 0 {
+		fmt.Println("No volumes found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Name\tPath\tCreated")
+	for _, volume := range volumes {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", volume.Name, volume.Path, formatTimestamp(volume.CreatedAt))
+	}
+	return w.Flush()
+}
+
+func (c *CLI) handleVolumeRemove(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("agent volume rm", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("exactly one volume name is required")
+	}
+
+	if err := c.vmService.DeleteVolume(fs.Arg(0)); err != nil {
+		return err
+	}
+
+	c.logger.Info("volume removed", map[string]any{"name": fs.Arg(0)})
+	return nil
+}
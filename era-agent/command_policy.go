@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var errCommandBlocked = errors.New("command blocked by policy")
+
+// commandPolicy restricts what Run may execute. Deny patterns are regular
+// expressions matched against the whole command line. A non-empty Allow list
+// names the only binaries a command may invoke.
+//
+// The checks are a guard for hardened deployments, not a sandbox: commands
+// are shell scripts, so allowing a shell or interpreter allows everything it
+// can start. The VM is still the isolation boundary.
+type commandPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+
+	deny []*regexp.Regexp
+}
+
+// loadCommandPolicy reads the policy named by AGENT_COMMAND_POLICY. It
+// returns nil when the variable is unset, and an error for a missing or
+// malformed file, so a broken policy blocks runs instead of allowing them.
+func loadCommandPolicy() (*commandPolicy, error) {
+	file := strings.TrimSpace(os.Getenv("AGENT_COMMAND_POLICY"))
+	if file == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("command policy: %w", err)
+	}
+	policy, err := parseCommandPolicy(raw)
+	if err != nil {
+		return nil, fmt.Errorf("command policy %s: %w", file, err)
+	}
+	return policy, nil
+}
+
+// parseCommandPolicy decodes a policy such as
+// {"allow": ["python3", "ls"], "deny": ["rm\\s+-rf\\s+/"]}.
+func parseCommandPolicy(raw []byte) (*commandPolicy, error) {
+	var policy commandPolicy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return nil, err
+	}
+	for _, pattern := range policy.Deny {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny pattern %q: %w", pattern, err)
+		}
+		policy.deny = append(policy.deny, re)
+	}
+	for i, binary := range policy.Allow {
+		policy.Allow[i] = strings.TrimSpace(binary)
+		if policy.Allow[i] == "" {
+			return nil, errors.New("allow list has an empty binary name")
+		}
+	}
+	return &policy, nil
+}
+
+// check returns errCommandBlocked naming the rule the command breaks. Deny
+// patterns are checked first, then every binary the command invokes must be
+// on the allow list, compared by base name.
+func (p *commandPolicy) check(command string) error {
+	if p == nil {
+		return nil
+	}
+	for i, re := range p.deny {
+		if re.MatchString(command) {
+			return fmt.Errorf("%w: matches deny pattern %q", errCommandBlocked, p.Deny[i])
+		}
+	}
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	binaries, quotedSubstitution := commandBinaries(command)
+	if quotedSubstitution {
+		return fmt.Errorf("%w: command substitution inside double quotes cannot be checked against the allow list", errCommandBlocked)
+	}
+	for _, binary := range binaries {
+		if !p.allows(binary) {
+			return fmt.Errorf("%w: %q is not in the allow list", errCommandBlocked, binary)
+		}
+	}
+	return nil
+}
+
+// checkShellAllowed refuses interactive shells while AGENT_COMMAND_POLICY
+// is set: what is typed into a shell never passes through check.
+func checkShellAllowed() error {
+	policy, err := loadCommandPolicy()
+	if err != nil {
+		return err
+	}
+	if policy != nil {
+		return fmt.Errorf("%w: interactive shells are disabled while AGENT_COMMAND_POLICY is set", errCommandBlocked)
+	}
+	return nil
+}
+
+func (p *commandPolicy) allows(binary string) bool {
+	for _, allowed := range p.Allow {
+		if path.Base(allowed) == binary {
+			return true
+		}
+	}
+	return false
+}
+
+// shellKeywordsSkip start a command without being one (if true; then ...),
+// so the next word is the binary. shellKeywordsNoCommand start or end a
+// compound statement that runs nothing itself.
+var (
+	shellKeywordsSkip      = map[string]bool{"if": true, "then": true, "else": true, "elif": true, "while": true, "until": true, "do": true, "!": true, "{": true, "}": true, "time": true}
+	shellKeywordsNoCommand = map[string]bool{"for": true, "case": true, "select": true, "fi": true, "done": true, "esac": true}
+)
+
+// commandBinaries returns the base name of the binary each simple command in
+// a shell command line starts, e.g. "cd /in && FOO=1 /usr/bin/python3 x.py |
+// sort" gives cd, python3 and sort. Quotes are honoured and leading variable
+// assignments skipped. Command substitutions inside double quotes are not
+// looked into; quotedSubstitution reports that the line has one, so check
+// can refuse it.
+func commandBinaries(command string) (binaries []string, quotedSubstitution bool) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  rune
+	)
+	endWord := func() {
+		if inWord {
+			words = append(words, word.String())
+			word.Reset()
+			inWord = false
+		}
+	}
+	endSegment := func() {
+		endWord()
+		if binary := segmentBinary(words); binary != "" {
+			binaries = append(binaries, binary)
+		}
+		words = nil
+	}
+
+	runes := []rune(command)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch {
+			case r == '"':
+				quote = 0
+			case r == '`' || (r == '$' && i+1 < len(runes) && runes[i+1] == '('):
+				quotedSubstitution = true
+				word.WriteRune(r)
+			case r == '\\' && i+1 < len(runes):
+				i++
+				word.WriteRune(runes[i])
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == ' ' || r == '\t':
+			endWord()
+		case strings.ContainsRune(";&|\n()`", r):
+			endSegment()
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '(':
+			endSegment()
+			i++
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	endSegment()
+	return binaries, quotedSubstitution
+}
+
+// segmentBinary picks the binary of one simple command from its words.
+func segmentBinary(words []string) string {
+	for _, word := range words {
+		if shellKeywordsNoCommand[word] {
+			return ""
+		}
+		if shellKeywordsSkip[word] {
+			continue
+		}
+		if name, _, ok := strings.Cut(word, "="); ok && envNamePattern.MatchString(name) {
+			continue
+		}
+		return path.Base(word)
+	}
+	return ""
+}
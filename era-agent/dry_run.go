@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var errDryRunUnsupported = errors.New("dry run is not supported")
+
+// dryRunLauncher is implemented by launchers that honour withDryRun,
+// reporting the commands they would execute instead of running them.
+type dryRunLauncher interface {
+	supportsDryRun()
+}
+
+type dryRunKey struct{}
+
+// withDryRun makes launchers pass the argv of every runtime command to
+// report and treat it as having succeeded.
+func withDryRun(ctx context.Context, report func(argv []string)) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, report)
+}
+
+// dryRunReporter returns the report function installed by withDryRun, or
+// nil when ctx is not a dry run.
+func dryRunReporter(ctx context.Context) func(argv []string) {
+	report, _ := ctx.Value(dryRunKey{}).(func(argv []string))
+	return report
+}
+
+// dryRunContext returns ctx set up to log each command the launcher would
+// run, failing when the launcher would run them anyway.
+func (s *VMService) dryRunContext(ctx context.Context) (context.Context, error) {
+	if _, ok := s.launcher.(dryRunLauncher); !ok {
+		return nil, fmt.Errorf("%w by this vm runtime", errDryRunUnsupported)
+	}
+	logger := loggerFor(ctx, s.logger)
+	return withDryRun(ctx, func(argv []string) {
+		logger.Info("dry run", map[string]any{"argv": argv})
+	}), nil
+}
+
+// dryRunCommand reports the launcher commands for running opts.Command in
+// record, including a relaunch of a stopped VM, without touching its
+// storage. opts.Command is the fully wrapped command run would execute
+// except for the exit status sentinel, which is added here.
+func (s *VMService) dryRunCommand(ctx context.Context, record VMRecord, opts VMRunOptions) (VMRunResult, error) {
+	ctx, err := s.dryRunContext(ctx)
+	if err != nil {
+		return VMRunResult{}, err
+	}
+	if !record.Storage.DisableGuestVolumes && record.Storage.OutputPath != "" {
+		opts.Command = exitCodeSentinelCommand(record.Storage.guestOut(), opts.Command)
+	}
+
+	switch record.Status {
+	case VMStatusReady, VMStatusRunning:
+	case VMStatusStopped:
+		if err := s.launch(ctx, record); err != nil {
+			return VMRunResult{}, err
+		}
+	default:
+		return VMRunResult{}, errors.New("vm is not available to run commands")
+	}
+
+	exitCode, err := s.launcher.Run(ctx, record, opts, io.Discard, io.Discard)
+	return VMRunResult{ExitCode: exitCode}, err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// captureDryRunLog points svc's logger at a JSON log file and returns a
+// function reading back the argv of every "dry run" entry.
+func captureDryRunLog(t *testing.T, svc *VMService) func() [][]string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := NewLogger("info", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.SetFormat(LogFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = logger.Close() })
+	svc.logger = logger
+
+	return func() [][]string {
+		t.Helper()
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		var argvs [][]string
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry struct {
+				Msg  string   `json:"msg"`
+				Argv []string `json:"argv"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatalf("decode log line %q: %v", scanner.Text(), err)
+			}
+			if entry.Msg == "dry run" {
+				argvs = append(argvs, entry.Argv)
+			}
+		}
+		return argvs
+	}
+}
+
+func TestDryRunCreateLogsKrunvmArgv(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	launcher := &krunVMLauncher{binary: "krunvm-not-installed"}
+	svc := newTestVMService(t, launcher)
+	logged := captureDryRunLog(t, svc)
+
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    2,
+		MemoryMiB:   512,
+		NetworkMode: "none",
+		DryRun:      true,
+	})
+	if err != nil {
+		t.Fatalf("dry-run create: %v", err)
+	}
+	if record.Status != VMStatusReady {
+		t.Fatalf("status = %q, want a synthetic ready record", record.Status)
+	}
+
+	want := append([]string{"krunvm-not-installed"}, launcher.createArgs(record)...)
+	if got := logged(); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Fatalf("logged argv = %q, want [%q]", got, want)
+	}
+	if _, ok := svc.Get(record.ID); ok {
+		t.Fatal("dry-run create stored the VM")
+	}
+	if _, err := os.Stat(record.Storage.Root); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("dry-run create left storage at %s: %v", record.Storage.Root, err)
+	}
+}
+
+func TestDryRunRunLogsKrunvmArgv(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	// The VM exists; only the runtime commands are faked from here on.
+	svc.launcher = &krunVMLauncher{binary: "krunvm-not-installed"}
+	logged := captureDryRunLog(t, svc)
+
+	result, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "echo hi",
+		Timeout: 5,
+		DryRun:  true,
+	})
+	if err != nil {
+		t.Fatalf("dry-run run: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("exit code = %d, want synthetic success", result.ExitCode)
+	}
+
+	want := []string{
+		"krunvm-not-installed", "start", record.ID, "--", "/bin/bash", "-c",
+		guestCommandScript(exitCodeSentinelCommand(guestOutputPath, "echo hi")),
+	}
+	if got := logged(); len(got) != 1 || !reflect.DeepEqual(got[0], want) {
+		t.Fatalf("logged argv = %q, want [%q]", got, want)
+	}
+	if stored, _ := svc.Get(record.ID); !stored.LastRunAt.Equal(record.LastRunAt) {
+		t.Fatal("dry-run run was recorded on the VM")
+	}
+}
+
+func TestDryRunUnsupportedLauncher(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none", DryRun: true})
+	if !errors.Is(err, errDryRunUnsupported) {
+		t.Fatalf("create error = %v, want errDryRunUnsupported", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// languageInterpreters lists the default interpreter for each language and
+// the environment variable that overrides it, e.g. AGENT_PYTHON_BIN=python3.12.
+var languageInterpreters = map[string]struct {
+	envVar string
+	binary string
+}{
+	"python":     {envVar: "AGENT_PYTHON_BIN", binary: "python3"},
+	"node":       {envVar: "AGENT_NODE_BIN", binary: "node"},
+	"javascript": {envVar: "AGENT_NODE_BIN", binary: "node"},
+	"js":         {envVar: "AGENT_NODE_BIN", binary: "node"},
+	"ruby":       {envVar: "AGENT_RUBY_BIN", binary: "ruby"},
+	"golang":     {envVar: "AGENT_GO_BIN", binary: "go run"},
+	"go":         {envVar: "AGENT_GO_BIN", binary: "go run"},
+}
+
+// interpreterFor returns the configured interpreter command for a language.
+func interpreterFor(language string) (string, error) {
+	entry, ok := languageInterpreters[normalizeLanguage(language)]
+	if !ok {
+		return "", fmt.Errorf("%w: no interpreter known for %q", errUnsupportedLang, language)
+	}
+	if override := strings.TrimSpace(os.Getenv(entry.envVar)); override != "" {
+		return override, nil
+	}
+	return entry.binary, nil
+}
+
+// buildExecutionCommand builds the guest command that runs a file staged in
+// the guest input directory inputDir (/in by default) with the language's
+// interpreter.
+func buildExecutionCommand(language, inputDir, fileName string) (string, error) {
+	base := path.Base(strings.TrimSpace(fileName))
+	if base == "" || base == "." || base == "/" {
+		return "", fmt.Errorf("invalid file name %q", fileName)
+	}
+
+	interpreter, err := interpreterFor(language)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s %s", interpreter, shellQuote(path.Join(inputDir, base))), nil
+}
+
+// shellQuote wraps s in single quotes for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// sequenceCommand joins commands into one script run by a single guest
+// shell, so working directory and exported variables carry over from one
+// command to the next, and so does an exit, which ends the sequence. Each
+// command is eval'd on its own so an unterminated one cannot run into the
+// next. The script stops at the first failure and exits with its status;
+// with continueOnError every command runs and the script exits with the
+// first non-zero status seen.
+func sequenceCommand(commands []string, continueOnError bool) string {
+	var builder strings.Builder
+	if continueOnError {
+		builder.WriteString("era_status=0\n")
+	}
+	for _, command := range commands {
+		if continueOnError {
+			fmt.Fprintf(&builder, "eval %s || { era_rc=$?; [ \"$era_status\" -ne 0 ] || era_status=$era_rc; }\n", shellQuote(command))
+		} else {
+			fmt.Fprintf(&builder, "eval %s || exit $?\n", shellQuote(command))
+		}
+	}
+	if continueOnError {
+		builder.WriteString("exit \"$era_status\"")
+	}
+	return strings.TrimSuffix(builder.String(), "\n")
+}
+
+// sequenceDisplay renders commands as the one-line equivalent of
+// sequenceCommand, for the run history.
+func sequenceDisplay(commands []string, continueOnError bool) string {
+	if continueOnError {
+		return strings.Join(commands, "; ")
+	}
+	return strings.Join(commands, " && ")
+}
+
+// guestTimeoutKillAfter is how long timeout(1) waits after SIGTERM before
+// sending SIGKILL.
+const guestTimeoutKillAfter = 2
+
+// guestTimeoutCommand wraps command so the guest's own timeout(1) enforces the
+// deadline, reaching descendants the host-side kill may miss. Guests without
+// timeout run the command unwrapped and rely on the host deadline alone.
+func guestTimeoutCommand(command string, seconds int) string {
+	quoted := shellQuote(command)
+	return fmt.Sprintf(
+		"if command -v timeout >/dev/null 2>&1; then timeout -k %d %d bash -c %s; else bash -c %s; fi",
+		guestTimeoutKillAfter, seconds, quoted, quoted,
+	)
+}
+
+// exitCodeFileName is the file in /out where the guest records the command's
+// exit status, see exitCodeSentinelCommand.
+const exitCodeFileName = "exit_code"
+
+// exitCodeSentinelCommand makes the guest write command's exit status to
+// exit_code in the guest output directory outputDir (/out by default) when it
+// finishes. krunvm on macOS can report 0 for commands that failed, so the
+// file serves as the authoritative status. The path is quoted inside the
+// trap's action, which is quoted again for the trap itself.
+func exitCodeSentinelCommand(outputDir, command string) string {
+	action := `printf "%d\n" "$?" 2>/dev/null >` + shellQuote(path.Join(outputDir, exitCodeFileName))
+	return fmt.Sprintf("trap %s EXIT\n%s", shellQuote(action), command)
+}
+
+// readExitCodeFile parses the status written by exitCodeSentinelCommand.
+func readExitCodeFile(file string) (int, bool) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, false
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return code, true
+}
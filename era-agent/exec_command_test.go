@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildExecutionCommandDefaults(t *testing.T) {
+	cases := map[string]string{
+		"python":     "python3 '/in/main.py'",
+		"javascript": "node '/in/main.py'",
+		"ruby":       "ruby '/in/main.py'",
+		"go":         "go run '/in/main.py'",
+	}
+	for language, want := range cases {
+		got, err := buildExecutionCommand(language, guestInputPath, "/host/path/main.py")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", language, err)
+		}
+		if got != want {
+			t.Errorf("%s: command = %q, want %q", language, got, want)
+		}
+	}
+
+	if _, err := buildExecutionCommand("cobol", guestInputPath, "main.cob"); err == nil {
+		t.Fatal("expected error for unknown language")
+	}
+}
+
+func TestBuildExecutionCommandUsesConfiguredInterpreter(t *testing.T) {
+	t.Setenv("AGENT_PYTHON_BIN", "python3.12")
+	t.Setenv("AGENT_NODE_BIN", "/opt/node/bin/node --no-warnings")
+
+	got, err := buildExecutionCommand("python", guestInputPath, "main.py")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := "python3.12 '/in/main.py'"; got != want {
+		t.Fatalf("command = %q, want %q", got, want)
+	}
+
+	got, err = buildExecutionCommand("node", guestInputPath, "it's.js")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `/opt/node/bin/node --no-warnings '/in/it'"'"'s.js'`; got != want {
+		t.Fatalf("command = %q, want %q", got, want)
+	}
+}
+
+func TestRunBuildsCommandFromFile(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	t.Setenv("AGENT_PYTHON_BIN", "python3.12")
+
+	launcher := newFakeLauncher()
+	var gotCommand string
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		gotCommand = opts.Command
+		return 0, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	script := filepath.Join(t.TempDir(), "main.py")
+	if err := os.WriteFile(script, []byte("print(1)\n"), 0o644); err != nil {
+		t.Fatalf("write script: %v", err)
+	}
+
+	if _, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, File: script, Timeout: 5}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if want := exitCodeSentinelCommand(guestOutputPath, "python3.12 '/in/main.py'"); gotCommand != want {
+		t.Fatalf("launcher got command %q, want %q", gotCommand, want)
+	}
+}
+
+func TestGuestTimeoutCommandWrapsWithTimeout(t *testing.T) {
+	got := guestTimeoutCommand("python3 main.py", 30)
+	if !strings.Contains(got, "timeout -k 2 30 bash -c 'python3 main.py'") {
+		t.Fatalf("wrapper missing guest timeout: %q", got)
+	}
+	if !strings.Contains(got, "else bash -c 'python3 main.py'") {
+		t.Fatalf("wrapper missing fallback: %q", got)
+	}
+}
+
+func TestGuestTimeoutCommandFallsBackWithoutTimeout(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not available")
+	}
+	binDir := t.TempDir()
+	if err := os.Symlink(bash, filepath.Join(binDir, "bash")); err != nil {
+		t.Fatalf("symlink bash: %v", err)
+	}
+
+	cmd := exec.Command(bash, "-c", guestTimeoutCommand("echo fallback", 5))
+	cmd.Env = []string{"PATH=" + binDir}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("wrapper failed without timeout on PATH: %v", err)
+	}
+	if string(out) != "fallback\n" {
+		t.Fatalf("output = %q, want %q", out, "fallback\n")
+	}
+}
+
+func TestRunWithGuestTimeoutRespectsHostDeadline(t *testing.T) {
+	launcher := newFakeLauncher()
+	var gotCommand string
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		gotCommand = opts.Command
+		return runHostCommand(ctx, exec.CommandContext(ctx, "/bin/sh", "-c", opts.Command), nil, stdout, stderr)
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	start := time.Now()
+	_, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:         record.ID,
+		Command:      "sleep 30",
+		Timeout:      1,
+		GuestTimeout: true,
+	})
+	if err == nil {
+		t.Fatal("expected the run to fail at the deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("run took %s, want it bounded by the 1s deadline", elapsed)
+	}
+	if !strings.Contains(gotCommand, "timeout -k 2 1 bash -c 'sleep 30'") {
+		t.Fatalf("launcher got %q, want the guest timeout wrapper", gotCommand)
+	}
+}
+
+func TestGuestExitCodeOverridesZeroFromLauncher(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+
+	// Like krunvm on macOS: the command runs, but the launcher always reports 0.
+	launcher := newFakeLauncher()
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		command := strings.ReplaceAll(opts.Command, guestOutputPath+"/", record.Storage.OutputPath+"/")
+		_, _ = runHostCommand(ctx, exec.CommandContext(ctx, "/bin/sh", "-c", command), nil, stdout, stderr)
+		return 0, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	_, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "echo failing; exit 7", Timeout: 5})
+	var runErr *VMRunError
+	if !errors.As(err, &runErr) || runErr.Result.ExitCode != 7 {
+		t.Fatalf("expected exit code 7 from the guest sentinel, got %v", err)
+	}
+
+	result, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "true", Timeout: 5})
+	if err != nil || result.ExitCode != 0 {
+		t.Fatalf("stale sentinel leaked into the next run: %+v, %v", result, err)
+	}
+}
+
+func TestExitCodeFallsBackWithoutSentinel(t *testing.T) {
+	launcher := newFakeLauncher()
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		if strings.Contains(opts.Command, exitCodeFileName) {
+			t.Errorf("sentinel added without guest volumes: %q", opts.Command)
+		}
+		return 3, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	_, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "exit 3", Timeout: 5})
+	var runErr *VMRunError
+	if !errors.As(err, &runErr) || runErr.Result.ExitCode != 3 {
+		t.Fatalf("expected launcher exit code 3, got %v", err)
+	}
+}
+
+func TestExitCodeSentinelQuotesOutputDir(t *testing.T) {
+	for _, name := range []string{"my out", "it's", "$HOME;x"} {
+		dir := filepath.Join(t.TempDir(), name)
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := exec.Command("/bin/sh", "-c", exitCodeSentinelCommand(dir, "exit 3")).Run(); err == nil {
+			t.Fatalf("%q: command succeeded, want exit status 3", name)
+		}
+		code, ok := readExitCodeFile(filepath.Join(dir, exitCodeFileName))
+		if !ok || code != 3 {
+			t.Fatalf("%q: exit_code = %d, %v; want 3", name, code, ok)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultGuestEnvAllow is the host environment forwarded to processes whose
+// environment reaches the guest. krunvm hands its own environment to the
+// guest command, so anything else on the host (ERA_API_KEY, cloud
+// credentials, ...) would otherwise leak into user code.
+var defaultGuestEnvAllow = []string{
+	"PATH",
+	"HOME",
+	"LANG",
+	"LC_ALL",
+	"TERM",
+	"TMPDIR",
+	"XDG_RUNTIME_DIR",
+}
+
+// guestEnv filters a host environment down to the allowed variables.
+// AGENT_GUEST_ENV_ALLOW adds comma-separated names to the default set and
+// AGENT_GUEST_ENV_DENY removes names even if they are allowed; a trailing "*"
+// matches a prefix in either list (e.g. LC_*).
+func guestEnv(host []string) []string {
+	allow := append(append([]string{}, defaultGuestEnvAllow...), envNameList("AGENT_GUEST_ENV_ALLOW")...)
+	deny := envNameList("AGENT_GUEST_ENV_DENY")
+
+	env := make([]string, 0, len(allow))
+	for _, entry := range host {
+		name, _, ok := strings.Cut(entry, "=")
+		if !ok || !matchesEnvName(allow, name) || matchesEnvName(deny, name) {
+			continue
+		}
+		env = append(env, entry)
+	}
+	return env
+}
+
+func envNameList(variable string) []string {
+	var names []string
+	for _, name := range strings.Split(os.Getenv(variable), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func matchesEnvName(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func envHas(env []string, name string) bool {
+	for _, entry := range env {
+		if strings.HasPrefix(entry, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGuestCommandEnvHidesSecrets(t *testing.T) {
+	t.Setenv("AGENT_STATE_DIR", t.TempDir())
+	stateRootOnce = sync.Once{}
+	t.Cleanup(func() { stateRootOnce = sync.Once{} })
+	t.Setenv("ERA_API_KEY", "super-secret")
+	t.Setenv("PATH", os.Getenv("PATH"))
+
+	launcher := &krunVMLauncher{binary: krunvmBinaryName}
+	env := launcher.guestCommandEnv()
+	if envHas(env, "ERA_API_KEY") {
+		t.Fatal("ERA_API_KEY forwarded to the guest environment")
+	}
+	for _, name := range []string{"PATH", "KRUNVM_DATA_DIR"} {
+		if !envHas(env, name) {
+			t.Fatalf("guest environment missing %s: %v", name, env)
+		}
+	}
+
+	// Host-side tooling such as image pulls still sees the full environment.
+	if !envHas(launcher.commandEnv(), "ERA_API_KEY") {
+		t.Fatal("commandEnv unexpectedly filtered")
+	}
+}
+
+func TestGuestEnvAllowAndDeny(t *testing.T) {
+	t.Setenv("AGENT_GUEST_ENV_ALLOW", "MY_TOOL_*, EXTRA")
+	t.Setenv("AGENT_GUEST_ENV_DENY", "MY_TOOL_TOKEN,HOME")
+
+	host := []string{
+		"PATH=/usr/bin",
+		"HOME=/root",
+		"EXTRA=1",
+		"MY_TOOL_MODE=fast",
+		"MY_TOOL_TOKEN=secret",
+		"AWS_SECRET_ACCESS_KEY=secret",
+	}
+	got := strings.Join(guestEnv(host), " ")
+	want := "PATH=/usr/bin EXTRA=1 MY_TOOL_MODE=fast"
+	if got != want {
+		t.Fatalf("guestEnv = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// GuestMounts moves a VM's storage directories inside the guest. Empty
+// fields keep the defaults, /in, /out and /persist; a persist directory
+// mounted at /workspace, say, gives runs a workspace that survives restarts.
+type GuestMounts struct {
+	Input   string `json:"in,omitempty"`
+	Output  string `json:"out,omitempty"`
+	Persist string `json:"persist,omitempty"`
+}
+
+// resolveGuestLayout validates the requested mount points and working
+// directory, filling in the defaults. The mount points must be distinct
+// absolute directories other than /; one may sit inside another, as in
+// persist at /workspace and in at /workspace/in. They may not contain ':'
+// or ',', which separate the fields of the launchers' volume specs.
+func resolveGuestLayout(mounts GuestMounts, workdir string) (GuestMounts, string, error) {
+	resolved := GuestMounts{Input: guestInputPath, Output: guestOutputPath, Persist: guestPersistPath}
+	seen := make(map[string]string, 3)
+	for _, field := range []struct {
+		name      string
+		requested string
+		target    *string
+	}{
+		{"in", mounts.Input, &resolved.Input},
+		{"out", mounts.Output, &resolved.Output},
+		{"persist", mounts.Persist, &resolved.Persist},
+	} {
+		if requested := strings.TrimSpace(field.requested); requested != "" {
+			guestPath := path.Clean(requested)
+			if !path.IsAbs(guestPath) || guestPath == "/" {
+				return GuestMounts{}, "", fmt.Errorf("guest %s path %q must be an absolute directory", field.name, field.requested)
+			}
+			if strings.ContainsAny(guestPath, ":,") {
+				return GuestMounts{}, "", fmt.Errorf("guest %s path %q may not contain ':' or ','", field.name, field.requested)
+			}
+			*field.target = guestPath
+		}
+		if other, ok := seen[*field.target]; ok {
+			return GuestMounts{}, "", fmt.Errorf("guest %s and %s paths are both %s", other, field.name, *field.target)
+		}
+		seen[*field.target] = field.name
+	}
+
+	if workdir = strings.TrimSpace(workdir); workdir != "" {
+		workdir = path.Clean(workdir)
+		if !path.IsAbs(workdir) {
+			return GuestMounts{}, "", fmt.Errorf("guest workdir %q must be an absolute path", workdir)
+		}
+	}
+	return resolved, workdir, nil
+}
+
+// guestIn, guestOut and guestPersist are where the layout's directories are
+// mounted in the guest. Records from before the mount points could be moved
+// have none stored and use the defaults.
+func (l StorageLayout) guestIn() string {
+	return orDefault(l.GuestMounts.Input, guestInputPath)
+}
+
+func (l StorageLayout) guestOut() string {
+	return orDefault(l.GuestMounts.Output, guestOutputPath)
+}
+
+func (l StorageLayout) guestPersist() string {
+	return orDefault(l.GuestMounts.Persist, guestPersistPath)
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCustomGuestLayoutInCreateArgs(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	svc := newTestVMService(t, newFakeLauncher())
+
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:     "python",
+		NetworkMode:  "none",
+		Persist:      true,
+		GuestMounts:  GuestMounts{Input: "/workspace/in/", Persist: "/workspace"},
+		GuestWorkdir: "/workspace",
+	})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if got := record.Storage.GuestMounts; got != (GuestMounts{Input: "/workspace/in", Output: "/out", Persist: "/workspace"}) {
+		t.Fatalf("recorded mounts = %+v", got)
+	}
+
+	launcher := &krunVMLauncher{binary: krunvmBinaryName}
+	args := strings.Join(launcher.createArgs(record), " ")
+	for _, want := range []string{
+		"--volume " + record.Storage.InputPath + ":/workspace/in",
+		"--volume " + record.Storage.OutputPath + ":/out",
+		"--volume " + record.Storage.PersistPath + ":/workspace",
+		"--workdir /workspace",
+	} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("krunvm args %q missing %q", args, want)
+		}
+	}
+
+	command, err := buildExecutionCommand(record.Language, record.Storage.guestIn(), "main.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "python3 '/workspace/in/main.py'"; command != want {
+		t.Fatalf("file command = %q, want %q", command, want)
+	}
+}
+
+func TestDefaultGuestLayout(t *testing.T) {
+	// Records stored before the layout was configurable have no mounts.
+	var legacy StorageLayout
+	if legacy.guestIn() != guestInputPath || legacy.guestOut() != guestOutputPath || legacy.guestPersist() != guestPersistPath {
+		t.Fatalf("empty layout resolves to %s %s %s", legacy.guestIn(), legacy.guestOut(), legacy.guestPersist())
+	}
+
+	launcher := &krunVMLauncher{binary: krunvmBinaryName}
+	args := strings.Join(launcher.createArgs(VMRecord{ID: "legacy", RootFSImage: "img", Storage: legacy}), " ")
+	if strings.Contains(args, "--workdir") {
+		t.Fatalf("krunvm args %q set a workdir without one configured", args)
+	}
+}
+
+func TestResolveGuestLayoutRejectsBadPaths(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		mounts  GuestMounts
+		workdir string
+	}{
+		{"relative", GuestMounts{Input: "in"}, ""},
+		{"root", GuestMounts{Persist: "/"}, ""},
+		{"colon", GuestMounts{Persist: "/data:ro"}, ""},
+		{"comma", GuestMounts{Output: "/out,readonly"}, ""},
+		{"duplicate", GuestMounts{Input: "/data", Output: "/data/"}, ""},
+		{"default clash", GuestMounts{Persist: "/out"}, ""},
+		{"relative workdir", GuestMounts{}, "work"},
+	} {
+		if _, _, err := resolveGuestLayout(tc.mounts, tc.workdir); err == nil {
+			t.Errorf("%s: resolveGuestLayout(%+v, %q) succeeded", tc.name, tc.mounts, tc.workdir)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const imageConfigFileName = "images.json"
+
+// imageConfigPath returns the language -> image mapping file, honouring
+// AGENT_IMAGE_CONFIG before falling back to <stateRoot>/images.json.
+func imageConfigPath() (string, bool) {
+	if override := strings.TrimSpace(os.Getenv("AGENT_IMAGE_CONFIG")); override != "" {
+		return override, true
+	}
+	return filepath.Join(stateRoot(), imageConfigFileName), false
+}
+
+// loadImageConfig reads the optional image mapping file. A missing default file
+// is not an error; an unreadable or malformed file is logged and ignored so the
+// built-in images keep working.
+func loadImageConfig(logger *Logger) map[string][]string {
+	path, explicit := imageConfigPath()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return nil
+		}
+		logger.Warn("ignoring image config", map[string]any{"path": path, "error": err.Error()})
+		return nil
+	}
+
+	images, err := parseImageConfig(raw)
+	if err != nil {
+		logger.Warn("ignoring malformed image config", map[string]any{"path": path, "error": err.Error()})
+		return nil
+	}
+
+	logger.Debug("loaded image config", map[string]any{"path": path, "languages": len(images)})
+	return images
+}
+
+// parseImageConfig decodes a JSON object mapping each language to an ordered
+// list of candidate images, e.g. {"rust": ["docker.io/library/rust:1-slim"]}.
+func parseImageConfig(raw []byte) (map[string][]string, error) {
+	var decoded map[string][]string
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+
+	images := make(map[string][]string, len(decoded))
+	for language, candidates := range decoded {
+		key := normalizeLanguage(language)
+		if key == "" {
+			return nil, errors.New("language names cannot be empty")
+		}
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("language %q has no images", language)
+		}
+
+		cleaned := make([]string, 0, len(candidates))
+		for _, candidate := range candidates {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "" {
+				return nil, fmt.Errorf("language %q has an empty image reference", language)
+			}
+			cleaned = append(cleaned, candidate)
+		}
+		images[key] = cleaned
+	}
+
+	return images, nil
+}
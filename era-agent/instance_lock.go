@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// instanceLockFileName is the PID file under the state directory that
+// serializes agent processes sharing that state.
+const instanceLockFileName = "agent.pid"
+
+// instanceLock is a held PID-file lock; release it on exit.
+type instanceLock struct {
+	file *os.File
+	path string
+}
+
+// instanceLockAttempts bounds how often acquireInstanceLock reopens the PID
+// file after locking one that a releasing agent unlinked meanwhile.
+const instanceLockAttempts = 5
+
+// acquireInstanceLock takes an exclusive flock on <dir>/agent.pid and
+// records this process's PID in it. The flock alone decides: a PID file left
+// by a crashed agent is unlocked and simply taken over, while a held lock
+// always means another agent, even when its PID is not written yet.
+func acquireInstanceLock(dir string) (*instanceLock, error) {
+	if err := ensureDir(dir); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, instanceLockFileName)
+
+	for attempt := 0; attempt < instanceLockAttempts; attempt++ {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			pid, _ := readPIDFile(file)
+			file.Close()
+			if !errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, fmt.Errorf("lock %s: %w", path, err)
+			}
+			if pid > 0 && processAlive(pid) {
+				return nil, fmt.Errorf("another instance of agent is already running with PID %d (set AGENT_ALLOW_MULTIPLE=1 or pass --allow-multiple to bypass)", pid)
+			}
+			// An empty PID file is an agent between locking and writing it.
+			return nil, fmt.Errorf("another instance of agent holds %s (set AGENT_ALLOW_MULTIPLE=1 or pass --allow-multiple to bypass)", path)
+		}
+
+		// release unlinks the file before unlocking it, so the inode locked
+		// here may no longer be the one at path; lock that one instead.
+		if !sameFile(file, path) {
+			file.Close()
+			continue
+		}
+		lock := &instanceLock{file: file, path: path}
+		if err := lock.writePID(); err != nil {
+			lock.release()
+			return nil, err
+		}
+		return lock, nil
+	}
+	return nil, fmt.Errorf("lock %s: the file kept being replaced", path)
+}
+
+// sameFile reports whether file is still the file at path.
+func sameFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	return err == nil && os.SameFile(opened, current)
+}
+
+func (l *instanceLock) writePID() error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	return err
+}
+
+// release removes the PID file and drops the lock.
+func (l *instanceLock) release() {
+	if l == nil || l.file == nil {
+		return
+	}
+	_ = os.Remove(l.path)
+	_ = syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	_ = l.file.Close()
+	l.file = nil
+}
+
+// processAlive reports whether pid names a running process. EPERM means it
+// exists but belongs to another user.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func readPIDFile(file *os.File) (int, error) {
+	buf := make([]byte, 32)
+	n, err := file.ReadAt(buf, 0)
+	if n == 0 && err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(buf[:n])))
+}
+
+// allowMultipleInstances reads AGENT_ALLOW_MULTIPLE.
+func allowMultipleInstances() bool {
+	enabled, err := strconv.ParseBool(strings.TrimSpace(os.Getenv("AGENT_ALLOW_MULTIPLE")))
+	return err == nil && enabled
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInstanceLockRejectsSecondAgent(t *testing.T) {
+	dir := t.TempDir()
+	lock, err := acquireInstanceLock(dir)
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, instanceLockFileName))
+	if strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("pid file = %q, want this process", data)
+	}
+
+	// flock conflicts between open file descriptions even in one process,
+	// so a second acquire stands in for a second agent.
+	if _, err := acquireInstanceLock(dir); err == nil || !strings.Contains(err.Error(), "already running with PID "+strconv.Itoa(os.Getpid())) {
+		t.Fatalf("second acquire err = %v, want already running", err)
+	}
+
+	lock.release()
+	if _, err := os.Stat(filepath.Join(dir, instanceLockFileName)); !os.IsNotExist(err) {
+		t.Fatalf("pid file left after release: %v", err)
+	}
+	again, err := acquireInstanceLock(dir)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	again.release()
+}
+
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot start a child process: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestInstanceLockRecoversStaleLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, instanceLockFileName)
+	pid := deadPID(t)
+
+	// A PID file left by a crashed agent, with nobody holding the lock.
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0o644); err != nil {
+		t.Fatalf("write pid file: %v", err)
+	}
+	lock, err := acquireInstanceLock(dir)
+	if err != nil {
+		t.Fatalf("acquire over unlocked stale file: %v", err)
+	}
+	lock.release()
+
+}
+
+func TestInstanceLockRespectsHeldLockWithoutLivePID(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, instanceLockFileName)
+
+	// Held, but naming no live process: an agent that has locked the file
+	// and not written its PID yet, or wrote a PID that is gone.
+	for _, content := range []string{"", "garbage\n", strconv.Itoa(deadPID(t)) + "\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write pid file: %v", err)
+		}
+		holder, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		if err := syscall.Flock(int(holder.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+			t.Fatalf("flock: %v", err)
+		}
+
+		if lock, err := acquireInstanceLock(dir); err == nil {
+			lock.release()
+			t.Fatalf("acquired a held lock whose pid file holds %q", content)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || string(data) != content {
+			t.Fatalf("held pid file = %q, %v; want it left alone as %q", data, err, content)
+		}
+		holder.Close()
+	}
+}
+
+func TestInstanceLockConcurrentStartersExcludeEachOther(t *testing.T) {
+	dir := t.TempDir()
+	const starters = 8
+	for round := 0; round < 20; round++ {
+		var (
+			wg      sync.WaitGroup
+			start   = make(chan struct{})
+			mu      sync.Mutex
+			holders []*instanceLock
+		)
+		for i := 0; i < starters; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				if lock, err := acquireInstanceLock(dir); err == nil {
+					mu.Lock()
+					holders = append(holders, lock)
+					mu.Unlock()
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+		if len(holders) != 1 {
+			t.Fatalf("round %d: %d starters got the lock, want exactly 1", round, len(holders))
+		}
+		holders[0].release()
+	}
+}
+
+func TestInstanceLockSurvivesRacingRelease(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		held int
+		max  int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				lock, err := acquireInstanceLock(dir)
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				if held++; held > max {
+					max = held
+				}
+				mu.Unlock()
+				time.Sleep(100 * time.Microsecond)
+				mu.Lock()
+				held--
+				mu.Unlock()
+				lock.release()
+			}
+		}()
+	}
+	wg.Wait()
+	if max != 1 {
+		t.Fatalf("%d agents held the lock at once, want 1", max)
+	}
+}
+
+func TestParseGlobalOptionsAllowMultiple(t *testing.T) {
+	opts, remaining, err := parseGlobalOptions([]string{"--allow-multiple", "vm", "list"})
+	if err != nil || !opts.AllowMultiple || len(remaining) != 2 {
+		t.Fatalf("opts = %+v, remaining = %v, err = %v", opts, remaining, err)
+	}
+	t.Setenv("AGENT_ALLOW_MULTIPLE", "1")
+	if opts, _, _ := parseGlobalOptions(nil); !opts.AllowMultiple {
+		t.Fatal("AGENT_ALLOW_MULTIPLE=1 ignored")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultLaunchAttempts = 3
+	defaultLaunchBackoff  = time.Second
+	maxLaunchBackoff      = 30 * time.Second
+
+	launcherListAttempts = 3
+	launcherListBackoff  = 100 * time.Millisecond
+	defaultListTimeout   = 10 * time.Second
+)
+
+// transientLaunchMarkers are lowercased fragments of runtime and registry
+// output for failures worth retrying: network errors and registry
+// throttling or outages. Anything else, such as a missing image or a bad
+// argument, fails the create straight away.
+var transientLaunchMarkers = []string{
+	"connection reset",
+	"connection refused",
+	"i/o timeout",
+	"tls handshake timeout",
+	"timed out",
+	"temporary failure in name resolution",
+	"unexpected eof",
+	"too many requests",
+	"toomanyrequests",
+	"502 bad gateway",
+	"503 service unavailable",
+	"504 gateway timeout",
+}
+
+// launchRetryPolicy bounds how often Create launches a VM before giving up.
+type launchRetryPolicy struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// launchRetryPolicyFromEnv reads AGENT_LAUNCH_ATTEMPTS (total tries,
+// default 3; 1 disables retries) and AGENT_LAUNCH_BACKOFF (the first wait,
+// doubled after each failure up to 30s; default 1s).
+func launchRetryPolicyFromEnv() launchRetryPolicy {
+	policy := launchRetryPolicy{Attempts: defaultLaunchAttempts, Backoff: defaultLaunchBackoff}
+	if attempts := positiveEnvInt("AGENT_LAUNCH_ATTEMPTS"); attempts > 0 {
+		policy.Attempts = attempts
+	}
+	if raw := strings.TrimSpace(os.Getenv("AGENT_LAUNCH_BACKOFF")); raw != "" {
+		if backoff, err := time.ParseDuration(raw); err == nil && backoff >= 0 {
+			policy.Backoff = backoff
+		}
+	}
+	return policy
+}
+
+// isTransientLaunchError reports whether a failed launch may succeed when
+// tried again, judged by the output of the runtime command that failed.
+func isTransientLaunchError(err error) bool {
+	var cmdErr *commandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	combined := strings.ToLower(cmdErr.stdout + " " + cmdErr.stderr)
+	for _, marker := range transientLaunchMarkers {
+		if strings.Contains(combined, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// launcherListTimeout returns AGENT_LIST_TIMEOUT (a Go duration bounding
+// each attempt at listing the launcher's VMs) or the default of 10s.
+func launcherListTimeout() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("AGENT_LIST_TIMEOUT"))
+	if raw == "" {
+		return defaultListTimeout
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		return defaultListTimeout
+	}
+	return timeout
+}
+
+// listLauncherVMs asks the launcher which VMs exist, giving each attempt
+// its own timeout and retrying a failed one a couple of times, since a
+// runtime that is briefly busy must not make every VM look gone.
+func (s *VMService) listLauncherVMs(ctx context.Context) ([]string, error) {
+	timeout := launcherListTimeout()
+	backoff := launcherListBackoff
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		ids, err := s.launcher.List(attemptCtx)
+		cancel()
+		if err == nil || attempt >= launcherListAttempts || ctx.Err() != nil {
+			return ids, err
+		}
+
+		loggerFor(ctx, s.logger).Debug("launcher list failed, retrying", map[string]any{
+			"attempt": attempt,
+			"backoff": backoff.String(),
+			"error":   err.Error(),
+		})
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// launchWithRetry is launch retried with exponential backoff while the
+// failure looks transient. Whatever a failed attempt left behind is removed
+// before the next one.
+func (s *VMService) launchWithRetry(ctx context.Context, record VMRecord) error {
+	policy := launchRetryPolicyFromEnv()
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := s.launch(ctx, record)
+		if err == nil || attempt >= policy.Attempts || ctx.Err() != nil || !isTransientLaunchError(err) {
+			return err
+		}
+
+		loggerFor(ctx, s.logger).Warn("vm launch failed, retrying", map[string]any{
+			"id":      record.ID,
+			"rootfs":  record.RootFSImage,
+			"attempt": attempt,
+			"backoff": backoff.String(),
+			"error":   err.Error(),
+		})
+		_ = s.launcher.Cleanup(context.Background(), record.ID)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxLaunchBackoff {
+			backoff = maxLaunchBackoff
+		}
+	}
+}
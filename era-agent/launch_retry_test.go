@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateRetriesTransientLaunchFailures(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_BACKOFF", "1ms")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+
+	failures := 0
+	launcher.launchFn = func(ctx context.Context, record VMRecord) error {
+		if failures < 2 {
+			failures++
+			return &commandError{args: []string{"krunvm", "create"}, stderr: "Error: pinging container registry: read tcp: connection reset by peer"}
+		}
+		return nil
+	}
+
+	record, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none"})
+	if err != nil {
+		t.Fatalf("create after two transient failures: %v", err)
+	}
+	if launcher.launchCalls != 3 {
+		t.Fatalf("launch called %d times, want 3", launcher.launchCalls)
+	}
+	if _, ok := svc.Get(record.ID); !ok {
+		t.Fatal("created vm not tracked")
+	}
+}
+
+func TestCreateGivesUpAfterLaunchAttempts(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_BACKOFF", "1ms")
+	t.Setenv("AGENT_LAUNCH_ATTEMPTS", "2")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+
+	launcher.launchFn = func(ctx context.Context, record VMRecord) error {
+		return &commandError{args: []string{"krunvm", "create"}, stderr: "toomanyrequests: rate limit exceeded"}
+	}
+	if _, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none", Image: "example/python:1"}); err == nil {
+		t.Fatal("create succeeded against a throttling registry")
+	}
+	if launcher.launchCalls != 2 {
+		t.Fatalf("launch called %d times, want AGENT_LAUNCH_ATTEMPTS=2", launcher.launchCalls)
+	}
+}
+
+func TestCreateDoesNotRetryFatalLaunchFailures(t *testing.T) {
+	t.Setenv("AGENT_LAUNCH_BACKOFF", "1ms")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+
+	launcher.launchFn = func(ctx context.Context, record VMRecord) error {
+		return &commandError{args: []string{"krunvm", "create"}, stderr: "Error: invalid argument --cpus"}
+	}
+	if _, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none", Image: "example/python:1"}); err == nil {
+		t.Fatal("create succeeded")
+	}
+	if launcher.launchCalls != 1 {
+		t.Fatalf("launch called %d times, want no retry", launcher.launchCalls)
+	}
+
+	if isTransientLaunchError(errors.New("connection reset by peer")) {
+		t.Fatal("a non-command error was treated as transient")
+	}
+}
+
+func TestListRetriesFlakyLauncher(t *testing.T) {
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	calls := 0
+	launcher.listFn = func(context.Context) ([]string, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("krunvm list: resource temporarily unavailable")
+		}
+		return []string{record.ID}, nil
+	}
+	records, err := svc.List(context.Background())
+	if err != nil {
+		t.Fatalf("list after one failed attempt: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("launcher listed %d times, want 2", calls)
+	}
+	if len(records) != 1 || records[0].Status != VMStatusReady {
+		t.Fatalf("records = %+v, want %s still ready", records, record.ID)
+	}
+}
+
+func TestListKeepsStatusesWhenLauncherListFails(t *testing.T) {
+	t.Setenv("AGENT_LIST_TIMEOUT", "20ms")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	calls := 0
+	launcher.listFn = func(ctx context.Context) ([]string, error) {
+		calls++
+		if calls%2 == 1 {
+			return nil, errors.New("krunvm list exploded")
+		}
+		// A hung runtime: only the per-attempt timeout ends the call.
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	start := time.Now()
+	records, err := svc.List(context.Background())
+	if !errors.Is(err, errLauncherList) {
+		t.Fatalf("list err = %v, want errLauncherList", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("list took %s against a hung launcher", elapsed)
+	}
+	if calls != launcherListAttempts {
+		t.Fatalf("launcher listed %d times, want %d", calls, launcherListAttempts)
+	}
+	if len(records) != 1 || records[0].Status != VMStatusReady {
+		t.Fatalf("records = %+v, want the cached ready status", records)
+	}
+	if stored, err := svc.store.Get(record.ID); err != nil || stored.Status != VMStatusReady {
+		t.Fatalf("stored record = %+v, %v; want status left ready", stored, err)
+	}
+}
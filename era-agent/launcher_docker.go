@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dockerBinaryName = "docker"
+
+	// dockerVMLabel marks containers created by the agent so List ignores
+	// unrelated containers on the host.
+	dockerVMLabel = "era.agent.vm"
+
+	// defaultDockerPidsLimit caps the processes in one container, so a fork
+	// bomb stays inside it. AGENT_DOCKER_PIDS_LIMIT overrides it.
+	defaultDockerPidsLimit = 1024
+
+	// dockerExecIDEnv tags every process of one `docker exec` so they can
+	// be killed inside the container; killing the docker CLI leaves them
+	// running.
+	dockerExecIDEnv = "ERA_EXEC_ID"
+
+	// dockerExecShell runs the command script ($0) with bash, or with sh on
+	// images without it, such as alpine.
+	dockerExecShell = `if [ -x /bin/bash ]; then exec /bin/bash -c "$0"; fi; exec /bin/sh -c "$0"`
+
+	// dockerKillExecScript kills the processes tagged with exec ID $0.
+	dockerKillExecScript = `for p in /proc/[0-9]*; do grep -q "` + dockerExecIDEnv + `=$0" "$p/environ" 2>/dev/null && kill -KILL "${p#/proc/}"; done; exit 0`
+
+	// dockerKillExecTimeout bounds the cleanup after a cancelled exec.
+	dockerKillExecTimeout = 10 * time.Second
+)
+
+func newDockerVMLauncher() (VMLauncher, error) {
+	launcher := &dockerVMLauncher{
+		binary: getenvOrDefault("AGENT_DOCKER_BIN", dockerBinaryName),
+	}
+	if err := requireRuntimeBinary(vmRuntimeDocker, launcher.binary); err != nil {
+		return nil, err
+	}
+	return launcher, nil
+}
+
+// dockerVMLauncher backs VMs with plain containers for hosts without nested
+// virtualization, such as Linux CI runners. Each VM is a long-lived container
+// idling on `sleep infinity`; commands run through `docker exec`.
+type dockerVMLauncher struct {
+	binary string
+}
+
+// Launch creates the VM's container. Stop keeps the container, so a VM
+// launched again after a stop or a resize already has one; it is reused with
+// the record's current sizing applied through `docker update`.
+func (l *dockerVMLauncher) Launch(ctx context.Context, record VMRecord) error {
+	exists, err := l.containerExists(ctx, record.ID)
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = l.runCommand(ctx, l.updateArgs(record), nil, nil, nil)
+		return err
+	}
+	_, err = l.runCommand(ctx, l.createArgs(record), nil, nil, nil)
+	return err
+}
+
+func (l *dockerVMLauncher) containerExists(ctx context.Context, vmID string) (bool, error) {
+	_, err := l.runCommand(ctx, []string{"container", "inspect", "--format", "{{.Id}}", vmID}, nil, io.Discard, nil)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(dockerNotFound(err), errVMNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// updateArgs builds the `docker update` arguments that resize an existing
+// container to the record's CPU and memory.
+func (l *dockerVMLauncher) updateArgs(record VMRecord) []string {
+	args := append([]string{"update"}, dockerResourceArgs(record.CPUCount, record.MemoryMiB)...)
+	return append(args, record.ID)
+}
+
+// createArgs builds the `docker create` arguments for a record.
+func (l *dockerVMLauncher) createArgs(record VMRecord) []string {
+	args := []string{
+		"create",
+		"--name", record.ID,
+		"--label", dockerVMLabel + "=" + record.ID,
+	}
+	args = append(args, dockerResourceArgs(record.CPUCount, record.MemoryMiB)...)
+
+	if record.NetworkMode == "" || record.NetworkMode == "none" {
+		args = append(args, "--network", "none")
+	} else {
+		for _, port := range record.Ports {
+			args = append(args, "--publish", port.String())
+		}
+	}
+
+	if !record.Storage.DisableGuestVolumes && strings.TrimSpace(record.Storage.Root) != "" {
+		volumes := []string{
+			formatVolume(record.Storage.InputPath, record.Storage.guestIn()),
+			formatVolume(record.Storage.OutputPath, record.Storage.guestOut()),
+		}
+		if record.Storage.PersistPath != "" {
+			volumes = append(volumes, formatVolume(record.Storage.PersistPath, record.Storage.guestPersist()))
+		}
+		for _, mount := range record.Storage.Volumes {
+			volumes = append(volumes, formatVolume(mount.HostPath, mount.GuestPath))
+		}
+
+		for _, volume := range volumes {
+			if volume == "" {
+				continue
+			}
+			args = append(args, "--volume", volume)
+		}
+	}
+
+	if record.Storage.GuestWorkdir != "" {
+		args = append(args, "--workdir", record.Storage.GuestWorkdir)
+	}
+
+	args = append(args, "--entrypoint", "sleep", record.RootFSImage, "infinity")
+
+	return args
+}
+
+// dockerResourceArgs turns a VM's CPU and memory into cgroup limits for
+// `docker create`. Containers run on the host kernel, so unlike a microVM
+// nothing else bounds them: swap is disabled so the memory limit is hard,
+// and the process count is capped. Zero CPU or memory leaves that unlimited.
+func dockerResourceArgs(cpuCount, memoryMiB int) []string {
+	var args []string
+	if cpuCount > 0 {
+		args = append(args, "--cpus", strconv.Itoa(cpuCount))
+	}
+	if memoryMiB > 0 {
+		memory := strconv.Itoa(memoryMiB) + "m"
+		args = append(args, "--memory", memory, "--memory-swap", memory)
+	}
+	pids := defaultDockerPidsLimit
+	if limit := positiveEnvInt("AGENT_DOCKER_PIDS_LIMIT"); limit > 0 {
+		pids = limit
+	}
+	return append(args, "--pids-limit", strconv.Itoa(pids))
+}
+
+func (l *dockerVMLauncher) Stop(ctx context.Context, vmID string) error {
+	if strings.TrimSpace(vmID) == "" {
+		return errVMNotFound
+	}
+	_, err := l.runCommand(ctx, []string{"stop", vmID}, nil, nil, nil)
+	return dockerNotFound(err)
+}
+
+func (l *dockerVMLauncher) Cleanup(ctx context.Context, vmID string) error {
+	if strings.TrimSpace(vmID) == "" {
+		return nil
+	}
+	_, err := l.runCommand(ctx, []string{"rm", "--force", vmID}, nil, nil, nil)
+	if err := dockerNotFound(err); err != nil && !errors.Is(err, errVMNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (l *dockerVMLauncher) Run(ctx context.Context, record VMRecord, opts VMRunOptions, stdout io.Writer, stderr io.Writer) (int, error) {
+	if err := l.ensureStarted(ctx, record.ID); err != nil {
+		return -1, err
+	}
+
+	var stdin io.Reader
+	if opts.Stdin != "" {
+		stdin = strings.NewReader(opts.Stdin)
+	}
+
+	execID := newRequestID()
+	return l.runExec(ctx, record.ID, execID, l.execArgs(record, opts, execID), stdin, stdout, stderr)
+}
+
+// execArgs builds the `docker exec` arguments that run opts.Command, tagged
+// with execID (see dockerExecIDEnv).
+func (l *dockerVMLauncher) execArgs(record VMRecord, opts VMRunOptions, execID string) []string {
+	args := []string{"exec"}
+	if opts.Stdin != "" {
+		args = append(args, "--interactive")
+	}
+	args = append(args, "--env", dockerExecIDEnv+"="+execID)
+	return append(args, record.ID, "/bin/sh", "-c", dockerExecShell, guestCommandScript(opts.Command))
+}
+
+// runExec runs a `docker exec`. When ctx ends first, for a timeout, an
+// abort or a drain, the exec's processes are killed inside the container
+// too, since cancelling only stops the local docker CLI.
+func (l *dockerVMLauncher) runExec(ctx context.Context, vmID, execID string, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	done := make(chan struct{})
+	killed := make(chan struct{})
+	go func() {
+		defer close(killed)
+		select {
+		case <-done:
+		case <-ctx.Done():
+			killCtx, cancel := context.WithTimeout(context.Background(), dockerKillExecTimeout)
+			defer cancel()
+			_, _ = l.runCommand(killCtx, []string{"exec", vmID, "/bin/sh", "-c", dockerKillExecScript, execID}, nil, io.Discard, io.Discard)
+		}
+	}()
+
+	code, err := l.runCommand(ctx, args, stdin, stdout, stderr)
+	close(done)
+	<-killed
+	return code, err
+}
+
+func (l *dockerVMLauncher) Shell(ctx context.Context, record VMRecord, shellCmd string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	execID := newRequestID()
+	args, err := l.shellArgs(record, shellCmd, isTerminal(stdin), execID)
+	if err != nil {
+		return -1, err
+	}
+	if err := l.ensureStarted(ctx, record.ID); err != nil {
+		return -1, err
+	}
+
+	return l.runExec(ctx, record.ID, execID, args, stdin, stdout, stderr)
+}
+
+// shellArgs builds the `docker exec -it` arguments for an interactive shell.
+// A TTY is only requested when stdin is one; docker refuses `-t` otherwise,
+// e.g. for WebSocket sessions.
+func (l *dockerVMLauncher) shellArgs(record VMRecord, shellCmd string, tty bool, execID string) ([]string, error) {
+	parts := strings.Fields(shellCmd)
+	if len(parts) == 0 {
+		return nil, errors.New("shell command cannot be empty")
+	}
+
+	args := []string{"exec", "--interactive"}
+	if tty {
+		args = append(args, "--tty")
+	}
+	args = append(args, "--env", dockerExecIDEnv+"="+execID, record.ID)
+	return append(args, parts...), nil
+}
+
+func (l *dockerVMLauncher) List(ctx context.Context) ([]string, error) {
+	var stdoutBuf bytes.Buffer
+	if _, err := l.runCommand(ctx, l.listArgs(), nil, &stdoutBuf, nil); err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(&stdoutBuf)
+	names := make([]string, 0)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+func (l *dockerVMLauncher) listArgs() []string {
+	return []string{"ps", "--all", "--filter", "label=" + dockerVMLabel, "--format", "{{.Names}}"}
+}
+
+// Stats reports resource usage of the container's init process. Container
+// processes are only visible from the host on Linux.
+func (l *dockerVMLauncher) Stats(ctx context.Context, record VMRecord) (VMStats, error) {
+	var stdoutBuf bytes.Buffer
+	args := []string{"inspect", "--format", "{{.State.Running}} {{.State.Pid}}", record.ID}
+	if _, err := l.runCommand(ctx, args, nil, &stdoutBuf, nil); err != nil {
+		if errors.Is(dockerNotFound(err), errVMNotFound) {
+			return VMStats{}, errVMNotRunning
+		}
+		return VMStats{}, err
+	}
+
+	fields := strings.Fields(stdoutBuf.String())
+	if len(fields) != 2 || fields[0] != "true" {
+		return VMStats{}, errVMNotRunning
+	}
+	pid, err := strconv.Atoi(fields[1])
+	if err != nil || pid <= 0 {
+		return VMStats{}, errVMNotRunning
+	}
+	if runtime.GOOS != "linux" {
+		return VMStats{}, errors.New("docker runtime stats are only available on linux hosts")
+	}
+	return readProcStats(pid)
+}
+
+// InspectImage checks the image reference in its registry with skopeo, as the
+// krunvm launcher does.
+func (l *dockerVMLauncher) InspectImage(ctx context.Context, ref string) (ImageInfo, error) {
+	return inspectImageWithSkopeo(ctx, os.Environ(), ref)
+}
+
+func (l *dockerVMLauncher) ImageCached(ctx context.Context, ref string) (bool, error) {
+	_, err := l.runCommand(ctx, []string{"image", "inspect", "--format", "{{.Id}}", ref}, nil, io.Discard, nil)
+	if err != nil {
+		var cmdErr *commandError
+		if errors.As(err, &cmdErr) && strings.Contains(strings.ToLower(cmdErr.stderr), "no such image") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *dockerVMLauncher) PullImage(ctx context.Context, ref string) error {
+	_, err := l.runCommand(ctx, []string{"pull", "--quiet", ref}, nil, nil, nil)
+	return err
+}
+
+// ensureStarted starts the container; `docker start` is a no-op for one that
+// is already running.
+func (l *dockerVMLauncher) ensureStarted(ctx context.Context, vmID string) error {
+	_, err := l.runCommand(ctx, []string{"start", vmID}, nil, nil, nil)
+	return err
+}
+
+// runCommand runs docker with args. A nil stdout or stderr is captured and
+// reported in the returned commandError on failure.
+func (l *dockerVMLauncher) runCommand(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.CommandContext(ctx, l.binary, args...)
+	cmd.Stdin = stdin
+	cmd.WaitDelay = commandWaitDelay
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	if stdout != nil {
+		cmd.Stdout = stdout
+	} else {
+		cmd.Stdout = &stdoutBuf
+	}
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, &stderrBuf)
+	} else {
+		cmd.Stderr = &stderrBuf
+	}
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return -1, fmt.Errorf("docker is required for the docker runtime: %w", err)
+		}
+		return exitErr.ExitCode(), &commandError{
+			args:   append([]string{l.binary}, args...),
+			err:    err,
+			stdout: stdoutBuf.String(),
+			stderr: stderrBuf.String(),
+		}
+	}
+	return 0, nil
+}
+
+// dockerNotFound maps docker's missing-container error to errVMNotFound.
+func dockerNotFound(err error) error {
+	var cmdErr *commandError
+	if errors.As(err, &cmdErr) && isMissingVMError(cmdErr) {
+		return errVMNotFound
+	}
+	return err
+}
+
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// TestDockerMemoryLimitEnforced runs a command that allocates without bound
+// in a 64 MiB container and expects the kernel to kill it.
+func TestDockerMemoryLimitEnforced(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping docker test")
+	}
+	if _, err := exec.LookPath(dockerBinaryName); err != nil {
+		t.Skip("docker not available")
+	}
+	if err := exec.Command(dockerBinaryName, "info").Run(); err != nil {
+		t.Skip("docker daemon not reachable")
+	}
+	image := getenvOrDefault("AGENT_DOCKER_TEST_IMAGE", "debian:bookworm-slim")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	launcher := &dockerVMLauncher{binary: dockerBinaryName}
+	record := VMRecord{
+		ID:          fmt.Sprintf("era-limit-test-%d", os.Getpid()),
+		RootFSImage: image,
+		CPUCount:    1,
+		MemoryMiB:   64,
+		NetworkMode: "none",
+		Storage:     StorageLayout{DisableGuestVolumes: true},
+	}
+	if err := launcher.Launch(ctx, record); err != nil {
+		t.Skipf("could not create a container from %s: %v", image, err)
+	}
+	defer launcher.Cleanup(context.Background(), record.ID)
+
+	// tail keeps the whole "line" of an endless newline-free stream in memory.
+	var stderr bytes.Buffer
+	exitCode, err := launcher.Run(ctx, record, VMRunOptions{Command: "tail /dev/zero"}, &bytes.Buffer{}, &stderr)
+	if ctx.Err() != nil {
+		t.Fatal("memory-heavy command was not stopped by the container's limit")
+	}
+	// 137 is SIGKILL from the OOM killer.
+	if exitCode != 137 {
+		t.Fatalf("exit code = %d (err %v, stderr %q), want 137 from the OOM killer", exitCode, err, stderr.String())
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDockerCreateArgs(t *testing.T) {
+	launcher := &dockerVMLauncher{binary: dockerBinaryName}
+	record := VMRecord{
+		ID:          "python-1",
+		RootFSImage: "docker.io/library/python:3.11-slim",
+		CPUCount:    2,
+		MemoryMiB:   512,
+		NetworkMode: "allow_all",
+		Ports:       []PortMapping{{HostPort: 8080, GuestPort: 80}},
+		Storage: StorageLayout{
+			Root:        "/state/vms/python-1",
+			InputPath:   "/state/vms/python-1/in",
+			OutputPath:  "/state/vms/python-1/out",
+			PersistPath: "/state/vms/python-1/persist",
+			Volumes:     []VolumeMount{{Name: "cache", GuestPath: "/cache", HostPath: "/state/volumes/cache"}},
+		},
+	}
+
+	want := []string{
+		"create",
+		"--name", "python-1",
+		"--label", "era.agent.vm=python-1",
+		"--cpus", "2",
+		"--memory", "512m",
+		"--memory-swap", "512m",
+		"--pids-limit", "1024",
+		"--publish", "8080:80",
+		"--volume", "/state/vms/python-1/in:/in",
+		"--volume", "/state/vms/python-1/out:/out",
+		"--volume", "/state/vms/python-1/persist:/persist",
+		"--volume", "/state/volumes/cache:/cache",
+		"--entrypoint", "sleep", "docker.io/library/python:3.11-slim", "infinity",
+	}
+	if got := launcher.createArgs(record); !reflect.DeepEqual(got, want) {
+		t.Fatalf("createArgs =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestDockerResourceArgs(t *testing.T) {
+	for _, tc := range []struct {
+		cpu, mem int
+		pids     string
+		want     []string
+	}{
+		{cpu: 1, mem: 256, want: []string{"--cpus", "1", "--memory", "256m", "--memory-swap", "256m", "--pids-limit", "1024"}},
+		{cpu: 0, mem: 0, want: []string{"--pids-limit", "1024"}},
+		{cpu: 4, mem: 2048, pids: "64", want: []string{"--cpus", "4", "--memory", "2048m", "--memory-swap", "2048m", "--pids-limit", "64"}},
+		{cpu: 1, mem: 128, pids: "bogus", want: []string{"--cpus", "1", "--memory", "128m", "--memory-swap", "128m", "--pids-limit", "1024"}},
+	} {
+		t.Setenv("AGENT_DOCKER_PIDS_LIMIT", tc.pids)
+		if got := dockerResourceArgs(tc.cpu, tc.mem); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("dockerResourceArgs(%d, %d) with pids %q = %q, want %q", tc.cpu, tc.mem, tc.pids, got, tc.want)
+		}
+	}
+}
+
+func TestDockerCreateArgsIsolatedWithoutVolumes(t *testing.T) {
+	launcher := &dockerVMLauncher{binary: dockerBinaryName}
+	record := VMRecord{
+		ID:          "node-1",
+		RootFSImage: "node:20-slim",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Ports:       []PortMapping{{HostPort: 3000, GuestPort: 3000}},
+		Storage: StorageLayout{
+			Root:                "/state/vms/node-1",
+			InputPath:           "/state/vms/node-1/in",
+			OutputPath:          "/state/vms/node-1/out",
+			DisableGuestVolumes: true,
+		},
+	}
+
+	args := strings.Join(launcher.createArgs(record), " ")
+	if !strings.Contains(args, "--network none") {
+		t.Fatalf("expected --network none in %q", args)
+	}
+	for _, unwanted := range []string{"--publish", "--volume"} {
+		if strings.Contains(args, unwanted) {
+			t.Fatalf("did not expect %s in %q", unwanted, args)
+		}
+	}
+}
+
+func TestDockerExecAndShellArgs(t *testing.T) {
+	launcher := &dockerVMLauncher{binary: dockerBinaryName}
+	record := VMRecord{ID: "python-1"}
+
+	got := launcher.execArgs(record, VMRunOptions{Command: "python main.py"}, "x1")
+	want := []string{"exec", "--env", "ERA_EXEC_ID=x1", "python-1", "/bin/sh", "-c", dockerExecShell, guestCommandScript("python main.py")}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("execArgs = %q, want %q", got, want)
+	}
+
+	got = launcher.execArgs(record, VMRunOptions{Command: "cat", Stdin: "data"}, "x1")
+	if got[1] != "--interactive" {
+		t.Fatalf("execArgs with stdin = %q, want --interactive", got)
+	}
+
+	got, err := launcher.shellArgs(record, "/bin/bash -l", true, "x2")
+	if err != nil {
+		t.Fatalf("shellArgs: %v", err)
+	}
+	if want := []string{"exec", "--interactive", "--tty", "--env", "ERA_EXEC_ID=x2", "python-1", "/bin/bash", "-l"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("shellArgs = %q, want %q", got, want)
+	}
+
+	got, err = launcher.shellArgs(record, "/bin/sh", false, "x2")
+	if err != nil {
+		t.Fatalf("shellArgs: %v", err)
+	}
+	if want := []string{"exec", "--interactive", "--env", "ERA_EXEC_ID=x2", "python-1", "/bin/sh"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("shellArgs without tty = %q, want %q", got, want)
+	}
+
+	if _, err := launcher.shellArgs(record, "  ", true, "x2"); err == nil {
+		t.Fatal("expected error for empty shell command")
+	}
+}
+
+func TestDockerMissingContainerMapsToNotFound(t *testing.T) {
+	err := &commandError{
+		args:   []string{"docker", "stop", "gone"},
+		stderr: "Error response from daemon: No such container: gone",
+	}
+	if got := dockerNotFound(err); got != errVMNotFound {
+		t.Fatalf("dockerNotFound = %v, want errVMNotFound", got)
+	}
+	if !strings.HasPrefix(err.Error(), "docker command failed: ") {
+		t.Fatalf("commandError should name the docker binary, got %q", err.Error())
+	}
+}
+
+// fakeDockerScript stands in for the docker CLI, keeping one file per
+// container under $FAKE_DOCKER_STATE and logging every invocation. Like
+// docker, create refuses a name that is taken and exec needs a started
+// container; exec runs the command on the host, in the background so that
+// killing the fake, like killing the docker CLI, leaves it running.
+const fakeDockerScript = `#!/bin/sh
+state="$FAKE_DOCKER_STATE"
+echo "$*" >> "$state/log"
+for name; do :; done
+case "$1" in
+create)
+	name=$3
+	if [ -e "$state/c-$name" ]; then
+		echo "Error response from daemon: Conflict. The container name \"/$name\" is already in use" >&2
+		exit 125
+	fi
+	: > "$state/c-$name" ;;
+container|update|start|stop)
+	if [ ! -e "$state/c-$name" ]; then
+		echo "Error response from daemon: No such container: $name" >&2
+		exit 1
+	fi
+	case "$1" in
+	start) : > "$state/r-$name" ;;
+	stop) rm -f "$state/r-$name" ;;
+	esac ;;
+rm)
+	rm -f "$state/c-$name" "$state/r-$name" ;;
+exec)
+	shift
+	while [ "$1" != "/bin/sh" ]; do
+		case "$1" in
+		--env) export "$2"; shift ;;
+		--*) ;;
+		*) container=$1 ;;
+		esac
+		shift
+	done
+	if [ ! -e "$state/r-$container" ]; then
+		echo "Error response from daemon: container $container is not running" >&2
+		exit 1
+	fi
+	exec 3<&0
+	"$@" <&3 &
+	wait $! ;;
+esac
+`
+
+func newFakeDockerLauncher(t *testing.T) (*dockerVMLauncher, string) {
+	t.Helper()
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "docker")
+	if err := os.WriteFile(binary, []byte(fakeDockerScript), 0o755); err != nil {
+		t.Fatalf("write fake docker: %v", err)
+	}
+	t.Setenv("FAKE_DOCKER_STATE", dir)
+	return &dockerVMLauncher{binary: binary}, filepath.Join(dir, "log")
+}
+
+func TestDockerResizeThenRun(t *testing.T) {
+	launcher, logPath := newFakeDockerLauncher(t)
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	ctx := context.Background()
+
+	run := func(stage string) {
+		t.Helper()
+		output, err := svc.Exec(ctx, VMRunOptions{VMID: record.ID, Command: "echo ok", Timeout: 5})
+		if err != nil || output.Stdout != "ok\n" {
+			t.Fatalf("run %s: %+v, %v", stage, output, err)
+		}
+	}
+	run("before resize")
+
+	if err := svc.UpdateResources(ctx, record.ID, 2, 1024); err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	if got, _ := svc.Get(record.ID); got.Status != VMStatusReady || got.CPUCount != 2 || got.MemoryMiB != 1024 {
+		t.Fatalf("resized vm = %+v, want ready with 2 cpu / 1024 MiB", got)
+	}
+	run("after resize")
+
+	// A plain stop also keeps the container; the next run relaunches it.
+	if err := svc.Stop(ctx, record.ID); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	run("after stop")
+
+	log, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("read docker log: %v", err)
+	}
+	if want := "update --cpus 2 --memory 1024m --memory-swap 1024m --pids-limit 1024 " + record.ID; !strings.Contains(string(log), want) {
+		t.Fatalf("docker calls:\n%s\nwant %q", log, want)
+	}
+	if creates := strings.Count(string(log), "create --name"); creates != 1 {
+		t.Fatalf("docker create ran %d times, want the container reused:\n%s", creates, log)
+	}
+}
+
+func TestDockerTimeoutKillsProcessInContainer(t *testing.T) {
+	launcher, logPath := newFakeDockerLauncher(t)
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	marker := filepath.Join(filepath.Dir(logPath), "survived")
+	output, _ := svc.Exec(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "sleep 2; touch " + shellQuote(marker),
+		Timeout: 1,
+	})
+	if !output.TimedOut {
+		t.Fatalf("run = %+v, want timed out", output)
+	}
+
+	time.Sleep(2500 * time.Millisecond)
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("the command kept running in the container after its timeout")
+	}
+}
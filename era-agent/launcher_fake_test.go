@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeLauncher is an in-memory VMLauncher for unit tests. Commands and shells
+// run directly on the host through /bin/sh so tests can exercise real process
+// I/O without a hypervisor. Individual operations can be overridden.
+type fakeLauncher struct {
+	mu  sync.Mutex
+	vms map[string]VMRecord
+
+	launchCalls int
+	pulls       []string
+	cached      map[string]bool
+
+	launchFn func(context.Context, VMRecord) error
+	runFn    func(context.Context, VMRecord, VMRunOptions, io.Writer, io.Writer) (int, error)
+	listFn   func(context.Context) ([]string, error)
+	statsFn  func(context.Context, VMRecord) (VMStats, error)
+	imageFn  func(context.Context, string) (ImageInfo, error)
+}
+
+func newFakeLauncher() *fakeLauncher {
+	return &fakeLauncher{vms: make(map[string]VMRecord)}
+}
+
+func (f *fakeLauncher) Launch(ctx context.Context, record VMRecord) error {
+	f.mu.Lock()
+	f.launchCalls++
+	launchFn := f.launchFn
+	f.mu.Unlock()
+
+	if launchFn != nil {
+		if err := launchFn(ctx, record); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	f.vms[record.ID] = record
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeLauncher) Stop(ctx context.Context, vmID string) error {
+	return f.Cleanup(ctx, vmID)
+}
+
+func (f *fakeLauncher) Cleanup(ctx context.Context, vmID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.vms[vmID]; !ok {
+		return errVMNotFound
+	}
+	delete(f.vms, vmID)
+	return nil
+}
+
+func (f *fakeLauncher) Run(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+	if f.runFn != nil {
+		return f.runFn(ctx, record, opts, stdout, stderr)
+	}
+	var stdin io.Reader
+	if opts.Stdin != "" {
+		stdin = strings.NewReader(opts.Stdin)
+	}
+	return runHostCommand(ctx, exec.CommandContext(ctx, "/bin/sh", "-c", opts.Command), stdin, stdout, stderr)
+}
+
+func (f *fakeLauncher) Shell(ctx context.Context, record VMRecord, shellCmd string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	parts := strings.Fields(shellCmd)
+	if len(parts) == 0 {
+		return -1, errors.New("shell command cannot be empty")
+	}
+	return runHostCommand(ctx, exec.CommandContext(ctx, parts[0], parts[1:]...), stdin, stdout, stderr)
+}
+
+func (f *fakeLauncher) List(ctx context.Context) ([]string, error) {
+	if f.listFn != nil {
+		return f.listFn(ctx)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.vms))
+	for id := range f.vms {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (f *fakeLauncher) Stats(ctx context.Context, record VMRecord) (VMStats, error) {
+	if f.statsFn != nil {
+		return f.statsFn(ctx, record)
+	}
+	return VMStats{}, errVMNotRunning
+}
+
+func (f *fakeLauncher) InspectImage(ctx context.Context, ref string) (ImageInfo, error) {
+	if f.imageFn != nil {
+		return f.imageFn(ctx, ref)
+	}
+	return ImageInfo{Ref: ref}, nil
+}
+
+func (f *fakeLauncher) ImageCached(ctx context.Context, ref string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cached[ref], nil
+}
+
+func (f *fakeLauncher) PullImage(ctx context.Context, ref string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pulls = append(f.pulls, ref)
+	if f.cached == nil {
+		f.cached = make(map[string]bool)
+	}
+	f.cached[ref] = true
+	return nil
+}
+
+func runHostCommand(ctx context.Context, cmd *exec.Cmd, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = commandWaitDelay
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), &commandError{args: cmd.Args, err: err}
+		}
+		return -1, err
+	}
+	return 0, nil
+}
+
+// newTestVMService builds a VMService backed by the given launcher and a Bolt
+// store inside a per-test state directory.
+func newTestVMService(t *testing.T, launcher VMLauncher) *VMService {
+	t.Helper()
+
+	t.Setenv("AGENT_STATE_DIR", t.TempDir())
+	stateRootOnce = sync.Once{}
+	t.Cleanup(func() { stateRootOnce = sync.Once{} })
+
+	logger, err := NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	store, err := NewBoltVMStore(stateRoot())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+
+	svc := &VMService{
+		logger:   logger,
+		launcher: launcher,
+		store:    store,
+		cache:    make(map[string]VMRecord),
+	}
+	svc.metrics = newVMMetrics(svc)
+	t.Cleanup(func() { _ = svc.Close() })
+	return svc
+}
+
+// createTestVM creates a python VM with default sizing through the service.
+func createTestVM(t *testing.T, svc *VMService) VMRecord {
+	t.Helper()
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+	})
+	if err != nil {
+		t.Fatalf("failed to create vm: %v", err)
+	}
+	return record
+}
@@ -0,0 +1,424 @@
+//go:build firecracker
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	firecrackerBinaryName = "firecracker"
+	firecrackerDirName    = "firecracker"
+	firecrackerConfigName = "vm.json"
+	firecrackerRootFSName = "rootfs.ext4"
+
+	// firecrackerExitMarker prefixes the line the guest init prints on the
+	// serial console with the command's exit status before rebooting.
+	firecrackerExitMarker = "__ERA_EXIT__="
+
+	// firecrackerMaxBootArgs mirrors the x86 kernel COMMAND_LINE_SIZE.
+	firecrackerMaxBootArgs = 2048
+)
+
+func newFirecrackerVMLauncher() (VMLauncher, error) {
+	launcher := &firecrackerVMLauncher{
+		binary: getenvOrDefault("AGENT_FIRECRACKER_BIN", firecrackerBinaryName),
+		kernel: strings.TrimSpace(os.Getenv("AGENT_FIRECRACKER_KERNEL")),
+	}
+
+	if launcher.kernel == "" {
+		return nil, errors.New("firecracker runtime requires AGENT_FIRECRACKER_KERNEL to point at an uncompressed guest kernel")
+	}
+	if _, err := os.Stat(launcher.kernel); err != nil {
+		return nil, fmt.Errorf("firecracker kernel not readable: %w", err)
+	}
+	if err := requireRuntimeBinary(vmRuntimeFirecracker, launcher.binary); err != nil {
+		return nil, err
+	}
+
+	return launcher, nil
+}
+
+// firecrackerVMLauncher boots one microVM per command with
+// `firecracker --no-api --config-file`, matching krunvm's model where
+// `krunvm start` boots the VM, runs a command and exits. Launch prepares a
+// private copy of the ext4 rootfs; the guest command is handed to the guest
+// init on the kernel command line and its exit status is read back from the
+// serial console.
+type firecrackerVMLauncher struct {
+	binary string
+	kernel string
+}
+
+// firecrackerConfig is the subset of Firecracker's --config-file schema the
+// launcher writes.
+type firecrackerConfig struct {
+	BootSource    firecrackerBootSource    `json:"boot-source"`
+	Drives        []firecrackerDrive       `json:"drives"`
+	MachineConfig firecrackerMachineConfig `json:"machine-config"`
+}
+
+type firecrackerBootSource struct {
+	KernelImagePath string `json:"kernel_image_path"`
+	BootArgs        string `json:"boot_args"`
+}
+
+type firecrackerDrive struct {
+	DriveID      string `json:"drive_id"`
+	PathOnHost   string `json:"path_on_host"`
+	IsRootDevice bool   `json:"is_root_device"`
+	IsReadOnly   bool   `json:"is_read_only"`
+}
+
+type firecrackerMachineConfig struct {
+	VCPUCount  int `json:"vcpu_count"`
+	MemSizeMiB int `json:"mem_size_mib"`
+}
+
+func (l *firecrackerVMLauncher) Launch(ctx context.Context, record VMRecord) error {
+	if record.NetworkMode != "" && record.NetworkMode != "none" {
+		return fmt.Errorf("firecracker runtime does not support network mode %q", record.NetworkMode)
+	}
+	if !record.Storage.DisableGuestVolumes && strings.TrimSpace(record.Storage.Root) != "" {
+		return errors.New("firecracker runtime cannot share host directories with the guest; unset AGENT_ENABLE_GUEST_VOLUMES")
+	}
+
+	vmDir := l.vmDir(record.ID)
+	if err := ensureDir(vmDir); err != nil {
+		return err
+	}
+	if err := copyFile(record.RootFSImage, filepath.Join(vmDir, firecrackerRootFSName)); err != nil {
+		_ = os.RemoveAll(vmDir)
+		return fmt.Errorf("prepare firecracker rootfs from %s: %w", record.RootFSImage, err)
+	}
+	return nil
+}
+
+// Stop removes the VM's private rootfs, as `krunvm delete` does.
+func (l *firecrackerVMLauncher) Stop(ctx context.Context, vmID string) error {
+	return l.deleteVM(vmID)
+}
+
+func (l *firecrackerVMLauncher) Cleanup(ctx context.Context, vmID string) error {
+	if err := l.deleteVM(vmID); err != nil && !errors.Is(err, errVMNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (l *firecrackerVMLauncher) Run(ctx context.Context, record VMRecord, opts VMRunOptions, stdout io.Writer, stderr io.Writer) (int, error) {
+	var stdin io.Reader
+	if opts.Stdin != "" {
+		stdin = strings.NewReader(opts.Stdin)
+	}
+	return l.boot(ctx, record, opts.Command, stdin, stdout, stderr)
+}
+
+// Shell boots the VM with shellCmd as the guest program. Firecracker wires
+// its own stdin/stdout to the guest serial console, which is what the caller
+// interacts with.
+func (l *firecrackerVMLauncher) Shell(ctx context.Context, record VMRecord, shellCmd string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	if len(strings.Fields(shellCmd)) == 0 {
+		return -1, errors.New("shell command cannot be empty")
+	}
+	return l.boot(ctx, record, shellCmd, stdin, stdout, stderr)
+}
+
+func (l *firecrackerVMLauncher) List(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(l.dataDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(l.dataDir(), entry.Name(), firecrackerRootFSName)); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Stats reports resource usage of the firecracker process backing the VM,
+// which only exists while a command is executing.
+func (l *firecrackerVMLauncher) Stats(ctx context.Context, record VMRecord) (VMStats, error) {
+	configPath := filepath.Join(l.vmDir(record.ID), firecrackerConfigName)
+	return findProcessStats(ctx, func(args []string) bool {
+		if len(args) == 0 || filepath.Base(args[0]) != filepath.Base(l.binary) {
+			return false
+		}
+		for _, arg := range args[1:] {
+			if arg == configPath {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// InspectImage describes a local rootfs image; firecracker boots ext4 files
+// rather than registry references.
+func (l *firecrackerVMLauncher) InspectImage(ctx context.Context, ref string) (ImageInfo, error) {
+	info, err := os.Stat(ref)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ImageInfo{}, fmt.Errorf("%w: %s", errImageNotFound, ref)
+		}
+		return ImageInfo{}, err
+	}
+	return ImageInfo{Ref: ref, SizeBytes: info.Size()}, nil
+}
+
+func (l *firecrackerVMLauncher) ImageCached(ctx context.Context, ref string) (bool, error) {
+	if _, err := os.Stat(ref); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *firecrackerVMLauncher) PullImage(ctx context.Context, ref string) error {
+	return errors.New("firecracker runtime cannot pull images; provide a local ext4 rootfs")
+}
+
+// boot writes the VM config for command and runs firecracker in the
+// foreground until the guest reboots.
+func (l *firecrackerVMLauncher) boot(ctx context.Context, record VMRecord, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	vmDir := l.vmDir(record.ID)
+	rootfs := filepath.Join(vmDir, firecrackerRootFSName)
+	if _, err := os.Stat(rootfs); err != nil {
+		// Report the same message as krunvm so the service recreates the VM.
+		return -1, &commandError{
+			args:   []string{l.binary},
+			err:    err,
+			stderr: "no vm found: " + record.ID,
+		}
+	}
+
+	config, err := l.config(record, rootfs, command)
+	if err != nil {
+		return -1, err
+	}
+	configPath := filepath.Join(vmDir, firecrackerConfigName)
+	if err := writeJSONFile(configPath, config); err != nil {
+		return -1, err
+	}
+
+	args := []string{"--no-api", "--config-file", configPath}
+	cmd := exec.CommandContext(ctx, l.binary, args...)
+	cmd.Stdin = stdin
+	cmd.WaitDelay = commandWaitDelay
+
+	console := newExitMarkerWriter(stdout)
+	cmd.Stdout = console
+	var stderrBuf bytes.Buffer
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, &stderrBuf)
+	} else {
+		cmd.Stderr = &stderrBuf
+	}
+
+	runErr := cmd.Run()
+	if err := console.flush(); err != nil && runErr == nil {
+		runErr = err
+	}
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(runErr, &exitErr) {
+			return -1, runErr
+		}
+		return exitErr.ExitCode(), &commandError{
+			args:   append([]string{l.binary}, args...),
+			err:    runErr,
+			stderr: stderrBuf.String(),
+		}
+	}
+
+	exitCode, ok := console.exitCode()
+	if !ok {
+		return -1, &commandError{
+			args:   append([]string{l.binary}, args...),
+			err:    errors.New("guest exited without reporting a status"),
+			stderr: stderrBuf.String(),
+		}
+	}
+	if exitCode != 0 {
+		return exitCode, &commandError{
+			args:   append([]string{l.binary}, args...),
+			err:    fmt.Errorf("guest command exited with status %d", exitCode),
+			stderr: stderrBuf.String(),
+		}
+	}
+	return 0, nil
+}
+
+func (l *firecrackerVMLauncher) config(record VMRecord, rootfs, command string) (firecrackerConfig, error) {
+	bootArgs := firecrackerBootArgs(command)
+	if len(bootArgs) > firecrackerMaxBootArgs {
+		return firecrackerConfig{}, fmt.Errorf("command too long for the firecracker kernel command line (%d bytes, max %d)", len(bootArgs), firecrackerMaxBootArgs)
+	}
+
+	return firecrackerConfig{
+		BootSource: firecrackerBootSource{
+			KernelImagePath: l.kernel,
+			BootArgs:        bootArgs,
+		},
+		Drives: []firecrackerDrive{{
+			DriveID:      "rootfs",
+			PathOnHost:   rootfs,
+			IsRootDevice: true,
+		}},
+		MachineConfig: firecrackerMachineConfig{
+			VCPUCount:  record.CPUCount,
+			MemSizeMiB: record.MemoryMiB,
+		},
+	}, nil
+}
+
+// firecrackerBootArgs runs command as the guest's init via bash. The kernel
+// hands unknown key=value parameters to init as environment variables, so the
+// command travels base64 encoded in ERA_CMD and the init script itself avoids
+// double quotes, which the kernel would treat as argument delimiters.
+func firecrackerBootArgs(command string) string {
+	encoded := base64.StdEncoding.EncodeToString([]byte(command))
+	script := "echo $ERA_CMD | base64 -d > /tmp/era-cmd; bash /tmp/era-cmd; echo " +
+		firecrackerExitMarker + "$?; reboot -f"
+	return fmt.Sprintf(`console=ttyS0 reboot=k panic=1 pci=off quiet loglevel=0 rw init=/bin/bash ERA_CMD=%s -- -c "%s"`, encoded, script)
+}
+
+func (l *firecrackerVMLauncher) deleteVM(vmID string) error {
+	if strings.TrimSpace(vmID) == "" {
+		return errVMNotFound
+	}
+	vmDir := l.vmDir(vmID)
+	if _, err := os.Stat(vmDir); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return errVMNotFound
+		}
+		return err
+	}
+	return os.RemoveAll(vmDir)
+}
+
+func (l *firecrackerVMLauncher) vmDir(vmID string) string {
+	return filepath.Join(l.dataDir(), vmID)
+}
+
+func (l *firecrackerVMLauncher) dataDir() string {
+	return filepath.Join(stateRoot(), firecrackerDirName)
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// exitMarkerWriter streams guest console output to w while holding back any
+// line that may be the exit marker. Once the marker is seen, the rest of the
+// console output (shutdown noise) is dropped.
+type exitMarkerWriter struct {
+	mu          sync.Mutex
+	w           io.Writer
+	pending     []byte
+	passthrough bool
+	found       bool
+	code        int
+}
+
+func newExitMarkerWriter(w io.Writer) *exitMarkerWriter {
+	if w == nil {
+		w = io.Discard
+	}
+	return &exitMarkerWriter{w: w}
+}
+
+func (e *exitMarkerWriter) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.found {
+		return len(p), nil
+	}
+
+	marker := []byte(firecrackerExitMarker)
+	out := make([]byte, 0, len(p))
+	for i, b := range p {
+		if e.passthrough {
+			out = append(out, b)
+			if b == '\n' {
+				e.passthrough = false
+			}
+			continue
+		}
+
+		e.pending = append(e.pending, b)
+		if b == '\n' {
+			if bytes.HasPrefix(e.pending, marker) {
+				code, err := strconv.Atoi(strings.TrimSpace(string(e.pending[len(marker):])))
+				if err == nil {
+					e.found = true
+					e.code = code
+					e.pending = nil
+					if _, err := e.w.Write(out); err != nil {
+						return i, err
+					}
+					return len(p), nil
+				}
+			}
+			out = append(out, e.pending...)
+			e.pending = nil
+			continue
+		}
+		if !bytes.HasPrefix(marker, e.pending) && !bytes.HasPrefix(e.pending, marker) {
+			out = append(out, e.pending...)
+			e.pending = nil
+			e.passthrough = true
+		}
+	}
+
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes any held-back partial line once the console has closed.
+func (e *exitMarkerWriter) flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.found || len(e.pending) == 0 {
+		return nil
+	}
+	_, err := e.w.Write(e.pending)
+	e.pending = nil
+	return err
+}
+
+func (e *exitMarkerWriter) exitCode() (int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.code, e.found
+}
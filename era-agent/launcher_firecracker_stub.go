@@ -0,0 +1,11 @@
+//go:build !firecracker
+
+package main
+
+import "errors"
+
+var errFirecrackerUnavailable = errors.New("firecracker runtime not available in this build (rebuild with -tags firecracker)")
+
+func newFirecrackerVMLauncher() (VMLauncher, error) {
+	return nil, errFirecrackerUnavailable
+}
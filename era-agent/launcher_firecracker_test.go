@@ -0,0 +1,46 @@
+//go:build firecracker
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExitMarkerWriterStripsMarker(t *testing.T) {
+	var out bytes.Buffer
+	w := newExitMarkerWriter(&out)
+
+	// Split writes so the marker straddles chunk boundaries.
+	for _, chunk := range []string{"hello\r\n__ERA", "_nope\r\npartial", " line\r\n__ERA_EX", "IT__=3\r\nreboot: Restarting system\r\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if err := w.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	code, ok := w.exitCode()
+	if !ok || code != 3 {
+		t.Fatalf("exitCode = %d, %v; want 3, true", code, ok)
+	}
+	if want := "hello\r\n__ERA_nope\r\npartial line\r\n"; out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFirecrackerBootArgsAvoidInnerQuotes(t *testing.T) {
+	args := firecrackerBootArgs(`echo "hi" && exit 2`)
+	_, script, ok := strings.Cut(args, " -- -c ")
+	if !ok {
+		t.Fatalf("boot args missing init arguments: %q", args)
+	}
+	if strings.Count(script, `"`) != 2 || !strings.HasPrefix(script, `"`) || !strings.HasSuffix(script, `"`) {
+		t.Fatalf("init script must be a single double-quoted argument: %q", script)
+	}
+	if strings.Contains(args, "&&") {
+		t.Fatalf("command should be base64 encoded, got %q", args)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,10 +15,13 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	krunvmBinaryName         = "krunvm"
+	skopeoBinaryName         = "skopeo"
+	buildahBinaryName        = "buildah"
 	krunvmDirName            = "krunvm"
 	containersDirName        = "containers"
 	containerStorageConfName = "storage.conf"
@@ -25,19 +29,36 @@ const (
 	guestInputPath   = "/in"
 	guestOutputPath  = "/out"
 	guestPersistPath = "/persist"
+
+	// commandWaitDelay bounds how long a cancelled command may keep its output
+	// pipes open, e.g. through a guest process that outlived krunvm.
+	commandWaitDelay = 2 * time.Second
 )
 
-func newKrunVMLauncher() VMLauncher {
-	return &krunVMLauncher{
-		binary: krunvmBinaryName,
+func newKrunVMLauncher() (VMLauncher, error) {
+	launcher := &krunVMLauncher{
+		binary: getenvOrDefault("AGENT_KRUNVM_BIN", krunvmBinaryName),
+	}
+	if err := requireRuntimeBinary(vmRuntimeKrunVM, launcher.binary); err != nil {
+		return nil, err
 	}
+	return launcher, nil
 }
 
 type krunVMLauncher struct {
 	binary string
 }
 
+// supportsDryRun marks the launcher as honouring withDryRun; see
+// runCommandWithInput.
+func (l *krunVMLauncher) supportsDryRun() {}
+
 func (l *krunVMLauncher) Launch(ctx context.Context, record VMRecord) error {
+	return l.runCommand(ctx, l.createArgs(record), nil, nil)
+}
+
+// createArgs builds the `krunvm create` arguments for a record.
+func (l *krunVMLauncher) createArgs(record VMRecord) []string {
 	args := []string{
 		"create",
 		"--name", record.ID,
@@ -47,11 +68,14 @@ func (l *krunVMLauncher) Launch(ctx context.Context, record VMRecord) error {
 
 	if !record.Storage.DisableGuestVolumes && strings.TrimSpace(record.Storage.Root) != "" {
 		volumes := []string{
-			formatVolume(record.Storage.InputPath, guestInputPath),
-			formatVolume(record.Storage.OutputPath, guestOutputPath),
+			formatVolume(record.Storage.InputPath, record.Storage.guestIn()),
+			formatVolume(record.Storage.OutputPath, record.Storage.guestOut()),
 		}
 		if record.Storage.PersistPath != "" {
-			volumes = append(volumes, formatVolume(record.Storage.PersistPath, guestPersistPath))
+			volumes = append(volumes, formatVolume(record.Storage.PersistPath, record.Storage.guestPersist()))
+		}
+		for _, mount := range record.Storage.Volumes {
+			volumes = append(volumes, formatVolume(mount.HostPath, mount.GuestPath))
 		}
 
 		for _, volume := range volumes {
@@ -62,9 +86,18 @@ func (l *krunVMLauncher) Launch(ctx context.Context, record VMRecord) error {
 		}
 	}
 
+	if record.NetworkMode != "" && record.NetworkMode != "none" {
+		for _, port := range record.Ports {
+			args = append(args, "--port", port.String())
+		}
+	}
+	if record.Storage.GuestWorkdir != "" {
+		args = append(args, "--workdir", record.Storage.GuestWorkdir)
+	}
+
 	args = append(args, record.RootFSImage)
 
-	return l.runCommand(ctx, args, nil, nil)
+	return args
 }
 
 func (l *krunVMLauncher) Stop(ctx context.Context, vmID string) error {
@@ -79,18 +112,21 @@ func (l *krunVMLauncher) Cleanup(ctx context.Context, vmID string) error {
 }
 
 func (l *krunVMLauncher) Run(ctx context.Context, record VMRecord, opts VMRunOptions, stdout io.Writer, stderr io.Writer) (int, error) {
-	// Use base64 encoding to safely pass the command and avoid quote issues
-	encodedCmd := base64.StdEncoding.EncodeToString([]byte(opts.Command))
 	args := []string{
 		"start",
 		record.ID,
 		"--",
 		"/bin/bash",
 		"-c",
-		fmt.Sprintf("echo %s | base64 -d | bash", encodedCmd),
+		guestCommandScript(opts.Command),
 	}
 
-	exitCode, _, _, err := l.runCommandWithOutput(ctx, args, stdout, stderr)
+	var stdin io.Reader
+	if opts.Stdin != "" {
+		stdin = strings.NewReader(opts.Stdin)
+	}
+
+	exitCode, _, _, err := l.runCommandWithInput(ctx, args, stdin, stdout, stderr)
 	return exitCode, err
 }
 func (l *krunVMLauncher) List(ctx context.Context) ([]string, error) {
@@ -123,6 +159,86 @@ func (l *krunVMLauncher) List(ctx context.Context) ([]string, error) {
 	return names, nil
 }
 
+// Stats reports resource usage of the host-side `krunvm start` process backing
+// the VM. krunvm only keeps that process alive while a command is executing.
+func (l *krunVMLauncher) Stats(ctx context.Context, record VMRecord) (VMStats, error) {
+	return findProcessStats(ctx, l.isStartProcess(record.ID))
+}
+
+// isStartProcess matches the argv of `krunvm start <vmID>`, whether krunvm
+// was run by name or by path.
+func (l *krunVMLauncher) isStartProcess(vmID string) func(args []string) bool {
+	return func(args []string) bool {
+		return len(args) >= 3 &&
+			filepath.Base(args[0]) == filepath.Base(l.binary) &&
+			args[1] == "start" &&
+			args[2] == vmID
+	}
+}
+
+// InspectImage checks that an image reference resolves in its registry by
+// fetching the manifest with skopeo, without pulling any layers.
+func (l *krunVMLauncher) InspectImage(ctx context.Context, ref string) (ImageInfo, error) {
+	return inspectImageWithSkopeo(ctx, l.commandEnv(), ref)
+}
+
+// ImageCached reports whether the image is already present in the containers
+// storage krunvm creates VMs from.
+func (l *krunVMLauncher) ImageCached(ctx context.Context, ref string) (bool, error) {
+	args := []string{"inspect", "--type", "image", ref}
+	if report := dryRunReporter(ctx); report != nil {
+		report(append([]string{buildahBinaryName}, args...))
+		return true, nil
+	}
+	cmd := exec.CommandContext(ctx, buildahBinaryName, args...)
+	cmd.Env = l.commandEnv()
+
+	var stderrBuf bytes.Buffer
+	cmd.Stderr = &stderrBuf
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return false, fmt.Errorf("buildah is required to check the local image cache: %w", err)
+		}
+		lower := strings.ToLower(stderrBuf.String())
+		if strings.Contains(lower, "not known") || strings.Contains(lower, "not found") || strings.Contains(lower, "no such image") {
+			return false, nil
+		}
+		return false, &commandError{
+			args:   append([]string{buildahBinaryName}, args...),
+			err:    err,
+			stderr: stderrBuf.String(),
+		}
+	}
+	return true, nil
+}
+
+// PullImage refreshes the image in krunvm's containers storage.
+func (l *krunVMLauncher) PullImage(ctx context.Context, ref string) error {
+	args := []string{"pull", ref}
+	if report := dryRunReporter(ctx); report != nil {
+		report(append([]string{buildahBinaryName}, args...))
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, buildahBinaryName, args...)
+	cmd.Env = l.commandEnv()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+
+	if err := cmd.Run(); err != nil {
+		return &commandError{
+			args:   append([]string{buildahBinaryName}, args...),
+			err:    err,
+			stdout: stdoutBuf.String(),
+			stderr: stderrBuf.String(),
+		}
+	}
+	return nil
+}
+
 func (l *krunVMLauncher) Shell(ctx context.Context, record VMRecord, shellCmd string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
 	// Parse the shell command to split it into command and arguments
 	parts := strings.Fields(shellCmd)
@@ -138,6 +254,7 @@ func (l *krunVMLauncher) Shell(ctx context.Context, record VMRecord, shellCmd st
 	args = append(args, parts...) // This will expand to command + all its arguments
 
 	cmd := exec.CommandContext(ctx, l.binary, args...)
+	cmd.Env = l.guestCommandEnv()
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
@@ -192,11 +309,85 @@ func (l *krunVMLauncher) runCommand(ctx context.Context, args []string, stdout i
 }
 
 func (l *krunVMLauncher) runCommandWithOutput(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) (int, string, string, error) {
+	return l.runCommandWithInput(ctx, args, nil, stdout, stderr)
+}
+
+func (l *krunVMLauncher) runCommandWithInput(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) (int, string, string, error) {
 	if len(args) == 0 {
 		return -1, "", "", errors.New("krunvm command missing")
 	}
+	if report := dryRunReporter(ctx); report != nil {
+		report(append([]string{l.binary}, args...))
+		return 0, "", "", nil
+	}
+
+	cmd := exec.CommandContext(ctx, l.binary, args...)
+	cmd.Env = l.commandEnv()
+	if args[0] == "start" {
+		// krunvm start passes its environment on to the guest command.
+		cmd.Env = l.guestCommandEnv()
+	}
+	cmd.Stdin = stdin
+	cmd.WaitDelay = commandWaitDelay
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	if stdout != nil {
+		cmd.Stdout = stdout
+	} else {
+		cmd.Stdout = &stdoutBuf
+	}
+	if stderr != nil {
+		cmd.Stderr = stderr
+	} else {
+		cmd.Stderr = &stderrBuf
+	}
+
+	err := cmd.Run()
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode = exitErr.ExitCode()
+		} else {
+			return -1, "", "", err
+		}
+	}
+
+	if exitCode != 0 {
+		return exitCode, stdoutBuf.String(), stderrBuf.String(), &commandError{
+			args:   append([]string{l.binary}, args...),
+			err:    err,
+			stdout: stdoutBuf.String(),
+			stderr: stderrBuf.String(),
+		}
+	}
+
+	stdoutStr := ""
+	stderrStr := ""
+	if stdout == nil {
+		stdoutStr = stdoutBuf.String()
+	}
+	if stderr == nil {
+		stderrStr = stderrBuf.String()
+	}
+
+	return exitCode, stdoutStr, stderrStr, nil
+}
 
-	env := append([]string{}, os.Environ()...)
+// commandEnv returns the process environment for krunvm and the container
+// tooling it drives, pointing them at the agent-managed storage and policy.
+func (l *krunVMLauncher) commandEnv() []string {
+	return l.commandEnvFrom(os.Environ())
+}
+
+// guestCommandEnv is commandEnv with the host environment filtered through
+// guestEnv, for krunvm invocations that run a command inside the guest.
+func (l *krunVMLauncher) guestCommandEnv() []string {
+	return l.commandEnvFrom(guestEnv(os.Environ()))
+}
+
+func (l *krunVMLauncher) commandEnvFrom(host []string) []string {
+	env := append([]string{}, host...)
 	env = append(env, fmt.Sprintf("KRUNVM_DATA_DIR=%s", l.dataDir()))
 
 	if runtime.GOOS == "darwin" {
@@ -247,51 +438,7 @@ func (l *krunVMLauncher) runCommandWithOutput(ctx context.Context, args []string
 		}
 	}
 
-	cmd := exec.CommandContext(ctx, l.binary, args...)
-	cmd.Env = env
-
-	var stdoutBuf, stderrBuf bytes.Buffer
-	if stdout != nil {
-		cmd.Stdout = stdout
-	} else {
-		cmd.Stdout = &stdoutBuf
-	}
-	if stderr != nil {
-		cmd.Stderr = stderr
-	} else {
-		cmd.Stderr = &stderrBuf
-	}
-
-	err := cmd.Run()
-	exitCode := 0
-	if err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			exitCode = exitErr.ExitCode()
-		} else {
-			return -1, "", "", err
-		}
-	}
-
-	if exitCode != 0 {
-		return exitCode, stdoutBuf.String(), stderrBuf.String(), &commandError{
-			args:   append([]string{l.binary}, args...),
-			err:    err,
-			stdout: stdoutBuf.String(),
-			stderr: stderrBuf.String(),
-		}
-	}
-
-	stdoutStr := ""
-	stderrStr := ""
-	if stdout == nil {
-		stdoutStr = stdoutBuf.String()
-	}
-	if stderr == nil {
-		stderrStr = stderrBuf.String()
-	}
-
-	return exitCode, stdoutStr, stderrStr, nil
+	return env
 }
 
 func (l *krunVMLauncher) dataDir() string {
@@ -333,12 +480,7 @@ func ensureContainersConfig() containersConfig {
 	}
 
 	confPath := filepath.Join(containersRoot, containerStorageConfName)
-	confContents := fmt.Sprintf(`[storage]
-driver = "vfs"
-graphroot = %q
-runroot = %q
-rootless_storage_path = %q
-`, storageRoot, runRoot, storageRoot)
+	confContents := containersStorageConf(storageRoot, runRoot, offlineImageDir())
 
 	if err := os.WriteFile(confPath, []byte(confContents), 0o640); err != nil {
 		return containersConfig{}
@@ -366,14 +508,7 @@ rootless_storage_path = %q
 		return containersConfig{}
 	}
 
-	registries := `unqualified-search-registries = ["localhost", "docker.io"]
-
-[[registry]]
-prefix = "docker.io"
-location = "registry-1.docker.io"
-blocked = false
-insecure = false
-`
+	registries := containersRegistriesConf(offlineModeEnabled())
 
 	if err := os.WriteFile(registriesConf, []byte(registries), 0o640); err != nil {
 		return containersConfig{}
@@ -399,7 +534,12 @@ type commandError struct {
 
 func (e *commandError) Error() string {
 	var builder strings.Builder
-	builder.WriteString("krunvm command failed: ")
+	binary := krunvmBinaryName
+	if len(e.args) > 0 {
+		binary = filepath.Base(e.args[0])
+	}
+	builder.WriteString(binary)
+	builder.WriteString(" command failed: ")
 	builder.WriteString(strings.Join(e.args, " "))
 	if e.err != nil {
 		builder.WriteString(": ")
@@ -417,6 +557,84 @@ func (e *commandError) Unwrap() error {
 	return e.err
 }
 
+// guestCommandScript wraps a user command for `bash -c` inside the guest. The
+// command travels base64 encoded to avoid quoting issues and is eval'd rather
+// than piped into bash so the guest process keeps the caller's stdin.
+func guestCommandScript(command string) string {
+	encodedCmd := base64.StdEncoding.EncodeToString([]byte(command))
+	return fmt.Sprintf(`eval "$(echo %s | base64 -d)"`, encodedCmd)
+}
+
+// skopeoInspectOutput is the subset of `skopeo inspect` output we rely on.
+type skopeoInspectOutput struct {
+	Name       string `json:"Name"`
+	Digest     string `json:"Digest"`
+	LayersData []struct {
+		Size int64 `json:"Size"`
+	} `json:"LayersData"`
+}
+
+func inspectImageWithSkopeo(ctx context.Context, env []string, ref string) (ImageInfo, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ImageInfo{}, errors.New("image reference is required")
+	}
+
+	target := ref
+	if !strings.Contains(target, "://") {
+		target = "docker://" + target
+	}
+
+	args := []string{"inspect", "--no-tags", target}
+	cmd := exec.CommandContext(ctx, skopeoBinaryName, args...)
+	cmd.Env = env
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return ImageInfo{}, fmt.Errorf("skopeo is required for image checks: %w", err)
+		}
+		if isImageNotFoundOutput(stderrBuf.String()) {
+			return ImageInfo{}, fmt.Errorf("%w: %s", errImageNotFound, ref)
+		}
+		return ImageInfo{}, &commandError{
+			args:   append([]string{skopeoBinaryName}, args...),
+			err:    err,
+			stdout: stdoutBuf.String(),
+			stderr: stderrBuf.String(),
+		}
+	}
+
+	return parseSkopeoInspect(ref, stdoutBuf.Bytes())
+}
+
+func parseSkopeoInspect(ref string, raw []byte) (ImageInfo, error) {
+	var out skopeoInspectOutput
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return ImageInfo{}, fmt.Errorf("parse skopeo output: %w", err)
+	}
+
+	info := ImageInfo{Ref: ref, Digest: out.Digest}
+	for _, layer := range out.LayersData {
+		info.SizeBytes += layer.Size
+	}
+	return info, nil
+}
+
+func isImageNotFoundOutput(stderr string) bool {
+	lower := strings.ToLower(stderr)
+	for _, marker := range []string{"manifest unknown", "name unknown", "not found", "requested access to the resource is denied"} {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
 func formatVolume(hostPath, guestPath string) string {
 	host := strings.TrimSpace(hostPath)
 	if host == "" || guestPath == "" {
@@ -6,7 +6,6 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
@@ -76,11 +75,14 @@ func (l *libkrunVMLauncher) Launch(ctx context.Context, record VMRecord) error {
 	if !record.Storage.DisableGuestVolumes && strings.TrimSpace(record.Storage.Root) != "" {
 		// Add volume mappings
 		volumes := []string{
-			formatVolume(record.Storage.InputPath, guestInputPath),
-			formatVolume(record.Storage.OutputPath, guestOutputPath),
+			formatVolume(record.Storage.InputPath, record.Storage.guestIn()),
+			formatVolume(record.Storage.OutputPath, record.Storage.guestOut()),
 		}
 		if record.Storage.PersistPath != "" {
-			volumes = append(volumes, formatVolume(record.Storage.PersistPath, guestPersistPath))
+			volumes = append(volumes, formatVolume(record.Storage.PersistPath, record.Storage.guestPersist()))
+		}
+		for _, mount := range record.Storage.Volumes {
+			volumes = append(volumes, formatVolume(mount.HostPath, mount.GuestPath))
 		}
 
 		for _, volume := range volumes {
@@ -91,6 +93,10 @@ func (l *libkrunVMLauncher) Launch(ctx context.Context, record VMRecord) error {
 		}
 	}
 	
+	if record.Storage.GuestWorkdir != "" {
+		args = append(args, "--workdir", record.Storage.GuestWorkdir)
+	}
+
 	// Add the VM ID as a name
 	args = append(args, "--name", record.ID)
 	
@@ -122,19 +128,20 @@ func (l *libkrunVMLauncher) Cleanup(ctx context.Context, vmID string) error {
 func (l *libkrunVMLauncher) Run(ctx context.Context, record VMRecord, opts VMRunOptions, stdout io.Writer, stderr io.Writer) (int, error) {
 	// For libkrun, execute command in the running VM context
 	// This is an abstraction since libkrun works differently than krunvm
-	
-	// Use base64 encoding to safely pass the command and avoid quote issues
-	encodedCmd := base64.StdEncoding.EncodeToString([]byte(opts.Command))
 	args := []string{
 		"exec",  // hypothetical command for libkrun
 		record.ID,
 		"--",
 		"/bin/bash",
 		"-c",
-		fmt.Sprintf("echo %s | base64 -d | bash", encodedCmd),
+		guestCommandScript(opts.Command),
 	}
 
 	cmd := exec.CommandContext(ctx, l.binary, args...)
+	if opts.Stdin != "" {
+		cmd.Stdin = strings.NewReader(opts.Stdin)
+	}
+	cmd.WaitDelay = commandWaitDelay
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
 	
@@ -225,9 +232,40 @@ func (l *libkrunVMLauncher) List(ctx context.Context) ([]string, error) {
 	return names, nil
 }
 
+func (l *libkrunVMLauncher) Stats(ctx context.Context, record VMRecord) (VMStats, error) {
+	return findProcessStats(ctx, func(args []string) bool {
+		return len(args) >= 3 &&
+			filepath.Base(args[0]) == filepath.Base(l.binary) &&
+			args[1] == "exec" &&
+			args[2] == record.ID
+	})
+}
+
+func (l *libkrunVMLauncher) InspectImage(ctx context.Context, ref string) (ImageInfo, error) {
+	return inspectImageWithSkopeo(ctx, os.Environ(), ref)
+}
+
+// ImageCached reports whether the rootfs exists locally; libkrun boots rootfs
+// paths directly rather than pulling from a registry.
+func (l *libkrunVMLauncher) ImageCached(ctx context.Context, ref string) (bool, error) {
+	if _, err := os.Stat(ref); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (l *libkrunVMLauncher) PullImage(ctx context.Context, ref string) error {
+	return errors.New("libkrun runtime cannot pull images; provide a local rootfs")
+}
+
 func (l *libkrunVMLauncher) setupEnvironment(record VMRecord) []string {
 	// Set up environment variables for libkrun
-	env := append([]string{}, os.Environ()...)
+	// libkrun exposes this environment to the guest, so only forward the
+	// allowed host variables.
+	env := guestEnv(os.Environ())
 	
 	// Add libkrun-specific environment variables
 	env = append(env, fmt.Sprintf("LIBKRUN_DATA_DIR=%s", l.dataDir()))
@@ -36,6 +36,22 @@ func (s *stubVMLauncher) List(ctx context.Context) ([]string, error) {
 	return nil, errLibkrunUnavailable
 }
 
+func (s *stubVMLauncher) Stats(ctx context.Context, record VMRecord) (VMStats, error) {
+	return VMStats{}, errLibkrunUnavailable
+}
+
+func (s *stubVMLauncher) InspectImage(ctx context.Context, ref string) (ImageInfo, error) {
+	return ImageInfo{}, errLibkrunUnavailable
+}
+
+func (s *stubVMLauncher) ImageCached(ctx context.Context, ref string) (bool, error) {
+	return false, errLibkrunUnavailable
+}
+
+func (s *stubVMLauncher) PullImage(ctx context.Context, ref string) error {
+	return errLibkrunUnavailable
+}
+
 func newLibkrunVMLauncher() (VMLauncher, error) {
 	return &stubVMLauncher{}, nil
 }
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,10 +28,51 @@ var logLevelAliases = map[string]LogLevel{
 	"error": LevelError,
 }
 
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	defaultLogMaxBytes   = 50 << 20
+	defaultLogMaxBackups = 5
+)
+
 type Logger struct {
 	level LogLevel
+	json  bool
 	file  *os.File
 	mu    sync.Mutex
+
+	// Size-based rotation of the log file mirror, see rotateLocked.
+	path       string
+	size       int64
+	maxBytes   int64
+	maxBackups int
+
+	// parent and fields are set on loggers made by With, which write
+	// through their root logger.
+	parent *Logger
+	fields map[string]any
+}
+
+// With returns a logger that adds fields to every entry and otherwise
+// writes through l, sharing its level, format and file. Fields passed to a
+// single call win over these.
+func (l *Logger) With(fields map[string]any) *Logger {
+	merged := make(map[string]any, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return &Logger{parent: l.root(), fields: merged}
+}
+
+func (l *Logger) root() *Logger {
+	if l.parent != nil {
+		return l.parent
+	}
+	return l
 }
 
 func NewLogger(rawLevel, logFile string) (*Logger, error) {
@@ -38,7 +81,11 @@ func NewLogger(rawLevel, logFile string) (*Logger, error) {
 		level = LevelInfo
 	}
 
-	var file *os.File
+	logger := &Logger{
+		level:      level,
+		maxBytes:   envInt64("AGENT_LOG_MAX_BYTES", defaultLogMaxBytes),
+		maxBackups: int(envInt64("AGENT_LOG_MAX_BACKUPS", defaultLogMaxBackups)),
+	}
 	if trimmed := strings.TrimSpace(logFile); trimmed != "" {
 		dir := filepath.Dir(trimmed)
 		if dir != "" && dir != "." {
@@ -47,22 +94,96 @@ func NewLogger(rawLevel, logFile string) (*Logger, error) {
 			}
 		}
 
-		var err error
-		file, err = os.OpenFile(trimmed, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
-		if err != nil {
-			return nil, fmt.Errorf("open log file: %w", err)
+		logger.path = trimmed
+		if err := logger.openLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	return logger, nil
+}
+
+func envInt64(name string, fallback int64) int64 {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+func (l *Logger) openLocked() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotateLocked renames the log file to <file>.1, shifting older backups up to
+// maxBackups and dropping the oldest, then reopens a fresh file. A
+// non-positive maxBytes disables rotation.
+func (l *Logger) rotateLocked() {
+	if l.maxBytes <= 0 || l.size < l.maxBytes {
+		return
+	}
+
+	if err := l.file.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "error closing log file for rotation: %v\n", err)
+	}
+	l.file = nil
+
+	if l.maxBackups > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+		}
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			fmt.Fprintf(os.Stderr, "error rotating log file: %v\n", err)
 		}
+	} else {
+		_ = os.Remove(l.path)
+	}
+
+	if err := l.openLocked(); err != nil {
+		fmt.Fprintf(os.Stderr, "error reopening log file: %v\n", err)
 	}
+}
 
-	return &Logger{level: level, file: file}, nil
+// SetFormat switches between human-readable lines ("text", the default) and
+// one JSON object per line ("json").
+func (l *Logger) SetFormat(format string) error {
+	l = l.root()
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case "", LogFormatText:
+		l.json = false
+	case LogFormatJSON:
+		l.json = true
+	default:
+		return fmt.Errorf("unsupported log format %q (want %s or %s)", format, LogFormatText, LogFormatJSON)
+	}
+	return nil
 }
 
 func (l *Logger) Close() error {
-	if l == nil || l.file == nil {
+	if l == nil {
 		return nil
 	}
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
 	err := l.file.Close()
 	l.file = nil
 	return err
@@ -85,10 +206,47 @@ func (l *Logger) Error(msg string, fields map[string]any) {
 }
 
 func (l *Logger) log(level LogLevel, msg string, fields map[string]any) {
+	if l.parent != nil {
+		if len(l.fields) > 0 {
+			merged := make(map[string]any, len(l.fields)+len(fields))
+			for key, value := range l.fields {
+				merged[key] = value
+			}
+			for key, value := range fields {
+				merged[key] = value
+			}
+			fields = merged
+		}
+		l.parent.log(level, msg, fields)
+		return
+	}
 	if level < l.level {
 		return
 	}
 
+	var output string
+	if l.json {
+		output = formatJSONLine(level, msg, fields)
+	} else {
+		output = formatTextLine(level, msg, fields)
+	}
+
+	if level >= LevelError {
+		fmt.Fprint(os.Stderr, output)
+	} else {
+		fmt.Fprint(os.Stdout, output)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		written, _ := l.file.WriteString(output)
+		l.size += int64(written)
+		l.rotateLocked()
+	}
+}
+
+func formatTextLine(level LogLevel, msg string, fields map[string]any) string {
 	ts := time.Now().UTC().Format(time.RFC3339)
 	levelStr := strings.ToUpper(levelString(level))
 
@@ -114,19 +272,36 @@ func (l *Logger) log(level LogLevel, msg string, fields map[string]any) {
 	}
 
 	builder.WriteString("\n")
+	return builder.String()
+}
 
-	output := builder.String()
-	if level >= LevelError {
-		fmt.Fprint(os.Stderr, output)
-	} else {
-		fmt.Fprint(os.Stdout, output)
+// formatJSONLine renders an entry as a single JSON object. Fields are
+// flattened into the top level; ones that would shadow ts, level or msg are
+// prefixed with "field_".
+func formatJSONLine(level LogLevel, msg string, fields map[string]any) string {
+	entry := make(map[string]any, len(fields)+3)
+	for key, value := range fields {
+		switch key {
+		case "ts", "level", "msg":
+			key = "field_" + key
+		}
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		if _, err := json.Marshal(value); err != nil {
+			value = fmt.Sprintf("%v", value)
+		}
+		entry[key] = value
 	}
+	entry["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = levelString(level)
+	entry["msg"] = msg
 
-	if l.file != nil {
-		l.mu.Lock()
-		_, _ = l.file.WriteString(output)
-		l.mu.Unlock()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return formatTextLine(level, msg, fields)
 	}
+	return string(line) + "\n"
 }
 
 func levelString(level LogLevel) string {
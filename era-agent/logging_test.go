@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func readLogLines(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	defer f.Close()
+
+	var lines []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scan log: %v", err)
+	}
+	return lines
+}
+
+func TestLoggerJSONFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := NewLogger("debug", path)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	if err := logger.SetFormat("json"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+
+	logger.Debug("debug message", map[string]any{"vm": "vm-1"})
+	logger.Info("info message", map[string]any{"count": 3})
+	logger.Warn("warn message", map[string]any{"error": errors.New("boom")})
+	logger.Error("error message", map[string]any{"msg": "shadowed"})
+	if err := logger.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	lines := readLogLines(t, path)
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want 4", len(lines))
+	}
+	for i, level := range []string{"debug", "info", "warn", "error"} {
+		if lines[i]["level"] != level {
+			t.Fatalf("line %d level = %v, want %s", i, lines[i]["level"], level)
+		}
+		if lines[i]["msg"] != level+" message" {
+			t.Fatalf("line %d msg = %v", i, lines[i]["msg"])
+		}
+		if _, ok := lines[i]["ts"].(string); !ok {
+			t.Fatalf("line %d missing ts: %v", i, lines[i])
+		}
+	}
+	if lines[0]["vm"] != "vm-1" || lines[1]["count"] != float64(3) || lines[2]["error"] != "boom" {
+		t.Fatalf("fields not flattened: %v", lines)
+	}
+	if lines[3]["field_msg"] != "shadowed" {
+		t.Fatalf("colliding field not renamed: %v", lines[3])
+	}
+}
+
+func TestLoggerJSONFormatHonorsLevel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := NewLogger("warn", path)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	if err := logger.SetFormat("json"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+
+	logger.Debug("hidden", nil)
+	logger.Info("hidden", nil)
+	logger.Warn("shown", nil)
+	logger.Close()
+
+	lines := readLogLines(t, path)
+	if len(lines) != 1 || lines[0]["msg"] != "shown" {
+		t.Fatalf("unexpected lines: %v", lines)
+	}
+}
+
+func TestLoggerSetFormatRejectsUnknown(t *testing.T) {
+	logger, err := NewLogger("info", "")
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	if err := logger.SetFormat("yaml"); err == nil {
+		t.Fatal("expected unknown format to be rejected")
+	}
+}
+
+func TestLoggerRotatesBySize(t *testing.T) {
+	t.Setenv("AGENT_LOG_MAX_BYTES", "512")
+	t.Setenv("AGENT_LOG_MAX_BACKUPS", "2")
+	path := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := NewLogger("error", path)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+
+	payload := strings.Repeat("x", 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				logger.Error("rotation test", map[string]any{"payload": payload})
+			}
+		}()
+	}
+	wg.Wait()
+	if err := logger.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat active log: %v", err)
+	}
+	if info.Size() >= 512 {
+		t.Fatalf("active log is %d bytes, want below the 512 byte threshold", info.Size())
+	}
+	for _, backup := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(backup); err != nil {
+			t.Fatalf("expected rotated backup %s: %v", backup, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("backups beyond AGENT_LOG_MAX_BACKUPS were kept: %v", err)
+	}
+}
+
+func TestLoggerWithAddsFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+	logger, err := NewLogger("info", path)
+	if err != nil {
+		t.Fatalf("new logger: %v", err)
+	}
+	if err := logger.SetFormat("json"); err != nil {
+		t.Fatalf("set format: %v", err)
+	}
+
+	child := logger.With(map[string]any{"request_id": "abc", "vm": "base"})
+	child.Info("child message", map[string]any{"vm": "vm-1"})
+	child.Debug("hidden", nil)
+	logger.Info("parent message", nil)
+	logger.Close()
+
+	lines := readLogLines(t, path)
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2: %v", len(lines), lines)
+	}
+	if lines[0]["request_id"] != "abc" || lines[0]["vm"] != "vm-1" {
+		t.Fatalf("child fields not merged under call fields: %v", lines[0])
+	}
+	if _, ok := lines[1]["request_id"]; ok {
+		t.Fatalf("child fields leaked into parent: %v", lines[1])
+	}
+}
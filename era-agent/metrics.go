@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// vmMetrics holds the Prometheus collectors for a VMService. It uses its own
+// registry rather than the global default so each service exports only its
+// own state. A nil *vmMetrics is valid and records nothing.
+type vmMetrics struct {
+	registry *prometheus.Registry
+
+	vmsCreated   *prometheus.CounterVec
+	vmsCleaned   *prometheus.CounterVec
+	runsTotal    *prometheus.CounterVec
+	runFailures  *prometheus.CounterVec
+	runDurations *prometheus.HistogramVec
+}
+
+func newVMMetrics(svc *VMService) *vmMetrics {
+	m := &vmMetrics{
+		registry: prometheus.NewRegistry(),
+		vmsCreated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "era_vms_created_total",
+			Help: "VMs created, by language.",
+		}, []string{"language"}),
+		vmsCleaned: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "era_vms_cleaned_total",
+			Help: "VMs cleaned up, by language.",
+		}, []string{"language"}),
+		runsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "era_vm_runs_total",
+			Help: "Commands executed in VMs, by language and exit code.",
+		}, []string{"language", "exit_code"}),
+		runFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "era_vm_run_failures_total",
+			Help: "Runs that exited non-zero or failed to execute, by language.",
+		}, []string{"language"}),
+		runDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "era_vm_run_duration_seconds",
+			Help:    "Wall-clock duration of VM runs, by language.",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		}, []string{"language"}),
+	}
+
+	m.registry.MustRegister(
+		m.vmsCreated,
+		m.vmsCleaned,
+		m.runsTotal,
+		m.runFailures,
+		m.runDurations,
+		&vmStateCollector{svc: svc},
+	)
+	return m
+}
+
+func (m *vmMetrics) handler() http.Handler {
+	if m == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *vmMetrics) observeCreate(language string) {
+	if m == nil {
+		return
+	}
+	m.vmsCreated.WithLabelValues(language).Inc()
+}
+
+func (m *vmMetrics) observeClean(language string) {
+	if m == nil {
+		return
+	}
+	m.vmsCleaned.WithLabelValues(language).Inc()
+}
+
+// observeRun records a finished run. Runs that never reached the guest, such
+// as a bad VM id, only count as failures.
+func (m *vmMetrics) observeRun(language string, result VMRunResult, err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	executed := result.StdoutPath != ""
+	if executed {
+		m.runsTotal.WithLabelValues(language, strconv.Itoa(result.ExitCode)).Inc()
+		m.runDurations.WithLabelValues(language).Observe(duration.Seconds())
+	}
+	if err != nil || result.ExitCode != 0 {
+		m.runFailures.WithLabelValues(language).Inc()
+	}
+}
+
+// vmStateCollector reports live VM counts from the service cache at scrape
+// time, so the gauge cannot drift from the tracked state.
+type vmStateCollector struct {
+	svc *VMService
+}
+
+var runningVMsDesc = prometheus.NewDesc(
+	"era_vms_running",
+	"VMs currently ready or running, by language.",
+	[]string{"language"}, nil,
+)
+
+func (c *vmStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- runningVMsDesc
+}
+
+func (c *vmStateCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := make(map[string]int)
+	c.svc.mu.RLock()
+	for _, record := range c.svc.cache {
+		if record.Status == VMStatusReady || record.Status == VMStatusRunning {
+			counts[record.Language]++
+		}
+	}
+	c.svc.mu.RUnlock()
+
+	for language, count := range counts {
+		ch <- prometheus.MustNewConstMetric(runningVMsDesc, prometheus.GaugeValue, float64(count), language)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpointReportsRuns(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	if _, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "true", Timeout: 5}); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if _, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "exit 2", Timeout: 5}); err == nil {
+		t.Fatal("expected failing run to return an error")
+	}
+
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("scrape status = %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	for _, want := range []string{
+		`era_vm_runs_total{exit_code="0",language="python"} 1`,
+		`era_vm_runs_total{exit_code="2",language="python"} 1`,
+		`era_vm_run_failures_total{language="python"} 1`,
+		`era_vms_created_total{language="python"} 1`,
+		`era_vms_running{language="python"} 1`,
+		`era_vm_run_duration_seconds_count{language="python"} 2`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics output missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The network modes every launcher implements: none gives the guest no
+// network, allow_all gives it outbound access and allows port mappings.
+const (
+	networkModeNone     = "none"
+	networkModeAllowAll = "allow_all"
+)
+
+var errInvalidNetworkMode = errors.New("invalid network mode")
+
+// normalizeNetworkMode lowercases mode and checks it is a known network
+// mode. Empty means none.
+func normalizeNetworkMode(mode string) (string, error) {
+	switch normalized := strings.ToLower(strings.TrimSpace(mode)); normalized {
+	case "", networkModeNone:
+		return networkModeNone, nil
+	case networkModeAllowAll:
+		return networkModeAllowAll, nil
+	default:
+		return "", fmt.Errorf("%w %q (want %s or %s)", errInvalidNetworkMode, mode, networkModeNone, networkModeAllowAll)
+	}
+}
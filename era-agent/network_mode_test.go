@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeNetworkMode(t *testing.T) {
+	for input, want := range map[string]string{
+		"":          networkModeNone,
+		"none":      networkModeNone,
+		" NONE ":    networkModeNone,
+		"allow_all": networkModeAllowAll,
+		"Allow_All": networkModeAllowAll,
+	} {
+		got, err := normalizeNetworkMode(input)
+		if err != nil || got != want {
+			t.Errorf("normalizeNetworkMode(%q) = %q, %v; want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"bridge", "allow-all", "host"} {
+		if _, err := normalizeNetworkMode(input); !errors.Is(err, errInvalidNetworkMode) {
+			t.Errorf("normalizeNetworkMode(%q) error = %v, want errInvalidNetworkMode", input, err)
+		}
+	}
+}
+
+func TestCreateNormalizesNetworkMode(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+
+	record, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "ALLOW_ALL"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if record.NetworkMode != networkModeAllowAll {
+		t.Fatalf("network mode = %q, want %q", record.NetworkMode, networkModeAllowAll)
+	}
+
+	if _, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "bridge"}); !errors.Is(err, errInvalidNetworkMode) {
+		t.Fatalf("create with an unknown network mode: %v", err)
+	}
+	if records, err := svc.List(context.Background()); err != nil || len(records) != 1 {
+		t.Fatalf("%d vms tracked (err %v), want only the valid one", len(records), err)
+	}
+}
+
+func TestAPIRejectsUnknownNetworkMode(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+
+	for _, path := range []string{"/api/vm/create", "/api/vm/temp"} {
+		body := `{"language":"python","command":"echo hi","network":"bridge"}`
+		resp, err := http.Post(server.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("%s request failed: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestCLIRejectsUnknownNetworkMode(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	cli := NewCLI(svc.logger, svc)
+
+	for _, args := range [][]string{
+		{"vm", "create", "--language", "python", "--network", "bridge"},
+		{"vm", "temp", "--language", "python", "--cmd", "echo hi", "--network", "bridge"},
+	} {
+		err := cli.Execute(context.Background(), args)
+		if !errors.Is(err, errInvalidNetworkMode) || !strings.Contains(err.Error(), "--network") {
+			t.Errorf("%q error = %v, want an invalid --network error", args, err)
+		}
+	}
+}
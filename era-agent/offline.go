@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// offlineModeEnabled reports AGENT_OFFLINE: images must already be in local
+// storage and nothing is pulled from a registry.
+func offlineModeEnabled() bool {
+	enabled, err := strconv.ParseBool(strings.TrimSpace(os.Getenv("AGENT_OFFLINE")))
+	return err == nil && enabled
+}
+
+// offlineImageDir returns AGENT_IMAGE_DIR, a containers-storage root holding
+// pre-pulled images that is consulted read-only next to the agent's own
+// storage.
+func offlineImageDir() string {
+	return strings.TrimSpace(os.Getenv("AGENT_IMAGE_DIR"))
+}
+
+// containersStorageConf renders storage.conf for the agent's storage. A
+// non-empty imageDir is added as an additional, read-only image store.
+func containersStorageConf(storageRoot, runRoot, imageDir string) string {
+	conf := fmt.Sprintf(`[storage]
+driver = "vfs"
+graphroot = %q
+runroot = %q
+rootless_storage_path = %q
+`, storageRoot, runRoot, storageRoot)
+	if imageDir != "" {
+		conf += fmt.Sprintf(`
+[storage.options]
+additionalimagestores = [%q]
+`, imageDir)
+	}
+	return conf
+}
+
+// containersRegistriesConf renders registries.conf. Offline, unqualified
+// names resolve only to localhost and docker.io is blocked, so a missing
+// image fails instead of reaching for the network.
+func containersRegistriesConf(offline bool) string {
+	if offline {
+		return `unqualified-search-registries = ["localhost"]
+
+[[registry]]
+prefix = "docker.io"
+location = "registry-1.docker.io"
+blocked = true
+insecure = false
+`
+	}
+	return `unqualified-search-registries = ["localhost", "docker.io"]
+
+[[registry]]
+prefix = "docker.io"
+location = "registry-1.docker.io"
+blocked = false
+insecure = false
+`
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEnsureContainersConfigOffline(t *testing.T) {
+	t.Setenv("AGENT_STATE_DIR", t.TempDir())
+	stateRootOnce = sync.Once{}
+	t.Cleanup(func() { stateRootOnce = sync.Once{} })
+
+	read := func(path string) string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		return string(data)
+	}
+
+	cfg := ensureContainersConfig()
+	if !cfg.valid() {
+		t.Fatal("config not written")
+	}
+	if registries := read(cfg.registriesConf); !strings.Contains(registries, "blocked = false") || !strings.Contains(registries, `"docker.io"]`) {
+		t.Fatalf("online registries.conf:\n%s", registries)
+	}
+	if storage := read(cfg.storageConf); strings.Contains(storage, "additionalimagestores") {
+		t.Fatalf("online storage.conf has an image store:\n%s", storage)
+	}
+
+	imageDir := filepath.Join(t.TempDir(), "images")
+	t.Setenv("AGENT_OFFLINE", "1")
+	t.Setenv("AGENT_IMAGE_DIR", imageDir)
+	cfg = ensureContainersConfig()
+	registries := read(cfg.registriesConf)
+	if !strings.Contains(registries, `unqualified-search-registries = ["localhost"]`) || !strings.Contains(registries, "blocked = true") {
+		t.Fatalf("offline registries.conf does not block remote pulls:\n%s", registries)
+	}
+	storage := read(cfg.storageConf)
+	if !strings.Contains(storage, "[storage.options]") || !strings.Contains(storage, `additionalimagestores = ["`+imageDir+`"]`) {
+		t.Fatalf("offline storage.conf misses AGENT_IMAGE_DIR:\n%s", storage)
+	}
+}
+
+func TestOfflineModeNeverPulls(t *testing.T) {
+	t.Setenv("AGENT_OFFLINE", "true")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+
+	opts := VMCreateOptions{Language: "python", Image: "docker.io/library/python:3.12", CPUCount: 1, MemoryMiB: 256, NetworkMode: "none", PullPolicy: pullPolicyAlways}
+	_, err := svc.Create(context.Background(), opts)
+	if !errors.Is(err, errImageNotCached) || !strings.Contains(err.Error(), "AGENT_OFFLINE") {
+		t.Fatalf("create err = %v, want an offline image-not-cached error", err)
+	}
+	if len(launcher.pulls) != 0 || launcher.launchCalls != 0 {
+		t.Fatalf("pulls = %v, launches = %d; want none offline", launcher.pulls, launcher.launchCalls)
+	}
+
+	launcher.cached = map[string]bool{opts.Image: true}
+	if _, err := svc.Create(context.Background(), opts); err != nil {
+		t.Fatalf("create of a local image offline: %v", err)
+	}
+	if len(launcher.pulls) != 0 {
+		t.Fatalf("pulled %v for a local image offline", launcher.pulls)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultMaxOutputBytes = 10 << 20 // 10 MiB per stream
+
+// maxOutputBytes resolves the per-stream capture limit: an explicit positive
+// option wins, then AGENT_MAX_OUTPUT_BYTES, then the default.
+func maxOutputBytes(requested int) int64 {
+	if requested > 0 {
+		return int64(requested)
+	}
+	if raw := strings.TrimSpace(os.Getenv("AGENT_MAX_OUTPUT_BYTES")); raw != "" {
+		if limit, err := strconv.ParseInt(raw, 10, 64); err == nil && limit > 0 {
+			return limit
+		}
+	}
+	return defaultMaxOutputBytes
+}
+
+// cappedWriter forwards at most limit bytes to w and silently counts the rest,
+// so a chatty guest keeps running instead of failing on a short write.
+type cappedWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	limit   int64
+	written int64
+	dropped int64
+}
+
+func newCappedWriter(w io.Writer, limit int64) *cappedWriter {
+	return &cappedWriter{w: w, limit: limit}
+}
+
+func (c *cappedWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	remaining := c.limit - c.written
+	if remaining <= 0 {
+		c.dropped += int64(len(p))
+		return len(p), nil
+	}
+
+	chunk := p
+	if int64(len(chunk)) > remaining {
+		chunk = chunk[:remaining]
+	}
+	n, err := c.w.Write(chunk)
+	c.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	c.dropped += int64(len(p) - len(chunk))
+	return len(p), nil
+}
+
+// reset clears the counters after the underlying writer has been rewound.
+func (c *cappedWriter) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = 0
+	c.dropped = 0
+}
+
+// finish appends the truncation marker when output was dropped and reports
+// whether that happened.
+func (c *cappedWriter) finish() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped == 0 {
+		return false, nil
+	}
+	_, err := fmt.Fprintf(c.w, "\n...[truncated %d bytes]\n", c.dropped)
+	return true, err
+}
+
+// truncationMarkerSlack leaves room for the marker finish appends past limit.
+const truncationMarkerSlack = 64
+
+// readCapturedOutput reads a capture file written through a cappedWriter. An
+// empty path yields no output.
+func readCapturedOutput(path string, limit int64) (string, error) {
+	if strings.TrimSpace(path) == "" {
+		return "", nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, limit+truncationMarkerSlack))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunTruncatesOutputOverLimit(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	result, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:           record.ID,
+		Command:        "head -c 5000 /dev/zero | tr '\\0' x",
+		Timeout:        5,
+		MaxOutputBytes: 1000,
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if !result.Truncated {
+		t.Fatal("expected result to be marked truncated")
+	}
+
+	stdout, err := os.ReadFile(result.StdoutPath)
+	if err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	want := strings.Repeat("x", 1000) + "\n...[truncated 4000 bytes]\n"
+	if string(stdout) != want {
+		t.Fatalf("stdout has %d bytes ending %q, want 1000 bytes plus marker", len(stdout), stdout[max(0, len(stdout)-40):])
+	}
+}
+
+func TestRunKeepsSmallOutput(t *testing.T) {
+	t.Setenv("AGENT_MAX_OUTPUT_BYTES", "1000")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	result, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "echo small", Timeout: 5})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.Truncated {
+		t.Fatal("small output must not be marked truncated")
+	}
+	stdout, err := os.ReadFile(result.StdoutPath)
+	if err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	if string(stdout) != "small\n" {
+		t.Fatalf("stdout = %q, want %q", stdout, "small\n")
+	}
+}
+
+func TestMaxOutputBytesResolution(t *testing.T) {
+	if got := maxOutputBytes(0); got != defaultMaxOutputBytes {
+		t.Fatalf("default limit = %d, want %d", got, defaultMaxOutputBytes)
+	}
+	t.Setenv("AGENT_MAX_OUTPUT_BYTES", "2048")
+	if got := maxOutputBytes(0); got != 2048 {
+		t.Fatalf("env limit = %d, want 2048", got)
+	}
+	if got := maxOutputBytes(512); got != 512 {
+		t.Fatalf("explicit limit = %d, want 512", got)
+	}
+}
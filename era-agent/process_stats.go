@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicksPerSecond is the USER_HZ value used by /proc accounting on Linux.
+// It is 100 on every mainstream architecture.
+const clockTicksPerSecond = 100
+
+// findProcessStats locates the first host process whose argv satisfies match and
+// reports its resource usage. It returns errVMNotRunning when no process matches.
+func findProcessStats(ctx context.Context, match func(args []string) bool) (VMStats, error) {
+	if runtime.GOOS == "linux" {
+		return findProcStats(match)
+	}
+	return findPSStats(ctx, match)
+}
+
+func findProcStats(match func(args []string) bool) (VMStats, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return VMStats{}, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil || len(raw) == 0 {
+			continue
+		}
+		args := strings.Split(strings.TrimRight(string(raw), "\x00"), "\x00")
+		if !match(args) {
+			continue
+		}
+		return readProcStats(pid)
+	}
+
+	return VMStats{}, errVMNotRunning
+}
+
+func readProcStats(pid int) (VMStats, error) {
+	procDir := filepath.Join("/proc", strconv.Itoa(pid))
+
+	statRaw, err := os.ReadFile(filepath.Join(procDir, "stat"))
+	if err != nil {
+		return VMStats{}, err
+	}
+	// The command name is wrapped in parentheses and may contain spaces, so
+	// parse the fields after the last closing parenthesis.
+	closing := bytes.LastIndexByte(statRaw, ')')
+	if closing < 0 {
+		return VMStats{}, fmt.Errorf("unexpected stat format for pid %d", pid)
+	}
+	fields := strings.Fields(string(statRaw[closing+1:]))
+	if len(fields) < 20 {
+		return VMStats{}, fmt.Errorf("unexpected stat format for pid %d", pid)
+	}
+	utime, _ := strconv.ParseFloat(fields[11], 64)
+	stime, _ := strconv.ParseFloat(fields[12], 64)
+	startTicks, _ := strconv.ParseFloat(fields[19], 64)
+
+	uptimeRaw, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return VMStats{}, err
+	}
+	uptimeFields := strings.Fields(string(uptimeRaw))
+	if len(uptimeFields) == 0 {
+		return VMStats{}, errors.New("unexpected /proc/uptime format")
+	}
+	systemUptime, _ := strconv.ParseFloat(uptimeFields[0], 64)
+
+	elapsed := systemUptime - startTicks/clockTicksPerSecond
+	stats := VMStats{
+		PID:    pid,
+		Uptime: time.Duration(elapsed * float64(time.Second)),
+	}
+	if elapsed > 0 {
+		stats.CPUPercent = (utime + stime) / clockTicksPerSecond / elapsed * 100
+	}
+
+	status, err := os.Open(filepath.Join(procDir, "status"))
+	if err != nil {
+		return VMStats{}, err
+	}
+	defer status.Close()
+
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) >= 2 {
+			rssKiB, _ := strconv.ParseFloat(parts[1], 64)
+			stats.MemoryMiB = rssKiB / 1024
+		}
+		break
+	}
+
+	return stats, scanner.Err()
+}
+
+func findPSStats(ctx context.Context, match func(args []string) bool) (VMStats, error) {
+	output, err := exec.CommandContext(ctx, "ps", "-axo", "pid=,etime=,%cpu=,rss=,command=").Output()
+	if err != nil {
+		return VMStats{}, err
+	}
+	return parsePSStats(output, match)
+}
+
+// parsePSStats reads `ps -o pid=,etime=,%cpu=,rss=,command=` output and
+// reports the first process whose command satisfies match.
+func parsePSStats(output []byte, match func(args []string) bool) (VMStats, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !match(fields[4:]) {
+			continue
+		}
+
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		uptime, err := parsePSElapsed(fields[1])
+		if err != nil {
+			return VMStats{}, err
+		}
+		cpu, _ := strconv.ParseFloat(fields[2], 64)
+		rssKiB, _ := strconv.ParseFloat(fields[3], 64)
+
+		return VMStats{
+			PID:        pid,
+			CPUPercent: cpu,
+			MemoryMiB:  rssKiB / 1024,
+			Uptime:     uptime,
+		}, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return VMStats{}, err
+	}
+
+	return VMStats{}, errVMNotRunning
+}
+
+// parsePSElapsed parses the ps etime format: [[dd-]hh:]mm:ss.
+func parsePSElapsed(raw string) (time.Duration, error) {
+	var days int
+	if idx := strings.Index(raw, "-"); idx >= 0 {
+		d, err := strconv.Atoi(raw[:idx])
+		if err != nil {
+			return 0, fmt.Errorf("invalid elapsed time %q", raw)
+		}
+		days = d
+		raw = raw[idx+1:]
+	}
+
+	parts := strings.Split(raw, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid elapsed time %q", raw)
+	}
+
+	seconds := 0
+	for _, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, fmt.Errorf("invalid elapsed time %q", raw)
+		}
+		seconds = seconds*60 + value
+	}
+
+	return time.Duration(days)*24*time.Hour + time.Duration(seconds)*time.Second, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePSElapsed(t *testing.T) {
+	for raw, want := range map[string]time.Duration{
+		"00:07":       7 * time.Second,
+		"12:34":       12*time.Minute + 34*time.Second,
+		"01:02:03":    time.Hour + 2*time.Minute + 3*time.Second,
+		"2-03:04:05":  2*24*time.Hour + 3*time.Hour + 4*time.Minute + 5*time.Second,
+		"10-00:00:00": 10 * 24 * time.Hour,
+	} {
+		got, err := parsePSElapsed(raw)
+		if err != nil || got != want {
+			t.Errorf("parsePSElapsed(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"", "7", "1:2:3:4", "aa:bb", "x-01:02"} {
+		if _, err := parsePSElapsed(raw); err == nil {
+			t.Errorf("parsePSElapsed(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestParsePSStats(t *testing.T) {
+	output := []byte(`    1 1-02:00:00  0.0   1024 /sbin/launchd
+  412      05:10 12.5  20480 /opt/homebrew/bin/krunvm start python-1 /bin/bash -c true
+  413      00:01  0.1   2048 /opt/homebrew/bin/krunvm start python-2 /bin/bash -c true
+`)
+	launcher := &krunVMLauncher{binary: "/opt/homebrew/bin/krunvm"}
+
+	stats, err := parsePSStats(output, launcher.isStartProcess("python-1"))
+	if err != nil {
+		t.Fatalf("parsePSStats: %v", err)
+	}
+	want := VMStats{PID: 412, CPUPercent: 12.5, MemoryMiB: 20, Uptime: 5*time.Minute + 10*time.Second}
+	if stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+
+	if _, err := parsePSStats(output, launcher.isStartProcess("python-3")); !errors.Is(err, errVMNotRunning) {
+		t.Fatalf("missing vm: err = %v, want errVMNotRunning", err)
+	}
+	if _, err := parsePSStats([]byte("  9 bogus 1.0 10 krunvm start python-1\n"), launcher.isStartProcess("python-1")); err == nil {
+		t.Fatal("bad etime parsed without an error")
+	}
+}
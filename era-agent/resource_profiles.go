@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// resourceProfile is the CPU and memory a VM gets when its create request
+// leaves them at zero.
+type resourceProfile struct {
+	CPUCount  int `json:"cpu"`
+	MemoryMiB int `json:"memory"`
+}
+
+// fallbackResourceProfile sizes languages without a profile of their own.
+var fallbackResourceProfile = resourceProfile{CPUCount: 1, MemoryMiB: 256}
+
+// defaultResourceProfiles gives compiled runtimes room to build; Go's
+// toolchain does not fit a module build in 256 MiB.
+var defaultResourceProfiles = map[string]resourceProfile{
+	"go":     {CPUCount: 2, MemoryMiB: 1024},
+	"golang": {CPUCount: 2, MemoryMiB: 1024},
+}
+
+// loadResourceProfiles merges the JSON file named by AGENT_PROFILES over the
+// defaults. The file maps languages to {"cpu": N, "memory": MiB}; a field
+// left out keeps the default. Like the image config, an unreadable or
+// malformed file is logged and ignored.
+func loadResourceProfiles(logger *Logger) map[string]resourceProfile {
+	profiles := make(map[string]resourceProfile, len(defaultResourceProfiles))
+	for language, profile := range defaultResourceProfiles {
+		profiles[language] = profile
+	}
+
+	path := strings.TrimSpace(os.Getenv("AGENT_PROFILES"))
+	if path == "" {
+		return profiles
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		logger.Warn("ignoring resource profiles", map[string]any{"path": path, "error": err.Error()})
+		return profiles
+	}
+	overrides, err := parseResourceProfiles(raw)
+	if err != nil {
+		logger.Warn("ignoring malformed resource profiles", map[string]any{"path": path, "error": err.Error()})
+		return profiles
+	}
+	for language, override := range overrides {
+		profile, ok := profiles[language]
+		if !ok {
+			profile = fallbackResourceProfile
+		}
+		if override.CPUCount > 0 {
+			profile.CPUCount = override.CPUCount
+		}
+		if override.MemoryMiB > 0 {
+			profile.MemoryMiB = override.MemoryMiB
+		}
+		profiles[language] = profile
+	}
+	return profiles
+}
+
+func parseResourceProfiles(raw []byte) (map[string]resourceProfile, error) {
+	var decoded map[string]resourceProfile
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]resourceProfile, len(decoded))
+	for language, profile := range decoded {
+		key := normalizeLanguage(language)
+		if key == "" {
+			return nil, errors.New("language names cannot be empty")
+		}
+		if profile.CPUCount < 0 || profile.MemoryMiB < 0 {
+			return nil, fmt.Errorf("language %q has a negative cpu or memory", language)
+		}
+		profiles[key] = profile
+	}
+	return profiles, nil
+}
+
+// resourceProfileFor returns the profile for a normalized language, using the
+// built-in defaults when the service was set up without loaded profiles.
+func (s *VMService) resourceProfileFor(language string) resourceProfile {
+	if profile, ok := s.profiles[language]; ok {
+		return profile
+	}
+	if profile, ok := defaultResourceProfiles[language]; ok {
+		return profile
+	}
+	return fallbackResourceProfile
+}
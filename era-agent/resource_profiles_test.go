@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateUsesLanguageResourceProfile(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	create := func(opts VMCreateOptions) VMRecord {
+		t.Helper()
+		opts.NetworkMode = "none"
+		record, err := svc.Create(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("create %s: %v", opts.Language, err)
+		}
+		return record
+	}
+
+	if record := create(VMCreateOptions{Language: "go"}); record.CPUCount != 2 || record.MemoryMiB != 1024 {
+		t.Fatalf("go with unset resources = %d cpu / %d MiB, want the 2 / 1024 profile", record.CPUCount, record.MemoryMiB)
+	}
+	if record := create(VMCreateOptions{Language: "python"}); record.CPUCount != 1 || record.MemoryMiB != 256 {
+		t.Fatalf("python with unset resources = %d cpu / %d MiB, want 1 / 256", record.CPUCount, record.MemoryMiB)
+	}
+	if record := create(VMCreateOptions{Language: "go", CPUCount: 1, MemoryMiB: 512}); record.CPUCount != 1 || record.MemoryMiB != 512 {
+		t.Fatalf("explicit go resources = %d cpu / %d MiB, want 1 / 512", record.CPUCount, record.MemoryMiB)
+	}
+	// A field left at zero still takes the profile.
+	if record := create(VMCreateOptions{Language: "golang", CPUCount: 4}); record.CPUCount != 4 || record.MemoryMiB != 1024 {
+		t.Fatalf("partial go resources = %d cpu / %d MiB, want 4 / 1024", record.CPUCount, record.MemoryMiB)
+	}
+}
+
+func TestLoadResourceProfilesOverrides(t *testing.T) {
+	logger, err := NewLogger("error", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	if err := os.WriteFile(path, []byte(`{"Go": {"memory": 2048}, "ruby": {"cpu": 2}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AGENT_PROFILES", path)
+
+	profiles := loadResourceProfiles(logger)
+	if got := profiles["go"]; got != (resourceProfile{CPUCount: 2, MemoryMiB: 2048}) {
+		t.Errorf("go profile = %+v, want the default cpu with 2048 MiB", got)
+	}
+	if got := profiles["ruby"]; got != (resourceProfile{CPUCount: 2, MemoryMiB: 256}) {
+		t.Errorf("ruby profile = %+v, want 2 cpu over the fallback memory", got)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"go": {"cpu": -1}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := loadResourceProfiles(logger)["go"]; got != defaultResourceProfiles["go"] {
+		t.Errorf("malformed profiles were applied: go = %+v", got)
+	}
+}
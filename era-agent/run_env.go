@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateRunEnvs rejects names a shell could not export.
+func validateRunEnvs(envs map[string]string) error {
+	for name := range envs {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid env name %q: use letters, digits and '_', not starting with a digit", name)
+		}
+	}
+	return nil
+}
+
+// envExportCommand prefixes command with an export per variable, in name
+// order so the guest command is reproducible.
+func envExportCommand(envs map[string]string, command string) string {
+	names := make([]string, 0, len(envs))
+	for name := range envs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&builder, "export %s=%s\n", name, shellQuote(envs[name]))
+	}
+	builder.WriteString(command)
+	return builder.String()
+}
+
+// parseEnvAssignment splits one KEY=VALUE entry. The value is kept as is,
+// including any '=' it contains.
+func parseEnvAssignment(raw string) (string, string, error) {
+	name, value, ok := strings.Cut(raw, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid env %q: want KEY=VALUE", raw)
+	}
+	if !envNamePattern.MatchString(name) {
+		return "", "", fmt.Errorf("invalid env name %q: use letters, digits and '_', not starting with a digit", name)
+	}
+	return name, value, nil
+}
+
+// parseEnvFile reads KEY=VALUE lines, skipping blank lines and lines starting
+// with '#'. A value wrapped in matching single or double quotes is unwrapped.
+func parseEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	envs := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, err := parseEnvAssignment(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		envs[name] = unquoteEnvValue(strings.TrimSpace(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+func unquoteEnvValue(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// runEnvsFromFlags merges --env-file with repeated --env flags; a flag wins
+// over the file, and a later flag over an earlier one.
+func runEnvsFromFlags(envFile string, envFlags []string) (map[string]string, error) {
+	envs := make(map[string]string)
+	if envFile != "" {
+		fileEnvs, err := parseEnvFile(envFile)
+		if err != nil {
+			return nil, fmt.Errorf("read --env-file: %w", err)
+		}
+		for name, value := range fileEnvs {
+			envs[name] = value
+		}
+	}
+	for _, raw := range envFlags {
+		name, value, err := parseEnvAssignment(raw)
+		if err != nil {
+			return nil, err
+		}
+		envs[name] = value
+	}
+	if len(envs) == 0 {
+		return nil, nil
+	}
+	return envs, nil
+}
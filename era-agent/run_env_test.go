@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeEnvFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "run.env")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write env file: %v", err)
+	}
+	return path
+}
+
+func TestParseEnvFile(t *testing.T) {
+	path := writeEnvFile(t, "# settings\n\nMODE=test\n  URL = https://x.test/?a=b  \nQUOTED=\"two words\"\nSINGLE='it'\nEMPTY=\n")
+	envs, err := parseEnvFile(path)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := map[string]string{
+		"MODE":   "test",
+		"URL":    "https://x.test/?a=b",
+		"QUOTED": "two words",
+		"SINGLE": "it",
+		"EMPTY":  "",
+	}
+	if !reflect.DeepEqual(envs, want) {
+		t.Fatalf("envs = %v, want %v", envs, want)
+	}
+}
+
+func TestParseEnvFileRejectsMalformedLines(t *testing.T) {
+	for _, content := range []string{"NOVALUE\n", "=value\n", "1BAD=x\n", "BAD NAME=x\n"} {
+		if _, err := parseEnvFile(writeEnvFile(t, content)); err == nil {
+			t.Errorf("parseEnvFile accepted %q", content)
+		}
+	}
+	if _, err := parseEnvFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("parseEnvFile accepted a missing file")
+	}
+}
+
+func TestRunEnvsFromFlags(t *testing.T) {
+	path := writeEnvFile(t, "MODE=file\nKEEP=1\n")
+	envs, err := runEnvsFromFlags(path, []string{"MODE=flag", "EXTRA=a=b", "EXTRA=last"})
+	if err != nil {
+		t.Fatalf("runEnvsFromFlags: %v", err)
+	}
+	want := map[string]string{"MODE": "flag", "KEEP": "1", "EXTRA": "last"}
+	if !reflect.DeepEqual(envs, want) {
+		t.Fatalf("envs = %v, want %v", envs, want)
+	}
+
+	if envs, err := runEnvsFromFlags("", nil); err != nil || envs != nil {
+		t.Fatalf("no flags = %v, %v; want nil", envs, err)
+	}
+	for _, raw := range []string{"NOEQUALS", "=x", "A-B=x"} {
+		if _, err := runEnvsFromFlags("", []string{raw}); err == nil {
+			t.Errorf("--env %q accepted", raw)
+		}
+	}
+}
+
+func TestRunExportsEnvs(t *testing.T) {
+	launcher := newFakeLauncher()
+	var command string
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		command = opts.Command
+		return 0, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	_, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "env",
+		Timeout: 5,
+		Envs:    map[string]string{"B": "it's", "A": "1"},
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if !strings.Contains(command, "export A='1'\nexport B='it'\"'\"'s'\nenv") {
+		t.Fatalf("guest command = %q, want sorted exports before the command", command)
+	}
+
+	_, err = svc.Run(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "env",
+		Timeout: 5,
+		Envs:    map[string]string{"BAD NAME": "x"},
+	})
+	if err == nil {
+		t.Fatal("run accepted an invalid env name")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// maxRunHistory bounds the run history kept in the state database; the
+	// oldest entries are dropped once it is exceeded.
+	maxRunHistory = 1000
+	// maxRunHistoryPerVM keeps one busy VM from pushing every other VM's
+	// runs out of the history.
+	maxRunHistoryPerVM = 100
+
+	defaultRecentRuns = 20
+)
+
+var runBucket = []byte("runs")
+
+// RunHistoryEntry is one finished run as kept in the run history.
+type RunHistoryEntry struct {
+	VMID      string        `json:"vm_id"`
+	Tenant    string        `json:"tenant,omitempty"`
+	Command   string        `json:"command"`
+	ExitCode  int           `json:"exit_code"`
+	Status    string        `json:"status"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration"`
+	Truncated bool          `json:"truncated,omitempty"`
+}
+
+// runHistoryKey orders entries by start time across all VMs; the VM ID keeps
+// keys unique when two runs start in the same nanosecond.
+func runHistoryKey(entry RunHistoryEntry) []byte {
+	key := make([]byte, 8, 8+len(entry.VMID))
+	binary.BigEndian.PutUint64(key, uint64(entry.StartedAt.UnixNano()))
+	return append(key, entry.VMID...)
+}
+
+// SaveRun appends entry, keeping at most maxRunHistoryPerVM entries per VM
+// and maxRunHistory overall.
+func (s *BoltVMStore) SaveRun(entry RunHistoryEntry) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(runBucket)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(runHistoryKey(entry), payload); err != nil {
+			return err
+		}
+
+		// Keys sort oldest first, so the VM's surplus is the head of its keys.
+		var vmKeys [][]byte
+		cursor := bucket.Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			if string(k[8:]) == entry.VMID {
+				vmKeys = append(vmKeys, append([]byte(nil), k...))
+			}
+		}
+		for i := 0; i < len(vmKeys)-maxRunHistoryPerVM; i++ {
+			if err := bucket.Delete(vmKeys[i]); err != nil {
+				return err
+			}
+		}
+
+		excess := bucket.Stats().KeyN - maxRunHistory
+		cursor = bucket.Cursor()
+		for k, _ := cursor.First(); k != nil && excess > 0; k, _ = cursor.First() {
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			excess--
+		}
+		return nil
+	})
+}
+
+// RecentRuns returns up to limit of the tenant's entries, newest first. An
+// empty vmID matches runs on every VM of the tenant.
+func (s *BoltVMStore) RecentRuns(tenant, vmID string, limit int) ([]RunHistoryEntry, error) {
+	if s == nil || s.db == nil {
+		return nil, errPersist
+	}
+
+	entries := []RunHistoryEntry{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runBucket)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.Last(); k != nil && len(entries) < limit; k, v = cursor.Prev() {
+			if vmID != "" && string(k[8:]) != vmID {
+				continue
+			}
+			var entry RunHistoryEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			if normalizeTenant(entry.Tenant) != normalizeTenant(tenant) {
+				continue
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// RecentRuns lists a tenant's most recent runs, newest first, optionally
+// restricted to one VM. A non-positive limit selects the default.
+func (s *VMService) RecentRuns(tenant, vmID string, limit int) ([]RunHistoryEntry, error) {
+	if limit <= 0 {
+		limit = defaultRecentRuns
+	}
+	if limit > maxRunHistory {
+		limit = maxRunHistory
+	}
+	return s.store.RecentRuns(tenant, vmID, limit)
+}
+
+// recordRun appends a finished run to the history. Runs rejected before
+// reaching the guest are not recorded.
+func (s *VMService) recordRun(record VMRecord, opts VMRunOptions, result VMRunResult, err error, started time.Time, duration time.Duration) {
+	if err != nil && result.StdoutPath == "" {
+		return
+	}
+
+	command := opts.Command
+	if len(opts.Commands) > 0 {
+		command = sequenceDisplay(opts.Commands, opts.ContinueOnError)
+	} else if command == "" && opts.File != "" {
+		command, _ = buildExecutionCommand(record.Language, record.Storage.guestIn(), opts.File)
+	}
+
+	status := "ok"
+	switch {
+	case result.Aborted:
+		status = "aborted"
+	case result.TimedOut:
+		status = "timeout"
+	case err != nil || result.ExitCode != 0:
+		status = "failed"
+	}
+
+	entry := RunHistoryEntry{
+		VMID:      record.ID,
+		Tenant:    record.Tenant,
+		Command:   command,
+		ExitCode:  result.ExitCode,
+		Status:    status,
+		StartedAt: started.UTC(),
+		Duration:  duration,
+		Truncated: result.Truncated,
+	}
+	s.saveRunHistory(entry)
+}
+
+// saveRunHistory stores entry, logging rather than failing the run when the
+// history cannot be written.
+func (s *VMService) saveRunHistory(entry RunHistoryEntry) {
+	if err := s.store.SaveRun(entry); err != nil {
+		s.logger.Warn("failed to record run history", map[string]any{
+			"vm":    entry.VMID,
+			"error": err.Error(),
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// defaultTenant owns VMs created without a tenant: everything made through
+// the CLI, API calls authenticated with a plain key, and every VM when
+// authentication is off.
+const defaultTenant = "default"
+
+func normalizeTenant(tenant string) string {
+	if tenant = strings.TrimSpace(tenant); tenant == "" {
+		return defaultTenant
+	}
+	return tenant
+}
+
+// ListTenant is List restricted to the VMs of one tenant.
+func (s *VMService) ListTenant(ctx context.Context, tenant string) ([]VMRecord, error) {
+	records, err := s.List(ctx)
+	tenant = normalizeTenant(tenant)
+	scoped := make([]VMRecord, 0, len(records))
+	for _, record := range records {
+		if normalizeTenant(record.Tenant) == tenant {
+			scoped = append(scoped, record)
+		}
+	}
+	return scoped, err
+}
+
+// GetInTenant is Get for one tenant. A VM owned by another tenant is reported
+// as missing, so its existence is not revealed.
+func (s *VMService) GetInTenant(tenant, vmID string) (VMRecord, bool) {
+	record, ok := s.Get(vmID)
+	if !ok || normalizeTenant(record.Tenant) != normalizeTenant(tenant) {
+		return VMRecord{}, false
+	}
+	return record, true
+}
+
+type tenantContextKey struct{}
+
+// requestTenant returns the tenant bound to the request's API key by the auth
+// middleware.
+func requestTenant(r *http.Request) string {
+	if tenant, ok := r.Context().Value(tenantContextKey{}).(string); ok {
+		return tenant
+	}
+	return defaultTenant
+}
+
+func withTenant(r *http.Request, tenant string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, normalizeTenant(tenant)))
+}
+
+// ownsVM reports whether the VM exists and belongs to the request's tenant.
+func (api *APIServer) ownsVM(r *http.Request, vmID string) bool {
+	_, ok := api.vmService.GetInTenant(requestTenant(r), vmID)
+	return ok
+}
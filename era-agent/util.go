@@ -2,8 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -35,3 +37,32 @@ func sanitizeID(raw string) string {
 	clean := whitespace.ReplaceAllString(strings.TrimSpace(raw), "-")
 	return strings.ToLower(clean)
 }
+
+// parsePortMapping parses a "host:guest" port pair such as "8080:80".
+func parsePortMapping(raw string) (PortMapping, error) {
+	hostRaw, guestRaw, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	if !ok {
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q: expected host:guest", raw)
+	}
+	hostPort, err := strconv.Atoi(strings.TrimSpace(hostRaw))
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid host port in %q", raw)
+	}
+	guestPort, err := strconv.Atoi(strings.TrimSpace(guestRaw))
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid guest port in %q", raw)
+	}
+	return PortMapping{HostPort: hostPort, GuestPort: guestPort}, nil
+}
+
+func parsePortMappings(raw []string) ([]PortMapping, error) {
+	ports := make([]PortMapping, 0, len(raw))
+	for _, entry := range raw {
+		port, err := parsePortMapping(entry)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
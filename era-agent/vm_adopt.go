@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// adoptableVMID matches the IDs defaultVMID generates, "<language>-<unixnano>-
+// <random>", so Adopt leaves alone VMs other tools made with the same
+// launcher, such as unrelated krunvm VMs on the host. The group is the
+// language.
+var adoptableVMID = regexp.MustCompile(`^(.+)-[0-9]+-[0-9a-f]{6}$`)
+
+// adoptOnStartEnabled reports whether AGENT_ADOPT_ON_START asks NewVMService
+// to adopt orphaned launcher VMs.
+func adoptOnStartEnabled() bool {
+	enabled, err := strconv.ParseBool(strings.TrimSpace(os.Getenv("AGENT_ADOPT_ON_START")))
+	return err == nil && enabled
+}
+
+// Adopt creates records for VMs the launcher reports but the state database
+// does not know, for example after the Bolt file was lost, so they can be
+// listed, run and cleaned again. Only VMs with an ERA-generated ID are
+// adopted. Their language is taken from the ID and their image is that
+// language's default, since launchers cannot report the original; a VM
+// whose language has no image is skipped. Adopted records are otherwise
+// minimal: ready, non-persistent and owned by the default tenant.
+func (s *VMService) Adopt(ctx context.Context) ([]VMRecord, error) {
+	ids, err := s.listLauncherVMs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list launcher vms: %w", err)
+	}
+	sort.Strings(ids)
+
+	adopted := make([]VMRecord, 0)
+	for _, id := range ids {
+		s.mu.RLock()
+		_, known := s.cache[id]
+		s.mu.RUnlock()
+		if known {
+			continue
+		}
+		match := adoptableVMID.FindStringSubmatch(id)
+		if match == nil {
+			continue
+		}
+		language := match[1]
+		images, err := s.resolveRootFSCandidates(language, "")
+		if err != nil || len(images) == 0 {
+			s.logger.Warn("not adopting vm without a known image", map[string]any{"vm": id, "language": language})
+			continue
+		}
+
+		layout, err := prepareStorage(id, false)
+		if err != nil {
+			return adopted, fmt.Errorf("prepare storage for %s: %w", id, err)
+		}
+		record := VMRecord{
+			ID:          id,
+			Language:    language,
+			RootFSImage: images[0],
+			Status:      VMStatusReady,
+			Storage:     layout,
+			CreatedAt:   s.clock().UTC(),
+			Tenant:      defaultTenant,
+		}
+		if err := s.store.Save(record); err != nil {
+			return adopted, err
+		}
+
+		s.mu.Lock()
+		s.cacheRecord(record)
+		s.mu.Unlock()
+
+		s.logger.Info("adopted orphaned vm", map[string]any{"vm": id})
+		adopted = append(adopted, record)
+	}
+	return adopted, nil
+}
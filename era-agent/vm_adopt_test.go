@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAdoptRecordsOrphanedLauncherVMs(t *testing.T) {
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	known := createTestVM(t, svc)
+
+	const orphan = "python-1736942400000000000-a1b2c3"
+	launcher.mu.Lock()
+	for _, id := range []string{orphan, "someone-elses-vm", "cobol-1736942400000000000-a1b2c3"} {
+		launcher.vms[id] = VMRecord{ID: id}
+	}
+	launcher.mu.Unlock()
+
+	adopted, err := svc.Adopt(context.Background())
+	if err != nil {
+		t.Fatalf("Adopt: %v", err)
+	}
+	if len(adopted) != 1 || adopted[0].ID != orphan {
+		t.Fatalf("adopted %v, want only %s (known vm %s)", adopted, orphan, known.ID)
+	}
+
+	stored, err := svc.store.Get(orphan)
+	if err != nil {
+		t.Fatalf("adopted vm not saved: %v", err)
+	}
+	if stored.Status != VMStatusReady || stored.Language != "python" || stored.Tenant != defaultTenant {
+		t.Fatalf("stored record = %+v, want ready/python/%s", stored, defaultTenant)
+	}
+	if stored.RootFSImage != "docker.io/library/python:3.11-slim" {
+		t.Fatalf("RootFSImage = %q, want the python default", stored.RootFSImage)
+	}
+	if _, ok := svc.Get(orphan); !ok {
+		t.Fatalf("adopted vm missing from service cache")
+	}
+
+	again, err := svc.Adopt(context.Background())
+	if err != nil {
+		t.Fatalf("second Adopt: %v", err)
+	}
+	if len(again) != 0 {
+		t.Fatalf("second Adopt adopted %v, want none", again)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// CloneOptions adjusts a clone relative to its source VM.
+type CloneOptions struct {
+	// CPUCount and MemoryMiB override the source's resources when non-zero.
+	CPUCount  int
+	MemoryMiB int
+	// Labels are merged over the source's labels.
+	Labels map[string]string
+}
+
+// Clone creates and launches a new VM with the language, rootfs, resources
+// and volumes of a persistent source VM, then copies the source's persist
+// directory into the clone's. Host ports are not cloned, since the source
+// still holds them.
+func (s *VMService) Clone(ctx context.Context, srcID string, opts CloneOptions) (VMRecord, error) {
+	src, err := s.fetchRecord(srcID)
+	if err != nil {
+		return VMRecord{}, err
+	}
+	if !src.Persist || src.Storage.PersistPath == "" {
+		return VMRecord{}, fmt.Errorf("clone %s: %w", srcID, errVMNotPersistent)
+	}
+	if err := validateLabels(opts.Labels); err != nil {
+		return VMRecord{}, err
+	}
+
+	labels := make(map[string]string, len(src.Labels)+len(opts.Labels))
+	for key, value := range src.Labels {
+		labels[key] = value
+	}
+	for key, value := range opts.Labels {
+		labels[key] = value
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+
+	createOpts := VMCreateOptions{
+		Language:    src.Language,
+		Image:       src.RootFSImage,
+		CPUCount:    src.CPUCount,
+		MemoryMiB:   src.MemoryMiB,
+		NetworkMode: src.NetworkMode,
+		Persist:     true,
+		PullPolicy:  src.PullPolicy,
+
+		ExpirePersistent: src.ExpirePersistent,
+		Volumes:          src.Storage.Volumes,
+		GuestMounts:      src.Storage.GuestMounts,
+		GuestWorkdir:     src.Storage.GuestWorkdir,
+		Owner:            src.Owner,
+		Labels:           labels,
+		Tenant:           src.Tenant,
+	}
+	if opts.CPUCount > 0 {
+		createOpts.CPUCount = opts.CPUCount
+	}
+	if opts.MemoryMiB > 0 {
+		createOpts.MemoryMiB = opts.MemoryMiB
+	}
+	if !src.ExpiresAt.IsZero() {
+		createOpts.TTL = src.ExpiresAt.Sub(src.CreatedAt)
+	}
+
+	clone, err := s.Create(ctx, createOpts)
+	if err != nil {
+		return VMRecord{}, err
+	}
+
+	// Hold the source's run lock so the copy does not race a run writing
+	// to the persist directory.
+	unlock := s.lockVM(src.ID)
+	_, copyErr := copyTree(src.Storage.PersistPath, clone.Storage.PersistPath, nil)
+	unlock()
+	if copyErr != nil {
+		if err := s.Clean(context.Background(), clone.ID, false); err != nil {
+			copyErr = errors.Join(copyErr, fmt.Errorf("clean clone %s: %w", clone.ID, err))
+		}
+		return VMRecord{}, fmt.Errorf("copy persist dir of %s: %w", src.ID, copyErr)
+	}
+
+	loggerFor(ctx, s.logger).Info("vm cloned", map[string]any{
+		"id":     clone.ID,
+		"source": src.ID,
+	})
+	return clone, nil
+}
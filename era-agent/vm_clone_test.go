@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloneCopiesPersistDir(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	src, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Persist:     true,
+		Labels:      map[string]string{"env": "ci"},
+	})
+	if err != nil {
+		t.Fatalf("create source: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(src.Storage.PersistPath, "data"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(src.Storage.PersistPath, "data", "model.pkl"), []byte("weights"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	clone, err := svc.Clone(context.Background(), src.ID, CloneOptions{MemoryMiB: 512, Labels: map[string]string{"fork": "yes"}})
+	if err != nil {
+		t.Fatalf("clone: %v", err)
+	}
+	if clone.ID == src.ID || clone.Storage.PersistPath == src.Storage.PersistPath {
+		t.Fatalf("clone shares identity with source: %+v", clone)
+	}
+	if clone.Language != src.Language || clone.RootFSImage != src.RootFSImage || clone.CPUCount != 1 || clone.MemoryMiB != 512 || !clone.Persist {
+		t.Fatalf("clone settings = %+v", clone)
+	}
+	if clone.Labels["env"] != "ci" || clone.Labels["fork"] != "yes" {
+		t.Fatalf("clone labels = %v, want source labels plus overrides", clone.Labels)
+	}
+	if clone.Status != VMStatusReady {
+		t.Fatalf("clone status = %s, want launched", clone.Status)
+	}
+
+	data, err := os.ReadFile(filepath.Join(clone.Storage.PersistPath, "data", "model.pkl"))
+	if err != nil || string(data) != "weights" {
+		t.Fatalf("cloned file = %q, %v", data, err)
+	}
+
+	// The copies are independent.
+	if err := os.WriteFile(filepath.Join(clone.Storage.PersistPath, "data", "model.pkl"), []byte("changed"), 0o644); err != nil {
+		t.Fatalf("write clone: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(src.Storage.PersistPath, "data", "model.pkl")); string(data) != "weights" {
+		t.Fatalf("writing the clone changed the source: %q", data)
+	}
+}
+
+func TestCloneRejectsNonPersistentVM(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	if _, err := svc.Clone(context.Background(), record.ID, CloneOptions{}); !errors.Is(err, errVMNotPersistent) {
+		t.Fatalf("clone of non-persistent vm err = %v, want errVMNotPersistent", err)
+	}
+	if _, err := svc.Clone(context.Background(), "missing", CloneOptions{}); !errors.Is(err, errVMNotFound) {
+		t.Fatalf("clone of unknown vm err = %v, want errVMNotFound", err)
+	}
+}
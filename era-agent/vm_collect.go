@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// StreamEvent is a chunk of run output as the guest wrote it.
+type StreamEvent struct {
+	// Seq numbers the events of one run from 1, across both streams.
+	Seq    int
+	Stream string // "stdout" or "stderr"
+	Data   string
+}
+
+// RunCollect runs a command like Run and also hands each chunk of its output
+// to onEvent as it is captured. onEvent is never called concurrently, and
+// sees exactly the bytes written to the result's StdoutPath and StderrPath
+// (after the MaxOutputBytes cap); an auto-install retry replays from the
+// start. onEvent runs on the output path, so it should not block.
+func (s *VMService) RunCollect(ctx context.Context, opts VMRunOptions, onEvent func(StreamEvent)) (VMRunResult, error) {
+	opts.onEvent = onEvent
+	return s.Run(ctx, opts)
+}
+
+// eventTaps turns writes on several streams into one ordered StreamEvent
+// sequence.
+type eventTaps struct {
+	mu      sync.Mutex
+	seq     int
+	onEvent func(StreamEvent)
+}
+
+func (t *eventTaps) writer(stream string) io.Writer {
+	return eventTapWriter{taps: t, stream: stream}
+}
+
+type eventTapWriter struct {
+	taps   *eventTaps
+	stream string
+}
+
+func (w eventTapWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.taps.mu.Lock()
+	defer w.taps.mu.Unlock()
+	w.taps.seq++
+	w.taps.onEvent(StreamEvent{Seq: w.taps.seq, Stream: w.stream, Data: string(p)})
+	return len(p), nil
+}
+
+// outputSinks returns where run output goes: the log files, teed into
+// opts.onEvent when RunCollect set one.
+func outputSinks(opts VMRunOptions, stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if opts.onEvent == nil {
+		return stdout, stderr
+	}
+	taps := &eventTaps{onEvent: opts.onEvent}
+	return io.MultiWriter(stdout, taps.writer("stdout")), io.MultiWriter(stderr, taps.writer("stderr"))
+}
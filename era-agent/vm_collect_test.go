@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRunCollectStreamsOrderedEvents(t *testing.T) {
+	launcher := newFakeLauncher()
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(stdout, "out %d\n", i)
+			fmt.Fprintf(stderr, "err %d\n", i)
+		}
+		return 3, nil
+	}
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	var events []StreamEvent
+	result, err := svc.RunCollect(context.Background(), VMRunOptions{VMID: record.ID, Command: "work", Timeout: 5}, func(event StreamEvent) {
+		events = append(events, event)
+	})
+	if err == nil || result.ExitCode != 3 {
+		t.Fatalf("RunCollect = exit %d, err %v; want exit 3 with an error", result.ExitCode, err)
+	}
+
+	var streams []string
+	aggregate := map[string]string{}
+	for i, event := range events {
+		if event.Seq != i+1 {
+			t.Fatalf("event %d has seq %d", i, event.Seq)
+		}
+		streams = append(streams, event.Stream)
+		aggregate[event.Stream] += event.Data
+	}
+	if want := []string{"stdout", "stderr", "stdout", "stderr", "stdout", "stderr"}; !reflect.DeepEqual(streams, want) {
+		t.Fatalf("event streams = %v, want %v", streams, want)
+	}
+
+	for stream, path := range map[string]string{"stdout": result.StdoutPath, "stderr": result.StderrPath} {
+		captured, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read %s: %v", stream, err)
+		}
+		if aggregate[stream] != string(captured) {
+			t.Fatalf("%s events = %q, captured %q", stream, aggregate[stream], captured)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDrainGrace is how long Stop and Clean wait for in-flight runs
+	// before aborting them.
+	defaultDrainGrace = 10 * time.Second
+
+	drainPollInterval = 50 * time.Millisecond
+)
+
+// drainGrace returns AGENT_DRAIN_GRACE (a Go duration such as "30s"; "0"
+// aborts runs at once) or the default.
+func drainGrace() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("AGENT_DRAIN_GRACE"))
+	if raw == "" {
+		return defaultDrainGrace
+	}
+	grace, err := time.ParseDuration(raw)
+	if err != nil || grace < 0 {
+		return defaultDrainGrace
+	}
+	return grace
+}
+
+// drainVM takes the VM's run lock for a stop or clean. In-flight runs get
+// the drain grace period to finish; after it, or at once when force is set,
+// they are aborted, and so are runs queued behind them, until the lock is
+// free. Giving up because ctx ended leaves the runs alone.
+func (s *VMService) drainVM(ctx context.Context, vmID string, force bool) (func(), error) {
+	lock := s.vmLock(vmID)
+	if lock.TryLock() {
+		return lock.Unlock, nil
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	if !force {
+		if grace := drainGrace(); grace > 0 {
+			timer := time.NewTimer(grace)
+			defer timer.Stop()
+		wait:
+			for {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-timer.C:
+					break wait
+				case <-ticker.C:
+					if lock.TryLock() {
+						return lock.Unlock, nil
+					}
+				}
+			}
+		}
+	}
+
+	loggerFor(ctx, s.logger).Info("aborting runs to drain vm", map[string]any{"vm": vmID, "force": force})
+	for {
+		s.cancelRuns(vmID)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+			if lock.TryLock() {
+				return lock.Unlock, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+type drainRunOutcome struct {
+	result VMRunResult
+	err    error
+}
+
+// startBlockingRun starts a run that lasts until release is closed or its
+// context ends, and waits for it to be in flight.
+func startBlockingRun(t *testing.T, svc *VMService, launcher *fakeLauncher, vmID string, release <-chan struct{}) <-chan drainRunOutcome {
+	t.Helper()
+	started := make(chan struct{})
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			return 0, nil
+		case <-ctx.Done():
+			// Killed like a real guest command would be.
+			return -1, &commandError{args: []string{opts.Command}, err: ctx.Err()}
+		}
+	}
+
+	done := make(chan drainRunOutcome, 1)
+	go func() {
+		result, err := svc.Run(context.Background(), VMRunOptions{VMID: vmID, Command: "work", Timeout: 60})
+		done <- drainRunOutcome{result, err}
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run never started")
+	}
+	return done
+}
+
+func TestCleanWaitsForInFlightRun(t *testing.T) {
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	release := make(chan struct{})
+	runDone := startBlockingRun(t, svc, launcher, record.ID, release)
+
+	cleaned := make(chan error, 1)
+	go func() { cleaned <- svc.Clean(context.Background(), record.ID, false) }()
+
+	select {
+	case err := <-cleaned:
+		t.Fatalf("clean returned while the run was in flight: %v", err)
+	case <-time.After(150 * time.Millisecond):
+	}
+	if _, ok := svc.Get(record.ID); !ok {
+		t.Fatal("vm removed while its run was in flight")
+	}
+
+	close(release)
+	if got := <-runDone; got.err != nil || got.result.Aborted {
+		t.Fatalf("run = %+v, %v; want it to finish normally", got.result, got.err)
+	}
+	select {
+	case err := <-cleaned:
+		if err != nil {
+			t.Fatalf("clean: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("clean did not finish after the run")
+	}
+	if _, ok := svc.Get(record.ID); ok {
+		t.Fatal("vm still present after clean")
+	}
+}
+
+func TestCleanAbortsRunAfterGrace(t *testing.T) {
+	t.Setenv("AGENT_DRAIN_GRACE", "100ms")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	runDone := startBlockingRun(t, svc, launcher, record.ID, nil)
+
+	start := time.Now()
+	if err := svc.Clean(context.Background(), record.ID, false); err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("clean took %s, want the 100ms grace and then an abort", elapsed)
+	}
+	got := <-runDone
+	if !errors.Is(got.err, errRunAborted) || !got.result.Aborted {
+		t.Fatalf("run = %+v, %v; want it aborted", got.result, got.err)
+	}
+}
+
+func TestForceStopSkipsGrace(t *testing.T) {
+	t.Setenv("AGENT_DRAIN_GRACE", "1m")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	runDone := startBlockingRun(t, svc, launcher, record.ID, nil)
+
+	start := time.Now()
+	if err := svc.ForceStop(context.Background(), record.ID); err != nil {
+		t.Fatalf("force stop: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("force stop took %s", elapsed)
+	}
+	if got := <-runDone; !got.result.Aborted {
+		t.Fatalf("run = %+v, %v; want it aborted", got.result, got.err)
+	}
+	if current, _ := svc.Get(record.ID); current.Status != VMStatusStopped {
+		t.Fatalf("status = %s, want stopped", current.Status)
+	}
+}
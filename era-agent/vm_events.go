@@ -0,0 +1,113 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// VMEventType names a VM lifecycle event.
+type VMEventType string
+
+const (
+	VMEventCreated     VMEventType = "created"
+	VMEventRunStarted  VMEventType = "run_started"
+	VMEventRunFinished VMEventType = "run_finished"
+	VMEventStopped     VMEventType = "stopped"
+	VMEventCleaned     VMEventType = "cleaned"
+)
+
+// VMEvent is one lifecycle event delivered to Subscribe's channels.
+type VMEvent struct {
+	Type     VMEventType
+	VMID     string
+	Language string
+	Time     time.Time
+	// ExitCode and Err describe the run for VMEventRunFinished.
+	ExitCode int
+	Err      error
+}
+
+// vmEventBuffer is each subscriber's channel capacity; events beyond it are
+// dropped until the subscriber catches up.
+const vmEventBuffer = 64
+
+// vmEventBus fans events out to subscribers. The zero value is ready to use.
+type vmEventBus struct {
+	mu     sync.Mutex
+	nextID uint64
+	subs   map[uint64]chan VMEvent
+	closed bool
+}
+
+// Subscribe returns a channel of lifecycle events for every VM, and a
+// function that unsubscribes and closes the channel. Delivery is at most
+// once: events are sent without blocking, so a subscriber that falls more
+// than vmEventBuffer events behind misses events rather than stalling VM
+// operations. Created, stopped and cleaned are sent once the operation has
+// succeeded; run_started and run_finished bracket every run that reached a
+// known VM, failed ones included. Events for one VM arrive in order, while
+// those for different VMs may interleave.
+// The channel is also closed when the service is closed.
+func (s *VMService) Subscribe() (<-chan VMEvent, func()) {
+	return s.events.subscribe()
+}
+
+func (b *vmEventBus) subscribe() (<-chan VMEvent, func()) {
+	ch := make(chan VMEvent, vmEventBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs == nil {
+		b.subs = make(map[uint64]chan VMEvent)
+	}
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = ch
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if sub, ok := b.subs[id]; ok {
+				delete(b.subs, id)
+				close(sub)
+			}
+		})
+	}
+}
+
+func (b *vmEventBus) publish(event VMEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+			// Full: drop rather than stall the operation.
+		}
+	}
+}
+
+// close ends every subscription.
+func (b *vmEventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for id, ch := range b.subs {
+		delete(b.subs, id)
+		close(ch)
+	}
+}
+
+func (s *VMService) emit(eventType VMEventType, record VMRecord) {
+	s.events.publish(VMEvent{
+		Type:     eventType,
+		VMID:     record.ID,
+		Language: record.Language,
+		Time:     s.clock(),
+	})
+}
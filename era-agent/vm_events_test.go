@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSubscribeLifecycleEvents(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	events, unsubscribe := svc.Subscribe()
+	defer unsubscribe()
+
+	record := createTestVM(t, svc)
+	if _, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "exit 3", Timeout: 5}); err == nil {
+		t.Fatal("run exiting 3 succeeded")
+	}
+	if err := svc.Stop(context.Background(), record.ID); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if err := svc.Clean(context.Background(), record.ID, false); err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+
+	want := []VMEventType{VMEventCreated, VMEventRunStarted, VMEventRunFinished, VMEventStopped, VMEventCleaned}
+	for i, wantType := range want {
+		select {
+		case event := <-events:
+			if event.Type != wantType || event.VMID != record.ID || event.Language != "python" {
+				t.Fatalf("event %d = %+v, want %s for %s", i, event, wantType, record.ID)
+			}
+			if event.Type == VMEventRunFinished && (event.ExitCode != 3 || event.Err == nil) {
+				t.Fatalf("run_finished = %+v, want exit code 3 and the run's error", event)
+			}
+		default:
+			t.Fatalf("got %d events, want %v", i, want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected extra event %+v", event)
+	default:
+	}
+}
+
+func TestSubscribeDropsWhenFull(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	events, unsubscribe := svc.Subscribe()
+
+	// Nobody reads, so publishing past the buffer must not block.
+	for i := 0; i < vmEventBuffer+10; i++ {
+		svc.emit(VMEventCreated, VMRecord{ID: "vm"})
+	}
+	if len(events) != vmEventBuffer {
+		t.Fatalf("buffered %d events, want %d", len(events), vmEventBuffer)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	for range events {
+	}
+	svc.emit(VMEventCleaned, VMRecord{ID: "vm"})
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxArchiveBytes bounds the uncompressed size of an uploaded archive so a
+// small gzip body cannot fill the host disk.
+const maxArchiveBytes = 1 << 30
+
+var (
+	errUnsafeArchivePath   = errors.New("unsafe archive entry")
+	errInvalidArchive      = errors.New("invalid archive")
+	errUploadRangeMismatch = errors.New("upload range does not start at the current file size")
+	errUploadLength        = errors.New("upload body does not match its range")
+)
+
+// ArchiveResult lists what ExtractArchive wrote, as guest paths under the
+// VM's input mount (/in by default).
+type ArchiveResult struct {
+	Paths []string
+	Bytes int64
+}
+
+// ExtractArchive unpacks a tar or gzip-compressed tar stream into the VM's
+// input directory. Entries with absolute paths, ".." components or links are
+// rejected. The archive is unpacked into a staging directory beside the
+// input directory first, so a bad archive leaves the input untouched.
+func (s *VMService) ExtractArchive(vmID string, body io.Reader) (ArchiveResult, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	dest := record.Storage.InputPath
+	if strings.TrimSpace(dest) == "" {
+		return ArchiveResult{}, errors.New("vm has no input directory")
+	}
+	staging, err := os.MkdirTemp(filepath.Dir(dest), "."+record.ID+"-upload-")
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	defer os.RemoveAll(staging)
+
+	result, err := extractTarArchive(staging, body, maxArchiveBytes)
+	if err != nil {
+		return ArchiveResult{}, err
+	}
+	if err := commitStagedTree(staging, dest); err != nil {
+		return ArchiveResult{}, err
+	}
+	for i, rel := range result.Paths {
+		result.Paths[i] = path.Join(record.Storage.guestIn(), rel)
+	}
+	return result, nil
+}
+
+// commitStagedTree moves the tree unpacked in staging into dest, merging it
+// with what dest already holds. If that fails part way, the move is undone:
+// directories it made and files it moved in are removed, and the files it
+// replaced are put back.
+func commitStagedTree(staging, dest string) (err error) {
+	backup, err := os.MkdirTemp(filepath.Dir(staging), filepath.Base(staging)+"-replaced-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(backup)
+
+	var undo []func()
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+	}()
+
+	return filepath.WalkDir(staging, func(src string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(staging, src)
+		if err != nil || rel == "." {
+			return err
+		}
+		if err := rejectSymlinkParents(dest, filepath.ToSlash(rel)); err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		info, statErr := os.Lstat(target)
+		if statErr != nil && !errors.Is(statErr, os.ErrNotExist) {
+			return statErr
+		}
+		exists := statErr == nil
+
+		if entry.IsDir() {
+			if exists {
+				if !info.IsDir() {
+					return fmt.Errorf("%w: %s would replace a file with a directory", errUnsafeArchivePath, filepath.ToSlash(rel))
+				}
+				return nil
+			}
+			if err := os.Mkdir(target, storageDirPerm); err != nil {
+				return err
+			}
+			undo = append(undo, func() { _ = os.Remove(target) })
+			return nil
+		}
+
+		if exists {
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%w: %s would replace a non-regular file", errUnsafeArchivePath, filepath.ToSlash(rel))
+			}
+			saved := filepath.Join(backup, strconv.Itoa(len(undo)))
+			if err := os.Rename(target, saved); err != nil {
+				return err
+			}
+			undo = append(undo, func() { _ = os.Rename(saved, target) })
+		}
+		if err := os.Rename(src, target); err != nil {
+			return err
+		}
+		undo = append(undo, func() { _ = os.Remove(target) })
+		return nil
+	})
+}
+
+// extractTarArchive unpacks a tar stream, gzip-compressed or not, into dest,
+// a fresh directory the caller discards if it fails. A positive limit bounds
+// the uncompressed size. The result's paths are relative to dest.
+func extractTarArchive(dest string, body io.Reader, limit int64) (ArchiveResult, error) {
+	reader := bufio.NewReader(body)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return ArchiveResult{}, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		defer gz.Close()
+		return extractTar(dest, gz, limit)
+	}
+	return extractTar(dest, reader, limit)
+}
+
+func extractTar(dest string, r io.Reader, limit int64) (ArchiveResult, error) {
+	result := ArchiveResult{Paths: []string{}}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+
+		rel, err := archiveEntryPath(hdr.Name)
+		if err != nil {
+			return result, err
+		}
+		if rel == "" {
+			continue
+		}
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+		if err := rejectSymlinkParents(dest, rel); err != nil {
+			return result, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, storageDirPerm); err != nil {
+				return result, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), storageDirPerm); err != nil {
+				return result, err
+			}
+			if info, statErr := os.Lstat(target); statErr == nil && !info.Mode().IsRegular() {
+				return result, fmt.Errorf("%w: %s would replace a non-regular file", errUnsafeArchivePath, hdr.Name)
+			}
+			if limit > 0 && result.Bytes+hdr.Size > limit {
+				return result, fmt.Errorf("%w: archive expands beyond %d bytes", errInvalidArchive, limit)
+			}
+			written, err := writeArchiveFile(target, tr, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return result, err
+			}
+			result.Bytes += written
+			result.Paths = append(result.Paths, rel)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return result, fmt.Errorf("%w: %s has unsupported type %q", errUnsafeArchivePath, hdr.Name, string(hdr.Typeflag))
+		}
+	}
+
+	return result, nil
+}
+
+// archiveEntryPath validates an entry name and returns it cleaned and
+// relative to the extraction root. The archive root itself yields "".
+func archiveEntryPath(name string) (string, error) {
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || strings.Contains(name, "\\") {
+		return "", fmt.Errorf("%w: %s is absolute", errUnsafeArchivePath, name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %s escapes the target directory", errUnsafeArchivePath, name)
+		}
+	}
+	clean := path.Clean(name)
+	if clean == "." {
+		return "", nil
+	}
+	return clean, nil
+}
+
+// rejectSymlinkParents refuses to write through symlinks already present in
+// the destination, which a guest with access to /in could have planted.
+func rejectSymlinkParents(dest, rel string) error {
+	current := dest
+	for _, part := range strings.Split(rel, "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s traverses a symlink", errUnsafeArchivePath, rel)
+		}
+	}
+	return nil
+}
+
+func writeArchiveFile(target string, r io.Reader, perm os.FileMode) (int64, error) {
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0o600)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	return written, nil
+}
+
+// ResolveVMPath maps a path relative to the VM's storage root, such as "out"
+// or "in/project", to its host location. It applies the same rules as archive
+// uploads and refuses to follow symlinks.
+func (s *VMService) ResolveVMPath(vmID, rel string) (string, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return "", err
+	}
+	root := record.Storage.Root
+	if strings.TrimSpace(root) == "" {
+		return "", errors.New("vm has no storage directory")
+	}
+
+	clean, err := archiveEntryPath(rel)
+	if err != nil {
+		return "", err
+	}
+	if clean == "" {
+		return root, nil
+	}
+	if err := rejectSymlinkParents(root, clean); err != nil {
+		return "", err
+	}
+	target := filepath.Join(root, filepath.FromSlash(clean))
+	if _, err := os.Stat(target); err != nil {
+		return "", err
+	}
+	return target, nil
+}
+
+// UploadRange places an upload body within the target file, as a
+// Content-Range header does: bytes Start through End inclusive, of a file
+// Total bytes long (-1 when the client does not know yet).
+type UploadRange struct {
+	Start int64
+	End   int64
+	Total int64
+}
+
+// WriteFile stores body at rel, a file path relative to the VM's storage
+// root such as "in/data.csv", creating parent directories. Without a range
+// the file is replaced. With one, body is appended, and the range must start
+// at the file's current size so an interrupted upload can resume where it
+// stopped. Files are capped at maxArchiveBytes. It returns the file's size.
+func (s *VMService) WriteFile(vmID, rel string, body io.Reader, rng *UploadRange) (int64, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return 0, err
+	}
+	root := record.Storage.Root
+	if strings.TrimSpace(root) == "" {
+		return 0, errors.New("vm has no storage directory")
+	}
+	clean, err := archiveEntryPath(rel)
+	if err != nil {
+		return 0, err
+	}
+	if clean == "" {
+		return 0, fmt.Errorf("%w: upload needs a file path", errUnsafeArchivePath)
+	}
+	if err := rejectSymlinkParents(root, clean); err != nil {
+		return 0, err
+	}
+	target := filepath.Join(root, filepath.FromSlash(clean))
+	if err := os.MkdirAll(filepath.Dir(target), storageDirPerm); err != nil {
+		return 0, err
+	}
+
+	if rng == nil {
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, storageFilePerm)
+		if err != nil {
+			return 0, err
+		}
+		written, err := io.Copy(f, io.LimitReader(body, maxArchiveBytes+1))
+		if err == nil && written > maxArchiveBytes {
+			err = fmt.Errorf("%w: uploads are limited to %d bytes", errResourceLimit, int64(maxArchiveBytes))
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			_ = os.Remove(target)
+			return 0, err
+		}
+		return written, nil
+	}
+
+	if rng.End+1 > maxArchiveBytes || rng.Total > maxArchiveBytes {
+		return 0, fmt.Errorf("%w: uploads are limited to %d bytes", errResourceLimit, int64(maxArchiveBytes))
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, storageFilePerm)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() != rng.Start {
+		return info.Size(), errUploadRangeMismatch
+	}
+	if _, err := f.Seek(rng.Start, io.SeekStart); err != nil {
+		return rng.Start, err
+	}
+	length := rng.End - rng.Start + 1
+	written, err := io.Copy(f, io.LimitReader(body, length+1))
+	switch {
+	case err != nil:
+	case written > length:
+		// Drop the bytes past the range; those within it are kept.
+		if err = f.Truncate(rng.End + 1); err == nil {
+			err = fmt.Errorf("%w: body is longer than %d bytes", errUploadLength, length)
+		}
+		written = length
+	case written < length:
+		// A short body keeps what arrived, so the client can resume from there.
+		err = fmt.Errorf("%w: body is %d bytes, range covers %d", errUploadLength, written, length)
+	}
+	return rng.Start + written, err
+}
+
+// FileSize returns the size of the regular file at rel in the VM's storage
+// directory, so a client can resume an interrupted upload from there.
+func (s *VMService) FileSize(vmID, rel string) (int64, error) {
+	target, err := s.ResolveVMPath(vmID, rel)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Lstat(target)
+	if err != nil {
+		return 0, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, fmt.Errorf("%w: %s is not a regular file", errUnsafeArchivePath, rel)
+	}
+	return info.Size(), nil
+}
+
+// VMFile is a regular file in a VM's storage directory.
+type VMFile struct {
+	Path   string // slash-separated, relative to the storage root
+	Size   int64
+	SHA256 string // hex digest; empty unless checksums were requested
+}
+
+// ListFiles lists the regular files under rel in the VM's storage directory,
+// all of it when rel is empty. rel follows the ResolveVMPath rules. With
+// checksum set every file is also hashed with SHA-256.
+func (s *VMService) ListFiles(vmID, rel string, checksum bool) ([]VMFile, error) {
+	root, err := s.ResolveVMPath(vmID, "")
+	if err != nil {
+		return nil, err
+	}
+	dir, err := s.ResolveVMPath(vmID, rel)
+	if err != nil {
+		return nil, err
+	}
+	return listFilesRecursive(root, dir, checksum)
+}
+
+// listFilesRecursive walks dir in lexical order and returns its regular
+// files with paths relative to root. Symlinks and special files are skipped.
+func listFilesRecursive(root, dir string, checksum bool) ([]VMFile, error) {
+	files := []VMFile{}
+	err := filepath.WalkDir(dir, func(current string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+
+		file := VMFile{Path: filepath.ToSlash(rel), Size: info.Size()}
+		if checksum {
+			if file.SHA256, err = fileSHA256(current); err != nil {
+				return err
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// fileSHA256 hashes a file as it is read, so large files are never held in
+// memory.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeDirArchive streams a gzip-compressed tar of root to w. Entry names are
+// relative to root; symlinks and other special files are skipped.
+func writeDirArchive(root string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	walkErr := filepath.WalkDir(root, func(current string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, current)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			if entry.IsDir() {
+				return nil
+			}
+			rel = filepath.Base(root)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(current)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+	if walkErr != nil {
+		return walkErr
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// CopyToVM copies a host file or directory into the VM's storage directory.
+// dest is relative to the storage root like ResolveVMPath paths ("in/data");
+// a leading "/" is accepted so guest-style paths such as /in/data work. When
+// dest is an existing directory the source is copied into it. Symlinks in the
+// source are skipped. It returns the number of bytes written.
+func (s *VMService) CopyToVM(vmID, src, dest string) (int64, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return 0, err
+	}
+	root := record.Storage.Root
+	if strings.TrimSpace(root) == "" {
+		return 0, errors.New("vm has no storage directory")
+	}
+
+	clean, err := archiveEntryPath(strings.TrimPrefix(dest, "/"))
+	if err != nil {
+		return 0, err
+	}
+	if clean == "" {
+		return 0, fmt.Errorf("%w: destination must be below the vm storage directory", errUnsafeArchivePath)
+	}
+	if err := rejectSymlinkParents(root, clean); err != nil {
+		return 0, err
+	}
+
+	src, err = filepath.EvalSymlinks(src)
+	if err != nil {
+		return 0, err
+	}
+	target := filepath.Join(root, filepath.FromSlash(clean))
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		target = filepath.Join(target, filepath.Base(src))
+	}
+
+	return copyTree(src, target, func(path string) error {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return rejectSymlinkParents(root, filepath.ToSlash(rel))
+	})
+}
+
+// CopyFromVM copies a file or directory from the VM's storage directory to
+// the host. src follows the same rules as CopyToVM's dest, and symlinks inside
+// the VM are never followed. When dest is an existing directory the source is
+// copied into it. It returns the number of bytes written.
+func (s *VMService) CopyFromVM(vmID, src, dest string) (int64, error) {
+	source, err := s.ResolveVMPath(vmID, strings.TrimPrefix(src, "/"))
+	if err != nil {
+		return 0, err
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		dest = filepath.Join(dest, filepath.Base(source))
+	}
+	return copyTree(source, dest, nil)
+}
+
+// copyTree copies src, a file or directory, to dst. checkTarget, when set,
+// vets every destination path before it is written. Symlinks and special
+// files are skipped.
+func copyTree(src, dst string, checkTarget func(string) error) (int64, error) {
+	var copied int64
+	err := filepath.WalkDir(src, func(current string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, current)
+		if err != nil {
+			return err
+		}
+		target := dst
+		if rel != "." {
+			target = filepath.Join(dst, rel)
+		}
+		if checkTarget != nil {
+			if err := checkTarget(target); err != nil {
+				return err
+			}
+		}
+
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, storageDirPerm)
+		case entry.Type().IsRegular():
+			if err := os.MkdirAll(filepath.Dir(target), storageDirPerm); err != nil {
+				return err
+			}
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			written, err := copyRegularFile(current, target, info.Mode().Perm())
+			copied += written
+			return err
+		default:
+			return nil
+		}
+	})
+	return copied, err
+}
+
+func copyRegularFile(src, dst string, perm os.FileMode) (int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0o600)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return written, err
+}
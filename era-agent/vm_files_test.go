@@ -0,0 +1,261 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name string
+	body string
+	dir  bool
+}
+
+func buildTar(t *testing.T, entries []tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.body)), Typeflag: tar.TypeReg}
+		if entry.dir {
+			hdr = &tar.Header{Name: entry.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(entry.body)); err != nil {
+			t.Fatalf("write body: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractArchiveWritesTree(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	archive := buildTar(t, []tarEntry{
+		{name: "project/", dir: true},
+		{name: "project/main.py", body: "print('hi')\n"},
+		{name: "project/pkg/util.py", body: "X = 1\n"},
+	})
+	result, err := svc.ExtractArchive(record.ID, bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+
+	if want := []string{"/in/project/main.py", "/in/project/pkg/util.py"}; !reflect.DeepEqual(result.Paths, want) {
+		t.Fatalf("Paths = %q, want %q", result.Paths, want)
+	}
+	if result.Bytes != int64(len("print('hi')\n")+len("X = 1\n")) {
+		t.Fatalf("Bytes = %d", result.Bytes)
+	}
+	data, err := os.ReadFile(filepath.Join(record.Storage.InputPath, "project", "pkg", "util.py"))
+	if err != nil || string(data) != "X = 1\n" {
+		t.Fatalf("util.py = %q, %v", data, err)
+	}
+}
+
+func TestExtractArchiveRejectsTraversal(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	for _, name := range []string{"../escape", "ok/../../escape", "/etc/passwd"} {
+		archive := buildTar(t, []tarEntry{
+			{name: "first.txt", body: "kept?"},
+			{name: name, body: "pwned"},
+		})
+		_, err := svc.ExtractArchive(record.ID, bytes.NewReader(archive))
+		if !errors.Is(err, errUnsafeArchivePath) {
+			t.Fatalf("%s: err = %v, want errUnsafeArchivePath", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(record.Storage.InputPath), "escape")); !os.IsNotExist(err) {
+			t.Fatalf("%s: entry escaped the input directory", name)
+		}
+		if _, err := os.Stat(filepath.Join(record.Storage.InputPath, "first.txt")); !os.IsNotExist(err) {
+			t.Fatalf("%s: partial extraction was not rolled back", name)
+		}
+	}
+}
+
+func TestExtractArchiveRejectsSymlinkedParent(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(record.Storage.InputPath, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	archive := buildTar(t, []tarEntry{{name: "link/evil.txt", body: "pwned"}})
+	if _, err := svc.ExtractArchive(record.ID, bytes.NewReader(archive)); !errors.Is(err, errUnsafeArchivePath) {
+		t.Fatalf("err = %v, want errUnsafeArchivePath", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatal("archive wrote through a symlink")
+	}
+}
+
+func TestExtractArchiveFailureKeepsExistingInput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	in := record.Storage.InputPath
+
+	existing := filepath.Join(in, "keep.txt")
+	if err := os.WriteFile(existing, []byte("old"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.Symlink(t.TempDir(), filepath.Join(in, "link")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	for name, entries := range map[string][]tarEntry{
+		"bad entry": {
+			{name: "keep.txt", body: "new"},
+			{name: "deep/nested/a.txt", body: "a"},
+			{name: "../escape", body: "pwned"},
+		},
+		"symlink after writes": {
+			{name: "deep/nested/a.txt", body: "a"},
+			{name: "keep.txt", body: "new"},
+			{name: "link/evil.txt", body: "pwned"},
+		},
+	} {
+		_, err := svc.ExtractArchive(record.ID, bytes.NewReader(buildTar(t, entries)))
+		if !errors.Is(err, errUnsafeArchivePath) {
+			t.Fatalf("%s: err = %v, want errUnsafeArchivePath", name, err)
+		}
+		if data, err := os.ReadFile(existing); err != nil || string(data) != "old" {
+			t.Fatalf("%s: keep.txt = %q, %v; want the original contents", name, data, err)
+		}
+		if _, err := os.Stat(filepath.Join(in, "deep")); !os.IsNotExist(err) {
+			t.Fatalf("%s: directories made for the upload were left behind", name)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(in))
+	if err != nil {
+		t.Fatalf("read storage root: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Name(), "-upload-") {
+			t.Fatalf("staging directory %s was left behind", entry.Name())
+		}
+	}
+}
+
+func TestExtractArchiveAcceptsGzip(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err := gz.Write(buildTar(t, []tarEntry{{name: "data.csv", body: "a,b\n1,2\n"}})); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+
+	result, err := svc.ExtractArchive(record.ID, &gzBuf)
+	if err != nil {
+		t.Fatalf("extract failed: %v", err)
+	}
+	if want := []string{"/in/data.csv"}; !reflect.DeepEqual(result.Paths, want) {
+		t.Fatalf("Paths = %q, want %q", result.Paths, want)
+	}
+	if data, err := os.ReadFile(filepath.Join(record.Storage.InputPath, "data.csv")); err != nil || string(data) != "a,b\n1,2\n" {
+		t.Fatalf("data.csv = %q, %v", data, err)
+	}
+}
+
+func TestCopyToVMAndBack(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	src := filepath.Join(t.TempDir(), "project")
+	if err := os.MkdirAll(filepath.Join(src, "pkg"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "main.py"), []byte("print(1)\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "pkg", "util.py"), []byte("X = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := svc.CopyToVM(record.ID, src, "/in")
+	if err != nil {
+		t.Fatalf("copy to vm failed: %v", err)
+	}
+	if copied != 15 {
+		t.Fatalf("copied %d bytes, want 15", copied)
+	}
+	data, err := os.ReadFile(filepath.Join(record.Storage.InputPath, "project", "pkg", "util.py"))
+	if err != nil || string(data) != "X = 1\n" {
+		t.Fatalf("nested file not copied: %q, %v", data, err)
+	}
+
+	dest := filepath.Join(t.TempDir(), "back")
+	copied, err = svc.CopyFromVM(record.ID, "in/project", dest)
+	if err != nil {
+		t.Fatalf("copy from vm failed: %v", err)
+	}
+	if copied != 15 {
+		t.Fatalf("copied %d bytes back, want 15", copied)
+	}
+	data, err = os.ReadFile(filepath.Join(dest, "main.py"))
+	if err != nil || string(data) != "print(1)\n" {
+		t.Fatalf("file not copied back: %q, %v", data, err)
+	}
+}
+
+func TestCopyMissingSource(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	if _, err := svc.CopyToVM(record.ID, filepath.Join(t.TempDir(), "missing"), "in"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing host source error, got %v", err)
+	}
+	if _, err := svc.CopyFromVM(record.ID, "out/missing", t.TempDir()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected missing vm source error, got %v", err)
+	}
+}
+
+func TestCopyRejectsTraversal(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	src := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(src, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(record.Storage.InputPath, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dest := range []string{"../escape.txt", "in/../../escape.txt", "in/link/file.txt", "/"} {
+		if _, err := svc.CopyToVM(record.ID, src, dest); !errors.Is(err, errUnsafeArchivePath) {
+			t.Fatalf("dest %q: expected unsafe path error, got %v", dest, err)
+		}
+	}
+	if _, err := svc.CopyFromVM(record.ID, "../../", t.TempDir()); !errors.Is(err, errUnsafeArchivePath) {
+		t.Fatalf("expected unsafe source error, got %v", err)
+	}
+	if _, err := svc.CopyFromVM(record.ID, "in/link", t.TempDir()); !errors.Is(err, errUnsafeArchivePath) {
+		t.Fatalf("expected symlinked source to be refused, got %v", err)
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("copy escaped through symlink: %v", entries)
+	}
+}
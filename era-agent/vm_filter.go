@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VMFilter selects VMs for listing. Every non-zero field must match (AND),
+// and the CLI and the HTTP API both list through it so that combined filters
+// behave the same everywhere.
+type VMFilter struct {
+	Status   string
+	Owner    string
+	Language string
+	// Labels must all be present on the VM with the same values.
+	Labels map[string]string
+	// CreatedAfter and CreatedBefore bound the creation time; either may be
+	// zero.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// IncludeStopped keeps stopped VMs when no Status is given.
+	IncludeStopped bool
+}
+
+func (f VMFilter) Match(record VMRecord) bool {
+	if status := strings.ToLower(strings.TrimSpace(f.Status)); status != "" {
+		if strings.ToLower(string(record.Status)) != status {
+			return false
+		}
+	} else if !f.IncludeStopped && record.Status == VMStatusStopped {
+		return false
+	}
+	if owner := strings.TrimSpace(f.Owner); owner != "" && record.Owner != owner {
+		return false
+	}
+	if language := normalizeLanguage(f.Language); language != "" && normalizeLanguage(record.Language) != language {
+		return false
+	}
+	if !matchLabels(record.Labels, f.Labels) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && record.CreatedAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !record.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// filterVMs keeps the records matching filter, preserving their order.
+func filterVMs(records []VMRecord, filter VMFilter) []VMRecord {
+	matched := make([]VMRecord, 0, len(records))
+	for _, record := range records {
+		if filter.Match(record) {
+			matched = append(matched, record)
+		}
+	}
+	return matched
+}
+
+// maxVMListLimit caps the page size a client can ask GET /api/vm/list for.
+const maxVMListLimit = 1000
+
+// paginateVMs returns up to limit records starting at offset (limit <= 0
+// means no limit) and the offset of the next page, or -1 on the last page.
+func paginateVMs(records []VMRecord, offset, limit int) ([]VMRecord, int) {
+	if offset < 0 || offset >= len(records) {
+		return []VMRecord{}, -1
+	}
+	end := len(records)
+	// Compared without adding, so a huge limit cannot overflow offset+limit.
+	if limit > 0 && limit < len(records)-offset {
+		end = offset + limit
+	}
+	next := -1
+	if end < len(records) {
+		next = end
+	}
+	return records[offset:end], next
+}
+
+// parseTimeBound reads a --since/--until style bound: an RFC 3339 timestamp,
+// or a duration counted back from now (e.g. 2h).
+func parseTimeBound(raw string, now time.Time) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if ts, err := time.Parse(time.RFC3339, raw); err == nil {
+		return ts, nil
+	}
+	ago, err := time.ParseDuration(raw)
+	if err != nil || ago < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q: want an RFC 3339 timestamp or a duration such as 2h", raw)
+	}
+	return now.Add(-ago), nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestVMFilterCombinesDimensions(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []VMRecord{
+		{ID: "match", Language: "python", Owner: "ci", Status: VMStatusReady, CreatedAt: base},
+		{ID: "other-owner", Language: "python", Owner: "alice", Status: VMStatusReady, CreatedAt: base},
+		{ID: "other-language", Language: "node", Owner: "ci", Status: VMStatusReady, CreatedAt: base},
+		{ID: "too-old", Language: "python", Owner: "ci", Status: VMStatusReady, CreatedAt: base.Add(-2 * time.Hour)},
+		{ID: "too-new", Language: "python", Owner: "ci", Status: VMStatusReady, CreatedAt: base.Add(2 * time.Hour)},
+		{ID: "stopped", Language: "python", Owner: "ci", Status: VMStatusStopped, CreatedAt: base},
+	}
+
+	ids := func(filter VMFilter) []string {
+		var out []string
+		for _, record := range filterVMs(records, filter) {
+			out = append(out, record.ID)
+		}
+		return out
+	}
+
+	filter := VMFilter{
+		Owner:         "ci",
+		Language:      "Python",
+		CreatedAfter:  base.Add(-time.Hour),
+		CreatedBefore: base.Add(time.Hour),
+	}
+	if got := ids(filter); !reflect.DeepEqual(got, []string{"match"}) {
+		t.Fatalf("combined filter = %v, want [match]", got)
+	}
+
+	filter.IncludeStopped = true
+	if got := ids(filter); !reflect.DeepEqual(got, []string{"match", "stopped"}) {
+		t.Fatalf("with stopped = %v, want [match stopped]", got)
+	}
+
+	filter.IncludeStopped = false
+	filter.Status = "stopped"
+	if got := ids(filter); !reflect.DeepEqual(got, []string{"stopped"}) {
+		t.Fatalf("status filter = %v, want [stopped]", got)
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cases := map[string]time.Time{
+		"":                     {},
+		"2h":                   now.Add(-2 * time.Hour),
+		"2023-12-31T00:00:00Z": time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC),
+	}
+	for raw, want := range cases {
+		got, err := parseTimeBound(raw, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("parseTimeBound(%q) = %v, %v; want %v", raw, got, err, want)
+		}
+	}
+	for _, raw := range []string{"yesterday", "-1h"} {
+		if _, err := parseTimeBound(raw, now); err == nil {
+			t.Errorf("parseTimeBound(%q) succeeded, want error", raw)
+		}
+	}
+}
+
+func TestPaginateVMsBoundaries(t *testing.T) {
+	records := []VMRecord{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	cases := []struct {
+		offset, limit int
+		want          []string
+		next          int
+	}{
+		{0, 0, []string{"a", "b", "c"}, -1},
+		{0, 2, []string{"a", "b"}, 2},
+		{2, 2, []string{"c"}, -1},
+		{1, 2, []string{"b", "c"}, -1},
+		{0, 3, []string{"a", "b", "c"}, -1},
+		{3, 1, nil, -1},
+		{10, 1, nil, -1},
+		{1, math.MaxInt, []string{"b", "c"}, -1},
+		{math.MaxInt, math.MaxInt, nil, -1},
+	}
+	for _, tc := range cases {
+		page, next := paginateVMs(records, tc.offset, tc.limit)
+		var got []string
+		for _, record := range page {
+			got = append(got, record.ID)
+		}
+		if !reflect.DeepEqual(got, tc.want) || next != tc.next {
+			t.Errorf("paginateVMs(offset=%d, limit=%d) = %v, %d; want %v, %d", tc.offset, tc.limit, got, next, tc.want, tc.next)
+		}
+	}
+}
+
+func TestVMFilterMatchesAllLabels(t *testing.T) {
+	records := []VMRecord{
+		{ID: "ci-web", Labels: map[string]string{"env": "ci", "project": "web"}},
+		{ID: "ci-api", Labels: map[string]string{"env": "ci", "project": "api"}},
+		{ID: "prod-web", Labels: map[string]string{"env": "prod", "project": "web"}},
+		{ID: "unlabelled"},
+	}
+	ids := func(selector ...string) []string {
+		t.Helper()
+		labels, err := parseLabels(selector)
+		if err != nil {
+			t.Fatalf("parseLabels(%v): %v", selector, err)
+		}
+		var out []string
+		for _, record := range filterVMs(records, VMFilter{Labels: labels}) {
+			out = append(out, record.ID)
+		}
+		return out
+	}
+
+	if got := ids(); len(got) != len(records) {
+		t.Fatalf("no selector = %v, want every vm", got)
+	}
+	if got := ids("env=ci"); !reflect.DeepEqual(got, []string{"ci-web", "ci-api"}) {
+		t.Fatalf("env=ci = %v", got)
+	}
+	if got := ids("env=ci", "project=web"); !reflect.DeepEqual(got, []string{"ci-web"}) {
+		t.Fatalf("env=ci,project=web = %v", got)
+	}
+	if got := ids("env="); got != nil {
+		t.Fatalf("env= matched %v; an empty value only matches an empty label", got)
+	}
+
+	for _, bad := range []string{"env", "=ci", "a,b=c"} {
+		if _, err := parseLabels([]string{bad}); err == nil {
+			t.Errorf("parseLabels(%q) succeeded, want error", bad)
+		}
+	}
+}
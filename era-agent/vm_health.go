@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// runtimeProbeTimeout bounds a whole probe: create, run and clean.
+	runtimeProbeTimeout = 30 * time.Second
+	// runtimeProbeCacheTTL lets frequent probes share one result instead of
+	// each launching a VM.
+	runtimeProbeCacheTTL = 5 * time.Second
+
+	runtimeProbeLanguage = "python"
+)
+
+// RuntimeHealth is the outcome of a runtime probe.
+type RuntimeHealth struct {
+	Healthy  bool
+	Detail   string
+	Duration time.Duration
+	Checked  time.Time
+}
+
+// CheckRuntime proves the virtualization stack works end to end: it creates
+// a throwaway VM, runs `echo ok` in it, checks the output and cleans up.
+// Probes are serialized, and one finished within runtimeProbeCacheTTL is
+// returned again rather than repeated.
+func (s *VMService) CheckRuntime(ctx context.Context) RuntimeHealth {
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+
+	if last := s.lastProbe; !last.Checked.IsZero() && s.clock().Sub(last.Checked) < runtimeProbeCacheTTL {
+		return last
+	}
+
+	start := time.Now()
+	health := RuntimeHealth{Healthy: true, Detail: "ok"}
+	// The result is cached and shared, so a caller that gives up must not
+	// fail the probe for everyone; it is bounded by its own timeout instead.
+	if err := s.probeRuntime(context.WithoutCancel(ctx)); err != nil {
+		health = RuntimeHealth{Detail: err.Error()}
+		s.logger.Warn("runtime probe failed", map[string]any{"error": err.Error()})
+	}
+	health.Duration = time.Since(start)
+	health.Checked = s.clock().UTC()
+	s.lastProbe = health
+	return health
+}
+
+func (s *VMService) probeRuntime(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, runtimeProbeTimeout)
+	defer cancel()
+
+	record, err := s.Create(ctx, VMCreateOptions{
+		Language:    runtimeProbeLanguage,
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Labels:      map[string]string{"era.probe": "runtime"},
+	})
+	if err != nil {
+		return fmt.Errorf("create probe vm: %w", err)
+	}
+	defer func() {
+		if err := s.Clean(context.Background(), record.ID, false); err != nil {
+			s.logger.Warn("failed to clean runtime probe vm", map[string]any{
+				"vm":    record.ID,
+				"error": err.Error(),
+			})
+		}
+	}()
+
+	timeout := int(runtimeProbeTimeout / time.Second)
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := int(time.Until(deadline) / time.Second); remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if timeout < 1 {
+		return fmt.Errorf("probe timed out after creating %s", record.ID)
+	}
+	output, err := s.Exec(ctx, VMRunOptions{VMID: record.ID, Command: "echo ok", Timeout: timeout})
+	if err != nil {
+		return fmt.Errorf("run in probe vm: %w", err)
+	}
+	if got := strings.TrimSpace(output.Stdout); got != "ok" {
+		return fmt.Errorf("probe vm printed %q, want %q", got, "ok")
+	}
+	return nil
+}
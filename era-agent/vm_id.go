@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// vmIDAttempts bounds how many IDs Create draws before giving up on finding
+// one that is not already taken.
+const vmIDAttempts = 8
+
+// defaultVMID returns "<language>-<unixnano>-<random>". The random suffix
+// keeps creates within the same clock tick apart.
+func defaultVMID(language string) string {
+	return fmt.Sprintf("%s-%d-%s", language, time.Now().UTC().UnixNano(), randomIDSuffix())
+}
+
+func randomIDSuffix() string {
+	var buf [3]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; the store still
+		// refuses a duplicate if it ever does.
+		return "000000"
+	}
+	return hex.EncodeToString(buf[:])
+}
+
+// reserveVMID picks an ID for a new VM that is neither known to the service,
+// stored, reserved by another in-flight create nor backed by a leftover
+// storage directory. The returned release must be called once the create
+// has finished, successful or not.
+func (s *VMService) reserveVMID(language string) (string, func(), error) {
+	generate := s.newID
+	if generate == nil {
+		generate = defaultVMID
+	}
+	for attempt := 0; attempt < vmIDAttempts; attempt++ {
+		vmID := sanitizeID(generate(language))
+		if vmID == "" || !s.claimVMID(vmID) {
+			continue
+		}
+		_, storeErr := s.store.Get(vmID)
+		_, statErr := os.Stat(filepath.Join(stateRoot(), "vms", vmID))
+		if storeErr == nil || statErr == nil {
+			s.releaseVMID(vmID)
+			continue
+		}
+		return vmID, func() { s.releaseVMID(vmID) }, nil
+	}
+	return "", nil, fmt.Errorf("%w: no free vm id after %d attempts", errVMExists, vmIDAttempts)
+}
+
+func (s *VMService) claimVMID(vmID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.cache[vmID]; ok {
+		return false
+	}
+	if _, ok := s.reservedIDs[vmID]; ok {
+		return false
+	}
+	if s.reservedIDs == nil {
+		s.reservedIDs = make(map[string]struct{})
+	}
+	s.reservedIDs[vmID] = struct{}{}
+	return true
+}
+
+func (s *VMService) releaseVMID(vmID string) {
+	s.mu.Lock()
+	delete(s.reservedIDs, vmID)
+	s.mu.Unlock()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestCreateAssignsUniqueIDs(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+
+	const workers, perWorker = 4, 25
+	ids := make(chan string, workers*perWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				record, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none"})
+				if err != nil {
+					t.Errorf("create: %v", err)
+					return
+				}
+				ids <- record.ID
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Fatalf("id %s handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Fatalf("got %d ids, want %d", len(seen), workers*perWorker)
+	}
+	records, err := svc.store.LoadAll()
+	if err != nil || len(records) != workers*perWorker {
+		t.Fatalf("stored %d records (%v), want %d", len(records), err, workers*perWorker)
+	}
+}
+
+func TestCreateSkipsTakenIDs(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	svc.newID = func(language string) string { return language + "-fixed" }
+
+	first, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none"})
+	if err != nil {
+		t.Fatalf("first create: %v", err)
+	}
+	if first.ID != "python-fixed" {
+		t.Fatalf("id = %q, want the generated python-fixed", first.ID)
+	}
+	_, err = svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none"})
+	if !errors.Is(err, errVMExists) {
+		t.Fatalf("create with only a taken id err = %v, want errVMExists", err)
+	}
+	if got, ok := svc.Get("python-fixed"); !ok || got.CreatedAt != first.CreatedAt {
+		t.Fatalf("first vm was overwritten: %+v", got)
+	}
+
+	calls := 0
+	svc.newID = func(language string) string {
+		calls++
+		if calls == 1 {
+			return language + "-fixed"
+		}
+		return language + "-fresh"
+	}
+	second, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", NetworkMode: "none"})
+	if err != nil || second.ID != "python-fresh" {
+		t.Fatalf("create after a collision = %q, %v; want python-fresh", second.ID, err)
+	}
+}
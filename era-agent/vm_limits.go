@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// resourceLimits caps what Create accepts. Zero means unlimited.
+type resourceLimits struct {
+	MaxCPU    int
+	MaxMemMiB int
+	MaxVMs    int
+}
+
+// resourceLimitsFromEnv reads AGENT_MAX_CPU, AGENT_MAX_MEM_MIB and
+// AGENT_MAX_VMS; unset, invalid or non-positive values leave a bound off.
+func resourceLimitsFromEnv() resourceLimits {
+	return resourceLimits{
+		MaxCPU:    positiveEnvInt("AGENT_MAX_CPU"),
+		MaxMemMiB: positiveEnvInt("AGENT_MAX_MEM_MIB"),
+		MaxVMs:    positiveEnvInt("AGENT_MAX_VMS"),
+	}
+}
+
+func positiveEnvInt(name string) int {
+	value, err := strconv.Atoi(strings.TrimSpace(os.Getenv(name)))
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+// check rejects CPU and memory requests above the configured maximums.
+func (l resourceLimits) check(cpu, memMiB int) error {
+	if l.MaxCPU > 0 && cpu > l.MaxCPU {
+		return fmt.Errorf("%w: %d cpus requested, AGENT_MAX_CPU allows %d", errResourceLimit, cpu, l.MaxCPU)
+	}
+	if l.MaxMemMiB > 0 && memMiB > l.MaxMemMiB {
+		return fmt.Errorf("%w: %d MiB requested, AGENT_MAX_MEM_MIB allows %d", errResourceLimit, memMiB, l.MaxMemMiB)
+	}
+	return nil
+}
+
+// reserveVMSlot counts a create against AGENT_MAX_VMS, together with the VMs
+// that are not stopped and the creates still in flight. The returned func
+// releases the reservation once the create has finished either way.
+func (s *VMService) reserveVMSlot(max int) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if max > 0 {
+		active := s.pendingCreates
+		for _, record := range s.cache {
+			if record.Status != VMStatusStopped {
+				active++
+			}
+		}
+		if active >= max {
+			return nil, fmt.Errorf("%w: %d vms are running, AGENT_MAX_VMS allows %d; stop or clean one first", errVMLimit, active, max)
+		}
+	}
+	s.pendingCreates++
+	return func() {
+		s.mu.Lock()
+		s.pendingCreates--
+		s.mu.Unlock()
+	}, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCreateEnforcesCPUAndMemoryCaps(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	t.Setenv("AGENT_MAX_CPU", "4")
+	t.Setenv("AGENT_MAX_MEM_MIB", "1024")
+
+	create := func(cpu, mem int) error {
+		_, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", CPUCount: cpu, MemoryMiB: mem, NetworkMode: "none"})
+		return err
+	}
+	if err := create(4, 1024); err != nil {
+		t.Fatalf("create at the limits failed: %v", err)
+	}
+	if err := create(5, 256); !errors.Is(err, errResourceLimit) {
+		t.Fatalf("cpu over the limit err = %v, want errResourceLimit", err)
+	}
+	if err := create(1, 1025); !errors.Is(err, errResourceLimit) {
+		t.Fatalf("memory over the limit err = %v, want errResourceLimit", err)
+	}
+}
+
+func TestCreateEnforcesVMCount(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	t.Setenv("AGENT_MAX_VMS", "2")
+
+	first := createTestVM(t, svc)
+	createTestVM(t, svc)
+	if _, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", CPUCount: 1, MemoryMiB: 256, NetworkMode: "none"}); !errors.Is(err, errVMLimit) {
+		t.Fatalf("create over AGENT_MAX_VMS err = %v, want errVMLimit", err)
+	}
+
+	// Stopped VMs no longer count.
+	if err := svc.Stop(context.Background(), first.ID); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	createTestVM(t, svc)
+}
+
+func TestResourceLimitsFromEnvIgnoresInvalidValues(t *testing.T) {
+	t.Setenv("AGENT_MAX_CPU", "lots")
+	t.Setenv("AGENT_MAX_MEM_MIB", "-1")
+	t.Setenv("AGENT_MAX_VMS", "3")
+	if got := resourceLimitsFromEnv(); got != (resourceLimits{MaxVMs: 3}) {
+		t.Fatalf("limits = %+v, want only MaxVMs", got)
+	}
+}
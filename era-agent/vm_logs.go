@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	stdoutLogName = "stdout.log"
+	stderrLogName = "stderr.log"
+
+	// logFollowInterval is how often FollowLogs polls the log files.
+	logFollowInterval = 200 * time.Millisecond
+)
+
+// RunLogs holds the output of a VM's most recent run.
+type RunLogs struct {
+	Stdout string
+	Stderr string
+}
+
+// parseLogStream validates a stream selector: stdout, stderr or both; empty
+// means both.
+func parseLogStream(raw string) (string, error) {
+	switch stream := strings.ToLower(strings.TrimSpace(raw)); stream {
+	case "":
+		return "both", nil
+	case "stdout", "stderr", "both":
+		return stream, nil
+	default:
+		return "", fmt.Errorf("invalid stream %q: want stdout, stderr or both", raw)
+	}
+}
+
+// Logs reads out/stdout.log and out/stderr.log of the VM's most recent run.
+// A positive tail keeps only the last tail lines of each. A VM that has
+// never run has empty logs rather than missing ones.
+func (s *VMService) Logs(vmID string, tail int) (RunLogs, error) {
+	stdout, err := s.readRunLog(vmID, stdoutLogName, tail)
+	if err != nil {
+		return RunLogs{}, err
+	}
+	stderr, err := s.readRunLog(vmID, stderrLogName, tail)
+	if err != nil {
+		return RunLogs{}, err
+	}
+	return RunLogs{Stdout: stdout, Stderr: stderr}, nil
+}
+
+func (s *VMService) readRunLog(vmID, name string, tail int) (string, error) {
+	path, err := s.ResolveVMPath(vmID, "out/"+name)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return tailLines(string(data), tail), nil
+}
+
+// LogFollowOptions controls where FollowLogs starts and how often it polls.
+type LogFollowOptions struct {
+	// Interval between polls; zero means logFollowInterval.
+	Interval time.Duration
+	// Tail, when positive, seeds each stream with only its last Tail lines
+	// of the current logs instead of all of them.
+	Tail int
+	// Since, when set, skips current logs last written before it.
+	Since time.Time
+}
+
+// FollowLogs calls emit with each complete line appended to the VM's stdout
+// and stderr logs, like tail -f, until ctx is done or emit fails. It starts
+// from the current logs as opts allows, and starts over when a new run
+// truncates them.
+func (s *VMService) FollowLogs(ctx context.Context, vmID string, opts LogFollowOptions, emit func(stream, line string) error) error {
+	if _, err := s.fetchRecord(vmID); err != nil {
+		return err
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = logFollowInterval
+	}
+
+	followers := []*logFollower{
+		{stream: "stdout", name: stdoutLogName, tail: opts.Tail, since: opts.Since},
+		{stream: "stderr", name: stderrLogName, tail: opts.Tail, since: opts.Since},
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, f := range followers {
+			if err := f.poll(s, vmID, emit); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// logFollower tracks how far one log file has been read. tail and since
+// only apply to what the log holds at the first poll; output written after
+// that is always emitted in full.
+type logFollower struct {
+	stream  string
+	name    string
+	tail    int
+	since   time.Time
+	seeded  bool
+	offset  int64
+	partial []byte
+}
+
+func (f *logFollower) poll(s *VMService, vmID string, emit func(stream, line string) error) error {
+	path, err := s.ResolveVMPath(vmID, "out/"+f.name)
+	if errors.Is(err, os.ErrNotExist) {
+		f.seeded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	tail := 0
+	if !f.seeded {
+		f.seeded = true
+		tail = f.tail
+		if !f.since.IsZero() && info.ModTime().Before(f.since) {
+			f.offset = info.Size()
+		}
+	}
+	if info.Size() < f.offset {
+		// A new run truncated the log.
+		f.offset = 0
+		f.partial = nil
+	}
+	if info.Size() == f.offset {
+		return nil
+	}
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return err
+	}
+	chunk, err := io.ReadAll(io.LimitReader(file, info.Size()-f.offset))
+	if err != nil {
+		return err
+	}
+	f.offset += int64(len(chunk))
+
+	data := append(f.partial, chunk...)
+	var lines []string
+	for {
+		idx := bytes.IndexByte(data, '\n')
+		if idx < 0 {
+			break
+		}
+		lines = append(lines, string(data[:idx]))
+		data = data[idx+1:]
+	}
+	f.partial = append([]byte(nil), data...)
+
+	if tail > 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+	for _, line := range lines {
+		if err := emit(f.stream, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tailLines returns the last n lines of text, all of it when n <= 0. A
+// trailing newline does not count as an extra, empty line.
+func tailLines(text string, n int) string {
+	if n <= 0 || text == "" {
+		return text
+	}
+	end := len(text)
+	if strings.HasSuffix(text, "\n") {
+		end--
+	}
+	start := end
+	for i := 0; i < n; i++ {
+		idx := strings.LastIndexByte(text[:start], '\n')
+		if idx < 0 {
+			return text
+		}
+		start = idx
+	}
+	return text[start+1:]
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestTailLines(t *testing.T) {
+	cases := []struct {
+		text string
+		n    int
+		want string
+	}{
+		{"a\nb\nc\n", 2, "b\nc\n"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\nc\n", 3, "a\nb\nc\n"},
+		{"a\nb\nc\n", 10, "a\nb\nc\n"},
+		{"a\nb\nc\n", 0, "a\nb\nc\n"},
+		{"", 5, ""},
+	}
+	for _, tc := range cases {
+		if got := tailLines(tc.text, tc.n); got != tc.want {
+			t.Errorf("tailLines(%q, %d) = %q, want %q", tc.text, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// validateVMName rejects names that would be ambiguous on the CLI or in
+// query strings.
+func validateVMName(name string) error {
+	if name != strings.TrimSpace(name) || strings.ContainsAny(name, " \t\n/=,") {
+		return fmt.Errorf("invalid vm name %q: must not contain spaces, '/', '=' or ','", name)
+	}
+	return nil
+}
+
+// nameLock is a per-name create lock. refs counts the creates holding or
+// waiting for it, so the last one out can drop it from nameLocks.
+type nameLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func vmNameKey(tenant, name string) string {
+	return normalizeTenant(tenant) + "/" + name
+}
+
+// lockName serializes creates that share a tenant and name, so concurrent
+// retries of the same create cannot both launch a VM.
+func (s *VMService) lockName(tenant, name string) func() {
+	key := vmNameKey(tenant, name)
+	s.mu.Lock()
+	if s.nameLocks == nil {
+		s.nameLocks = make(map[string]*nameLock)
+	}
+	lock, ok := s.nameLocks[key]
+	if !ok {
+		lock = &nameLock{}
+		s.nameLocks[key] = lock
+	}
+	lock.refs++
+	s.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		s.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(s.nameLocks, key)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// cacheRecord stores record in the cache and keeps the name index in step.
+// The caller holds mu.
+func (s *VMService) cacheRecord(record VMRecord) {
+	if old, ok := s.cache[record.ID]; ok {
+		s.unindexName(old)
+	}
+	s.cache[record.ID] = record
+	if record.Name == "" {
+		return
+	}
+	if s.names == nil {
+		s.names = make(map[string]map[string]struct{})
+	}
+	key := vmNameKey(record.Tenant, record.Name)
+	if s.names[key] == nil {
+		s.names[key] = make(map[string]struct{})
+	}
+	s.names[key][record.ID] = struct{}{}
+}
+
+// uncacheRecord removes a VM from the cache and the name index. The caller
+// holds mu.
+func (s *VMService) uncacheRecord(vmID string) {
+	if old, ok := s.cache[vmID]; ok {
+		s.unindexName(old)
+	}
+	delete(s.cache, vmID)
+}
+
+func (s *VMService) unindexName(record VMRecord) {
+	if record.Name == "" {
+		return
+	}
+	key := vmNameKey(record.Tenant, record.Name)
+	delete(s.names[key], record.ID)
+	if len(s.names[key]) == 0 {
+		delete(s.names, key)
+	}
+}
+
+// findByName returns the tenant's VM with the given name that is not
+// stopped. If several match, the newest wins.
+func (s *VMService) findByName(tenant, name string) (VMRecord, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found VMRecord
+	ok := false
+	for id := range s.names[vmNameKey(tenant, name)] {
+		record := s.cache[id]
+		if record.Status == VMStatusStopped {
+			continue
+		}
+		if !ok || record.CreatedAt.After(found.CreatedAt) {
+			found, ok = record, true
+		}
+	}
+	return found, ok
+}
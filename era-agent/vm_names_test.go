@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func createNamedTestVM(t *testing.T, svc *VMService, name, tenant string) VMRecord {
+	t.Helper()
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Name:        name,
+		Tenant:      tenant,
+	})
+	if err != nil {
+		t.Fatalf("create %q: %v", name, err)
+	}
+	return record
+}
+
+func TestCreateWithNameIsIdempotent(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+
+	first := createNamedTestVM(t, svc, "agent-task-42", "")
+	again := createNamedTestVM(t, svc, "agent-task-42", "")
+	if again.ID != first.ID {
+		t.Fatalf("second create returned %s, want %s", again.ID, first.ID)
+	}
+	if other := createNamedTestVM(t, svc, "agent-task-43", ""); other.ID == first.ID {
+		t.Fatal("a different name reused the vm")
+	}
+	if otherTenant := createNamedTestVM(t, svc, "agent-task-42", "acme"); otherTenant.ID == first.ID {
+		t.Fatal("a name in another tenant reused the vm")
+	}
+
+	if err := svc.Stop(context.Background(), first.ID); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	if fresh := createNamedTestVM(t, svc, "agent-task-42", ""); fresh.ID == first.ID {
+		t.Fatal("a stopped vm was returned for its name")
+	}
+
+	if _, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", CPUCount: 1, MemoryMiB: 256, Name: "two words"}); err == nil {
+		t.Fatal("name with a space accepted")
+	}
+}
+
+func TestConcurrentCreatesWithNameShareOneVM(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+
+	ids := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			record, err := svc.Create(context.Background(), VMCreateOptions{Language: "python", CPUCount: 1, MemoryMiB: 256, Name: "retry"})
+			if err != nil {
+				t.Errorf("create: %v", err)
+			}
+			ids[i] = record.ID
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("concurrent creates returned %v, want one id", ids)
+		}
+	}
+}
+
+func TestNameIndexFollowsCache(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+
+	first := createNamedTestVM(t, svc, "indexed", "")
+	if err := svc.Stop(context.Background(), first.ID); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+	second := createNamedTestVM(t, svc, "indexed", "")
+	if err := svc.Clean(context.Background(), first.ID, false); err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+
+	svc.mu.RLock()
+	ids := svc.names[vmNameKey("", "indexed")]
+	locks := len(svc.nameLocks)
+	svc.mu.RUnlock()
+	if _, ok := ids[second.ID]; len(ids) != 1 || !ok {
+		t.Fatalf("name index = %v, want only %s", ids, second.ID)
+	}
+	if locks != 0 {
+		t.Fatalf("%d name locks left after the creates finished", locks)
+	}
+	if found, ok := svc.findByName(defaultTenant, "indexed"); !ok || found.ID != second.ID {
+		t.Fatalf("findByName = %s, %v; want %s", found.ID, ok, second.ID)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultReapInterval = time.Minute
+
+// reapInterval returns how often expired VMs are swept, from
+// AGENT_REAP_INTERVAL (a Go duration such as "30s") or the default.
+func reapInterval() time.Duration {
+	raw := strings.TrimSpace(os.Getenv("AGENT_REAP_INTERVAL"))
+	if raw == "" {
+		return defaultReapInterval
+	}
+	interval, err := time.ParseDuration(raw)
+	if err != nil || interval <= 0 {
+		return defaultReapInterval
+	}
+	return interval
+}
+
+// startReaper periodically cleans VMs whose TTL has elapsed until Close is
+// called.
+func (s *VMService) startReaper(interval time.Duration) {
+	s.stopReaper = make(chan struct{})
+	s.reaperDone = make(chan struct{})
+
+	go func() {
+		defer close(s.reaperDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stopReaper:
+				return
+			case <-ticker.C:
+				s.reapExpired(context.Background())
+			}
+		}
+	}()
+}
+
+// reapExpired cleans every VM past its ExpiresAt and returns the reaped IDs.
+// Persistent VMs are skipped unless they were created with ExpirePersistent,
+// in which case their persist volume is removed as well.
+func (s *VMService) reapExpired(ctx context.Context) []string {
+	now := s.clock()
+
+	s.mu.RLock()
+	expired := make([]VMRecord, 0)
+	for _, record := range s.cache {
+		if record.ExpiresAt.IsZero() || now.Before(record.ExpiresAt) {
+			continue
+		}
+		if record.Persist && !record.ExpirePersistent {
+			continue
+		}
+		expired = append(expired, record)
+	}
+	s.mu.RUnlock()
+
+	reaped := make([]string, 0, len(expired))
+	for _, record := range expired {
+		if err := s.Clean(ctx, record.ID, false); err != nil {
+			s.logger.Warn("failed to reap expired vm", map[string]any{
+				"vm":    record.ID,
+				"error": err.Error(),
+			})
+			continue
+		}
+		s.logger.Info("vm expired and reaped", map[string]any{
+			"vm":         record.ID,
+			"language":   record.Language,
+			"expired_at": record.ExpiresAt,
+		})
+		reaped = append(reaped, record.ID)
+	}
+	return reaped
+}
+
+func (s *VMService) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReapExpiredRemovesVMAfterTTL(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	svc.now = func() time.Time { return now }
+
+	newVM := func(opts VMCreateOptions) VMRecord {
+		t.Helper()
+		opts.Language = "python"
+		opts.CPUCount = 1
+		opts.MemoryMiB = 256
+		opts.NetworkMode = "none"
+		record, err := svc.Create(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+		return record
+	}
+
+	ephemeral := newVM(VMCreateOptions{TTL: time.Minute})
+	forever := newVM(VMCreateOptions{})
+	persisted := newVM(VMCreateOptions{TTL: time.Minute, Persist: true})
+	persistedExpiring := newVM(VMCreateOptions{TTL: time.Minute, Persist: true, ExpirePersistent: true})
+
+	if want := now.Add(time.Minute); !ephemeral.ExpiresAt.Equal(want) {
+		t.Fatalf("ExpiresAt = %s, want %s", ephemeral.ExpiresAt, want)
+	}
+	if encoded, _ := json.Marshal(vmRecordToInfo(ephemeral)); !strings.Contains(string(encoded), `"expires_at":"2025-01-01T12:01:00Z"`) {
+		t.Fatalf("VM with a TTL encodes as %s, want its expires_at", encoded)
+	}
+	if encoded, _ := json.Marshal(vmRecordToInfo(forever)); strings.Contains(string(encoded), "expires_at") {
+		t.Fatalf("VM without a TTL encodes as %s, want no expires_at", encoded)
+	}
+
+	if reaped := svc.reapExpired(context.Background()); len(reaped) != 0 {
+		t.Fatalf("reaped %v before expiry", reaped)
+	}
+
+	now = now.Add(2 * time.Minute)
+	reaped := svc.reapExpired(context.Background())
+	if len(reaped) != 2 {
+		t.Fatalf("reaped %v, want the ephemeral and expire-persistent VMs", reaped)
+	}
+
+	for _, gone := range []VMRecord{ephemeral, persistedExpiring} {
+		if _, err := svc.store.Get(gone.ID); !errors.Is(err, errNotFound) {
+			t.Fatalf("expected %s to be removed from the store, got %v", gone.ID, err)
+		}
+	}
+	for _, kept := range []VMRecord{forever, persisted} {
+		if _, err := svc.store.Get(kept.ID); err != nil {
+			t.Fatalf("expected %s to be kept: %v", kept.ID, err)
+		}
+	}
+}
+
+func TestReaperStopsOnClose(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	svc.startReaper(time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- svc.Close() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the reaper")
+	}
+}
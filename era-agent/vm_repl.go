@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errREPLClosed fails evals still waiting when their REPL is shut down by
+// StopREPL, Stop, Clean or a resize.
+var errREPLClosed = errors.New("repl closed")
+
+// replCloseTimeout bounds how long closing a REPL waits for its process.
+const replCloseTimeout = 5 * time.Second
+
+// REPLResult is the output of one EvalREPL call.
+type REPLResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int // 1 when the code raised; the interpreter's status if it exited
+	Duration time.Duration
+	// Started is set when this eval launched a new interpreter, so state from
+	// earlier evals is gone.
+	Started bool
+	// Exited is set when the code ended the interpreter; the next eval
+	// starts a new one.
+	Exited bool
+	// TimedOut is set when the eval ran past its timeout. The interpreter is
+	// killed with it, since it cannot be interrupted mid-statement.
+	TimedOut bool
+	// Aborted is set when Abort cancelled the eval; the interpreter is killed
+	// as for a timeout.
+	Aborted bool
+	// Truncated is set when stdout or stderr went past the capture limit
+	// (AGENT_MAX_OUTPUT_BYTES) and was cut short.
+	Truncated bool
+}
+
+// replDrivers are the programs run as the long-lived interpreter. Rather
+// than scrape the prompts of `python3 -i` or the node REPL, a driver reads
+// one "<token> <base64 code>" line per eval from stdin, runs the code in a
+// namespace kept across evals, echoes the value of a bare expression the way
+// the interactive prompt does, then writes "\n<token> <status>" to stdout and
+// "\n<token>" to stderr so the agent can tell where each eval's output ends.
+var replDrivers = map[string]struct{ binary, flag, source string }{
+	"python": {"python3", "-u -c", `import base64, sys, traceback
+namespace = {"__name__": "__main__"}
+while True:
+    line = sys.stdin.readline()
+    if not line:
+        break
+    token, _, payload = line.strip().partition(" ")
+    status = 0
+    try:
+        code = base64.b64decode(payload).decode()
+        try:
+            compiled = compile(code, "<repl>", "eval")
+        except SyntaxError:
+            exec(compile(code, "<repl>", "exec"), namespace)
+        else:
+            value = eval(compiled, namespace)
+            if value is not None:
+                print(repr(value))
+    except BaseException:
+        traceback.print_exc()
+        status = 1
+    sys.stdout.flush()
+    sys.stderr.write("\n" + token + "\n")
+    sys.stderr.flush()
+    sys.stdout.write("\n" + token + " " + str(status) + "\n")
+    sys.stdout.flush()
+`},
+	"node": {"node", "-e", `const util = require('util');
+const vm = require('vm');
+const rl = require('readline').createInterface({ input: process.stdin, terminal: false });
+rl.on('line', (line) => {
+  const space = line.indexOf(' ');
+  const token = space < 0 ? line : line.slice(0, space);
+  let status = 0;
+  try {
+    const code = Buffer.from(space < 0 ? '' : line.slice(space + 1), 'base64').toString();
+    const value = vm.runInThisContext(code, { filename: '<repl>' });
+    if (value !== undefined) console.log(util.inspect(value));
+  } catch (err) {
+    console.error(err && err.stack ? err.stack : String(err));
+    status = 1;
+  }
+  process.stderr.write('\n' + token + '\n');
+  process.stdout.write('\n' + token + ' ' + status + '\n');
+});
+`},
+}
+
+// replCommand builds the shell command that starts the driver for a
+// language. Launchers split Shell commands on whitespace, so the driver is
+// passed base64-encoded inside a single whitespace-free argument.
+func replCommand(language string) (binary, command string, err error) {
+	switch normalizeLanguage(language) {
+	case "python":
+		language = "python"
+	case "node", "javascript", "js":
+		language = "node"
+	default:
+		return "", "", fmt.Errorf("%w: repl sessions support python and node, not %q", errUnsupportedLang, language)
+	}
+	driver := replDrivers[language]
+	encoded := base64.StdEncoding.EncodeToString([]byte(driver.source))
+	var loader string
+	if language == "python" {
+		loader = "exec(__import__('base64').b64decode('" + encoded + "'))"
+	} else {
+		loader = "eval(Buffer.from('" + encoded + "','base64').toString())"
+	}
+	return driver.binary, driver.binary + " " + driver.flag + " " + loader, nil
+}
+
+// replSession is one long-lived interpreter inside a VM, started through the
+// launcher's Shell with its stdin, stdout and stderr on pipes.
+type replSession struct {
+	stdin  *os.File
+	stdout *replStream
+	stderr *replStream
+	cancel context.CancelFunc
+
+	// done closes when the interpreter exits; exitCode and exitErr are set
+	// before it does.
+	done     chan struct{}
+	exitCode int
+	exitErr  error
+
+	// closed is set by close, so an eval cut short can tell being shut
+	// down from the interpreter exiting by itself.
+	closed atomic.Bool
+
+	// evalMu serializes evals, which share the pipes.
+	evalMu sync.Mutex
+	seq    uint64
+}
+
+// replStream collects one of the interpreter's output pipes. Output left
+// after an eval's marker, such as writes from a background thread, is kept
+// for the next eval.
+type replStream struct {
+	chunks chan []byte
+	// pending holds output not yet handed to readUntil's writer: at most a
+	// chunk plus the start of a marker split across chunks.
+	pending []byte
+}
+
+func newREPLStream(r io.Reader) *replStream {
+	stream := &replStream{chunks: make(chan []byte, 16)}
+	go func() {
+		defer close(stream.chunks)
+		for {
+			buf := make([]byte, 32*1024)
+			n, err := r.Read(buf)
+			if n > 0 {
+				stream.chunks <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return stream
+}
+
+// readUntil writes the output before marker to w and consumes the marker.
+// Output is handed on as it arrives rather than held until the marker shows
+// up, so a capped w bounds the memory of an eval that prints forever. When
+// the pipe closes or ctx ends first, it writes whatever was read and returns
+// io.EOF or the context's error.
+func (s *replStream) readUntil(ctx context.Context, marker []byte, w io.Writer) error {
+	for {
+		if i := bytes.Index(s.pending, marker); i >= 0 {
+			_, err := w.Write(s.pending[:i])
+			s.pending = append([]byte(nil), s.pending[i+len(marker):]...)
+			return err
+		}
+		// Everything but a possible start of the marker is output.
+		if keep := len(marker) - 1; len(s.pending) > keep {
+			if _, err := w.Write(s.pending[:len(s.pending)-keep]); err != nil {
+				return err
+			}
+			s.pending = append([]byte(nil), s.pending[len(s.pending)-keep:]...)
+		}
+		select {
+		case chunk, ok := <-s.chunks:
+			if !ok {
+				_, _ = w.Write(s.pending)
+				s.pending = nil
+				return io.EOF
+			}
+			s.pending = append(s.pending, chunk...)
+		case <-ctx.Done():
+			_, _ = w.Write(s.pending)
+			s.pending = nil
+			return ctx.Err()
+		}
+	}
+}
+
+// startREPLSession launches command in the VM. The interpreter outlives the
+// request that started it, so it runs under its own context, cancelled by
+// close. Its stdin is an OS pipe rather than an io.Pipe: exec copies other
+// readers in a goroutine that Wait blocks on, which would keep Shell from
+// returning after the interpreter exits by itself.
+func startREPLSession(launcher VMLauncher, record VMRecord, command string) (*replSession, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+
+	session := &replSession{
+		stdin:  stdinW,
+		stdout: newREPLStream(stdoutR),
+		stderr: newREPLStream(stderrR),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		session.exitCode, session.exitErr = launcher.Shell(ctx, record, command, stdinR, stdoutW, stderrW)
+		stdinR.Close()
+		stdoutW.Close()
+		stderrW.Close()
+		close(session.done)
+	}()
+	return session, nil
+}
+
+func (r *replSession) exited() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close kills the interpreter and waits, up to replCloseTimeout, for the
+// launcher to return.
+func (r *replSession) close() {
+	r.closed.Store(true)
+	r.stdin.Close()
+	r.cancel()
+	select {
+	case <-r.done:
+	case <-time.After(replCloseTimeout):
+	}
+}
+
+// eval sends code to the interpreter and collects its output up to the
+// markers, keeping at most limit bytes of each stream. Output read before a
+// timeout or exit is returned with the error.
+func (r *replSession) eval(ctx context.Context, code string, limit int64) (REPLResult, error) {
+	r.evalMu.Lock()
+	defer r.evalMu.Unlock()
+
+	r.seq++
+	var nonce [6]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return REPLResult{}, err
+	}
+	token := fmt.Sprintf("__era_repl_%d_%s__", r.seq, hex.EncodeToString(nonce[:]))
+
+	start := time.Now()
+	line := token + " " + base64.StdEncoding.EncodeToString([]byte(code)) + "\n"
+	// A zero deadline clears the previous eval's.
+	deadline, _ := ctx.Deadline()
+	r.stdin.SetWriteDeadline(deadline)
+	if _, err := r.stdin.WriteString(line); err != nil {
+		if ctx.Err() != nil {
+			return REPLResult{}, ctx.Err()
+		}
+		if r.exited() {
+			return REPLResult{ExitCode: r.exitCode, Exited: true, Duration: time.Since(start)}, nil
+		}
+		return REPLResult{}, err
+	}
+
+	var (
+		result         REPLResult
+		stdout, stderr bytes.Buffer
+		status         bytes.Buffer
+	)
+	stdoutCapture := newCappedWriter(&stdout, limit)
+	stderrCapture := newCappedWriter(&stderr, limit)
+	err := r.stdout.readUntil(ctx, []byte("\n"+token+" "), stdoutCapture)
+	if err == nil {
+		err = r.stdout.readUntil(ctx, []byte("\n"), newCappedWriter(&status, 32))
+	}
+	// stderr carries its own marker; after an exit it holds the
+	// interpreter's last words up to EOF.
+	if err == nil || errors.Is(err, io.EOF) {
+		stderrErr := r.stderr.readUntil(ctx, []byte("\n"+token+"\n"), stderrCapture)
+		if err == nil {
+			err = stderrErr
+		}
+	}
+	stdoutTruncated, _ := stdoutCapture.finish()
+	stderrTruncated, _ := stderrCapture.finish()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	result.Truncated = stdoutTruncated || stderrTruncated
+	result.Duration = time.Since(start)
+
+	switch {
+	case err == nil:
+		result.ExitCode, _ = strconv.Atoi(strings.TrimSpace(status.String()))
+		return result, nil
+	case errors.Is(err, io.EOF):
+		<-r.done
+		if r.closed.Load() {
+			return result, errREPLClosed
+		}
+		result.Exited = true
+		result.ExitCode = r.exitCode
+		return result, nil
+	default:
+		return result, err
+	}
+}
+
+// EvalREPL runs code in the VM's REPL session, starting the interpreter for
+// the VM's language on first use. Variables, imports and definitions persist
+// from one eval to the next until the REPL is stopped or the VM is stopped
+// or cleaned. Evals on one VM run one at a time, can be cancelled by Abort
+// and are kept in the run history. A timeout of zero means no limit.
+func (s *VMService) EvalREPL(ctx context.Context, vmID, code string, timeout time.Duration) (REPLResult, error) {
+	session, started, err := s.replFor(ctx, vmID)
+	if err != nil {
+		return REPLResult{}, err
+	}
+
+	startedAt := s.clock()
+	abortCtx, release := s.trackRun(ctx, vmID)
+	defer release()
+	evalCtx := abortCtx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		evalCtx, cancel = context.WithTimeout(abortCtx, timeout)
+		defer cancel()
+	}
+	result, err := session.eval(evalCtx, code, maxOutputBytes(0))
+	result.Started = started
+	defer func() { s.recordREPLEval(vmID, code, result, err, startedAt) }()
+
+	if errors.Is(context.Cause(abortCtx), errRunAborted) {
+		// Like a timeout, the interpreter is left mid-statement.
+		s.dropREPL(vmID, session)
+		result.Aborted = true
+		return result, nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		// An interpreter stuck in the user's code cannot take another eval.
+		s.dropREPL(vmID, session)
+		result.TimedOut = true
+		err = nil
+		return result, nil
+	}
+	if err != nil && ctx.Err() != nil {
+		// The caller went away mid-eval; the interpreter's output is now out
+		// of step with the markers, so it cannot be reused.
+		s.dropREPL(vmID, session)
+	}
+	if result.Exited {
+		s.dropREPL(vmID, session)
+	}
+
+	loggerFor(ctx, s.logger).Debug("repl eval", map[string]any{
+		"vm":        vmID,
+		"exit_code": result.ExitCode,
+		"started":   started,
+		"duration":  result.Duration.String(),
+	})
+	return result, err
+}
+
+// recordREPLEval adds an eval that reached the interpreter to the run
+// history, under a "repl: " prefix so it reads apart from runs.
+func (s *VMService) recordREPLEval(vmID, code string, result REPLResult, err error, started time.Time) {
+	record, ok := s.Get(vmID)
+	if !ok {
+		return
+	}
+	status := "ok"
+	switch {
+	case result.Aborted:
+		status = "aborted"
+	case result.TimedOut:
+		status = "timeout"
+	case err != nil || result.ExitCode != 0:
+		status = "failed"
+	}
+	s.saveRunHistory(RunHistoryEntry{
+		VMID:      record.ID,
+		Tenant:    record.Tenant,
+		Command:   "repl: " + code,
+		ExitCode:  result.ExitCode,
+		Status:    status,
+		StartedAt: started.UTC(),
+		Duration:  result.Duration,
+		Truncated: result.Truncated,
+	})
+}
+
+// StopREPL ends the VM's REPL session, if it has one, discarding its state.
+func (s *VMService) StopREPL(vmID string) error {
+	if _, err := s.fetchRecord(vmID); err != nil {
+		return err
+	}
+	s.closeREPL(vmID)
+	return nil
+}
+
+// replFor returns the VM's REPL session, starting one if it has none or the
+// last one exited. started reports whether it is new.
+func (s *VMService) replFor(ctx context.Context, vmID string) (*replSession, bool, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return nil, false, err
+	}
+	if record.Status != VMStatusReady && record.Status != VMStatusRunning {
+		return nil, false, fmt.Errorf("%w: %s is %s", errVMNotRunning, vmID, record.Status)
+	}
+
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+	if session, ok := s.repls[vmID]; ok && !session.exited() {
+		return session, false, nil
+	}
+
+	binary, command, err := replCommand(record.Language)
+	if err != nil {
+		return nil, false, err
+	}
+	policy, err := loadCommandPolicy()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := policy.check(binary); err != nil {
+		return nil, false, err
+	}
+
+	if s.repls == nil {
+		s.repls = make(map[string]*replSession)
+	}
+	session, err := startREPLSession(s.launcher, record, command)
+	if err != nil {
+		return nil, false, err
+	}
+	s.repls[vmID] = session
+	loggerFor(ctx, s.logger).Info("repl started", map[string]any{"vm": vmID, "language": record.Language})
+	return session, true, nil
+}
+
+// dropREPL closes session and forgets it, unless it was already replaced.
+func (s *VMService) dropREPL(vmID string, session *replSession) {
+	s.replMu.Lock()
+	if s.repls[vmID] == session {
+		delete(s.repls, vmID)
+	}
+	s.replMu.Unlock()
+	session.close()
+}
+
+func (s *VMService) closeREPL(vmID string) {
+	s.replMu.Lock()
+	session, ok := s.repls[vmID]
+	delete(s.repls, vmID)
+	s.replMu.Unlock()
+	if ok {
+		session.close()
+	}
+}
+
+func (s *VMService) closeAllREPLs() {
+	s.replMu.Lock()
+	sessions := s.repls
+	s.repls = nil
+	s.replMu.Unlock()
+	for _, session := range sessions {
+		session.close()
+	}
+}
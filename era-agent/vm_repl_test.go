@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The fake launcher runs Shell commands on the host, so these tests drive a
+// real interpreter when one is installed.
+func requireInterpreter(t *testing.T, binary string) {
+	t.Helper()
+	if _, err := exec.LookPath(binary); err != nil {
+		t.Skipf("%s not installed", binary)
+	}
+}
+
+func evalREPL(t *testing.T, svc *VMService, vmID, code string) REPLResult {
+	t.Helper()
+	result, err := svc.EvalREPL(context.Background(), vmID, code, 10*time.Second)
+	if err != nil {
+		t.Fatalf("eval %q: %v", code, err)
+	}
+	return result
+}
+
+func TestREPLKeepsStateBetweenEvals(t *testing.T) {
+	requireInterpreter(t, "python3")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	first := evalREPL(t, svc, record.ID, "x = 41")
+	if !first.Started || first.ExitCode != 0 || first.Stdout != "" {
+		t.Fatalf("first eval = %+v, want a fresh interpreter and no output", first)
+	}
+	second := evalREPL(t, svc, record.ID, "x + 1")
+	if second.Started || second.Stdout != "42\n" {
+		t.Fatalf("second eval = %+v, want 42 from the same interpreter", second)
+	}
+
+	// Output without a trailing newline keeps its shape.
+	if got := evalREPL(t, svc, record.ID, `print("no newline", end="")`).Stdout; got != "no newline" {
+		t.Fatalf("stdout = %q, want %q", got, "no newline")
+	}
+
+	failed := evalREPL(t, svc, record.ID, "1 / 0")
+	if failed.ExitCode != 1 || !strings.Contains(failed.Stderr, "ZeroDivisionError") {
+		t.Fatalf("failing eval = %+v, want exit code 1 and the traceback", failed)
+	}
+	if got := evalREPL(t, svc, record.ID, "print(x)").Stdout; got != "41\n" {
+		t.Fatalf("state after an error = %q, want 41", got)
+	}
+}
+
+func TestREPLNode(t *testing.T) {
+	requireInterpreter(t, "node")
+	svc := newTestVMService(t, newFakeLauncher())
+	record, err := svc.Create(context.Background(), VMCreateOptions{Language: "node", NetworkMode: "none"})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	evalREPL(t, svc, record.ID, "let greeting = 'hello'")
+	if got := evalREPL(t, svc, record.ID, "console.log(greeting + ' world')").Stdout; got != "hello world\n" {
+		t.Fatalf("stdout = %q, want hello world", got)
+	}
+}
+
+func TestREPLRestartsAfterExitAndTimeout(t *testing.T) {
+	requireInterpreter(t, "python3")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	evalREPL(t, svc, record.ID, "x = 1")
+	exited := evalREPL(t, svc, record.ID, "import os; os._exit(3)")
+	if !exited.Exited || exited.ExitCode != 3 {
+		t.Fatalf("exit eval = %+v, want exited with code 3", exited)
+	}
+	restarted := evalREPL(t, svc, record.ID, "'x' in globals()")
+	if !restarted.Started || restarted.Stdout != "False\n" {
+		t.Fatalf("eval after exit = %+v, want a fresh interpreter", restarted)
+	}
+
+	result, err := svc.EvalREPL(context.Background(), record.ID, "while True: pass", 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("eval: %v", err)
+	}
+	if !result.TimedOut {
+		t.Fatalf("busy loop = %+v, want timed out", result)
+	}
+	if got := evalREPL(t, svc, record.ID, "1 + 1"); !got.Started || got.Stdout != "2\n" {
+		t.Fatalf("eval after timeout = %+v, want a fresh interpreter", got)
+	}
+}
+
+func TestREPLStreamSplitsMarkerAcrossChunks(t *testing.T) {
+	reader, writer := io.Pipe()
+	stream := newREPLStream(reader)
+	go func() {
+		for _, chunk := range []string{"hello ", "wor", "ld\n__m", "ark__rest"} {
+			_, _ = writer.Write([]byte(chunk))
+		}
+		writer.Close()
+	}()
+
+	var out bytes.Buffer
+	if err := stream.readUntil(context.Background(), []byte("\n__mark__"), &out); err != nil {
+		t.Fatalf("readUntil: %v", err)
+	}
+	if out.String() != "hello world" {
+		t.Fatalf("output = %q, want %q", out.String(), "hello world")
+	}
+	out.Reset()
+	if err := stream.readUntil(context.Background(), []byte("\n__mark__"), &out); !errors.Is(err, io.EOF) || out.String() != "rest" {
+		t.Fatalf("output after the marker = %q, %v; want rest and EOF", out.String(), err)
+	}
+}
+
+func TestREPLCapsOutput(t *testing.T) {
+	requireInterpreter(t, "python3")
+	t.Setenv("AGENT_MAX_OUTPUT_BYTES", "1000")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	result := evalREPL(t, svc, record.ID, "for _ in range(100000): print('x' * 99)")
+	if !result.Truncated || len(result.Stdout) > 1000+truncationMarkerSlack {
+		t.Fatalf("chatty eval kept %d bytes (truncated %v), want at most the 1000 byte cap", len(result.Stdout), result.Truncated)
+	}
+	if !strings.Contains(result.Stdout, "[truncated ") {
+		t.Fatalf("stdout tail = %q, want the truncation marker", result.Stdout[len(result.Stdout)-40:])
+	}
+	// The marker was still found, so the interpreter stays usable.
+	if got := evalREPL(t, svc, record.ID, "1 + 1"); got.Started || got.Stdout != "2\n" || got.Truncated {
+		t.Fatalf("eval after a capped one = %+v, want 2 from the same interpreter", got)
+	}
+}
+
+func TestREPLEvalAbortedAndRecorded(t *testing.T) {
+	requireInterpreter(t, "python3")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	evalREPL(t, svc, record.ID, "x = 1")
+
+	done := make(chan REPLResult, 1)
+	go func() {
+		result, _ := svc.EvalREPL(context.Background(), record.ID, "import time; time.sleep(30)", 0)
+		done <- result
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		aborted, err := svc.Abort(record.ID)
+		if err != nil {
+			t.Fatalf("abort: %v", err)
+		}
+		if aborted > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the eval never became abortable")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case result := <-done:
+		if !result.Aborted {
+			t.Fatalf("aborted eval = %+v, want aborted", result)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("eval kept running after abort")
+	}
+
+	runs, err := svc.RecentRuns(record.Tenant, record.ID, 10)
+	if err != nil {
+		t.Fatalf("recent runs: %v", err)
+	}
+	var got []string
+	for _, run := range runs {
+		got = append(got, run.Command+" "+run.Status)
+	}
+	want := []string{"repl: import time; time.sleep(30) aborted", "repl: x = 1 ok"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("history = %q, want %q", got, want)
+	}
+}
+
+func TestREPLClosedWithVM(t *testing.T) {
+	requireInterpreter(t, "python3")
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	evalREPL(t, svc, record.ID, "x = 1")
+	if err := svc.Clean(context.Background(), record.ID, false); err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+	svc.replMu.Lock()
+	remaining := len(svc.repls)
+	svc.replMu.Unlock()
+	if remaining != 0 {
+		t.Fatalf("%d repl sessions left after clean", remaining)
+	}
+}
+
+func TestREPLUnsupportedLanguage(t *testing.T) {
+	if _, _, err := replCommand("rust"); err == nil {
+		t.Fatal("replCommand(rust) succeeded, want errUnsupportedLang")
+	}
+	_, command, err := replCommand("python")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields := strings.Fields(command); len(fields) != 4 {
+		t.Fatalf("python repl command splits into %d fields, want 4: %q", len(fields), command)
+	}
+}
+
+func TestAPIREPLRoute(t *testing.T) {
+	requireInterpreter(t, "python3")
+	svc := newTestVMService(t, newFakeLauncher())
+	_, server := newTestAPIServer(t, svc)
+	record := createTestVM(t, svc)
+	url := server.URL + "/api/vm/" + record.ID + "/repl"
+
+	post := func(code string) REPLEvalResult {
+		t.Helper()
+		body, _ := json.Marshal(REPLRequest{Code: code})
+		resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decoded struct {
+			Success bool           `json:"success"`
+			Data    REPLEvalResult `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil || !decoded.Success {
+			t.Fatalf("POST %s: status %d, %v", url, resp.StatusCode, err)
+		}
+		return decoded.Data
+	}
+
+	post("counter = 10")
+	if got := post("counter * 2"); got.Stdout != "20\n" || got.Started {
+		t.Fatalf("second eval = %+v, want 20 from the same session", got)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE status = %d, want 200", resp.StatusCode)
+	}
+	if got := post("'counter' in globals()"); !got.Started || got.Stdout != "False\n" {
+		t.Fatalf("eval after DELETE = %+v, want a fresh session", got)
+	}
+}
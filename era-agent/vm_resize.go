@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// UpdateResources changes a VM's CPU count and memory. A zero value keeps the
+// current setting. The runtimes size a VM when it is created, so a VM that is
+// up is drained like Stop, torn down and launched again with the new sizing;
+// a stopped VM picks the sizing up on its next run. Storage, including the
+// persist directory, is kept.
+func (s *VMService) UpdateResources(ctx context.Context, vmID string, cpu, memMiB int) error {
+	if cpu < 0 {
+		return fmt.Errorf("%w: cpu must not be negative", errResourceLimit)
+	}
+	if memMiB < 0 {
+		return fmt.Errorf("%w: mem must not be negative", errResourceLimit)
+	}
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return err
+	}
+	if cpu == 0 {
+		cpu = record.CPUCount
+	}
+	if memMiB == 0 {
+		memMiB = record.MemoryMiB
+	}
+	if err := resourceLimitsFromEnv().check(cpu, memMiB); err != nil {
+		return err
+	}
+	if cpu == record.CPUCount && memMiB == record.MemoryMiB {
+		return nil
+	}
+
+	unlock, err := s.drainVM(ctx, vmID, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if current, ok := s.Get(vmID); ok {
+		record = current
+	}
+
+	previous := fmt.Sprintf("%d cpu / %d MiB", record.CPUCount, record.MemoryMiB)
+	record.CPUCount = cpu
+	record.MemoryMiB = memMiB
+
+	var launchErr error
+	if record.Status != VMStatusStopped {
+		s.closeREPL(vmID)
+		s.closeShells(vmID)
+		if err := s.launcher.Stop(ctx, vmID); err != nil && !errors.Is(err, errVMNotFound) {
+			return err
+		}
+		// Until the relaunch succeeds the VM is down; a failed relaunch
+		// leaves it stopped, to be launched again by its next run.
+		record.Status = VMStatusStopped
+		if launchErr = s.launch(ctx, record); launchErr == nil {
+			record.Status = VMStatusReady
+		}
+	}
+	if err := s.store.Save(record); err != nil {
+		return errors.Join(launchErr, err)
+	}
+	s.mu.Lock()
+	s.cacheRecord(record)
+	s.mu.Unlock()
+	if launchErr != nil {
+		return fmt.Errorf("relaunch %s: %w", vmID, launchErr)
+	}
+
+	loggerFor(ctx, s.logger).Info("vm resources updated", map[string]any{
+		"id":       vmID,
+		"previous": previous,
+		"cpu":      cpu,
+		"mem_mib":  memMiB,
+	})
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUpdateResourcesRelaunchesWithNewSizing(t *testing.T) {
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+	launches := launcher.launchCalls
+
+	if err := svc.UpdateResources(context.Background(), record.ID, 2, 1024); err != nil {
+		t.Fatalf("update resources: %v", err)
+	}
+	stored, err := svc.store.Get(record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CPUCount != 2 || stored.MemoryMiB != 1024 || stored.Status != VMStatusReady {
+		t.Fatalf("stored record = %d cpu / %d MiB, %s; want 2 / 1024, ready", stored.CPUCount, stored.MemoryMiB, stored.Status)
+	}
+	if launcher.launchCalls != launches+1 {
+		t.Fatalf("launch calls = %d, want one relaunch after %d", launcher.launchCalls, launches)
+	}
+	if launched := launcher.vms[record.ID]; launched.CPUCount != 2 || launched.MemoryMiB != 1024 {
+		t.Fatalf("relaunched with %d cpu / %d MiB, want 2 / 1024", launched.CPUCount, launched.MemoryMiB)
+	}
+
+	// Zero keeps a setting; a stopped VM is resized without launching.
+	if err := svc.Stop(context.Background(), record.ID); err != nil {
+		t.Fatal(err)
+	}
+	launches = launcher.launchCalls
+	if err := svc.UpdateResources(context.Background(), record.ID, 0, 512); err != nil {
+		t.Fatalf("update stopped vm: %v", err)
+	}
+	if got, _ := svc.Get(record.ID); got.CPUCount != 2 || got.MemoryMiB != 512 || got.Status != VMStatusStopped {
+		t.Fatalf("stopped vm = %d cpu / %d MiB, %s; want 2 / 512, stopped", got.CPUCount, got.MemoryMiB, got.Status)
+	}
+	if launcher.launchCalls != launches {
+		t.Fatal("resizing a stopped vm launched it")
+	}
+}
+
+func TestUpdateResourcesValidatesBounds(t *testing.T) {
+	t.Setenv("AGENT_MAX_CPU", "4")
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	record := createTestVM(t, svc)
+
+	for _, c := range []struct{ cpu, mem int }{{8, 0}, {-1, 0}, {0, -256}} {
+		if err := svc.UpdateResources(context.Background(), record.ID, c.cpu, c.mem); !errors.Is(err, errResourceLimit) {
+			t.Errorf("UpdateResources(%d, %d) err = %v, want errResourceLimit", c.cpu, c.mem, err)
+		}
+	}
+	if got, _ := svc.Get(record.ID); got.CPUCount != 1 || got.MemoryMiB != 256 {
+		t.Fatalf("rejected updates changed the vm to %d cpu / %d MiB", got.CPUCount, got.MemoryMiB)
+	}
+	if err := svc.UpdateResources(context.Background(), "missing", 2, 0); !errors.Is(err, errVMNotFound) {
+		t.Fatalf("missing vm err = %v", err)
+	}
+}
+
+func TestPatchVMResources(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	_, server := newTestAPIServer(t, svc)
+
+	patch := func(body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/vm/"+record.ID, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := patch(`{"cpu": 2, "memory": 768}`)
+	defer resp.Body.Close()
+	var decoded struct {
+		Success bool   `json:"success"`
+		Data    VMInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || decoded.Data.CPUCount != 2 || decoded.Data.MemoryMiB != 768 {
+		t.Fatalf("patch = %d, %+v", resp.StatusCode, decoded.Data)
+	}
+
+	for body, want := range map[string]int{`{}`: http.StatusBadRequest, `{"cpu": -2}`: http.StatusBadRequest} {
+		resp := patch(body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("patch %s = %d, want %d", body, resp.StatusCode, want)
+		}
+	}
+}
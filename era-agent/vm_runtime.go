@@ -2,18 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"os/exec"
+	"runtime"
 	"strings"
 )
 
 const (
-	vmRuntimeKrunVM  = "krunvm"
-	vmRuntimeLibkrun = "libkrun"
+	vmRuntimeKrunVM      = "krunvm"
+	vmRuntimeLibkrun     = "libkrun"
+	vmRuntimeFirecracker = "firecracker"
+	vmRuntimeDocker      = "docker"
 )
 
 // VMLauncher defines the backend-specific lifecycle operations for managing VMs.
-// Implementations can be backed by krunvm, libkrun, or any other virtualization
+// Implementations can be backed by krunvm, libkrun, Firecracker, Docker, or any other virtualization
 // runtime that can satisfy the contract.
 type VMLauncher interface {
 	Launch(context.Context, VMRecord) error
@@ -22,6 +27,10 @@ type VMLauncher interface {
 	Run(context.Context, VMRecord, VMRunOptions, io.Writer, io.Writer) (int, error)
 	Shell(context.Context, VMRecord, string, io.Reader, io.Writer, io.Writer) (int, error)
 	List(context.Context) ([]string, error)
+	Stats(context.Context, VMRecord) (VMStats, error)
+	InspectImage(context.Context, string) (ImageInfo, error)
+	ImageCached(context.Context, string) (bool, error)
+	PullImage(context.Context, string) error
 }
 
 // newVMLauncher constructs a VMLauncher implementation based on the requested runtime.
@@ -30,10 +39,42 @@ func newVMLauncher(runtimeName string) (VMLauncher, error) {
 	runtime := strings.ToLower(strings.TrimSpace(runtimeName))
 	switch runtime {
 	case "", vmRuntimeKrunVM:
-		return newKrunVMLauncher(), nil
+		return newKrunVMLauncher()
 	case vmRuntimeLibkrun:
 		return newLibkrunVMLauncher()
+	case vmRuntimeFirecracker:
+		return newFirecrackerVMLauncher()
+	case vmRuntimeDocker:
+		return newDockerVMLauncher()
 	default:
 		return nil, fmt.Errorf("unsupported vm runtime %q", runtimeName)
 	}
 }
+
+// errRuntimeNotFound means the selected runtime's binary is not installed.
+var errRuntimeNotFound = errors.New("vm runtime not found")
+
+// requireRuntimeBinary checks that binary can be executed, so a missing
+// runtime is reported at startup rather than on the first launch.
+func requireRuntimeBinary(runtimeName, binary string) error {
+	if _, err := exec.LookPath(binary); err != nil {
+		return fmt.Errorf("%w: %s runtime needs %q, which is not on PATH; %s", errRuntimeNotFound, runtimeName, binary, runtimeInstallHint(runtimeName, runtime.GOOS))
+	}
+	return nil
+}
+
+// runtimeInstallHint suggests how to install a runtime on goos.
+func runtimeInstallHint(runtimeName, goos string) string {
+	switch {
+	case runtimeName == vmRuntimeKrunVM && goos == "darwin":
+		return "install it with `brew tap slp/krun && brew install krunvm`, or choose another runtime with --vm-runtime"
+	case runtimeName == vmRuntimeKrunVM:
+		return "install krunvm from https://github.com/containers/krunvm, set AGENT_KRUNVM_BIN, or choose another runtime with --vm-runtime"
+	case runtimeName == vmRuntimeDocker:
+		return "install Docker, set AGENT_DOCKER_BIN, or choose another runtime with --vm-runtime"
+	case runtimeName == vmRuntimeFirecracker:
+		return "install firecracker or set AGENT_FIRECRACKER_BIN"
+	default:
+		return "install it or choose another runtime with --vm-runtime"
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMissingRuntimeBinaryFailsFast(t *testing.T) {
+	t.Setenv("AGENT_STATE_DIR", t.TempDir())
+	t.Setenv("AGENT_KRUNVM_BIN", filepath.Join(t.TempDir(), "krunvm"))
+	t.Setenv("AGENT_DOCKER_BIN", "era-test-missing-docker")
+
+	logger, err := NewLogger("error", "")
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	_, err = NewVMService(logger, "")
+	if !errors.Is(err, errRuntimeNotFound) {
+		t.Fatalf("NewVMService err = %v, want errRuntimeNotFound", err)
+	}
+	for _, want := range []string{"krunvm runtime", "not on PATH", "--vm-runtime"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if _, statErr := os.Stat(filepath.Join(os.Getenv("AGENT_STATE_DIR"), stateDBFileName)); !os.IsNotExist(statErr) {
+		t.Errorf("state database opened before the runtime check: %v", statErr)
+	}
+
+	if _, err := newVMLauncher(vmRuntimeDocker); !errors.Is(err, errRuntimeNotFound) || !strings.Contains(err.Error(), `"era-test-missing-docker"`) {
+		t.Fatalf("docker launcher err = %v, want errRuntimeNotFound naming the binary", err)
+	}
+}
+
+func TestRuntimeInstallHintSuggestsHomebrewOnMac(t *testing.T) {
+	if hint := runtimeInstallHint(vmRuntimeKrunVM, "darwin"); !strings.Contains(hint, "brew install krunvm") {
+		t.Fatalf("darwin hint = %q, want a Homebrew suggestion", hint)
+	}
+	if hint := runtimeInstallHint(vmRuntimeKrunVM, "linux"); strings.Contains(hint, "brew") {
+		t.Fatalf("linux hint = %q, should not suggest Homebrew", hint)
+	}
+}
@@ -19,37 +19,132 @@ const (
 	stateDirName           = "agent"
 	stateDBFileName        = "agent.db"
 	defaultGuestUIDGID     = 65532
+	shellAuditLogName      = "shell.log"
 
-	storageDirPerm       os.FileMode = 0o755
-	sharedStoragePerm    os.FileMode = 0o777
-	vmStatusProvisioning             = "provisioning"
-	vmStatusReady                    = "ready"
-	vmStatusRunning                  = "running"
-	vmStatusStopped                  = "stopped"
+	storageDirPerm    os.FileMode = 0o755
+	storageFilePerm   os.FileMode = 0o644
+	sharedStoragePerm os.FileMode = 0o777
+
+	pullPolicyAlways       = "always"
+	pullPolicyIfNotPresent = "ifnotpresent"
+	pullPolicyNever        = "never"
+)
+
+// VMStatus is a VM's lifecycle state. It encodes as the bare string, so
+// stored records and API responses are unchanged.
+type VMStatus string
+
+const (
+	VMStatusProvisioning VMStatus = "provisioning"
+	VMStatusReady        VMStatus = "ready"
+	VMStatusRunning      VMStatus = "running"
+	VMStatusStopped      VMStatus = "stopped"
 )
 
 var (
 	errVMNotFound      = errors.New("vm not found")
+	errVMNotRunning    = errors.New("vm is not running")
+	errImageNotFound   = errors.New("image not found")
+	errRunAborted      = errors.New("run aborted")
+	errImageNotCached  = errors.New("image not cached locally")
 	errUnsupportedLang = errors.New("unsupported language")
+	// errLauncherList wraps List errors from the launcher: the records are
+	// still returned, but their statuses are the last known ones.
+	errLauncherList = errors.New("launcher list failed")
+	// errRunTimeout marks runs killed at their deadline, so a timeout can be
+	// told apart from a command that itself exits with 124.
+	errRunTimeout = errors.New("run timed out")
+	// errVMNotPersistent rejects operations on a VM's persist directory
+	// when it was created without one.
+	errVMNotPersistent = errors.New("vm is not persistent")
+	// errResourceLimit and errVMLimit reject creates above AGENT_MAX_CPU /
+	// AGENT_MAX_MEM_MIB and AGENT_MAX_VMS.
+	errResourceLimit = errors.New("resource limit exceeded")
+	errVMLimit       = errors.New("vm limit reached")
 
 	stateRootOnce     sync.Once
 	resolvedStateRoot string
 )
 
 type VMCreateOptions struct {
-	Language    string
-	Image       string
+	Language string
+	Image    string
+	// CPUCount and MemoryMiB fall back to the language's resource profile
+	// when zero.
 	CPUCount    int
 	MemoryMiB   int
 	NetworkMode string
 	Persist     bool
+	PullPolicy  string
+	Ports       []PortMapping
+	// TTL expires the VM after the given duration; zero keeps it until cleaned.
+	TTL              time.Duration
+	ExpirePersistent bool
+	// Volumes mounts named shared volumes; only Name and GuestPath are read.
+	Volumes []VolumeMount
+	// Owner is an opaque label carried into accounting records.
+	Owner string
+	// Labels group VMs (by project, environment, ...) for list filters.
+	Labels map[string]string
+	// Tenant namespaces the VM in the state database; empty means the
+	// default tenant.
+	Tenant string
+	// Name makes Create idempotent: when the tenant already has a VM of
+	// that name that is not stopped, it is returned instead and the other
+	// options are ignored.
+	Name string
+	// GuestMounts moves the in, out and persist mounts; GuestWorkdir is the
+	// directory runs start in, the image's own when empty.
+	GuestMounts  GuestMounts
+	GuestWorkdir string
+	// DryRun logs the runtime commands instead of executing them and returns
+	// the record the VM would have had, without storing it.
+	DryRun bool
+}
+
+// PortMapping forwards a host TCP port to a port inside the guest.
+type PortMapping struct {
+	HostPort  int
+	GuestPort int
+}
+
+func (p PortMapping) String() string {
+	return fmt.Sprintf("%d:%d", p.HostPort, p.GuestPort)
 }
 
 type VMRunOptions struct {
 	VMID    string
 	Command string
 	File    string
+	Stdin   string
 	Timeout int
+	// Commands run one after another in the same guest shell, in place of
+	// Command or File. The run stops at the first non-zero exit, which
+	// becomes its exit code.
+	Commands []string
+	// ContinueOnError runs every one of Commands regardless of failures;
+	// the exit code is then the first non-zero one.
+	ContinueOnError bool
+	// MaxOutputBytes caps each captured stream; zero uses the default.
+	MaxOutputBytes int
+	// AutoInstall retries once after installing a package the command failed
+	// to import (python and node only; needs network access).
+	AutoInstall bool
+	// GuestTimeout also enforces Timeout inside the guest with timeout(1), so
+	// the kill reaches every descendant. AGENT_GUEST_TIMEOUT=1 turns it on for
+	// all runs.
+	GuestTimeout bool
+	// CleanOutput empties /out before the run so files left by earlier runs
+	// cannot be mistaken for this run's. Always on for non-persistent VMs.
+	CleanOutput bool
+	// Envs are exported in the guest shell before the command runs.
+	Envs map[string]string
+	// DryRun logs the runtime command instead of executing it and returns
+	// a zero exit code. Nothing is staged, captured or recorded.
+	DryRun bool
+
+	// onEvent receives output as it is captured; see RunCollect.
+	onEvent func(StreamEvent)
 }
 
 type VMRunResult struct {
@@ -57,6 +152,55 @@ type VMRunResult struct {
 	StdoutPath string
 	StderrPath string
 	Duration   time.Duration
+	Aborted    bool
+	TimedOut   bool // killed because the run's timeout expired
+	Truncated  bool
+	// AutoInstalled names the package installed before the final attempt.
+	AutoInstalled string
+}
+
+// ExecOutput is a VMRunResult with the captured streams read into memory.
+type ExecOutput struct {
+	ExitCode  int
+	Stdout    string
+	Stderr    string
+	Duration  time.Duration
+	Aborted   bool
+	TimedOut  bool
+	Truncated bool
+
+	AutoInstalled string
+}
+
+// VMCreateTimings breaks down where time went while creating a VM.
+type VMCreateTimings struct {
+	Resolve time.Duration // option validation, rootfs and volume resolution
+	Storage time.Duration // host directory preparation
+	Launch  time.Duration // pull policy checks and krunvm create, across rootfs fallbacks
+	Persist time.Duration // writing the record to the state store
+	Total   time.Duration
+}
+
+type VMStats struct {
+	PID int
+	// CPUPercent is the CPU time used since the process started, as a share
+	// of its lifetime, not a current rate (ps's %cpu is the same average).
+	CPUPercent float64
+	MemoryMiB  float64
+	Uptime     time.Duration
+}
+
+type ImageInfo struct {
+	Ref       string
+	Digest    string
+	SizeBytes int64
+}
+
+type ImageCheckResult struct {
+	Ref       string
+	Exists    bool
+	Digest    string
+	SizeBytes int64
 }
 
 type VMRunError struct {
@@ -86,29 +230,95 @@ type StorageLayout struct {
 	NetworkMode         string
 	ReadOnlyRoot        bool
 	DisableGuestVolumes bool
+	Volumes             []VolumeMount
+	// GuestMounts are the guest paths of InputPath, OutputPath and
+	// PersistPath; see guestIn. GuestWorkdir is where runs start.
+	GuestMounts  GuestMounts
+	GuestWorkdir string
 }
 
 type VMRecord struct {
 	ID          string
 	Language    string
 	RootFSImage string
+	PullPolicy  string
 	CPUCount    int
 	MemoryMiB   int
 	NetworkMode string
+	Ports       []PortMapping
 	Persist     bool
-	Status      string
+	Status      VMStatus
 	Storage     StorageLayout
 	CreatedAt   time.Time
 	LastRunAt   time.Time
+
+	ExpiresAt        time.Time
+	ExpirePersistent bool
+
+	// CreateTimings is only set on the record Create returns, for the create
+	// response; it is never stored, since the store write is one of the
+	// phases it measures.
+	CreateTimings VMCreateTimings `json:"-"`
+
+	Owner  string
+	Labels map[string]string
+	Tenant string
+	Name   string
 }
 
 type VMService struct {
 	logger   *Logger
 	launcher VMLauncher
-	store    *BoltVMStore
+	store    VMStore
+
+	// images maps languages to rootfs candidates from the image config file.
+	images map[string][]string
+	// profiles maps languages to the default CPU and memory for creates.
+	profiles map[string]resourceProfile
+
+	// shellAudit tees interactive shell output into the VM's out/ directory.
+	shellAudit bool
+
+	// repls holds the VMs' REPL sessions (see EvalREPL). Guarded by replMu.
+	replMu sync.Mutex
+	repls  map[string]*replSession
 
 	mu    sync.RWMutex
 	cache map[string]VMRecord
+	// names indexes cache by tenant and name (see cacheRecord). Guarded by
+	// mu.
+	names map[string]map[string]struct{}
+	// vmLocks serializes runs per VM, since they share out/stdout.log and
+	// out/stderr.log. Guarded by mu.
+	vmLocks map[string]*sync.Mutex
+	// nameLocks serializes creates per tenant and name. Guarded by mu.
+	nameLocks map[string]*nameLock
+	// pendingCreates counts creates between reserveVMSlot and their end.
+	// Guarded by mu.
+	pendingCreates int
+	// reservedIDs holds the IDs of creates that have not been stored yet
+	// (see reserveVMID). Guarded by mu.
+	reservedIDs map[string]struct{}
+
+	runMu     sync.Mutex
+	nextRunID uint64
+	runs      map[string]map[uint64]context.CancelCauseFunc
+	// shells holds the VMs' open interactive shells, which stop and clean
+	// end (see Shell). Guarded by runMu.
+	shells map[string]map[uint64]context.CancelFunc
+
+	metrics    *vmMetrics
+	accounting *runAccountant
+	events     vmEventBus
+
+	// probeMu serializes CheckRuntime and guards lastProbe.
+	probeMu   sync.Mutex
+	lastProbe RuntimeHealth
+
+	now        func() time.Time
+	newID      func(language string) string
+	stopReaper chan struct{}
+	reaperDone chan struct{}
 }
 
 func NewVMService(logger *Logger, runtimeName string) (*VMService, error) {
@@ -117,7 +327,7 @@ func NewVMService(logger *Logger, runtimeName string) (*VMService, error) {
 		return nil, err
 	}
 
-	store, err := NewBoltVMStore(stateRoot())
+	store, err := openVMStore(stateRoot())
 	if err != nil {
 		return nil, err
 	}
@@ -128,34 +338,67 @@ func NewVMService(logger *Logger, runtimeName string) (*VMService, error) {
 		return nil, err
 	}
 
-	cache := make(map[string]VMRecord, len(records))
+	accounting, err := newRunAccountant(logger)
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("open accounting sink: %w", err)
+	}
+
+	svc := &VMService{
+		logger:     logger,
+		launcher:   launcher,
+		store:      store,
+		images:     loadImageConfig(logger),
+		profiles:   loadResourceProfiles(logger),
+		shellAudit: shellAuditEnabled(),
+		cache:      make(map[string]VMRecord, len(records)),
+		runs:       make(map[string]map[uint64]context.CancelCauseFunc),
+		accounting: accounting,
+	}
 	for _, record := range records {
 		record.Storage = normalizeStorageLayout(record.Storage)
-		cache[record.ID] = record
+		record.Tenant = normalizeTenant(record.Tenant)
+		svc.cacheRecord(record)
 		_ = ensureStorageLayout(record.Storage)
 	}
+	svc.metrics = newVMMetrics(svc)
+	if adoptOnStartEnabled() {
+		if _, err := svc.Adopt(context.Background()); err != nil {
+			logger.Warn("failed to adopt orphaned vms", map[string]any{"error": err.Error()})
+		}
+	}
+	svc.startReaper(reapInterval())
 
-	return &VMService{
-		logger:   logger,
-		launcher: launcher,
-		store:    store,
-		cache:    cache,
-	}, nil
+	return svc, nil
 }
 
 func (s *VMService) Close() error {
+	s.closeAllREPLs()
+	s.events.close()
+	if s.stopReaper != nil {
+		close(s.stopReaper)
+		<-s.reaperDone
+		s.stopReaper = nil
+	}
+	if err := s.accounting.Close(); err != nil {
+		s.logger.Warn("failed to close accounting sink", map[string]any{"error": err.Error()})
+	}
 	return s.store.Close()
 }
 
+// List returns every tracked VM, reconciling statuses with the VMs the
+// launcher reports. If the launcher cannot be listed even after retries
+// (see listLauncherVMs), no status is changed: the records are returned with
+// their last known statuses, along with an error wrapping errLauncherList.
 func (s *VMService) List(ctx context.Context) ([]VMRecord, error) {
 	presentIDs := make(map[string]struct{})
 	var listErr error
-	if ids, err := s.launcher.List(ctx); err == nil {
+	if ids, err := s.listLauncherVMs(ctx); err == nil {
 		for _, id := range ids {
 			presentIDs[id] = struct{}{}
 		}
 	} else {
-		listErr = err
+		listErr = fmt.Errorf("%w: %v", errLauncherList, err)
 		s.logger.Warn("failed to enumerate krunvm instances", map[string]any{"error": err.Error()})
 		presentIDs = nil
 	}
@@ -167,16 +410,16 @@ func (s *VMService) List(ctx context.Context) ([]VMRecord, error) {
 	for id, record := range s.cache {
 		if presentIDs != nil {
 			_, exists := presentIDs[id]
-			if !exists && record.Status != vmStatusStopped {
-				record.Status = vmStatusStopped
-				s.cache[id] = record
+			if !exists && record.Status != VMStatusStopped {
+				record.Status = VMStatusStopped
+				s.cacheRecord(record)
 				if err := s.store.Save(record); err != nil {
 					s.logger.Warn("failed to persist vm status", map[string]any{"vm": id, "error": err.Error()})
 				}
 			}
-			if exists && record.Status == vmStatusStopped {
-				record.Status = vmStatusReady
-				s.cache[id] = record
+			if exists && record.Status == VMStatusStopped {
+				record.Status = VMStatusReady
+				s.cacheRecord(record)
 				if err := s.store.Save(record); err != nil {
 					s.logger.Warn("failed to persist vm status", map[string]any{"vm": id, "error": err.Error()})
 				}
@@ -195,18 +438,110 @@ func (s *VMService) List(ctx context.Context) ([]VMRecord, error) {
 	return records, listErr
 }
 
+// ListByStatus is List narrowed to VMs in one of statuses, matched after
+// reconciliation; no statuses returns every VM. A launcher listing error is
+// returned the same way as from List.
+func (s *VMService) ListByStatus(ctx context.Context, statuses ...VMStatus) ([]VMRecord, error) {
+	records, err := s.List(ctx)
+	if len(statuses) == 0 {
+		return records, err
+	}
+	filtered := records[:0]
+	for _, record := range records {
+		for _, status := range statuses {
+			if record.Status == status {
+				filtered = append(filtered, record)
+				break
+			}
+		}
+	}
+	return filtered, err
+}
+
 func (s *VMService) Create(ctx context.Context, opts VMCreateOptions) (VMRecord, error) {
+	createStart := time.Now()
+	phaseStart := createStart
+	var timings VMCreateTimings
+	endPhase := func(phase *time.Duration) {
+		now := time.Now()
+		*phase = now.Sub(phaseStart)
+		phaseStart = now
+	}
+
 	language := normalizeLanguage(opts.Language)
 	if language == "" {
 		return VMRecord{}, errors.New("language is required")
 	}
 
-	if opts.CPUCount <= 0 {
+	if opts.CPUCount < 0 {
 		return VMRecord{}, errors.New("cpu must be greater than zero")
 	}
-	if opts.MemoryMiB <= 0 {
+	if opts.MemoryMiB < 0 {
 		return VMRecord{}, errors.New("mem must be greater than zero")
 	}
+	profile := s.resourceProfileFor(language)
+	if opts.CPUCount == 0 {
+		opts.CPUCount = profile.CPUCount
+	}
+	if opts.MemoryMiB == 0 {
+		opts.MemoryMiB = profile.MemoryMiB
+	}
+	name := strings.TrimSpace(opts.Name)
+	if name != "" {
+		if err := validateVMName(name); err != nil {
+			return VMRecord{}, err
+		}
+		tenant := normalizeTenant(opts.Tenant)
+		unlock := s.lockName(tenant, name)
+		defer unlock()
+		if existing, ok := s.findByName(tenant, name); ok {
+			return existing, nil
+		}
+	}
+
+	limits := resourceLimitsFromEnv()
+	if err := limits.check(opts.CPUCount, opts.MemoryMiB); err != nil {
+		return VMRecord{}, err
+	}
+	release, err := s.reserveVMSlot(limits.MaxVMs)
+	if err != nil {
+		return VMRecord{}, err
+	}
+	defer release()
+
+	pullPolicy, err := normalizePullPolicy(opts.PullPolicy)
+	if err != nil {
+		return VMRecord{}, err
+	}
+
+	if opts.NetworkMode, err = normalizeNetworkMode(opts.NetworkMode); err != nil {
+		return VMRecord{}, err
+	}
+	if err := validatePortMappings(opts.NetworkMode, opts.Ports); err != nil {
+		return VMRecord{}, err
+	}
+	if opts.TTL < 0 {
+		return VMRecord{}, errors.New("ttl cannot be negative")
+	}
+	if err := validateLabels(opts.Labels); err != nil {
+		return VMRecord{}, err
+	}
+	var labels map[string]string
+	if len(opts.Labels) > 0 {
+		labels = make(map[string]string, len(opts.Labels))
+		for key, value := range opts.Labels {
+			labels[key] = value
+		}
+	}
+
+	guestMounts, guestWorkdir, err := resolveGuestLayout(opts.GuestMounts, opts.GuestWorkdir)
+	if err != nil {
+		return VMRecord{}, err
+	}
+	volumeMounts, err := s.resolveVolumeMounts(opts.Volumes, guestMounts)
+	if err != nil {
+		return VMRecord{}, err
+	}
 
 	rootfsCandidates, err := s.resolveRootFSCandidates(language, opts.Image)
 	if err != nil {
@@ -216,34 +551,61 @@ func (s *VMService) Create(ctx context.Context, opts VMCreateOptions) (VMRecord,
 		return VMRecord{}, errors.New("no rootfs candidates resolved")
 	}
 
-	vmID := sanitizeID(fmt.Sprintf("%s-%d", language, time.Now().UTC().UnixNano()))
+	endPhase(&timings.Resolve)
+
+	if opts.DryRun {
+		if ctx, err = s.dryRunContext(ctx); err != nil {
+			return VMRecord{}, err
+		}
+	}
+
+	vmID, releaseID, err := s.reserveVMID(language)
+	if err != nil {
+		return VMRecord{}, err
+	}
+	defer releaseID()
 	layout, err := prepareStorage(vmID, opts.Persist)
 	if err != nil {
 		return VMRecord{}, err
 	}
+	endPhase(&timings.Storage)
 	layout.NetworkMode = opts.NetworkMode
 	layout.ReadOnlyRoot = true
+	layout.Volumes = volumeMounts
+	layout.GuestMounts = guestMounts
+	layout.GuestWorkdir = guestWorkdir
 
 	record := VMRecord{
 		ID:          vmID,
 		Language:    language,
 		RootFSImage: rootfsCandidates[0],
+		PullPolicy:  pullPolicy,
 		CPUCount:    opts.CPUCount,
 		MemoryMiB:   opts.MemoryMiB,
 		NetworkMode: opts.NetworkMode,
+		Ports:       opts.Ports,
 		Persist:     opts.Persist,
-		Status:      vmStatusProvisioning,
+		Status:      VMStatusProvisioning,
 		Storage:     layout,
-		CreatedAt:   time.Now().UTC(),
+		CreatedAt:   s.clock().UTC(),
+
+		ExpirePersistent: opts.ExpirePersistent,
+		Owner:            strings.TrimSpace(opts.Owner),
+		Labels:           labels,
+		Tenant:           normalizeTenant(opts.Tenant),
+		Name:             name,
+	}
+	if opts.TTL > 0 {
+		record.ExpiresAt = record.CreatedAt.Add(opts.TTL)
 	}
 
 	var launchErr error
 	for idx, candidate := range rootfsCandidates {
 		record.RootFSImage = candidate
-		launchErr = s.launcher.Launch(ctx, record)
+		launchErr = s.launchWithRetry(ctx, record)
 		if launchErr == nil {
 			if idx > 0 {
-				s.logger.Info("vm rootfs fallback applied", map[string]any{
+				loggerFor(ctx, s.logger).Info("vm rootfs fallback applied", map[string]any{
 					"id":      record.ID,
 					"rootfs":  candidate,
 					"attempt": idx + 1,
@@ -251,9 +613,13 @@ func (s *VMService) Create(ctx context.Context, opts VMCreateOptions) (VMRecord,
 			}
 			break
 		}
+		// A cancelled create must not move on to the next candidate.
+		if ctx.Err() != nil {
+			break
+		}
 
 		if idx < len(rootfsCandidates)-1 {
-			s.logger.Warn("vm launch failed with rootfs candidate", map[string]any{
+			loggerFor(ctx, s.logger).Warn("vm launch failed with rootfs candidate", map[string]any{
 				"id":      record.ID,
 				"rootfs":  candidate,
 				"attempt": idx + 1,
@@ -263,16 +629,33 @@ func (s *VMService) Create(ctx context.Context, opts VMCreateOptions) (VMRecord,
 	}
 
 	if launchErr != nil {
+		if ctx.Err() != nil {
+			// The launcher may have been interrupted halfway through creating
+			// the VM, so remove whatever it left behind.
+			_ = s.launcher.Cleanup(context.Background(), vmID)
+			launchErr = fmt.Errorf("create cancelled: %w", ctx.Err())
+		}
 		_ = os.RemoveAll(layout.Root)
 		if opts.Persist && layout.PersistPath != "" {
 			_ = os.RemoveAll(layout.PersistPath)
 		}
 		return VMRecord{}, launchErr
 	}
+	endPhase(&timings.Launch)
 
-	record.Status = vmStatusReady
+	record.Status = VMStatusReady
 
-	if err := s.store.Save(record); err != nil {
+	if opts.DryRun {
+		_ = os.RemoveAll(layout.Root)
+		if opts.Persist && layout.PersistPath != "" {
+			_ = os.RemoveAll(layout.PersistPath)
+		}
+		record.CreateTimings = timings
+		record.CreateTimings.Total = time.Since(createStart)
+		return record, nil
+	}
+
+	if err := s.store.Insert(record); err != nil {
 		_ = s.launcher.Cleanup(ctx, vmID)
 		_ = os.RemoveAll(layout.Root)
 		if opts.Persist && layout.PersistPath != "" {
@@ -281,33 +664,201 @@ func (s *VMService) Create(ctx context.Context, opts VMCreateOptions) (VMRecord,
 		return VMRecord{}, err
 	}
 
+	endPhase(&timings.Persist)
+
 	s.mu.Lock()
-	s.cache[vmID] = record
+	s.cacheRecord(record)
 	s.mu.Unlock()
 
+	s.metrics.observeCreate(record.Language)
+	s.emit(VMEventCreated, record)
+
+	record.CreateTimings = timings
+	record.CreateTimings.Total = time.Since(createStart)
 	return record, nil
 }
 
+// Run executes a command in the VM. Runs on the same VM are serialized
+// because they share its output files; use separate VMs for parallelism.
 func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, error) {
+	unlock := s.lockVM(opts.VMID)
+	defer unlock()
+	if opts.DryRun {
+		// Nothing runs, so there is nothing to observe or record.
+		return s.run(ctx, opts)
+	}
+	return s.runObserved(ctx, opts)
+}
+
+// lockVM takes the per-VM run lock and returns the function releasing it.
+func (s *VMService) lockVM(vmID string) func() {
+	lock := s.vmLock(vmID)
+	lock.Lock()
+	return lock.Unlock
+}
+
+func (s *VMService) vmLock(vmID string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.vmLocks == nil {
+		s.vmLocks = make(map[string]*sync.Mutex)
+	}
+	lock, ok := s.vmLocks[vmID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.vmLocks[vmID] = lock
+	}
+	return lock
+}
+
+func (s *VMService) runObserved(ctx context.Context, opts VMRunOptions) (VMRunResult, error) {
+	start := time.Now()
+	startedAt := s.clock()
+	stopSampling := func() *float64 { return nil }
+	if record, ok := s.Get(opts.VMID); ok {
+		stopSampling = s.accounting.sampleMemory(ctx, s.launcher, record)
+		s.emit(VMEventRunStarted, record)
+	}
+
+	result, err := s.run(ctx, opts)
+	if err != nil && opts.AutoInstall {
+		if retried, ok, retryErr := s.autoInstallAndRetry(ctx, opts, err); ok {
+			result, err = retried, retryErr
+		}
+	}
+
+	peakMemory := stopSampling()
+	if record, ok := s.Get(opts.VMID); ok {
+		duration := time.Since(start)
+		s.metrics.observeRun(record.Language, result, err, duration)
+		s.accounting.observeRun(record, result, err, start, duration, peakMemory)
+		s.recordRun(record, opts, result, err, startedAt, duration)
+		s.events.publish(VMEvent{
+			Type:     VMEventRunFinished,
+			VMID:     record.ID,
+			Language: record.Language,
+			Time:     s.clock(),
+			ExitCode: result.ExitCode,
+			Err:      err,
+		})
+	}
+	return result, err
+}
+
+// Exec runs a command like Run and returns the captured output, read once from
+// the output files and capped at opts.MaxOutputBytes per stream. A failed run
+// still returns whatever output it produced alongside the error.
+func (s *VMService) Exec(ctx context.Context, opts VMRunOptions) (ExecOutput, error) {
+	// Hold the VM until the output files are read back.
+	unlock := s.lockVM(opts.VMID)
+	defer unlock()
+
+	result, err := s.runObserved(ctx, opts)
+	var runErr *VMRunError
+	if errors.As(err, &runErr) {
+		result = runErr.Result
+	}
+
+	output := ExecOutput{
+		ExitCode:  result.ExitCode,
+		Duration:  result.Duration,
+		Aborted:   result.Aborted,
+		TimedOut:  result.TimedOut,
+		Truncated: result.Truncated,
+
+		AutoInstalled: result.AutoInstalled,
+	}
+
+	limit := maxOutputBytes(opts.MaxOutputBytes)
+	var readErr error
+	if output.Stdout, readErr = readCapturedOutput(result.StdoutPath, limit); readErr != nil && err == nil {
+		err = readErr
+	}
+	if output.Stderr, readErr = readCapturedOutput(result.StderrPath, limit); readErr != nil && err == nil {
+		err = readErr
+	}
+
+	return output, err
+}
+
+func (s *VMService) run(ctx context.Context, opts VMRunOptions) (VMRunResult, error) {
 	if opts.Timeout <= 0 {
 		return VMRunResult{}, errors.New("timeout must be positive")
 	}
-	if opts.Command == "" {
+	if len(opts.Commands) > 0 {
+		if opts.Command != "" || opts.File != "" {
+			return VMRunResult{}, errors.New("commands cannot be combined with cmd or file")
+		}
+		for i, command := range opts.Commands {
+			if strings.TrimSpace(command) == "" {
+				return VMRunResult{}, fmt.Errorf("commands[%d] is empty", i)
+			}
+		}
+	} else if opts.Command == "" && opts.File == "" {
 		return VMRunResult{}, errors.New("cmd is required")
 	}
+	if err := validateRunEnvs(opts.Envs); err != nil {
+		return VMRunResult{}, err
+	}
 
 	record, err := s.fetchRecord(opts.VMID)
 	if err != nil {
 		return VMRunResult{}, err
 	}
 
+	if opts.Command == "" && opts.File != "" {
+		// Run the staged file with the VM language's interpreter.
+		command, err := buildExecutionCommand(record.Language, record.Storage.guestIn(), opts.File)
+		if err != nil {
+			return VMRunResult{}, err
+		}
+		opts.Command = command
+	}
+	policy, err := loadCommandPolicy()
+	if err != nil {
+		return VMRunResult{}, err
+	}
+	if len(opts.Commands) > 0 {
+		for _, command := range opts.Commands {
+			if err := policy.check(command); err != nil {
+				return VMRunResult{}, err
+			}
+		}
+		opts.Command = sequenceCommand(opts.Commands, opts.ContinueOnError)
+	} else if err := policy.check(opts.Command); err != nil {
+		return VMRunResult{}, err
+	}
+	if len(opts.Envs) > 0 {
+		opts.Command = envExportCommand(opts.Envs, opts.Command)
+	}
+
+	if opts.GuestTimeout || guestTimeoutEnabled() {
+		opts.Command = guestTimeoutCommand(opts.Command, opts.Timeout)
+	}
+	if opts.DryRun {
+		return s.dryRunCommand(ctx, record, opts)
+	}
+	if (opts.CleanOutput || !record.Persist) && record.Storage.OutputPath != "" {
+		if err := clearOutputDir(record.Storage.OutputPath); err != nil {
+			return VMRunResult{}, fmt.Errorf("clear output directory: %w", err)
+		}
+	}
+	exitCodePath := ""
+	if !record.Storage.DisableGuestVolumes && record.Storage.OutputPath != "" {
+		exitCodePath = filepath.Join(record.Storage.OutputPath, exitCodeFileName)
+		if err := os.Remove(exitCodePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return VMRunResult{}, err
+		}
+		opts.Command = exitCodeSentinelCommand(record.Storage.guestOut(), opts.Command)
+	}
+
 	switch record.Status {
-	case vmStatusReady, vmStatusRunning:
-	case vmStatusStopped:
-		if err := s.launcher.Launch(ctx, record); err != nil {
+	case VMStatusReady, VMStatusRunning:
+	case VMStatusStopped:
+		if err := s.launch(ctx, record); err != nil {
 			return VMRunResult{}, err
 		}
-		record.Status = vmStatusReady
+		record.Status = VMStatusReady
 	default:
 		return VMRunResult{}, errors.New("vm is not available to run commands")
 	}
@@ -321,13 +872,16 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 		}
 	}
 
-	runCtx, cancel := context.WithTimeout(ctx, time.Duration(opts.Timeout)*time.Second)
+	abortCtx, release := s.trackRun(ctx, record.ID)
+	defer release()
+
+	runCtx, cancel := context.WithTimeout(abortCtx, time.Duration(opts.Timeout)*time.Second)
 	defer cancel()
 
 	start := time.Now()
 
-	stdoutPath := filepath.Join(record.Storage.OutputPath, "stdout.log")
-	stderrPath := filepath.Join(record.Storage.OutputPath, "stderr.log")
+	stdoutPath := filepath.Join(record.Storage.OutputPath, stdoutLogName)
+	stderrPath := filepath.Join(record.Storage.OutputPath, stderrLogName)
 
 	stdoutFile, err := os.OpenFile(stdoutPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
 	if err != nil {
@@ -345,7 +899,12 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 		_ = stderrFile.Close()
 	}()
 
-	exitCode, runErr := s.launcher.Run(runCtx, record, opts, stdoutFile, stderrFile)
+	outputLimit := maxOutputBytes(opts.MaxOutputBytes)
+	stdoutSink, stderrSink := outputSinks(opts, stdoutFile, stderrFile)
+	stdoutCapture := newCappedWriter(stdoutSink, outputLimit)
+	stderrCapture := newCappedWriter(stderrSink, outputLimit)
+
+	exitCode, runErr := s.launcher.Run(runCtx, record, opts, stdoutCapture, stderrCapture)
 	if runErr != nil {
 		var cmdErr *commandError
 		if !errors.As(runErr, &cmdErr) {
@@ -353,15 +912,15 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 		}
 
 		if isMissingVMError(cmdErr) {
-			s.logger.Warn("vm missing from krunvm, recreating", map[string]any{
+			loggerFor(ctx, s.logger).Warn("vm missing from krunvm, recreating", map[string]any{
 				"vm":       record.ID,
 				"language": record.Language,
 			})
 
-			if err := s.launcher.Launch(ctx, record); err != nil {
+			if err := s.launch(ctx, record); err != nil {
 				return VMRunResult{}, err
 			}
-			record.Status = vmStatusReady
+			record.Status = VMStatusReady
 
 			if err := truncateAndRewind(stdoutFile); err != nil {
 				return VMRunResult{}, err
@@ -369,8 +928,10 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 			if err := truncateAndRewind(stderrFile); err != nil {
 				return VMRunResult{}, err
 			}
+			stdoutCapture.reset()
+			stderrCapture.reset()
 
-			exitCode, runErr = s.launcher.Run(runCtx, record, opts, stdoutFile, stderrFile)
+			exitCode, runErr = s.launcher.Run(runCtx, record, opts, stdoutCapture, stderrCapture)
 			if runErr != nil {
 				if !errors.As(runErr, &cmdErr) {
 					return VMRunResult{}, runErr
@@ -381,15 +942,36 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 
 	duration := time.Since(start)
 
+	// A zero from the launcher is not trusted when the guest recorded a
+	// different status; see exitCodeSentinelCommand.
+	if exitCode == 0 && exitCodePath != "" {
+		if guestCode, ok := readExitCodeFile(exitCodePath); ok && guestCode != 0 {
+			loggerFor(ctx, s.logger).Debug("using guest-reported exit code", map[string]any{
+				"vm":        record.ID,
+				"exit_code": guestCode,
+			})
+			exitCode = guestCode
+		}
+	}
+
+	stdoutTruncated, err := stdoutCapture.finish()
+	if err != nil {
+		return VMRunResult{}, err
+	}
+	stderrTruncated, err := stderrCapture.finish()
+	if err != nil {
+		return VMRunResult{}, err
+	}
+
 	record.LastRunAt = time.Now().UTC()
-	record.Status = vmStatusReady
+	record.Status = VMStatusReady
 
 	if err := s.store.Save(record); err != nil {
 		return VMRunResult{}, err
 	}
 
 	s.mu.Lock()
-	s.cache[record.ID] = record
+	s.cacheRecord(record)
 	s.mu.Unlock()
 
 	result := VMRunResult{
@@ -397,6 +979,23 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 		StdoutPath: stdoutPath,
 		StderrPath: stderrPath,
 		Duration:   duration,
+		Truncated:  stdoutTruncated || stderrTruncated,
+	}
+
+	if errors.Is(context.Cause(abortCtx), errRunAborted) {
+		result.Aborted = true
+		return result, &VMRunError{
+			Result: result,
+			Err:    fmt.Errorf("%w after %s", errRunAborted, duration.Round(time.Millisecond)),
+		}
+	}
+
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+		return result, &VMRunError{
+			Result: result,
+			Err:    fmt.Errorf("%w after %ds (exit code %d)", errRunTimeout, opts.Timeout, exitCode),
+		}
 	}
 
 	if exitCode != 0 {
@@ -413,20 +1012,180 @@ func (s *VMService) Run(ctx context.Context, opts VMRunOptions) (VMRunResult, er
 	return result, nil
 }
 
+// Abort cancels every in-flight run on the VM without tearing the VM down and
+// returns how many runs were signalled. Only runs started by this process are
+// tracked.
+func (s *VMService) Abort(vmID string) (int, error) {
+	if _, err := s.fetchRecord(vmID); err != nil {
+		return 0, err
+	}
+
+	aborted := s.cancelRuns(vmID)
+	if aborted > 0 {
+		s.logger.Info("vm runs aborted", map[string]any{"vm": vmID, "runs": aborted})
+	}
+	return aborted, nil
+}
+
+// cancelRuns aborts the VM's tracked runs and returns how many there were.
+func (s *VMService) cancelRuns(vmID string) int {
+	s.runMu.Lock()
+	active := s.runs[vmID]
+	delete(s.runs, vmID)
+	s.runMu.Unlock()
+
+	for _, cancel := range active {
+		cancel(errRunAborted)
+	}
+	return len(active)
+}
+
+// trackRun derives a context that Abort can cancel. The returned release func
+// must be called once the run finishes.
+func (s *VMService) trackRun(ctx context.Context, vmID string) (context.Context, func()) {
+	runCtx, cancel := context.WithCancelCause(ctx)
+
+	s.runMu.Lock()
+	if s.runs == nil {
+		s.runs = make(map[string]map[uint64]context.CancelCauseFunc)
+	}
+	s.nextRunID++
+	runID := s.nextRunID
+	if s.runs[vmID] == nil {
+		s.runs[vmID] = make(map[uint64]context.CancelCauseFunc)
+	}
+	s.runs[vmID][runID] = cancel
+	s.runMu.Unlock()
+
+	return runCtx, func() {
+		s.runMu.Lock()
+		if active := s.runs[vmID]; active != nil {
+			delete(active, runID)
+			if len(active) == 0 {
+				delete(s.runs, vmID)
+			}
+		}
+		s.runMu.Unlock()
+		cancel(nil)
+	}
+}
+
+// trackShell derives a context that closeShells cancels. The returned
+// release func must be called once the shell exits.
+func (s *VMService) trackShell(ctx context.Context, vmID string) (context.Context, func()) {
+	shellCtx, cancel := context.WithCancel(ctx)
+
+	s.runMu.Lock()
+	if s.shells == nil {
+		s.shells = make(map[string]map[uint64]context.CancelFunc)
+	}
+	s.nextRunID++
+	shellID := s.nextRunID
+	if s.shells[vmID] == nil {
+		s.shells[vmID] = make(map[uint64]context.CancelFunc)
+	}
+	s.shells[vmID][shellID] = cancel
+	s.runMu.Unlock()
+
+	return shellCtx, func() {
+		s.runMu.Lock()
+		if open := s.shells[vmID]; open != nil {
+			delete(open, shellID)
+			if len(open) == 0 {
+				delete(s.shells, vmID)
+			}
+		}
+		s.runMu.Unlock()
+		cancel()
+	}
+}
+
+// closeShells kills the VM's open shells and returns how many there were.
+func (s *VMService) closeShells(vmID string) int {
+	s.runMu.Lock()
+	open := s.shells[vmID]
+	delete(s.shells, vmID)
+	s.runMu.Unlock()
+
+	for _, cancel := range open {
+		cancel()
+	}
+	return len(open)
+}
+
+// Shell attaches an interactive shell to the VM. In audit mode everything the
+// shell writes is also appended to out/shell.log while still reaching the
+// caller's terminal. Stopping, cleaning or resizing the VM kills the shell.
+func (s *VMService) Shell(ctx context.Context, vmID, shellCmd string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return -1, err
+	}
+
+	if record.Status != VMStatusReady && record.Status != VMStatusRunning {
+		return -1, fmt.Errorf("vm %s is not ready or running", vmID)
+	}
+	if err := checkShellAllowed(); err != nil {
+		return -1, err
+	}
+	ctx, release := s.trackShell(ctx, vmID)
+	defer release()
+
+	if s.shellAudit {
+		auditPath := filepath.Join(record.Storage.OutputPath, shellAuditLogName)
+		auditFile, err := os.OpenFile(auditPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return -1, err
+		}
+		defer func() {
+			_ = auditFile.Close()
+		}()
+
+		fmt.Fprintf(auditFile, "=== shell session %s: %s ===\n", time.Now().UTC().Format(time.RFC3339), shellCmd)
+		stdout = io.MultiWriter(stdout, auditFile)
+		stderr = io.MultiWriter(stderr, auditFile)
+
+		loggerFor(ctx, s.logger).Debug("shell audit enabled", map[string]any{"vm": vmID, "path": auditPath})
+	}
+
+	return s.launcher.Shell(ctx, record, shellCmd, stdin, stdout, stderr)
+}
+
+// Stop shuts the VM down, first draining its runs (see drainVM).
 func (s *VMService) Stop(ctx context.Context, vmID string) error {
+	return s.stop(ctx, vmID, false)
+}
+
+// ForceStop is Stop without the grace period: in-flight runs are aborted.
+func (s *VMService) ForceStop(ctx context.Context, vmID string) error {
+	return s.stop(ctx, vmID, true)
+}
+
+func (s *VMService) stop(ctx context.Context, vmID string, force bool) error {
 	record, err := s.fetchRecord(vmID)
 	if err != nil {
 		return err
 	}
+	unlock, err := s.drainVM(ctx, vmID, force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// A run that finished while draining may have updated the record.
+	if current, ok := s.Get(vmID); ok {
+		record = current
+	}
+	s.closeREPL(vmID)
+	s.closeShells(vmID)
 
 	if err := s.launcher.Stop(ctx, vmID); err != nil {
 		if errors.Is(err, errVMNotFound) {
-			record.Status = vmStatusStopped
+			record.Status = VMStatusStopped
 		} else {
 			return err
 		}
 	} else {
-		record.Status = vmStatusStopped
+		record.Status = VMStatusStopped
 	}
 
 	record.LastRunAt = time.Now().UTC()
@@ -436,17 +1195,40 @@ func (s *VMService) Stop(ctx context.Context, vmID string) error {
 	}
 
 	s.mu.Lock()
-	s.cache[vmID] = record
+	s.cacheRecord(record)
 	s.mu.Unlock()
 
+	s.emit(VMEventStopped, record)
 	return nil
 }
 
+// Clean deletes the VM and its storage, first draining its runs (see
+// drainVM). keepPersist keeps the persist directory and snapshots.
 func (s *VMService) Clean(ctx context.Context, vmID string, keepPersist bool) error {
+	return s.clean(ctx, vmID, keepPersist, false)
+}
+
+// ForceClean is Clean without the grace period: in-flight runs are aborted.
+func (s *VMService) ForceClean(ctx context.Context, vmID string, keepPersist bool) error {
+	return s.clean(ctx, vmID, keepPersist, true)
+}
+
+func (s *VMService) clean(ctx context.Context, vmID string, keepPersist, force bool) error {
 	record, err := s.fetchRecord(vmID)
 	if err != nil {
 		return err
 	}
+	unlock, err := s.drainVM(ctx, vmID, force)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	// A run that finished while draining may have updated the record.
+	if current, ok := s.Get(vmID); ok {
+		record = current
+	}
+	s.closeREPL(vmID)
+	s.closeShells(vmID)
 
 	if err := s.launcher.Cleanup(ctx, vmID); err != nil {
 		if !errors.Is(err, errVMNotFound) {
@@ -461,19 +1243,75 @@ func (s *VMService) Clean(ctx context.Context, vmID string, keepPersist bool) er
 		if err := os.RemoveAll(record.Storage.PersistPath); err != nil && !os.IsNotExist(err) {
 			return err
 		}
+		// Snapshots only restore into this VM, so they go with its data.
+		if err := os.RemoveAll(snapshotDir(vmID)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
-	if err := s.store.Delete(vmID); err != nil {
+	if err := s.store.Delete(record.Tenant, vmID); err != nil {
 		return err
 	}
 
 	s.mu.Lock()
-	delete(s.cache, vmID)
+	s.uncacheRecord(vmID)
+	delete(s.vmLocks, vmID)
 	s.mu.Unlock()
 
+	s.metrics.observeClean(record.Language)
+	s.emit(VMEventCleaned, record)
 	return nil
 }
 
+// Stats reports current CPU, resident memory and uptime for a running VM.
+func (s *VMService) Stats(ctx context.Context, vmID string) (VMStats, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return VMStats{}, err
+	}
+	if record.Status == VMStatusStopped {
+		return VMStats{}, fmt.Errorf("%w: %s", errVMNotRunning, vmID)
+	}
+
+	stats, err := s.launcher.Stats(ctx, record)
+	if err != nil {
+		if errors.Is(err, errVMNotRunning) {
+			return VMStats{}, fmt.Errorf("%w: %s", errVMNotRunning, vmID)
+		}
+		return VMStats{}, err
+	}
+	return stats, nil
+}
+
+// CheckStore reports the integrity of the state database.
+func (s *VMService) CheckStore() (StoreCheckResult, error) {
+	return s.store.Check()
+}
+
+// CheckImage reports whether an image reference can be pulled without creating
+// a VM. A missing image is reported through Exists rather than as an error.
+func (s *VMService) CheckImage(ctx context.Context, ref string) (ImageCheckResult, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ImageCheckResult{}, errors.New("image reference is required")
+	}
+
+	info, err := s.launcher.InspectImage(ctx, ref)
+	if err != nil {
+		if errors.Is(err, errImageNotFound) {
+			return ImageCheckResult{Ref: ref, Exists: false}, nil
+		}
+		return ImageCheckResult{}, err
+	}
+
+	return ImageCheckResult{
+		Ref:       ref,
+		Exists:    true,
+		Digest:    info.Digest,
+		SizeBytes: info.SizeBytes,
+	}, nil
+}
+
 func (s *VMService) Get(vmID string) (VMRecord, bool) {
 	record, err := s.fetchRecord(vmID)
 	if err != nil {
@@ -503,17 +1341,83 @@ func (s *VMService) fetchRecord(vmID string) (VMRecord, error) {
 	}
 
 	s.mu.Lock()
-	s.cache[vmID] = record
+	s.cacheRecord(record)
 	s.mu.Unlock()
 
 	return record, nil
 }
 
+// launch boots the VM after applying its image pull policy. krunvm already
+// pulls missing images on create, so ifnotpresent needs no extra work. In
+// offline mode every policy behaves like never.
+func (s *VMService) launch(ctx context.Context, record VMRecord) error {
+	policy := record.PullPolicy
+	if offlineModeEnabled() {
+		policy = pullPolicyNever
+	}
+	switch policy {
+	case pullPolicyAlways:
+		if err := s.launcher.PullImage(ctx, record.RootFSImage); err != nil {
+			return fmt.Errorf("pull image %s: %w", record.RootFSImage, err)
+		}
+	case pullPolicyNever:
+		cached, err := s.launcher.ImageCached(ctx, record.RootFSImage)
+		if err != nil {
+			return err
+		}
+		if !cached && offlineModeEnabled() {
+			return fmt.Errorf("%w: %s (AGENT_OFFLINE is set; add the image to local storage or AGENT_IMAGE_DIR)", errImageNotCached, record.RootFSImage)
+		}
+		if !cached {
+			return fmt.Errorf("%w: %s (pull policy %q)", errImageNotCached, record.RootFSImage, pullPolicyNever)
+		}
+	}
+	return s.launcher.Launch(ctx, record)
+}
+
+func validatePortMappings(networkMode string, ports []PortMapping) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	if mode := strings.TrimSpace(networkMode); mode == "" || mode == "none" {
+		return errors.New("port mappings require a network mode other than none")
+	}
+
+	seen := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		if port.HostPort < 1 || port.HostPort > 65535 || port.GuestPort < 1 || port.GuestPort > 65535 {
+			return fmt.Errorf("invalid port mapping %s: ports must be between 1 and 65535", port)
+		}
+		if seen[port.HostPort] {
+			return fmt.Errorf("host port %d is mapped more than once", port.HostPort)
+		}
+		seen[port.HostPort] = true
+	}
+	return nil
+}
+
+func normalizePullPolicy(raw string) (string, error) {
+	policy := strings.ToLower(strings.TrimSpace(raw))
+	policy = strings.ReplaceAll(policy, "-", "")
+	switch policy {
+	case "":
+		return pullPolicyIfNotPresent, nil
+	case pullPolicyAlways, pullPolicyIfNotPresent, pullPolicyNever:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid pull policy %q (want always, ifnotpresent or never)", raw)
+	}
+}
+
 func (s *VMService) resolveRootFSCandidates(language, override string) ([]string, error) {
 	if override != "" {
 		return []string{override}, nil
 	}
 
+	if candidates, ok := s.images[language]; ok {
+		return append([]string(nil), candidates...), nil
+	}
+
 	switch language {
 	case "python":
 		return []string{"docker.io/library/python:3.11-slim"}, nil
@@ -533,7 +1437,7 @@ func isMissingVMError(err *commandError) bool {
 		return false
 	}
 	combined := strings.ToLower(err.stdout + " " + err.stderr)
-	return strings.Contains(combined, "no vm found")
+	return strings.Contains(combined, "no vm found") || strings.Contains(combined, "no such container")
 }
 
 func truncateAndRewind(f *os.File) error {
@@ -645,6 +1549,28 @@ func ensureStorageLayout(layout StorageLayout) error {
 	return nil
 }
 
+// clearOutputDir removes everything in a VM's output directory except the
+// shell audit log, which has to outlive individual runs. The directory itself
+// stays because the guest has it mounted.
+func clearOutputDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == shellAuditLogName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func normalizeStorageLayout(layout StorageLayout) StorageLayout {
 	if !guestVolumeSharingEnabled() {
 		layout.DisableGuestVolumes = true
@@ -673,6 +1599,30 @@ func guestVolumeSharingEnabled() bool {
 	return enabled
 }
 
+func guestTimeoutEnabled() bool {
+	raw := strings.TrimSpace(os.Getenv("AGENT_GUEST_TIMEOUT"))
+	if raw == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+func shellAuditEnabled() bool {
+	raw := strings.TrimSpace(os.Getenv("AGENT_SHELL_AUDIT"))
+	if raw == "" {
+		return false
+	}
+	enabled, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
 func computeStateRoot() string {
 	if override := strings.TrimSpace(os.Getenv("AGENT_STATE_DIR")); override != "" {
 		return override
@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckImageMapsLauncherResult(t *testing.T) {
+	launcher := newFakeLauncher()
+	svc := newTestVMService(t, launcher)
+	inspectErr := errors.New("registry unreachable")
+
+	launcher.imageFn = func(ctx context.Context, ref string) (ImageInfo, error) {
+		switch ref {
+		case "docker.io/library/python:3.11":
+			return ImageInfo{Ref: ref, Digest: "sha256:abc", SizeBytes: 4096}, nil
+		case "docker.io/library/missing:latest":
+			return ImageInfo{}, fmt.Errorf("%w: %s", errImageNotFound, ref)
+		default:
+			return ImageInfo{}, inspectErr
+		}
+	}
+
+	found, err := svc.CheckImage(context.Background(), " docker.io/library/python:3.11 ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := ImageCheckResult{Ref: "docker.io/library/python:3.11", Exists: true, Digest: "sha256:abc", SizeBytes: 4096}
+	if found != want {
+		t.Fatalf("got %+v, want %+v", found, want)
+	}
+
+	missing, err := svc.CheckImage(context.Background(), "docker.io/library/missing:latest")
+	if err != nil {
+		t.Fatalf("not-found should not be an error, got %v", err)
+	}
+	if missing.Exists || missing.Ref != "docker.io/library/missing:latest" {
+		t.Fatalf("unexpected not-found result: %+v", missing)
+	}
+
+	if _, err := svc.CheckImage(context.Background(), "registry.invalid/app"); !errors.Is(err, inspectErr) {
+		t.Fatalf("expected launcher error to propagate, got %v", err)
+	}
+
+	if _, err := svc.CheckImage(context.Background(), "  "); err == nil {
+		t.Fatal("expected error for empty reference")
+	}
+}
+
+func TestIsImageNotFoundOutput(t *testing.T) {
+	cases := map[string]bool{
+		"Error: reading manifest latest in docker.io/library/nope: manifest unknown": true,
+		"Error: requested access to the resource is denied":                          true,
+		"Error: pinging container registry: dial tcp: i/o timeout":                   false,
+	}
+	for stderr, want := range cases {
+		if got := isImageNotFoundOutput(stderr); got != want {
+			t.Errorf("isImageNotFoundOutput(%q) = %v, want %v", stderr, got, want)
+		}
+	}
+}
+
+func TestShellAuditTeesOutput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	svc.shellAudit = true
+	record := createTestVM(t, svc)
+
+	var stdout, stderr bytes.Buffer
+	stdin := strings.NewReader("echo audited\necho oops 1>&2\n")
+	exitCode, err := svc.Shell(context.Background(), record.ID, "/bin/sh", stdin, &stdout, &stderr)
+	if err != nil || exitCode != 0 {
+		t.Fatalf("shell failed: exit=%d err=%v", exitCode, err)
+	}
+
+	if stdout.String() != "audited\n" {
+		t.Fatalf("caller stdout = %q, want %q", stdout.String(), "audited\n")
+	}
+	if stderr.String() != "oops\n" {
+		t.Fatalf("caller stderr = %q, want %q", stderr.String(), "oops\n")
+	}
+
+	logged, err := os.ReadFile(filepath.Join(record.Storage.OutputPath, shellAuditLogName))
+	if err != nil {
+		t.Fatalf("audit log missing: %v", err)
+	}
+	for _, want := range []string{"shell session", "audited\n", "oops\n"} {
+		if !strings.Contains(string(logged), want) {
+			t.Fatalf("audit log %q does not contain %q", logged, want)
+		}
+	}
+}
+
+func TestShellWithoutAuditLeavesNoLog(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	var stdout bytes.Buffer
+	if _, err := svc.Shell(context.Background(), record.ID, "/bin/sh", strings.NewReader("echo hi\n"), &stdout, io.Discard); err != nil {
+		t.Fatalf("shell failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(record.Storage.OutputPath, shellAuditLogName)); !os.IsNotExist(err) {
+		t.Fatalf("expected no audit log without audit mode, got %v", err)
+	}
+}
+
+func TestAbortCancelsInFlightRun(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	type outcome struct {
+		result VMRunResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := svc.Run(context.Background(), VMRunOptions{
+			VMID:    record.ID,
+			Command: "sleep 30",
+			Timeout: 60,
+		})
+		done <- outcome{result, err}
+	}()
+
+	// Wait for the run to register before aborting it.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		svc.runMu.Lock()
+		active := len(svc.runs[record.ID])
+		svc.runMu.Unlock()
+		if active > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("run never became active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	aborted, err := svc.Abort(record.ID)
+	if err != nil {
+		t.Fatalf("abort failed: %v", err)
+	}
+	if aborted != 1 {
+		t.Fatalf("aborted %d runs, want 1", aborted)
+	}
+
+	select {
+	case got := <-done:
+		if !errors.Is(got.err, errRunAborted) {
+			t.Fatalf("expected errRunAborted, got %v", got.err)
+		}
+		if !got.result.Aborted {
+			t.Fatalf("expected aborted result, got %+v", got.result)
+		}
+		if got.result.Duration > 5*time.Second {
+			t.Fatalf("run took %s to return after abort", got.result.Duration)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after abort")
+	}
+
+	if _, ok := svc.Get(record.ID); !ok {
+		t.Fatal("abort must not remove the vm")
+	}
+	if _, err := svc.Abort("missing"); !errors.Is(err, errVMNotFound) {
+		t.Fatalf("expected errVMNotFound for unknown vm, got %v", err)
+	}
+}
+
+func TestRunReportsTimeout(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	result, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "sleep 5",
+		Timeout: 1,
+	})
+	if !errors.Is(err, errRunTimeout) {
+		t.Fatalf("expected errRunTimeout, got %v", err)
+	}
+	if !result.TimedOut || result.Aborted {
+		t.Fatalf("expected timed out result, got %+v", result)
+	}
+	if result.Duration > 4*time.Second {
+		t.Fatalf("run took %s despite a 1s timeout", result.Duration)
+	}
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{VMID: record.ID, Command: "exit 124", Timeout: 5})
+	if errors.Is(err, errRunTimeout) || output.TimedOut {
+		t.Fatalf("a command exiting 124 on its own is not a timeout: %+v, %v", output, err)
+	}
+}
+
+func TestRunFeedsStdin(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	result, err := svc.Run(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "cat",
+		Stdin:   "hello\n",
+		Timeout: 5,
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	stdout, err := os.ReadFile(result.StdoutPath)
+	if err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	if string(stdout) != "hello\n" {
+		t.Fatalf("stdout = %q, want %q", stdout, "hello\n")
+	}
+}
+
+func TestGuestCommandScriptKeepsStdin(t *testing.T) {
+	// The wrapped command must read the caller's stdin rather than the
+	// pipe that carried the script.
+	cmd := exec.Command("/bin/bash", "-c", guestCommandScript("cat"))
+	cmd.Stdin = strings.NewReader("hello\n")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("bash failed: %v", err)
+	}
+	if string(out) != "hello\n" {
+		t.Fatalf("output = %q, want %q", out, "hello\n")
+	}
+}
+
+func TestCreateHonoursPullPolicy(t *testing.T) {
+	const image = "docker.io/library/python:3.11-slim"
+
+	cases := []struct {
+		policy      string
+		cached      bool
+		wantPolicy  string
+		wantPulls   int
+		wantLaunch  int
+		wantErr     error
+		wantInvalid bool
+	}{
+		{policy: "", cached: false, wantPolicy: pullPolicyIfNotPresent, wantPulls: 0, wantLaunch: 1},
+		{policy: "if-not-present", cached: true, wantPolicy: pullPolicyIfNotPresent, wantPulls: 0, wantLaunch: 1},
+		{policy: "always", cached: true, wantPolicy: pullPolicyAlways, wantPulls: 1, wantLaunch: 1},
+		{policy: "never", cached: true, wantPolicy: pullPolicyNever, wantPulls: 0, wantLaunch: 1},
+		{policy: "never", cached: false, wantPulls: 0, wantLaunch: 0, wantErr: errImageNotCached},
+		{policy: "sometimes", wantLaunch: 0, wantInvalid: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.policy+"/"+fmt.Sprint(tc.cached), func(t *testing.T) {
+			launcher := newFakeLauncher()
+			launcher.cached = map[string]bool{image: tc.cached}
+			svc := newTestVMService(t, launcher)
+
+			record, err := svc.Create(context.Background(), VMCreateOptions{
+				Language:    "python",
+				CPUCount:    1,
+				MemoryMiB:   256,
+				NetworkMode: "none",
+				PullPolicy:  tc.policy,
+			})
+
+			switch {
+			case tc.wantInvalid:
+				if err == nil {
+					t.Fatal("expected invalid pull policy error")
+				}
+			case tc.wantErr != nil:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+			default:
+				if err != nil {
+					t.Fatalf("create failed: %v", err)
+				}
+				if record.PullPolicy != tc.wantPolicy {
+					t.Fatalf("record pull policy = %q, want %q", record.PullPolicy, tc.wantPolicy)
+				}
+			}
+
+			if len(launcher.pulls) != tc.wantPulls {
+				t.Fatalf("pulls = %v, want %d", launcher.pulls, tc.wantPulls)
+			}
+			if launcher.launchCalls != tc.wantLaunch {
+				t.Fatalf("launch calls = %d, want %d", launcher.launchCalls, tc.wantLaunch)
+			}
+		})
+	}
+}
+
+func TestCancelledCreateLeavesNoState(t *testing.T) {
+	launcher := newFakeLauncher()
+	ctx, cancel := context.WithCancel(context.Background())
+	launching := make(chan struct{})
+	launcher.launchFn = func(ctx context.Context, record VMRecord) error {
+		// Simulate a launcher that registered the VM before blocking on a pull.
+		launcher.mu.Lock()
+		launcher.vms[record.ID] = record
+		launcher.mu.Unlock()
+		close(launching)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	svc := newTestVMService(t, launcher)
+
+	go func() {
+		<-launching
+		cancel()
+	}()
+	_, err := svc.Create(ctx, VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Persist:     true,
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancelled create, got %v", err)
+	}
+
+	if launcher.launchCalls != 1 {
+		t.Fatalf("launch calls = %d, want 1", launcher.launchCalls)
+	}
+	if len(launcher.vms) != 0 {
+		t.Fatalf("launcher still holds partial vms: %v", launcher.vms)
+	}
+	if records, err := svc.store.LoadAll(); err != nil || len(records) != 0 {
+		t.Fatalf("store records = %v (err %v), want none", records, err)
+	}
+	if len(svc.cache) != 0 {
+		t.Fatalf("cancelled vm still cached: %v", svc.cache)
+	}
+	for _, dir := range []string{"vms", "persist"} {
+		entries, err := os.ReadDir(filepath.Join(stateRoot(), dir))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("read %s dir: %v", dir, err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("orphaned %s storage left behind: %v", dir, entries)
+		}
+	}
+}
+
+func TestRunsOnSameVMAreSerialized(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+	other := createTestVM(t, svc)
+
+	script := func(tag string) string {
+		return fmt.Sprintf("for i in 1 2 3 4 5; do echo %s$i; sleep 0.02; done", tag)
+	}
+	outputs := make([]ExecOutput, 2)
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i, tag := range []string{"A", "B"} {
+		wg.Add(1)
+		go func(i int, tag string) {
+			defer wg.Done()
+			outputs[i], errs[i] = svc.Exec(context.Background(), VMRunOptions{VMID: record.ID, Command: script(tag), Timeout: 10})
+		}(i, tag)
+	}
+	wg.Wait()
+
+	for i, tag := range []string{"A", "B"} {
+		if errs[i] != nil {
+			t.Fatalf("run %s failed: %v", tag, errs[i])
+		}
+		want := fmt.Sprintf("%[1]s1\n%[1]s2\n%[1]s3\n%[1]s4\n%[1]s5\n", tag)
+		if outputs[i].Stdout != want {
+			t.Fatalf("run %s stdout = %q, want %q", tag, outputs[i].Stdout, want)
+		}
+	}
+
+	// Different VMs are not held up by each other.
+	unlock := svc.lockVM(record.ID)
+	defer unlock()
+	done := make(chan error, 1)
+	go func() {
+		_, err := svc.Run(context.Background(), VMRunOptions{VMID: other.ID, Command: "true", Timeout: 5})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run on other vm failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run on a different vm blocked behind a locked vm")
+	}
+}
+
+func TestExecReturnsCapturedOutput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:    record.ID,
+		Command: "echo out; echo err >&2; exit 3",
+		Timeout: 5,
+	})
+	var runErr *VMRunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("expected VMRunError for non-zero exit, got %v", err)
+	}
+	if output.ExitCode != 3 {
+		t.Fatalf("ExitCode = %d, want 3", output.ExitCode)
+	}
+
+	for name, pair := range map[string][2]string{
+		"stdout": {output.Stdout, runErr.Result.StdoutPath},
+		"stderr": {output.Stderr, runErr.Result.StderrPath},
+	} {
+		onDisk, err := os.ReadFile(pair[1])
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if pair[0] != string(onDisk) {
+			t.Fatalf("%s = %q, file holds %q", name, pair[0], onDisk)
+		}
+	}
+	if output.Stdout != "out\n" || output.Stderr != "err\n" {
+		t.Fatalf("unexpected output: stdout %q, stderr %q", output.Stdout, output.Stderr)
+	}
+}
+
+func TestExecCapsOutput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:           record.ID,
+		Command:        "printf '%0100d' 0",
+		Timeout:        5,
+		MaxOutputBytes: 10,
+	})
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+	if !output.Truncated {
+		t.Fatal("expected output to be marked truncated")
+	}
+	if want := "0000000000\n...[truncated 90 bytes]\n"; output.Stdout != want {
+		t.Fatalf("stdout = %q, want %q", output.Stdout, want)
+	}
+}
+
+func TestRunStartsWithCleanOutput(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	launcher := svc.launcher.(*fakeLauncher)
+	var seen []string
+	launcher.runFn = func(ctx context.Context, record VMRecord, opts VMRunOptions, stdout, stderr io.Writer) (int, error) {
+		entries, err := os.ReadDir(record.Storage.OutputPath)
+		if err != nil {
+			return 1, err
+		}
+		seen = seen[:0]
+		for _, entry := range entries {
+			seen = append(seen, entry.Name())
+		}
+		return 0, os.WriteFile(filepath.Join(record.Storage.OutputPath, "result.json"), []byte(opts.Command), 0o644)
+	}
+
+	run := func(record VMRecord, cleanOutput bool) []string {
+		t.Helper()
+		if _, err := svc.Run(context.Background(), VMRunOptions{VMID: record.ID, Command: "true", Timeout: 5, CleanOutput: cleanOutput}); err != nil {
+			t.Fatalf("run failed: %v", err)
+		}
+		leftovers := []string{}
+		for _, name := range seen {
+			if name != "stdout.log" && name != "stderr.log" {
+				leftovers = append(leftovers, name)
+			}
+		}
+		return leftovers
+	}
+
+	ephemeral := createTestVM(t, svc)
+	if err := os.WriteFile(filepath.Join(ephemeral.Storage.OutputPath, shellAuditLogName), []byte("audit"), 0o644); err != nil {
+		t.Fatalf("seed audit log: %v", err)
+	}
+	run(ephemeral, false)
+	if leftovers := run(ephemeral, false); len(leftovers) != 1 || leftovers[0] != shellAuditLogName {
+		t.Fatalf("ephemeral run saw %v from the previous run, want only %s", leftovers, shellAuditLogName)
+	}
+
+	persistent, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Persist:     true,
+	})
+	if err != nil {
+		t.Fatalf("create persistent vm: %v", err)
+	}
+	run(persistent, false)
+	if leftovers := run(persistent, false); len(leftovers) != 1 || leftovers[0] != "result.json" {
+		t.Fatalf("persistent vm /out = %v, want the previous result.json kept", leftovers)
+	}
+	if leftovers := run(persistent, true); len(leftovers) != 0 {
+		t.Fatalf("--clean-output run saw %v", leftovers)
+	}
+}
+
+func TestListByStatus(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	ready := createTestVM(t, svc)
+	running := createTestVM(t, svc)
+	stopped := createTestVM(t, svc)
+
+	running.Status = VMStatusRunning
+	if err := svc.store.Save(running); err != nil {
+		t.Fatal(err)
+	}
+	svc.mu.Lock()
+	svc.cache[running.ID] = running
+	svc.mu.Unlock()
+	if err := svc.Stop(context.Background(), stopped.ID); err != nil {
+		t.Fatalf("stop: %v", err)
+	}
+
+	ids := func(statuses ...VMStatus) []string {
+		t.Helper()
+		records, err := svc.ListByStatus(context.Background(), statuses...)
+		if err != nil {
+			t.Fatalf("ListByStatus(%v): %v", statuses, err)
+		}
+		ids := make([]string, 0, len(records))
+		for _, record := range records {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+
+	for _, tc := range []struct {
+		statuses []VMStatus
+		want     []string
+	}{
+		{[]VMStatus{VMStatusReady}, []string{ready.ID}},
+		{[]VMStatus{VMStatusReady, VMStatusRunning}, []string{ready.ID, running.ID}},
+		{[]VMStatus{VMStatusStopped}, []string{stopped.ID}},
+		{[]VMStatus{VMStatusProvisioning}, []string{}},
+		{nil, []string{ready.ID, running.ID, stopped.ID}},
+	} {
+		if got := ids(tc.statuses...); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ListByStatus(%v) = %v, want %v", tc.statuses, got, tc.want)
+		}
+	}
+
+	// The typed status still encodes as the bare string.
+	encoded, err := json.Marshal(ready)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(encoded), `"Status":"ready"`) {
+		t.Fatalf("encoded record %s lacks the plain status string", encoded)
+	}
+}
+
+func TestExecCommandsShareOneShell(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:     record.ID,
+		Commands: []string{"cd /tmp", "export GREETING='hi there'", "echo \"$GREETING from $(pwd)\""},
+		Timeout:  5,
+	})
+	if err != nil {
+		t.Fatalf("exec commands: %v", err)
+	}
+	if output.ExitCode != 0 || output.Stdout != "hi there from /tmp\n" {
+		t.Fatalf("output = %+v, want the last command to see the earlier ones' state", output)
+	}
+
+	runs, err := svc.RecentRuns(record.Tenant, record.ID, 1)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("recent runs = %+v, %v", runs, err)
+	}
+	if want := "cd /tmp && export GREETING='hi there' && echo \"$GREETING from $(pwd)\""; runs[0].Command != want {
+		t.Fatalf("history command = %q, want %q", runs[0].Command, want)
+	}
+}
+
+func TestExecCommandsStopAtFirstFailure(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:     record.ID,
+		Commands: []string{"echo one", "(exit 4)", "echo three"},
+		Timeout:  5,
+	})
+	var runErr *VMRunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("expected VMRunError for the failing command, got %v", err)
+	}
+	if output.ExitCode != 4 || output.Stdout != "one\n" {
+		t.Fatalf("output = %+v, want exit 4 before the third command", output)
+	}
+
+	if _, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:     record.ID,
+		Command:  "echo one",
+		Commands: []string{"echo two"},
+		Timeout:  5,
+	}); err == nil {
+		t.Fatal("commands combined with cmd were accepted")
+	}
+}
+
+func TestExecCommandsContinueOnError(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	output, err := svc.Exec(context.Background(), VMRunOptions{
+		VMID:            record.ID,
+		Commands:        []string{"echo one", "(exit 4)", "false", "echo four"},
+		ContinueOnError: true,
+		Timeout:         5,
+	})
+	var runErr *VMRunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("expected VMRunError, got %v", err)
+	}
+	if output.ExitCode != 4 || output.Stdout != "one\nfour\n" {
+		t.Fatalf("output = %+v, want every command run and the first failure's exit code", output)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var (
+	snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+	errSnapshotNotFound = errors.New("snapshot not found")
+)
+
+// snapshotDir holds the snapshots of one VM.
+func snapshotDir(vmID string) string {
+	return filepath.Join(stateRoot(), "snapshots", vmID)
+}
+
+func snapshotPath(vmID, name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	return filepath.Join(snapshotDir(vmID), name+".tar.gz"), nil
+}
+
+// persistentRecord fetches a VM and checks that it has a persist directory.
+func (s *VMService) persistentRecord(vmID string) (VMRecord, error) {
+	record, err := s.fetchRecord(vmID)
+	if err != nil {
+		return VMRecord{}, err
+	}
+	if !record.Persist || record.Storage.PersistPath == "" {
+		return VMRecord{}, fmt.Errorf("vm %s: %w", vmID, errVMNotPersistent)
+	}
+	return record, nil
+}
+
+// Snapshot archives the VM's persist directory to
+// <state dir>/snapshots/<vm>/<name>.tar.gz, replacing an existing snapshot
+// of the same name. Runs on the VM wait until the archive is written.
+func (s *VMService) Snapshot(ctx context.Context, vmID, name string) error {
+	record, err := s.persistentRecord(vmID)
+	if err != nil {
+		return err
+	}
+	dest, err := snapshotPath(record.ID, name)
+	if err != nil {
+		return err
+	}
+	if err := ensureDir(filepath.Dir(dest)); err != nil {
+		return err
+	}
+
+	unlock := s.lockVM(record.ID)
+	defer unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Write beside the target and rename, so a failed snapshot never
+	// replaces a good one.
+	tmp, err := os.CreateTemp(filepath.Dir(dest), "."+name+"-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := writeDirArchive(record.Storage.PersistPath, tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return err
+	}
+
+	loggerFor(ctx, s.logger).Info("vm snapshot saved", map[string]any{
+		"id":       record.ID,
+		"snapshot": name,
+	})
+	return nil
+}
+
+// Restore replaces the contents of the VM's persist directory with a
+// snapshot taken by Snapshot. The snapshot is unpacked into a staging
+// directory first, so a damaged archive leaves the persist directory as it
+// was. The directory itself is kept, since a running VM has it mounted.
+func (s *VMService) Restore(ctx context.Context, vmID, name string) error {
+	record, err := s.persistentRecord(vmID)
+	if err != nil {
+		return err
+	}
+	src, err := snapshotPath(record.ID, name)
+	if err != nil {
+		return err
+	}
+	archive, err := os.Open(src)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", errSnapshotNotFound, name)
+	}
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	unlock := s.lockVM(record.ID)
+	defer unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	persist := record.Storage.PersistPath
+	staging, err := os.MkdirTemp(filepath.Dir(persist), "."+record.ID+"-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+	if _, err := extractTarArchive(staging, archive, 0); err != nil {
+		return fmt.Errorf("restore %s: %w", name, err)
+	}
+
+	entries, err := os.ReadDir(persist)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(persist, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if _, err := copyTree(staging, persist, nil); err != nil {
+		return fmt.Errorf("restore %s: %w", name, err)
+	}
+
+	loggerFor(ctx, s.logger).Info("vm snapshot restored", map[string]any{
+		"id":       record.ID,
+		"snapshot": name,
+	})
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func createPersistentTestVM(t *testing.T, svc *VMService) VMRecord {
+	t.Helper()
+	record, err := svc.Create(context.Background(), VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Persist:     true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create vm: %v", err)
+	}
+	return record
+}
+
+func TestSnapshotRestoreRoundTrip(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createPersistentTestVM(t, svc)
+	persist := record.Storage.PersistPath
+	ctx := context.Background()
+
+	if err := os.MkdirAll(filepath.Join(persist, "data"), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(persist, "data", "state.txt"), []byte("v1"), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := svc.Snapshot(ctx, record.ID, "before"); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stateRoot(), "snapshots", record.ID, "before.tar.gz")); err != nil {
+		t.Fatalf("snapshot archive missing: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(persist, "data", "state.txt"), []byte("v2"), 0o644); err != nil {
+		t.Fatalf("modify: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(persist, "scratch.txt"), []byte("tmp"), 0o644); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	if err := svc.Restore(ctx, record.ID, "before"); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(persist, "data", "state.txt")); err != nil || string(data) != "v1" {
+		t.Fatalf("restored file = %q, %v; want v1", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(persist, "scratch.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file added after the snapshot survived restore: %v", err)
+	}
+
+	if err := svc.Restore(ctx, record.ID, "missing"); !errors.Is(err, errSnapshotNotFound) {
+		t.Fatalf("restore of unknown snapshot err = %v, want errSnapshotNotFound", err)
+	}
+	if err := svc.Snapshot(ctx, record.ID, "../escape"); err == nil {
+		t.Fatal("snapshot name with a path separator accepted")
+	}
+
+	if err := svc.Clean(ctx, record.ID, false); err != nil {
+		t.Fatalf("clean: %v", err)
+	}
+	if _, err := os.Stat(snapshotDir(record.ID)); !os.IsNotExist(err) {
+		t.Fatalf("clean left snapshots behind: %v", err)
+	}
+}
+
+func TestSnapshotRejectsNonPersistentVM(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	record := createTestVM(t, svc)
+
+	if err := svc.Snapshot(context.Background(), record.ID, "first"); !errors.Is(err, errVMNotPersistent) {
+		t.Fatalf("snapshot err = %v, want errVMNotPersistent", err)
+	}
+	if err := svc.Restore(context.Background(), record.ID, "first"); !errors.Is(err, errVMNotPersistent) {
+		t.Fatalf("restore err = %v, want errVMNotPersistent", err)
+	}
+}
@@ -3,19 +3,65 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 var (
 	vmBucket      = []byte("vms")
+	volumeBucket  = []byte("volumes")
+	metaBucket    = []byte("meta")
+	schemaKey     = []byte("schema_version")
 	errPersist    = errors.New("vm persistence error")
 	errNotFound   = errors.New("vm record not found")
+	errVMExists   = errors.New("vm already exists")
 	boltFilePerms = os.FileMode(0o600)
 )
 
+// VMStore persists VM records, volumes and the run history. Bolt is the
+// default backend; AGENT_STORE_BACKEND=sqlite selects SQLiteVMStore, whose
+// tables can be queried with SQL.
+type VMStore interface {
+	Save(record VMRecord) error
+	// Insert saves a new record, failing with errVMExists when a record
+	// with its ID is stored in any tenant.
+	Insert(record VMRecord) error
+	Delete(tenant, vmID string) error
+	Get(vmID string) (VMRecord, error)
+	GetInTenant(tenant, vmID string) (VMRecord, error)
+	LoadAll() ([]VMRecord, error)
+	LoadTenant(tenant string) ([]VMRecord, error)
+
+	SaveVolume(volume VolumeRecord) error
+	DeleteVolume(name string) error
+	GetVolume(name string) (VolumeRecord, error)
+	LoadVolumes() ([]VolumeRecord, error)
+
+	SaveRun(entry RunHistoryEntry) error
+	RecentRuns(tenant, vmID string, limit int) ([]RunHistoryEntry, error)
+
+	Check() (StoreCheckResult, error)
+	Close() error
+}
+
+// openVMStore opens the backend chosen by AGENT_STORE_BACKEND (bolt when
+// unset) under stateRoot.
+func openVMStore(stateRoot string) (VMStore, error) {
+	switch backend := strings.ToLower(strings.TrimSpace(os.Getenv("AGENT_STORE_BACKEND"))); backend {
+	case "", "bolt":
+		return NewBoltVMStore(stateRoot)
+	case "sqlite":
+		return NewSQLiteVMStore(stateRoot)
+	default:
+		return nil, fmt.Errorf("unknown AGENT_STORE_BACKEND %q: want bolt or sqlite", backend)
+	}
+}
+
 type BoltVMStore struct {
 	db *bolt.DB
 }
@@ -29,10 +75,194 @@ func NewBoltVMStore(stateRoot string) (*BoltVMStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Update(migrateSchema); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
 
 	return &BoltVMStore{db: db}, nil
 }
 
+// storeMigrations upgrade the database one schema version at a time:
+// storeMigrations[i] turns version i into version i+1. Databases without a
+// meta bucket predate versioning and are version 0. Append new steps here
+// whenever a stored shape changes; never edit a released one.
+var storeMigrations = []func(*bolt.Tx) error{
+	migrateTenantBuckets,
+	backfillRecordDefaults,
+}
+
+// currentSchemaVersion is the version written by this build.
+var currentSchemaVersion = len(storeMigrations)
+
+func readSchemaVersion(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return 0, nil
+	}
+	raw := meta.Get(schemaKey)
+	if raw == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q: %w", raw, err)
+	}
+	return version, nil
+}
+
+// migrateSchema runs the migrations the database has not seen yet, in the
+// same transaction as the version bump, and refuses databases written by a
+// newer agent rather than dropping fields it does not know.
+func migrateSchema(tx *bolt.Tx) error {
+	version, err := readSchemaVersion(tx)
+	if err != nil {
+		return err
+	}
+	if version > currentSchemaVersion {
+		return fmt.Errorf("state database schema version %d is newer than this agent supports (%d)", version, currentSchemaVersion)
+	}
+	for ; version < currentSchemaVersion; version++ {
+		if err := storeMigrations[version](tx); err != nil {
+			return fmt.Errorf("migrate state database to schema version %d: %w", version+1, err)
+		}
+	}
+
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(schemaKey, []byte(strconv.Itoa(currentSchemaVersion)))
+}
+
+// SchemaVersion reports the schema version recorded in the database.
+func (s *BoltVMStore) SchemaVersion() (int, error) {
+	if s == nil || s.db == nil {
+		return 0, errPersist
+	}
+	var version int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		version, err = readSchemaVersion(tx)
+		return err
+	})
+	return version, err
+}
+
+// VM records live in one nested bucket per tenant under vms/. Databases
+// written before tenants existed kept the records directly in vms/;
+// migrateTenantBuckets moves each of them into its tenant's bucket. A record
+// that no longer decodes goes to the default tenant unchanged so Check can
+// still report it.
+func migrateTenantBuckets(tx *bolt.Tx) error {
+	vms := tx.Bucket(vmBucket)
+	if vms == nil {
+		return nil
+	}
+
+	var keys, values [][]byte
+	if err := vms.ForEach(func(k, v []byte) error {
+		if v != nil {
+			keys = append(keys, append([]byte(nil), k...))
+			values = append(values, append([]byte(nil), v...))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		tenant := defaultTenant
+		var record VMRecord
+		if err := json.Unmarshal(values[i], &record); err == nil {
+			tenant = normalizeTenant(record.Tenant)
+		}
+		bucket, err := vms.CreateBucketIfNotExists([]byte(tenant))
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put(key, values[i]); err != nil {
+			return err
+		}
+		if err := vms.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillRecordDefaults fills fields that records from older versions may
+// lack: a missing status becomes stopped, which List corrects from the
+// launcher, and a missing tenant is written out as the default one. Records
+// that do not decode are left for Check to report.
+func backfillRecordDefaults(tx *bolt.Tx) error {
+	return forEachTenant(tx, func(tenant string, bucket *bolt.Bucket) error {
+		updates := make(map[string][]byte)
+		if err := bucket.ForEach(func(k, v []byte) error {
+			var record VMRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return nil
+			}
+			if record.Status != "" && record.Tenant != "" {
+				return nil
+			}
+			if record.Status == "" {
+				record.Status = VMStatusStopped
+			}
+			if record.Tenant == "" {
+				record.Tenant = tenant
+			}
+			payload, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			updates[string(k)] = payload
+			return nil
+		}); err != nil {
+			return err
+		}
+		for key, payload := range updates {
+			if err := bucket.Put([]byte(key), payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// tenantBucket returns the tenant's VM bucket, or nil if it has none yet.
+func tenantBucket(tx *bolt.Tx, tenant string) *bolt.Bucket {
+	vms := tx.Bucket(vmBucket)
+	if vms == nil {
+		return nil
+	}
+	return vms.Bucket([]byte(normalizeTenant(tenant)))
+}
+
+func forEachTenant(tx *bolt.Tx, fn func(tenant string, bucket *bolt.Bucket) error) error {
+	vms := tx.Bucket(vmBucket)
+	if vms == nil {
+		return nil
+	}
+	return vms.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		return fn(string(k), vms.Bucket(k))
+	})
+}
+
+func decodeVMRecords(bucket *bolt.Bucket, records *[]VMRecord) error {
+	return bucket.ForEach(func(_, v []byte) error {
+		var record VMRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		*records = append(*records, record)
+		return nil
+	})
+}
+
 func (s *BoltVMStore) Close() error {
 	if s == nil || s.db == nil {
 		return nil
@@ -45,7 +275,11 @@ func (s *BoltVMStore) Save(record VMRecord) error {
 		return errPersist
 	}
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(vmBucket)
+		vms, err := tx.CreateBucketIfNotExists(vmBucket)
+		if err != nil {
+			return err
+		}
+		bucket, err := vms.CreateBucketIfNotExists([]byte(normalizeTenant(record.Tenant)))
 		if err != nil {
 			return err
 		}
@@ -57,19 +291,50 @@ func (s *BoltVMStore) Save(record VMRecord) error {
 	})
 }
 
-func (s *BoltVMStore) Delete(vmID string) error {
+func (s *BoltVMStore) Insert(record VMRecord) error {
 	if s == nil || s.db == nil {
 		return errPersist
 	}
 	return s.db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists(vmBucket)
+		vms, err := tx.CreateBucketIfNotExists(vmBucket)
 		if err != nil {
 			return err
 		}
+		err = forEachTenant(tx, func(_ string, bucket *bolt.Bucket) error {
+			if bucket.Get([]byte(record.ID)) != nil {
+				return fmt.Errorf("%w: %s", errVMExists, record.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		bucket, err := vms.CreateBucketIfNotExists([]byte(normalizeTenant(record.Tenant)))
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(record.ID), payload)
+	})
+}
+
+func (s *BoltVMStore) Delete(tenant, vmID string) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tenantBucket(tx, tenant)
+		if bucket == nil {
+			return nil
+		}
 		return bucket.Delete([]byte(vmID))
 	})
 }
 
+// Get looks the VM up across all tenants; GetInTenant is the scoped variant.
 func (s *BoltVMStore) Get(vmID string) (VMRecord, error) {
 	var record VMRecord
 	if s == nil || s.db == nil {
@@ -77,7 +342,31 @@ func (s *BoltVMStore) Get(vmID string) (VMRecord, error) {
 	}
 
 	err := s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(vmBucket)
+		found := false
+		err := forEachTenant(tx, func(_ string, bucket *bolt.Bucket) error {
+			raw := bucket.Get([]byte(vmID))
+			if raw == nil || found {
+				return nil
+			}
+			found = true
+			return json.Unmarshal(raw, &record)
+		})
+		if err == nil && !found {
+			return errNotFound
+		}
+		return err
+	})
+	return record, err
+}
+
+func (s *BoltVMStore) GetInTenant(tenant, vmID string) (VMRecord, error) {
+	var record VMRecord
+	if s == nil || s.db == nil {
+		return record, errPersist
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tenantBucket(tx, tenant)
 		if bucket == nil {
 			return errNotFound
 		}
@@ -90,6 +379,7 @@ func (s *BoltVMStore) Get(vmID string) (VMRecord, error) {
 	return record, err
 }
 
+// LoadAll returns the VMs of every tenant.
 func (s *BoltVMStore) LoadAll() ([]VMRecord, error) {
 	if s == nil || s.db == nil {
 		return nil, errPersist
@@ -97,18 +387,177 @@ func (s *BoltVMStore) LoadAll() ([]VMRecord, error) {
 
 	var records []VMRecord
 	err := s.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(vmBucket)
+		return forEachTenant(tx, func(_ string, bucket *bolt.Bucket) error {
+			return decodeVMRecords(bucket, &records)
+		})
+	})
+	return records, err
+}
+
+func (s *BoltVMStore) LoadTenant(tenant string) ([]VMRecord, error) {
+	if s == nil || s.db == nil {
+		return nil, errPersist
+	}
+
+	var records []VMRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tenantBucket(tx, tenant)
+		if bucket == nil {
+			return nil
+		}
+		return decodeVMRecords(bucket, &records)
+	})
+	return records, err
+}
+
+func (s *BoltVMStore) SaveVolume(volume VolumeRecord) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(volumeBucket)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(volume)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(volume.Name), payload)
+	})
+}
+
+func (s *BoltVMStore) DeleteVolume(name string) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(volumeBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(name))
+	})
+}
+
+func (s *BoltVMStore) GetVolume(name string) (VolumeRecord, error) {
+	var volume VolumeRecord
+	if s == nil || s.db == nil {
+		return volume, errPersist
+	}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(volumeBucket)
+		if bucket == nil {
+			return errNotFound
+		}
+		raw := bucket.Get([]byte(name))
+		if raw == nil {
+			return errNotFound
+		}
+		return json.Unmarshal(raw, &volume)
+	})
+	return volume, err
+}
+
+func (s *BoltVMStore) LoadVolumes() ([]VolumeRecord, error) {
+	if s == nil || s.db == nil {
+		return nil, errPersist
+	}
+
+	var volumes []VolumeRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(volumeBucket)
 		if bucket == nil {
 			return nil
 		}
 		return bucket.ForEach(func(_, v []byte) error {
-			var record VMRecord
-			if err := json.Unmarshal(v, &record); err != nil {
+			var volume VolumeRecord
+			if err := json.Unmarshal(v, &volume); err != nil {
 				return err
 			}
-			records = append(records, record)
+			volumes = append(volumes, volume)
 			return nil
 		})
 	})
-	return records, err
+	return volumes, err
+}
+
+// StoreCheckResult summarizes the integrity of the state database.
+type StoreCheckResult struct {
+	Path          string
+	SchemaVersion int
+	VMs           int
+	Volumes       int
+	// Corrupt lists entries that fail to decode, as LoadAll would hit them.
+	Corrupt []CorruptEntry
+	// PageErrors holds bolt's page-level consistency errors.
+	PageErrors []string
+}
+
+type CorruptEntry struct {
+	Bucket string
+	Key    string
+	Err    string
+}
+
+func (r StoreCheckResult) Healthy() bool {
+	return len(r.Corrupt) == 0 && len(r.PageErrors) == 0
+}
+
+// Check walks every record in a single read transaction and reports entries
+// that cannot be decoded along with any bolt consistency errors.
+func (s *BoltVMStore) Check() (StoreCheckResult, error) {
+	if s == nil || s.db == nil {
+		return StoreCheckResult{}, errPersist
+	}
+
+	result := StoreCheckResult{Path: s.db.Path()}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for pageErr := range tx.Check() {
+			result.PageErrors = append(result.PageErrors, pageErr.Error())
+		}
+		version, err := readSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		result.SchemaVersion = version
+
+		checkBucket := func(name string, bucket *bolt.Bucket, decode func([]byte) error) (int, error) {
+			if bucket == nil {
+				return 0, nil
+			}
+			count := 0
+			err := bucket.ForEach(func(k, v []byte) error {
+				count++
+				if err := decode(v); err != nil {
+					result.Corrupt = append(result.Corrupt, CorruptEntry{
+						Bucket: name,
+						Key:    string(k),
+						Err:    err.Error(),
+					})
+				}
+				return nil
+			})
+			return count, err
+		}
+
+		err = forEachTenant(tx, func(tenant string, bucket *bolt.Bucket) error {
+			count, err := checkBucket(string(vmBucket)+"/"+tenant, bucket, func(v []byte) error {
+				var record VMRecord
+				return json.Unmarshal(v, &record)
+			})
+			result.VMs += count
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		result.Volumes, err = checkBucket(string(volumeBucket), tx.Bucket(volumeBucket), func(v []byte) error {
+			var volume VolumeRecord
+			return json.Unmarshal(v, &volume)
+		})
+		return err
+	})
+	return result, err
 }
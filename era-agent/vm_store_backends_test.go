@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// storeBackends opens each VMStore implementation in its own directory, so
+// the tests below pin down behavior every backend must share.
+var storeBackends = map[string]func(dir string) (VMStore, error){
+	"bolt":   func(dir string) (VMStore, error) { return NewBoltVMStore(dir) },
+	"sqlite": func(dir string) (VMStore, error) { return NewSQLiteVMStore(dir) },
+}
+
+func forEachStoreBackend(t *testing.T, fn func(t *testing.T, store VMStore)) {
+	t.Helper()
+	for name, open := range storeBackends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			store, err := open(t.TempDir())
+			if err != nil {
+				t.Fatalf("open store: %v", err)
+			}
+			t.Cleanup(func() { _ = store.Close() })
+			fn(t, store)
+		})
+	}
+}
+
+func recordIDs(records []VMRecord) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestStoreBackendsRecords(t *testing.T) {
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	forEachStoreBackend(t, func(t *testing.T, store VMStore) {
+		records := []VMRecord{
+			{ID: "python-a", Language: "python", Status: VMStatusReady, CreatedAt: created, CPUCount: 2, Labels: map[string]string{"env": "ci"}},
+			{ID: "node-b", Language: "node", Status: VMStatusStopped, CreatedAt: created, Tenant: "acme"},
+		}
+		for _, record := range records {
+			if err := store.Save(record); err != nil {
+				t.Fatalf("save %s: %v", record.ID, err)
+			}
+		}
+
+		got, err := store.Get("python-a")
+		if err != nil || !reflect.DeepEqual(got, records[0]) {
+			t.Fatalf("Get = %+v, %v; want %+v", got, err, records[0])
+		}
+		if _, err := store.GetInTenant(defaultTenant, "node-b"); !errors.Is(err, errNotFound) {
+			t.Fatalf("GetInTenant across tenants err = %v, want errNotFound", err)
+		}
+		if got, err := store.GetInTenant("acme", "node-b"); err != nil || got.Language != "node" {
+			t.Fatalf("GetInTenant = %+v, %v", got, err)
+		}
+
+		all, err := store.LoadAll()
+		if err != nil || !reflect.DeepEqual(recordIDs(all), []string{"node-b", "python-a"}) {
+			t.Fatalf("LoadAll = %v, %v", recordIDs(all), err)
+		}
+		scoped, err := store.LoadTenant("")
+		if err != nil || !reflect.DeepEqual(recordIDs(scoped), []string{"python-a"}) {
+			t.Fatalf("LoadTenant(default) = %v, %v", recordIDs(scoped), err)
+		}
+
+		updated := records[0]
+		updated.Status = VMStatusStopped
+		updated.LastRunAt = created.Add(time.Minute)
+		if err := store.Save(updated); err != nil {
+			t.Fatalf("resave: %v", err)
+		}
+		if got, _ := store.Get("python-a"); !reflect.DeepEqual(got, updated) {
+			t.Fatalf("after resave Get = %+v, want %+v", got, updated)
+		}
+
+		// Delete is scoped to the tenant like every other write.
+		if err := store.Delete(defaultTenant, "node-b"); err != nil {
+			t.Fatalf("delete in wrong tenant: %v", err)
+		}
+		if _, err := store.Get("node-b"); err != nil {
+			t.Fatalf("delete in the wrong tenant removed the record: %v", err)
+		}
+		if err := store.Delete("acme", "node-b"); err != nil {
+			t.Fatalf("delete: %v", err)
+		}
+		if _, err := store.Get("node-b"); !errors.Is(err, errNotFound) {
+			t.Fatalf("Get after delete err = %v, want errNotFound", err)
+		}
+		if _, err := store.Get("missing"); !errors.Is(err, errNotFound) {
+			t.Fatalf("Get missing err = %v, want errNotFound", err)
+		}
+	})
+}
+
+func TestStoreBackendsInsertRefusesExistingID(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, store VMStore) {
+		original := VMRecord{ID: "python-a", Language: "python", Status: VMStatusReady, Tenant: "acme"}
+		if err := store.Insert(original); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+		// The ID is taken whichever tenant asks for it.
+		duplicate := VMRecord{ID: "python-a", Language: "node", Status: VMStatusReady}
+		if err := store.Insert(duplicate); !errors.Is(err, errVMExists) {
+			t.Fatalf("insert duplicate err = %v, want errVMExists", err)
+		}
+		got, err := store.Get("python-a")
+		if err != nil || got.Language != "python" || got.Tenant != "acme" {
+			t.Fatalf("Get after refused insert = %+v, %v", got, err)
+		}
+	})
+}
+
+func TestStoreBackendsVolumes(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, store VMStore) {
+		volume := VolumeRecord{Name: "shared", Path: "/state/volumes/shared", CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		if err := store.SaveVolume(volume); err != nil {
+			t.Fatalf("save volume: %v", err)
+		}
+		if got, err := store.GetVolume("shared"); err != nil || !reflect.DeepEqual(got, volume) {
+			t.Fatalf("GetVolume = %+v, %v; want %+v", got, err, volume)
+		}
+		if volumes, err := store.LoadVolumes(); err != nil || len(volumes) != 1 {
+			t.Fatalf("LoadVolumes = %+v, %v", volumes, err)
+		}
+		if err := store.DeleteVolume("shared"); err != nil {
+			t.Fatalf("delete volume: %v", err)
+		}
+		if _, err := store.GetVolume("shared"); !errors.Is(err, errNotFound) {
+			t.Fatalf("GetVolume after delete err = %v, want errNotFound", err)
+		}
+	})
+}
+
+func TestStoreBackendsRunHistory(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	forEachStoreBackend(t, func(t *testing.T, store VMStore) {
+		entries := []RunHistoryEntry{
+			{VMID: "vm-a", Command: "first", StartedAt: start},
+			{VMID: "vm-b", Command: "second", StartedAt: start.Add(time.Second)},
+			{VMID: "vm-a", Command: "third", StartedAt: start.Add(2 * time.Second), ExitCode: 1, Status: "failed"},
+			{VMID: "vm-c", Command: "other tenant", StartedAt: start.Add(3 * time.Second), Tenant: "acme"},
+		}
+		for _, entry := range entries {
+			if err := store.SaveRun(entry); err != nil {
+				t.Fatalf("save run: %v", err)
+			}
+		}
+
+		commands := func(tenant, vmID string, limit int) []string {
+			t.Helper()
+			runs, err := store.RecentRuns(tenant, vmID, limit)
+			if err != nil {
+				t.Fatalf("RecentRuns: %v", err)
+			}
+			out := []string{}
+			for _, run := range runs {
+				out = append(out, run.Command)
+			}
+			return out
+		}
+		if got := commands(defaultTenant, "", 10); !reflect.DeepEqual(got, []string{"third", "second", "first"}) {
+			t.Fatalf("recent runs = %v, want newest first in the default tenant", got)
+		}
+		if got := commands(defaultTenant, "vm-a", 1); !reflect.DeepEqual(got, []string{"third"}) {
+			t.Fatalf("recent runs for vm-a = %v, want [third]", got)
+		}
+		if got := commands("acme", "", 10); !reflect.DeepEqual(got, []string{"other tenant"}) {
+			t.Fatalf("acme runs = %v", got)
+		}
+	})
+}
+
+func TestStoreBackendsCheckHealthy(t *testing.T) {
+	forEachStoreBackend(t, func(t *testing.T, store VMStore) {
+		if err := store.Save(VMRecord{ID: "python-a", Language: "python"}); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		result, err := store.Check()
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		if !result.Healthy() || result.VMs != 1 || result.SchemaVersion == 0 {
+			t.Fatalf("check = %+v, want healthy with one vm and a schema version", result)
+		}
+	})
+}
+
+func TestOpenVMStoreSelectsBackend(t *testing.T) {
+	for backend, want := range map[string]string{"": "*main.BoltVMStore", "bolt": "*main.BoltVMStore", "SQLite": "*main.SQLiteVMStore"} {
+		t.Setenv("AGENT_STORE_BACKEND", backend)
+		store, err := openVMStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("openVMStore(%q): %v", backend, err)
+		}
+		if got := reflect.TypeOf(store).String(); got != want {
+			t.Errorf("openVMStore(%q) = %s, want %s", backend, got, want)
+		}
+		store.Close()
+	}
+
+	t.Setenv("AGENT_STORE_BACKEND", "postgres")
+	if _, err := openVMStore(t.TempDir()); err == nil {
+		t.Fatal("unknown backend accepted")
+	}
+}
+
+func TestSQLiteMigratesVersionOneSchema(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewSQLiteVMStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// Rebuild the vms table as version 1 had it, without the name column.
+	for _, stmt := range []string{
+		"DROP TABLE vms",
+		"CREATE TABLE vms (id TEXT PRIMARY KEY, tenant TEXT NOT NULL, language TEXT NOT NULL, status TEXT NOT NULL, created_at TEXT NOT NULL, last_run_at TEXT, record TEXT NOT NULL)",
+		"PRAGMA user_version = 1",
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	store.Close()
+
+	store, err = NewSQLiteVMStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if err := store.Save(VMRecord{ID: "python-a", Language: "python", Name: "task"}); err != nil {
+		t.Fatalf("save after migration: %v", err)
+	}
+	var name string
+	if err := store.db.QueryRow("SELECT name FROM vms WHERE id = 'python-a'").Scan(&name); err != nil || name != "task" {
+		t.Fatalf("name column = %q, %v", name, err)
+	}
+}
+
+func TestStoreBackendsRunHistoryCapsPerVMAndSurvivesReopen(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, open := range storeBackends {
+		open := open
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := open(dir)
+			if err != nil {
+				t.Fatalf("open store: %v", err)
+			}
+			for i := 0; i < maxRunHistoryPerVM+5; i++ {
+				entry := RunHistoryEntry{VMID: "busy", Command: "run", StartedAt: start.Add(time.Duration(i) * time.Second), ExitCode: i}
+				if err := store.SaveRun(entry); err != nil {
+					t.Fatalf("save run %d: %v", i, err)
+				}
+			}
+			if err := store.SaveRun(RunHistoryEntry{VMID: "quiet", Command: "once", StartedAt: start, Truncated: true}); err != nil {
+				t.Fatalf("save quiet run: %v", err)
+			}
+			store.Close()
+
+			store, err = open(dir)
+			if err != nil {
+				t.Fatalf("reopen store: %v", err)
+			}
+			defer store.Close()
+
+			busy, err := store.RecentRuns(defaultTenant, "busy", maxRunHistory)
+			if err != nil {
+				t.Fatalf("RecentRuns: %v", err)
+			}
+			if len(busy) != maxRunHistoryPerVM || busy[0].ExitCode != maxRunHistoryPerVM+4 || busy[len(busy)-1].ExitCode != 5 {
+				t.Fatalf("busy history = %d entries from %d to %d, want the newest %d", len(busy), busy[0].ExitCode, busy[len(busy)-1].ExitCode, maxRunHistoryPerVM)
+			}
+			quiet, err := store.RecentRuns(defaultTenant, "quiet", 10)
+			if err != nil || len(quiet) != 1 || !quiet[0].Truncated {
+				t.Fatalf("quiet history = %+v, %v; want its one truncated run", quiet, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	sqliteDBFileName = "agent.sqlite"
+
+	// sqliteSchemaVersion is kept in PRAGMA user_version, the SQLite
+	// counterpart of the bolt meta bucket.
+	sqliteSchemaVersion = 2
+
+	// sqliteTimeFormat sorts as text and is understood by SQLite's date
+	// functions, so created_at and last_run_at can be compared in queries.
+	sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+)
+
+// sqliteSchema keeps the full record as JSON next to the columns worth
+// querying; the JSON is what the agent reads back.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS vms (
+	id          TEXT PRIMARY KEY,
+	tenant      TEXT NOT NULL,
+	language    TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	created_at  TEXT NOT NULL,
+	last_run_at TEXT,
+	name        TEXT,
+	record      TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS vms_tenant ON vms (tenant);
+CREATE TABLE IF NOT EXISTS volumes (
+	name   TEXT PRIMARY KEY,
+	record TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS runs (
+	started_at INTEGER NOT NULL,
+	vm_id      TEXT NOT NULL,
+	tenant     TEXT NOT NULL,
+	exit_code  INTEGER NOT NULL,
+	status     TEXT NOT NULL,
+	record     TEXT NOT NULL,
+	PRIMARY KEY (started_at, vm_id)
+);
+`
+
+// SQLiteVMStore is the SQL-queryable VMStore. It behaves like BoltVMStore;
+// runs.started_at holds Unix nanoseconds.
+type SQLiteVMStore struct {
+	db   *sql.DB
+	path string
+}
+
+func NewSQLiteVMStore(stateRoot string) (*SQLiteVMStore, error) {
+	dbPath := filepath.Join(stateRoot, sqliteDBFileName)
+	if err := ensureDir(filepath.Dir(dbPath)); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	// One connection serializes writers the way bolt does and avoids
+	// SQLITE_BUSY between the agent's own goroutines.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteVMStore{db: db, path: dbPath}, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > sqliteSchemaVersion {
+		return fmt.Errorf("state database schema version %d is newer than this agent supports (%d)", version, sqliteSchemaVersion)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	if version == 1 {
+		// Version 2 added the name column; CREATE TABLE IF NOT EXISTS
+		// leaves existing tables as they were.
+		if _, err := db.Exec("ALTER TABLE vms ADD COLUMN name TEXT"); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS vms_tenant_name ON vms (tenant, name)"); err != nil {
+		return err
+	}
+	_, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteSchemaVersion))
+	return err
+}
+
+func sqliteTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.UTC().Format(sqliteTimeFormat), Valid: true}
+}
+
+func (s *SQLiteVMStore) Close() error {
+	if s == nil || s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *SQLiteVMStore) Save(record VMRecord) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT OR REPLACE INTO vms (id, tenant, language, status, created_at, last_run_at, name, record)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.ID, normalizeTenant(record.Tenant), record.Language, string(record.Status),
+		record.CreatedAt.UTC().Format(sqliteTimeFormat), sqliteTime(record.LastRunAt),
+		sql.NullString{String: record.Name, Valid: record.Name != ""}, string(payload))
+	return err
+}
+
+func (s *SQLiteVMStore) Insert(record VMRecord) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Exec(`INSERT INTO vms (id, tenant, language, status, created_at, last_run_at, name, record)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (id) DO NOTHING`,
+		record.ID, normalizeTenant(record.Tenant), record.Language, string(record.Status),
+		record.CreatedAt.UTC().Format(sqliteTimeFormat), sqliteTime(record.LastRunAt),
+		sql.NullString{String: record.Name, Valid: record.Name != ""}, string(payload))
+	if err != nil {
+		return err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return err
+	} else if inserted == 0 {
+		return fmt.Errorf("%w: %s", errVMExists, record.ID)
+	}
+	return nil
+}
+
+func (s *SQLiteVMStore) Delete(tenant, vmID string) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	_, err := s.db.Exec("DELETE FROM vms WHERE id = ? AND tenant = ?", vmID, normalizeTenant(tenant))
+	return err
+}
+
+func (s *SQLiteVMStore) getRecord(query string, args ...any) (VMRecord, error) {
+	var record VMRecord
+	if s == nil || s.db == nil {
+		return record, errPersist
+	}
+	var raw string
+	if err := s.db.QueryRow(query, args...).Scan(&raw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return record, errNotFound
+		}
+		return record, err
+	}
+	err := json.Unmarshal([]byte(raw), &record)
+	return record, err
+}
+
+// Get looks the VM up across all tenants; GetInTenant is the scoped variant.
+func (s *SQLiteVMStore) Get(vmID string) (VMRecord, error) {
+	return s.getRecord("SELECT record FROM vms WHERE id = ?", vmID)
+}
+
+func (s *SQLiteVMStore) GetInTenant(tenant, vmID string) (VMRecord, error) {
+	return s.getRecord("SELECT record FROM vms WHERE id = ? AND tenant = ?", vmID, normalizeTenant(tenant))
+}
+
+func (s *SQLiteVMStore) loadRecords(query string, args ...any) ([]VMRecord, error) {
+	if s == nil || s.db == nil {
+		return nil, errPersist
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []VMRecord
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var record VMRecord
+		if err := json.Unmarshal([]byte(raw), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// LoadAll returns the VMs of every tenant.
+func (s *SQLiteVMStore) LoadAll() ([]VMRecord, error) {
+	return s.loadRecords("SELECT record FROM vms ORDER BY tenant, id")
+}
+
+func (s *SQLiteVMStore) LoadTenant(tenant string) ([]VMRecord, error) {
+	return s.loadRecords("SELECT record FROM vms WHERE tenant = ? ORDER BY id", normalizeTenant(tenant))
+}
+
+func (s *SQLiteVMStore) SaveVolume(volume VolumeRecord) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	payload, err := json.Marshal(volume)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("INSERT OR REPLACE INTO volumes (name, record) VALUES (?, ?)", volume.Name, string(payload))
+	return err
+}
+
+func (s *SQLiteVMStore) DeleteVolume(name string) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	_, err := s.db.Exec("DELETE FROM volumes WHERE name = ?", name)
+	return err
+}
+
+func (s *SQLiteVMStore) GetVolume(name string) (VolumeRecord, error) {
+	var volume VolumeRecord
+	if s == nil || s.db == nil {
+		return volume, errPersist
+	}
+	var raw string
+	if err := s.db.QueryRow("SELECT record FROM volumes WHERE name = ?", name).Scan(&raw); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return volume, errNotFound
+		}
+		return volume, err
+	}
+	err := json.Unmarshal([]byte(raw), &volume)
+	return volume, err
+}
+
+func (s *SQLiteVMStore) LoadVolumes() ([]VolumeRecord, error) {
+	if s == nil || s.db == nil {
+		return nil, errPersist
+	}
+	rows, err := s.db.Query("SELECT record FROM volumes ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var volumes []VolumeRecord
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var volume VolumeRecord
+		if err := json.Unmarshal([]byte(raw), &volume); err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, volume)
+	}
+	return volumes, rows.Err()
+}
+
+// SaveRun records a finished run and drops the oldest entries beyond
+// maxRunHistoryPerVM and maxRunHistory, as the bolt store does.
+func (s *SQLiteVMStore) SaveRun(entry RunHistoryEntry) error {
+	if s == nil || s.db == nil {
+		return errPersist
+	}
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO runs (started_at, vm_id, tenant, exit_code, status, record)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		entry.StartedAt.UnixNano(), entry.VMID, normalizeTenant(entry.Tenant), entry.ExitCode, entry.Status, string(payload)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM runs WHERE rowid IN (
+		SELECT rowid FROM runs WHERE vm_id = ? ORDER BY started_at
+		LIMIT max(0, (SELECT COUNT(*) FROM runs WHERE vm_id = ?) - ?))`,
+		entry.VMID, entry.VMID, maxRunHistoryPerVM); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM runs WHERE rowid IN (
+		SELECT rowid FROM runs ORDER BY started_at, vm_id
+		LIMIT max(0, (SELECT COUNT(*) FROM runs) - ?))`, maxRunHistory); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RecentRuns returns up to limit of the tenant's entries, newest first. An
+// empty vmID matches runs on every VM of the tenant.
+func (s *SQLiteVMStore) RecentRuns(tenant, vmID string, limit int) ([]RunHistoryEntry, error) {
+	if s == nil || s.db == nil {
+		return nil, errPersist
+	}
+	rows, err := s.db.Query(`SELECT record FROM runs
+		WHERE tenant = ? AND (? = '' OR vm_id = ?)
+		ORDER BY started_at DESC, vm_id DESC LIMIT ?`,
+		normalizeTenant(tenant), vmID, vmID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []RunHistoryEntry{}
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var entry RunHistoryEntry
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Check reports records whose JSON no longer decodes, naming them by the
+// same vms/<tenant> and volumes buckets as the bolt store, and the findings
+// of PRAGMA integrity_check as page errors.
+func (s *SQLiteVMStore) Check() (StoreCheckResult, error) {
+	if s == nil || s.db == nil {
+		return StoreCheckResult{}, errPersist
+	}
+
+	result := StoreCheckResult{Path: s.path}
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&result.SchemaVersion); err != nil {
+		return result, err
+	}
+
+	rows, err := s.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return result, err
+		}
+		if line != "ok" {
+			result.PageErrors = append(result.PageErrors, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	checkRows := func(query string, decode func([]byte) error) (int, error) {
+		rows, err := s.db.Query(query)
+		if err != nil {
+			return 0, err
+		}
+		defer rows.Close()
+		count := 0
+		for rows.Next() {
+			var bucket, key, raw string
+			if err := rows.Scan(&bucket, &key, &raw); err != nil {
+				return count, err
+			}
+			count++
+			if err := decode([]byte(raw)); err != nil {
+				result.Corrupt = append(result.Corrupt, CorruptEntry{Bucket: bucket, Key: key, Err: err.Error()})
+			}
+		}
+		return count, rows.Err()
+	}
+
+	result.VMs, err = checkRows("SELECT 'vms/' || tenant, id, record FROM vms ORDER BY tenant, id", func(v []byte) error {
+		var record VMRecord
+		return json.Unmarshal(v, &record)
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Volumes, err = checkRows("SELECT 'volumes', name, record FROM volumes ORDER BY name", func(v []byte) error {
+		var volume VolumeRecord
+		return json.Unmarshal(v, &volume)
+	})
+	return result, err
+}
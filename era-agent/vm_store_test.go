@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStoreCheckReportsCorruptEntries(t *testing.T) {
+	store, err := NewBoltVMStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if err := store.Save(VMRecord{ID: "python-ok", Language: "python"}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if err := store.SaveVolume(VolumeRecord{Name: "cache"}); err != nil {
+		t.Fatalf("save volume: %v", err)
+	}
+
+	result, err := store.Check()
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !result.Healthy() || result.VMs != 1 || result.Volumes != 1 {
+		t.Fatalf("unexpected result for clean store: %+v", result)
+	}
+
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		return tenantBucket(tx, defaultTenant).Put([]byte("python-bad"), []byte("{not json"))
+	}); err != nil {
+		t.Fatalf("write corrupt value: %v", err)
+	}
+
+	result, err = store.Check()
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if result.Healthy() {
+		t.Fatal("expected corrupt store to be unhealthy")
+	}
+	if result.VMs != 2 {
+		t.Fatalf("VMs = %d, want 2", result.VMs)
+	}
+	if len(result.Corrupt) != 1 || result.Corrupt[0].Bucket != "vms/default" || result.Corrupt[0].Key != "python-bad" {
+		t.Fatalf("Corrupt = %+v, want the python-bad vm entry", result.Corrupt)
+	}
+	if _, err := store.LoadAll(); err == nil {
+		t.Fatal("expected LoadAll to fail on the same entry")
+	}
+}
+
+func TestStoreScopesRecordsByTenant(t *testing.T) {
+	store, err := NewBoltVMStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	for _, record := range []VMRecord{
+		{ID: "python-a", Tenant: "acme"},
+		{ID: "python-b", Tenant: "globex"},
+		{ID: "python-c"},
+	} {
+		if err := store.Save(record); err != nil {
+			t.Fatalf("save %s: %v", record.ID, err)
+		}
+	}
+
+	records, err := store.LoadTenant("acme")
+	if err != nil || len(records) != 1 || records[0].ID != "python-a" {
+		t.Fatalf("acme records = %+v (err %v)", records, err)
+	}
+	if _, err := store.GetInTenant("globex", "python-a"); !errors.Is(err, errNotFound) {
+		t.Fatalf("cross-tenant get err = %v, want errNotFound", err)
+	}
+	if _, err := store.GetInTenant(defaultTenant, "python-c"); err != nil {
+		t.Fatalf("default tenant get: %v", err)
+	}
+	if err := store.Delete("globex", "python-a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if all, err := store.LoadAll(); err != nil || len(all) != 3 {
+		t.Fatalf("cross-tenant delete removed a record: %+v (err %v)", all, err)
+	}
+}
+
+func TestStoreMigratesLegacyVMBucket(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltVMStore(dir)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(vmBucket)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte("python-old"), []byte(`{"ID":"python-old","Language":"python"}`)); err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte("python-acme"), []byte(`{"ID":"python-acme","Tenant":"acme"}`)); err != nil {
+			return err
+		}
+		// Databases from before tenants also had no schema version.
+		return tx.DeleteBucket(metaBucket)
+	}); err != nil {
+		t.Fatalf("write legacy records: %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltVMStore(dir)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	if record, err := store.GetInTenant(defaultTenant, "python-old"); err != nil || record.Language != "python" {
+		t.Fatalf("legacy record not in default tenant: %+v (err %v)", record, err)
+	}
+	if _, err := store.GetInTenant("acme", "python-acme"); err != nil {
+		t.Fatalf("legacy record not in its tenant: %v", err)
+	}
+	if err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmBucket).ForEach(func(k, v []byte) error {
+			if v != nil {
+				t.Errorf("record %s left at the top level", k)
+			}
+			return nil
+		})
+	}); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+}
+
+func TestStoreMigrationBackfillsOldRecords(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltVMStore(dir)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	if version, err := store.SchemaVersion(); err != nil || version != currentSchemaVersion {
+		t.Fatalf("new store schema version = %d, %v; want %d", version, err, currentSchemaVersion)
+	}
+	// Rewrite the database the way a pre-versioning agent left it.
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(metaBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucketIfNotExists(vmBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("python-v0"), []byte(`{"ID":"python-v0","Language":"python","CPUCount":1}`))
+	}); err != nil {
+		t.Fatalf("write old records: %v", err)
+	}
+	store.Close()
+
+	store, err = NewBoltVMStore(dir)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	if version, err := store.SchemaVersion(); err != nil || version != currentSchemaVersion {
+		t.Fatalf("migrated schema version = %d, %v; want %d", version, err, currentSchemaVersion)
+	}
+	record, err := store.Get("python-v0")
+	if err != nil {
+		t.Fatalf("get migrated record: %v", err)
+	}
+	if record.Status != VMStatusStopped || record.Tenant != defaultTenant || record.CPUCount != 1 {
+		t.Fatalf("migrated record = %+v, want stopped in the default tenant with fields kept", record)
+	}
+
+	// A database from a newer agent is refused instead of being rewritten.
+	if err := store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put(schemaKey, []byte(strconv.Itoa(currentSchemaVersion+1)))
+	}); err != nil {
+		t.Fatalf("bump schema version: %v", err)
+	}
+	store.Close()
+	if store, err := NewBoltVMStore(dir); err == nil {
+		store.Close()
+		t.Fatal("opened a database with a newer schema version")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errVolumeNotFound = errors.New("volume not found")
+	errVolumeExists   = errors.New("volume already exists")
+	errVolumeInUse    = errors.New("volume is in use")
+
+	volumeNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+)
+
+// VolumeRecord is a named host directory that can be mounted into several VMs.
+type VolumeRecord struct {
+	Name      string
+	Path      string
+	CreatedAt time.Time
+}
+
+// VolumeMount attaches a named volume at GuestPath. HostPath is resolved from
+// the volume record when the VM is created.
+type VolumeMount struct {
+	Name      string
+	GuestPath string
+	HostPath  string
+}
+
+// parseVolumeMount parses a "name:/guest/path" mount specification.
+func parseVolumeMount(raw string) (VolumeMount, error) {
+	name, guestPath, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	if !ok || name == "" || guestPath == "" {
+		return VolumeMount{}, fmt.Errorf("invalid volume mount %q: expected name:/guest/path", raw)
+	}
+	return VolumeMount{Name: name, GuestPath: guestPath}, nil
+}
+
+func parseVolumeMounts(raw []string) ([]VolumeMount, error) {
+	mounts := make([]VolumeMount, 0, len(raw))
+	for _, entry := range raw {
+		mount, err := parseVolumeMount(entry)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, mount)
+	}
+	return mounts, nil
+}
+
+func (s *VMService) CreateVolume(name string) (VolumeRecord, error) {
+	name = strings.TrimSpace(name)
+	if !volumeNamePattern.MatchString(name) {
+		return VolumeRecord{}, fmt.Errorf("invalid volume name %q: use lowercase letters, digits, '.', '_' or '-'", name)
+	}
+
+	if _, err := s.store.GetVolume(name); err == nil {
+		return VolumeRecord{}, fmt.Errorf("%w: %s", errVolumeExists, name)
+	} else if !errors.Is(err, errNotFound) {
+		return VolumeRecord{}, err
+	}
+
+	volumesRoot := filepath.Join(stateRoot(), "volumes")
+	if err := ensureDir(volumesRoot); err != nil {
+		return VolumeRecord{}, err
+	}
+
+	volume := VolumeRecord{
+		Name:      name,
+		Path:      filepath.Join(volumesRoot, name),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := ensureDir(volume.Path); err != nil {
+		return VolumeRecord{}, err
+	}
+	// Guests write as an unprivileged uid, matching /in, /out and /persist.
+	if err := os.Chmod(volume.Path, sharedStoragePerm); err != nil {
+		return VolumeRecord{}, err
+	}
+
+	if err := s.store.SaveVolume(volume); err != nil {
+		_ = os.RemoveAll(volume.Path)
+		return VolumeRecord{}, err
+	}
+	return volume, nil
+}
+
+func (s *VMService) ListVolumes() ([]VolumeRecord, error) {
+	volumes, err := s.store.LoadVolumes()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Name < volumes[j].Name
+	})
+	return volumes, nil
+}
+
+// DeleteVolume removes a volume and its data. Volumes still mounted by a
+// tracked VM are refused.
+func (s *VMService) DeleteVolume(name string) error {
+	volume, err := s.store.GetVolume(name)
+	if err != nil {
+		if errors.Is(err, errNotFound) {
+			return fmt.Errorf("%w: %s", errVolumeNotFound, name)
+		}
+		return err
+	}
+
+	s.mu.RLock()
+	for _, record := range s.cache {
+		for _, mount := range record.Storage.Volumes {
+			if mount.Name == name {
+				s.mu.RUnlock()
+				return fmt.Errorf("%w by vm %s", errVolumeInUse, record.ID)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	if err := os.RemoveAll(volume.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.store.DeleteVolume(name)
+}
+
+// resolveVolumeMounts validates requested mounts and fills in host paths.
+// Volumes cannot take the guest paths of the VM's own storage.
+func (s *VMService) resolveVolumeMounts(requested []VolumeMount, storage GuestMounts) ([]VolumeMount, error) {
+	if len(requested) == 0 {
+		return nil, nil
+	}
+	if !guestVolumeSharingEnabled() {
+		return nil, errors.New("named volumes require guest volume sharing (AGENT_ENABLE_GUEST_VOLUMES=1)")
+	}
+
+	reserved := map[string]bool{storage.Input: true, storage.Output: true, storage.Persist: true}
+	seen := make(map[string]bool, len(requested))
+	mounts := make([]VolumeMount, 0, len(requested))
+
+	for _, mount := range requested {
+		guestPath := path.Clean(mount.GuestPath)
+		if !path.IsAbs(guestPath) || guestPath == "/" {
+			return nil, fmt.Errorf("volume %s: guest path %q must be an absolute directory", mount.Name, mount.GuestPath)
+		}
+		if reserved[guestPath] {
+			return nil, fmt.Errorf("volume %s: guest path %s is reserved", mount.Name, guestPath)
+		}
+		if seen[guestPath] {
+			return nil, fmt.Errorf("guest path %s is mounted more than once", guestPath)
+		}
+		seen[guestPath] = true
+
+		volume, err := s.store.GetVolume(mount.Name)
+		if err != nil {
+			if errors.Is(err, errNotFound) {
+				return nil, fmt.Errorf("%w: %s (create it with `agent volume create %s`)", errVolumeNotFound, mount.Name, mount.Name)
+			}
+			return nil, err
+		}
+
+		mounts = append(mounts, VolumeMount{Name: volume.Name, GuestPath: guestPath, HostPath: volume.Path})
+	}
+	return mounts, nil
+}
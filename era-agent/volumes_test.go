@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVolumeSharedBetweenVMs(t *testing.T) {
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	svc := newTestVMService(t, newFakeLauncher())
+
+	volume, err := svc.CreateVolume("shared-data")
+	if err != nil {
+		t.Fatalf("create volume failed: %v", err)
+	}
+	if _, err := svc.CreateVolume("shared-data"); !errors.Is(err, errVolumeExists) {
+		t.Fatalf("expected errVolumeExists, got %v", err)
+	}
+	if _, err := svc.CreateVolume("Bad Name"); err == nil {
+		t.Fatal("expected invalid volume name to be rejected")
+	}
+
+	newVM := func() VMRecord {
+		t.Helper()
+		record, err := svc.Create(context.Background(), VMCreateOptions{
+			Language:    "python",
+			CPUCount:    1,
+			MemoryMiB:   256,
+			NetworkMode: "none",
+			Volumes:     []VolumeMount{{Name: "shared-data", GuestPath: "/data"}},
+		})
+		if err != nil {
+			t.Fatalf("create vm failed: %v", err)
+		}
+		return record
+	}
+	writer, reader := newVM(), newVM()
+
+	launcher := &krunVMLauncher{binary: krunvmBinaryName}
+	for _, record := range []VMRecord{writer, reader} {
+		args := strings.Join(launcher.createArgs(record), " ")
+		if want := "--volume " + volume.Path + ":/data"; !strings.Contains(args, want) {
+			t.Fatalf("krunvm args %q missing %q", args, want)
+		}
+	}
+
+	// The fake launcher runs on the host, so address the volume by host path.
+	writePath := filepath.Join(writer.Storage.Volumes[0].HostPath, "greeting")
+	if _, err := svc.Run(context.Background(), VMRunOptions{VMID: writer.ID, Command: "echo hello > " + writePath, Timeout: 5}); err != nil {
+		t.Fatalf("write run failed: %v", err)
+	}
+
+	readPath := filepath.Join(reader.Storage.Volumes[0].HostPath, "greeting")
+	result, err := svc.Run(context.Background(), VMRunOptions{VMID: reader.ID, Command: "cat " + readPath, Timeout: 5})
+	if err != nil {
+		t.Fatalf("read run failed: %v", err)
+	}
+	stdout, err := os.ReadFile(result.StdoutPath)
+	if err != nil {
+		t.Fatalf("read stdout: %v", err)
+	}
+	if string(stdout) != "hello\n" {
+		t.Fatalf("reader saw %q, want %q", stdout, "hello\n")
+	}
+
+	if err := svc.DeleteVolume("shared-data"); !errors.Is(err, errVolumeInUse) {
+		t.Fatalf("expected errVolumeInUse, got %v", err)
+	}
+}
+
+func TestVolumeMountValidation(t *testing.T) {
+	svc := newTestVMService(t, newFakeLauncher())
+	opts := VMCreateOptions{
+		Language:    "python",
+		CPUCount:    1,
+		MemoryMiB:   256,
+		NetworkMode: "none",
+		Volumes:     []VolumeMount{{Name: "missing", GuestPath: "/data"}},
+	}
+
+	if _, err := svc.Create(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "guest volume sharing") {
+		t.Fatalf("expected guest volume sharing error, got %v", err)
+	}
+
+	t.Setenv("AGENT_ENABLE_GUEST_VOLUMES", "1")
+	if _, err := svc.Create(context.Background(), opts); !errors.Is(err, errVolumeNotFound) {
+		t.Fatalf("expected errVolumeNotFound, got %v", err)
+	}
+
+	if _, err := svc.CreateVolume("scratch"); err != nil {
+		t.Fatalf("create volume failed: %v", err)
+	}
+	opts.Volumes = []VolumeMount{{Name: "scratch", GuestPath: "/out"}}
+	if _, err := svc.Create(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("expected reserved path error, got %v", err)
+	}
+
+	if _, err := parseVolumeMount("no-path"); err == nil {
+		t.Fatal("expected parse error for mount without guest path")
+	}
+}